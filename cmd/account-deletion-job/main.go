@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/caarlos0/env/v6"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"user-manager/cmd/app/service/accountdeletion"
+	"user-manager/cmd/app/service/users"
+	"user-manager/util/command"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/logger"
+)
+
+type Config struct {
+	DbInfo      db.Info
+	Environment string `env:"ENVIRONMENT"`
+}
+
+func main() {
+	slog.SetDefault(logger.NewLogger(false))
+	command.Run(startJob)
+}
+
+func startJob() error {
+	slog.Info("Starting up")
+
+	config := Config{}
+	if err := env.Parse(&config, env.Options{RequiredIfNoDef: true}); err != nil {
+		return errs.Wrap("error parsing env", err)
+	}
+
+	if config.Environment != "local" {
+		slog.SetDefault(logger.NewLogger(true))
+	}
+
+	database, err := db.OpenDbConnection(config.DbInfo)
+	if err != nil {
+		return errs.Wrap("issue opening db connection", err)
+	}
+	defer db.CloseOrPanic(database.Client())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	// Run until shutdown signal is received
+	timeBetweenRuns := 1 * time.Minute
+	for {
+		if err = deleteDueAccounts(database); err != nil {
+			return errs.Wrap("issue deleting accounts", err)
+		}
+		select {
+		case <-signals:
+			slog.Info("Shutdown signal received. About to shut down")
+			return nil
+		case <-time.After(timeBetweenRuns):
+		}
+	}
+}
+
+// deleteDueAccounts removes the users whose grace period ended together with everything referring to them. The user
+// document goes last, so that an interrupted run is picked up again by the next one.
+func deleteDueAccounts(database *mongo.Database) error {
+	ctx := context.Background()
+	now := time.Now()
+
+	dueUsers, err := users.GetUsersDueForDeletion(ctx, database, now)
+	if err != nil {
+		return errs.Wrap("issue fetching users due for deletion", err)
+	}
+
+	for _, user := range dueUsers {
+		started, err := users.StartDueDeletion(ctx, database, user.ID(), now)
+		if err != nil {
+			return errs.Wrap("issue starting user deletion", err)
+		}
+		if !started {
+			continue
+		}
+
+		if err = accountdeletion.DeleteUserData(ctx, database, user); err != nil {
+			return errs.Wrap("issue deleting data of user", err)
+		}
+		if _, err = users.DeleteUserDueForDeletion(ctx, database, user.ID(), now); err != nil {
+			return errs.Wrap("issue deleting user", err)
+		}
+		slog.Info("Account deleted", "userID", user.IDHex())
+	}
+	return nil
+}
@@ -11,6 +11,16 @@ func HXLocationOrRedirect(c *gin.Context, location string) {
 	}
 }
 
+// HXRedirectOrRedirect navigates the browser away from the app, where an HX-Location request could not follow
+func HXRedirectOrRedirect(c *gin.Context, location string) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", location)
+		c.Status(204)
+	} else {
+		c.Redirect(302, location)
+	}
+}
+
 func HXIsFullPageLoad(c *gin.Context) bool {
 	return c.GetHeader("HX-Request") != "true" || c.GetHeader("HX-History-Restore-Request") == "true"
 }
@@ -13,6 +13,9 @@ type HandlerReturningComponentWithoutPayload func(*gin.Context, *dm.RequestConte
 
 func WrapTempl[requestTO interface{}](handler HandlerReturningComponent[requestTO]) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if rejectPersonalAccessToken(c) {
+			return
+		}
 		var request requestTO
 		if err := c.Bind(&request); err != nil {
 			_ = c.AbortWithError(http.StatusBadRequest, errs.Wrap("cannot bind to request TO", err))
@@ -45,6 +48,9 @@ func WrapTempl[requestTO interface{}](handler HandlerReturningComponent[requestT
 }
 func WrapTemplWithoutPayload(handler HandlerReturningComponentWithoutPayload) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if rejectPersonalAccessToken(c) {
+			return
+		}
 		component, err := handler(c, GetRequestContext(c))
 		if err != nil {
 			_ = c.AbortWithError(http.StatusInternalServerError, errs.Wrap("error making component", err))
@@ -69,6 +75,15 @@ func WrapTemplWithoutPayload(handler HandlerReturningComponentWithoutPayload) gi
 	}
 }
 
+// rejectPersonalAccessToken limits personal access tokens to the JSON endpoints. Pages and fragments are only served to login sessions.
+func rejectPersonalAccessToken(c *gin.Context) bool {
+	if GetRequestContext(c).PersonalAccessToken.IsPresent() {
+		_ = c.AbortWithError(http.StatusForbidden, errs.Error("personal access tokens are only accepted by JSON endpoints"))
+		return true
+	}
+	return false
+}
+
 func shouldWriteStatus(c *gin.Context) bool {
 	return !c.IsAborted() && c.Writer.Status() == 0
 }
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"log/slog"
 	"net/http"
 	"time"
 	"user-manager/cmd/app/router"
+	"user-manager/cmd/app/service/accesstokens"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/externallogin"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/oidc"
+	"user-manager/cmd/app/service/organizations"
+	"user-manager/cmd/app/service/throttling"
 	dm "user-manager/domain-model"
 	"user-manager/util/command"
 	"user-manager/util/db"
@@ -38,6 +47,42 @@ func runServer() error {
 	}
 	defer db.CloseOrPanic(database.Client())
 
+	if err = auth.CreateSessionIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create session indexes", err)
+	}
+
+	if err = organizations.CreateOrganizationIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create organization indexes", err)
+	}
+
+	if err = audit.CreateAuditEventIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create audit event indexes", err)
+	}
+
+	if err = throttling.CreateThrottleIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create throttle indexes", err)
+	}
+
+	if err = oidc.CreateOidcIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create oidc indexes", err)
+	}
+
+	if err = externallogin.CreateExternalLoginIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create external login indexes", err)
+	}
+
+	if err = accesstokens.CreatePersonalAccessTokenIndexes(context.Background(), database); err != nil {
+		return errs.Wrap("cannot create personal access token indexes", err)
+	}
+
+	if _, err = ledger.ParseSigningKey(config); err != nil {
+		return errs.Wrap("invalid ledger signing key", err)
+	}
+
+	if err = auth.LoadBreachedPasswords(config); err != nil {
+		return errs.Wrap("cannot load breached passwords", err)
+	}
+
 	engine, err := router.New(config, database)
 	if err != nil {
 		return errs.Wrap("cannot setup router", err)
@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAccountDeletionResource(group *gin.RouterGroup) {
+	group.POST("delete-account", ginext.WrapTemplWithoutPayload(DeleteAccount))
+}
+
+func RegisterAccountDeletionCancelResource(group *gin.RouterGroup) {
+	group.GET("cancel-account-deletion", ginext.WrapTempl(AccountDeletionCancelPage))
+	group.POST("cancel-account-deletion", ginext.WrapTempl(CancelAccountDeletion))
+}
+
+// DeleteAccount schedules the deletion after the grace period and signs the user out everywhere. The account stays
+// locked until the account-deletion-job removes it, or the user follows the cancel link sent by email.
+func DeleteAccount(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	lastAdminManager, err := wouldRemoveLastAdminManager(ctx, r, user, nil)
+	if err != nil {
+		return nil, errs.Wrap("issue checking for remaining admin managers", err)
+	}
+	if lastAdminManager {
+		logger.Info("Account deletion of last super-admin prevented")
+		return userrender.AccountDeletionSection(r.Config.AccountDeletionGraceDays, "You are the last super-admin. Appoint another one before deleting your account."), nil
+	}
+
+	lastOwnedOrganization, err := getLastOwnedOrganization(ctx, r, user)
+	if err != nil {
+		return nil, errs.Wrap("issue checking for remaining organization owners", err)
+	}
+	if lastOwnedOrganization.IsPresent() {
+		logger.Info("Account deletion of last organization owner prevented", "organizationID", lastOwnedOrganization.IDHex())
+		return userrender.AccountDeletionSection(r.Config.AccountDeletionGraceDays, "You are the last owner of "+lastOwnedOrganization.Name+". Appoint another owner before deleting your account."), nil
+	}
+
+	cancelToken := random.MakeRandomURLSafeB64(21)
+	deleteAt := time.Now().Add(time.Duration(r.Config.AccountDeletionGraceDays) * 24 * time.Hour)
+	scheduledNow, err := users.ScheduleDeletion(ctx, r.Database, user.ID(), deleteAt, auth.HashToken(r.Config, cancelToken))
+	if err != nil {
+		return nil, errs.Wrap("issue scheduling account deletion", err)
+	}
+	if !scheduledNow {
+		return nil, errs.Error("account deletion scheduled already")
+	}
+	logger.Info("Account deletion scheduled", "deleteAt", deleteAt)
+
+	if err = auth.DeleteSessionsForUser(ctx, r.Database, user.ID()); err != nil {
+		return nil, errs.Wrap("issue deleting sessions", err)
+	}
+	auth.RemoveSessionCookie(ctx, r.Config, dm.UserSessionTypeLogin)
+	auth.RemoveSessionCookie(ctx, r.Config, dm.UserSessionTypeSudo)
+
+	if err = mail.SendAccountDeletionScheduledEmail(ctx, r, user.Email, user.Name, cancelToken, deleteAt); err != nil {
+		return nil, errs.Wrap("error sending account deletion email", err)
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccountDeletionRequest,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"deleteAt": deleteAt.UTC().Format(time.RFC3339)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	return userrender.AccountDeletionScheduled(deleteAt), nil
+}
+
+type AccountDeletionCancelTO struct {
+	Token string `form:"token"`
+}
+
+// AccountDeletionCancelPage asks for confirmation first, so that mail scanners following the link do not cancel the deletion
+func AccountDeletionCancelPage(ctx *gin.Context, r *dm.RequestContext, requestTO AccountDeletionCancelTO) (templ.Component, error) {
+	logger := r.Logger
+
+	user, err := users.GetUserForDeletionCancelTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user scheduled for deletion", err)
+	}
+	if !user.IsPresent() {
+		logger.Info("Account deletion cancel page with invalid token")
+		return render.FullPage(ctx, "Keep account", render.AccountDeletionCancelInvalid()), nil
+	}
+
+	return render.FullPage(ctx, "Keep account", render.AccountDeletionCancel(requestTO.Token, user.DeletionScheduledFor)), nil
+}
+
+func CancelAccountDeletion(ctx *gin.Context, r *dm.RequestContext, requestTO AccountDeletionCancelTO) (templ.Component, error) {
+	logger := r.Logger
+
+	user, err := users.GetUserForDeletionCancelTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user scheduled for deletion", err)
+	}
+	if !user.IsPresent() {
+		logger.Info("Account deletion cancel with invalid token")
+		return render.AccountDeletionCancelInvalid(), nil
+	}
+
+	canceled, err := users.CancelDeletion(ctx, r.Database, user.ID(), user.DeletionCancelTokenHash)
+	if err != nil {
+		return nil, errs.Wrap("issue canceling account deletion", err)
+	}
+	if !canceled {
+		logger.Info("Account deletion cancel after deletion started", "userID", user.IDHex())
+		return render.AccountDeletionCancelInvalid(), nil
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccountDeletionCancel,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	logger.Info("Account deletion canceled", "userID", user.IDHex())
+	return render.AccountDeletionCanceled(), nil
+}
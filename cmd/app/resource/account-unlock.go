@@ -0,0 +1,116 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/throttling"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAccountUnlockResource(group *gin.RouterGroup) {
+	group.GET("unlock-account", ginext.WrapTempl(AccountUnlockPage))
+	group.POST("unlock-account", ginext.WrapTempl(UnlockAccount))
+}
+
+type AccountUnlockTO struct {
+	Token string `form:"token"`
+}
+
+// AccountUnlockPage asks for confirmation first, so that mail scanners following the link do not unlock the account
+func AccountUnlockPage(ctx *gin.Context, r *dm.RequestContext, requestTO AccountUnlockTO) (templ.Component, error) {
+	logger := r.Logger
+
+	lockedUser, err := users.GetUserForUnlockTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching locked user", err)
+	}
+	if !lockedUser.IsPresent() {
+		logger.Info("Account unlock page with invalid token")
+		return render.FullPage(ctx, "Unlock account", render.AccountUnlockInvalid()), nil
+	}
+
+	return render.FullPage(ctx, "Unlock account", render.AccountUnlock(requestTO.Token)), nil
+}
+
+func UnlockAccount(ctx *gin.Context, r *dm.RequestContext, requestTO AccountUnlockTO) (templ.Component, error) {
+	logger := r.Logger
+
+	lockedUser, err := users.GetUserForUnlockTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching locked user", err)
+	}
+	if !lockedUser.IsPresent() {
+		logger.Info("Account unlock with invalid token")
+		return render.AccountUnlockInvalid(), nil
+	}
+
+	if err = unlockAccount(ctx, r, lockedUser); err != nil {
+		return nil, errs.Wrap("issue unlocking account", err)
+	}
+
+	logger.Info("Account unlocked", "userID", lockedUser.IDHex())
+	return render.AccountUnlocked(), nil
+}
+
+func unlockAccount(ctx *gin.Context, r *dm.RequestContext, user dm.User) error {
+	if err := users.UnlockUser(ctx, r.Database, user.ID()); err != nil {
+		return errs.Wrap("issue unlocking user", err)
+	}
+	if err := throttling.ResetAccount(ctx, r.Database, user.ID()); err != nil {
+		return errs.Wrap("issue resetting throttling", err)
+	}
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccountUnlock,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+	}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+	return nil
+}
+
+// recordFailedAttempt counts a failed attempt of the user. Once the configured threshold is reached, the account is
+// locked and the unlock link is emailed.
+func recordFailedAttempt(ctx *gin.Context, r *dm.RequestContext, scope dm.ThrottlingScope, user dm.User) (dm.ThrottleStatus, error) {
+	logger := r.Logger
+
+	status, err := throttling.RecordFailure(ctx, r.Database, scope, user, ctx.ClientIP(), r.Config.AccountLockThreshold)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording failure", err)
+	}
+	if !status.Locked {
+		return status, nil
+	}
+
+	unlockToken := random.MakeRandomURLSafeB64(21)
+	lockedNow, err := users.LockUser(ctx, r.Database, user.ID(), auth.HashToken(r.Config, unlockToken))
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue locking user", err)
+	}
+	if !lockedNow {
+		return status, nil
+	}
+
+	logger.Warn("Account locked after too many failed attempts", "userID", user.IDHex(), "scope", scope)
+	if err = mail.SendAccountLockedEmail(ctx, r, user.Email, unlockToken); err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("error sending account locked email", err)
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccountLock,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"scope": string(scope)},
+	}); err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording audit event", err)
+	}
+	return status, nil
+}
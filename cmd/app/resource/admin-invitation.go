@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"github.com/pquerna/otp"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAdminInvitationResource(group *gin.RouterGroup) {
+	group.GET("accept-admin-invitation", ginext.WrapTempl(AdminInvitationPage))
+	group.POST("accept-admin-invitation", ginext.WrapTempl(AcceptAdminInvitation))
+}
+
+type AdminInvitationTokenTO struct {
+	Token string `form:"token"`
+}
+
+// AdminInvitationPage lets an invited admin choose a password and enroll the mandatory second factor
+func AdminInvitationPage(ctx *gin.Context, r *dm.RequestContext, requestTO AdminInvitationTokenTO) (templ.Component, error) {
+	logger := r.Logger
+
+	invitedUser, err := users.GetUserForAdminInvitationTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching invited user", err)
+	}
+	if !invitedUser.IsPresent() {
+		logger.Info("Admin invitation page with invalid or expired token")
+		return render.FullPage(ctx, "Admin invitation", admin.AdminInvitationInvalid()), nil
+	}
+
+	// Reopening the page, or a mail scanner opening it first, must not replace a secret the admin enrolled already
+	var key *otp.Key
+	if invitedUser.TemporarySecondFactorToken != "" {
+		key, err = auth.SecondFactorKeyForSecret(r.Config, invitedUser.Email, invitedUser.TemporarySecondFactorToken)
+		if err != nil {
+			return nil, errs.Wrap("issue rebuilding second factor key", err)
+		}
+	} else {
+		key, err = auth.GenerateSecondFactorKey(r.Config, invitedUser.Email)
+		if err != nil {
+			return nil, errs.Wrap("issue generating second factor key", err)
+		}
+		if err = users.SetTemporarySecondFactorToken(ctx, r.Database, invitedUser.ID(), key.Secret()); err != nil {
+			return nil, errs.Wrap("issue persisting temporary second factor token", err)
+		}
+	}
+	qrCode, err := auth.MakeQRCodeDataURI(key)
+	if err != nil {
+		return nil, errs.Wrap("issue making qr code", err)
+	}
+
+	logger.Info("Admin invitation opened", "userID", invitedUser.IDHex())
+	return render.FullPage(ctx, "Admin invitation", admin.AdminInvitation(requestTO.Token, invitedUser.Email, qrCode, key.Secret())), nil
+}
+
+type AcceptAdminInvitationTO struct {
+	Token    string `form:"token"`
+	Password string `form:"password"`
+	Code     string `form:"code"`
+}
+
+func AcceptAdminInvitation(ctx *gin.Context, r *dm.RequestContext, requestTO AcceptAdminInvitationTO) (templ.Component, error) {
+	logger := r.Logger
+
+	invitedUser, err := users.GetUserForAdminInvitationTokenHash(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching invited user", err)
+	}
+	if !invitedUser.IsPresent() {
+		logger.Info("Admin invitation accepted with invalid or expired token")
+		ginext.HXRetarget(ctx, "#admin-invitation")
+		return admin.AdminInvitationInvalid(), nil
+	}
+	if invitedUser.TemporarySecondFactorToken == "" {
+		logger.Info("Admin invitation accepted without second factor enrollment", "userID", invitedUser.IDHex())
+		return admin.AdminInvitationError("No two-factor setup in progress. Please open the invitation link again."), nil
+	}
+
+	if violations := auth.CheckPasswordPolicy(r.Config, []byte(requestTO.Password), invitedUser.Email, invitedUser.Name); len(violations) > 0 {
+		logger.Info("Admin invitation with password rejected by policy", "violations", violations)
+		return admin.AdminInvitationPasswordRejected(violations), nil
+	}
+
+	if !auth.VerifySecondFactorCode(requestTO.Code, invitedUser.TemporarySecondFactorToken) {
+		logger.Info("Admin invitation with wrong second factor code", "userID", invitedUser.IDHex())
+		return admin.AdminInvitationError("Invalid code"), nil
+	}
+
+	credentials, err := auth.MakeCredentials(r.Config, []byte(requestTO.Password))
+	if err != nil {
+		return nil, errs.Wrap("issue making credentials", err)
+	}
+
+	recoveryCodes, recoveryCodeHashes := auth.MakeRecoveryCodes()
+	if err = users.AcceptAdminInvitation(ctx, r.Database, invitedUser.ID(), credentials, invitedUser.TemporarySecondFactorToken, recoveryCodeHashes); err != nil {
+		return nil, errs.Wrap("issue accepting admin invitation", err)
+	}
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  invitedUser.ObjectID,
+		Details: map[string]string{"reason": "admin-invitation-accepted"},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	logger.Info("Admin invitation accepted", "userID", invitedUser.IDHex())
+	ginext.HXRetarget(ctx, "#admin-invitation")
+	return admin.AdminInvitationAccepted(recoveryCodes), nil
+}
@@ -0,0 +1,140 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/middleware"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+const adminUsersPageSize = 20
+
+func RegisterAdminUsersResource(group *gin.RouterGroup) {
+	group.GET("users", ginext.WrapTempl(AdminUsersPage))
+	group.GET("users/:userID", ginext.WrapTemplWithoutPayload(AdminUserPage))
+	group.POST("users/:userID/send-password-reset", middleware.RequirePermission(dm.PermissionMailResend), ginext.WrapTemplWithoutPayload(AdminSendPasswordReset))
+	group.POST("users/:userID/force-logout", middleware.RequirePermission(dm.PermissionSessionsRevoke), ginext.WrapTemplWithoutPayload(AdminForceLogout))
+	group.POST("users/:userID/mark-email-verified", middleware.RequirePermission(dm.PermissionUsersVerify), ginext.WrapTemplWithoutPayload(AdminMarkEmailVerified))
+}
+
+type AdminUserSearchTO struct {
+	Query string `form:"q"`
+	Page  int    `form:"page"`
+}
+
+func AdminUsersPage(ctx *gin.Context, r *dm.RequestContext, requestTO AdminUserSearchTO) (templ.Component, error) {
+	page := max(requestTO.Page, 0)
+
+	foundUsers, total, err := users.SearchUsers(ctx, r.Database, requestTO.Query, page, adminUsersPageSize)
+	if err != nil {
+		return nil, errs.Wrap("issue searching users", err)
+	}
+
+	pageCount := int((total + adminUsersPageSize - 1) / adminUsersPageSize)
+	return render.FullPage(ctx, "Users", admin.Users(requestTO.Query, foundUsers, page, pageCount)), nil
+}
+
+func AdminUserPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user, err := getAdminTargetUser(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !user.IsPresent() {
+		return nil, nil
+	}
+
+	details, err := makeAdminUserDetails(ctx, r, user, "")
+	if err != nil {
+		return nil, errs.Wrap("issue making user details", err)
+	}
+	return render.FullPage(ctx, user.Email, admin.UserPage(details)), nil
+}
+
+func AdminSendPasswordReset(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user, err := getAdminTargetUser(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !user.IsPresent() {
+		return nil, nil
+	}
+
+	if err = sendPasswordReset(ctx, r, user); err != nil {
+		return nil, errs.Wrap("issue sending password reset", err)
+	}
+
+	r.Logger.Info("Admin sent password reset", "targetUserID", user.IDHex())
+	return makeAdminUserDetails(ctx, r, user, "Password reset email sent.")
+}
+
+func AdminForceLogout(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user, err := getAdminTargetUser(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !user.IsPresent() {
+		return nil, nil
+	}
+
+	if err = auth.DeleteSessionsForUser(ctx, r.Database, user.ID()); err != nil {
+		return nil, errs.Wrap("issue deleting sessions", err)
+	}
+
+	r.Logger.Info("Admin forced logout", "targetUserID", user.IDHex())
+	return makeAdminUserDetails(ctx, r, user, "All sessions have been revoked.")
+}
+
+func AdminMarkEmailVerified(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user, err := getAdminTargetUser(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !user.IsPresent() {
+		return nil, nil
+	}
+
+	if err = users.SetEmailToVerified(ctx, r.Database, user.ID()); err != nil {
+		return nil, errs.Wrap("issue setting email to verified", err)
+	}
+	user.EmailVerified = true
+
+	r.Logger.Info("Admin marked email as verified", "targetUserID", user.IDHex())
+	return makeAdminUserDetails(ctx, r, user, "Email marked as verified.")
+}
+
+// getAdminTargetUser loads the user named in the path, responding with 404 if there is none
+func getAdminTargetUser(ctx *gin.Context, r *dm.RequestContext) (dm.User, error) {
+	userID, err := primitive.ObjectIDFromHex(ctx.Param("userID"))
+	if err != nil {
+		r.Logger.Info("Admin request with invalid user ID")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return dm.User{}, nil
+	}
+
+	user, err := users.GetUserForID(ctx, r.Database, dm.UserID(userID))
+	if err != nil {
+		return dm.User{}, errs.Wrap("issue loading user", err)
+	}
+	if !user.IsPresent() {
+		r.Logger.Info("Admin request for non-existent user")
+		ctx.AbortWithStatus(http.StatusNotFound)
+	}
+	return user, nil
+}
+
+func makeAdminUserDetails(ctx *gin.Context, r *dm.RequestContext, user dm.User, message string) (templ.Component, error) {
+	sessions, err := auth.GetSessionsForUser(ctx, r.Database, user.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue loading sessions", err)
+	}
+	return admin.UserDetails(user, sessions, message), nil
+}
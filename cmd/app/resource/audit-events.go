@@ -0,0 +1,243 @@
+package resource
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/middleware"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditEventsPageLimit = 100
+	auditEventDateFormat = "2006-01-02"
+)
+
+func RegisterAuditEventsResource(group *gin.RouterGroup) {
+	requireAuditRead := middleware.RequirePermission(dm.PermissionAuditRead)
+
+	group.GET("audit-events", requireAuditRead, ginext.WrapTempl(AuditEventsPage))
+	group.GET("audit-events/export", requireAuditRead, ExportAuditEvents)
+}
+
+type AuditEventFilterTO struct {
+	Type    dm.AuditEventType `form:"type"`
+	Outcome dm.AuditOutcome   `form:"outcome"`
+	Email   string            `form:"email"`
+	From    string            `form:"from"`
+	To      string            `form:"to"`
+	Format  string            `form:"format"`
+}
+
+func AuditEventsPage(ctx *gin.Context, r *dm.RequestContext, requestTO AuditEventFilterTO) (templ.Component, error) {
+	filter, message, err := makeAuditEventFilter(ctx, r, requestTO)
+	if err != nil {
+		return nil, errs.Wrap("issue making audit event filter", err)
+	}
+
+	events := []dm.AuditEvent{}
+	if message == "" {
+		events, err = audit.QueryEvents(ctx, r.Database, filter, auditEventsPageLimit)
+		if err != nil {
+			return nil, errs.Wrap("issue querying audit events", err)
+		}
+	}
+
+	return render.FullPage(ctx, "Audit log", admin.AuditEvents(admin.AuditEventFilterValues{
+		Type:    string(requestTO.Type),
+		Outcome: string(requestTO.Outcome),
+		Email:   requestTO.Email,
+		From:    requestTO.From,
+		To:      requestTO.To,
+	}, events, dm.AuditEventTypes, message)), nil
+}
+
+// ExportAuditEvents streams all events matching the filter as CSV or JSON lines, oldest first
+func ExportAuditEvents(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	var requestTO AuditEventFilterTO
+	if err := ctx.Bind(&requestTO); err != nil {
+		_ = ctx.AbortWithError(http.StatusBadRequest, errs.Wrap("cannot bind to request TO", err))
+		return
+	}
+	if requestTO.Format != "csv" && requestTO.Format != "jsonl" {
+		_ = ctx.AbortWithError(http.StatusBadRequest, errs.Errorf("unsupported export format %s", requestTO.Format))
+		return
+	}
+
+	filter, message, err := makeAuditEventFilter(ctx, r, requestTO)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making audit event filter", err))
+		return
+	}
+	if message != "" {
+		_ = ctx.AbortWithError(http.StatusBadRequest, errs.Error(message))
+		return
+	}
+
+	fileName := "audit-events-" + time.Now().Format(auditEventDateFormat) + "." + requestTO.Format
+	ctx.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	var writeEvent func(event dm.AuditEvent) error
+	var flush func() error
+	if requestTO.Format == "csv" {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(ctx.Writer)
+		if err = writer.Write(auditEventCSVHeader); err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue writing csv header", err))
+			return
+		}
+		writeEvent = func(event dm.AuditEvent) error {
+			return writer.Write(auditEventCSVRecord(event))
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		ctx.Header("Content-Type", "application/jsonl; charset=utf-8")
+		encoder := json.NewEncoder(ctx.Writer)
+		writeEvent = func(event dm.AuditEvent) error {
+			return encoder.Encode(makeAuditEventTO(event))
+		}
+		flush = func() error {
+			return nil
+		}
+	}
+
+	ctx.Status(http.StatusOK)
+	if err = audit.ForEachEvent(ctx, r.Database, filter, writeEvent); err != nil {
+		// the status has already been sent, the export ends early
+		logger.Error("Audit event export failed", "err", err)
+		return
+	}
+	if err = flush(); err != nil {
+		logger.Error("Audit event export failed", "err", err)
+		return
+	}
+	logger.Info("Audit events exported", "format", requestTO.Format)
+}
+
+// makeAuditEventFilter translates the submitted filter. It returns a message instead of a filter if the input is invalid.
+func makeAuditEventFilter(ctx *gin.Context, r *dm.RequestContext, requestTO AuditEventFilterTO) (dm.AuditEventFilter, string, error) {
+	filter := dm.AuditEventFilter{
+		Type:    requestTO.Type,
+		Outcome: requestTO.Outcome,
+	}
+
+	if requestTO.Email != "" {
+		user, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+		if err != nil {
+			return dm.AuditEventFilter{}, "", errs.Wrap("issue fetching user", err)
+		}
+		if !user.IsPresent() {
+			return dm.AuditEventFilter{}, "No user with this email exists.", nil
+		}
+		filter.UserID = user.ObjectID
+	}
+
+	if requestTO.From != "" {
+		from, err := time.Parse(auditEventDateFormat, requestTO.From)
+		if err != nil {
+			return dm.AuditEventFilter{}, "The start date is invalid.", nil
+		}
+		filter.From = from
+	}
+	if requestTO.To != "" {
+		to, err := time.Parse(auditEventDateFormat, requestTO.To)
+		if err != nil {
+			return dm.AuditEventFilter{}, "The end date is invalid.", nil
+		}
+		// the end date is inclusive
+		filter.To = to.AddDate(0, 0, 1)
+	}
+
+	return filter, "", nil
+}
+
+type AuditEventTO struct {
+	ID             string            `json:"id"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	Type           dm.AuditEventType `json:"type"`
+	Outcome        dm.AuditOutcome   `json:"outcome"`
+	ActorID        string            `json:"actorID,omitempty"`
+	SubjectID      string            `json:"subjectID,omitempty"`
+	OrganizationID string            `json:"organizationID,omitempty"`
+	ClientIP       string            `json:"clientIP"`
+	UserAgent      string            `json:"userAgent"`
+	RequestID      string            `json:"requestID"`
+	Details        map[string]string `json:"details,omitempty"`
+}
+
+func makeAuditEventTO(event dm.AuditEvent) AuditEventTO {
+	return AuditEventTO{
+		ID:             event.ObjectID.Hex(),
+		CreatedAt:      event.CreatedAt,
+		Type:           event.Type,
+		Outcome:        event.Outcome,
+		ActorID:        hexOrEmpty(event.ActorID),
+		SubjectID:      hexOrEmpty(event.SubjectID),
+		OrganizationID: hexOrEmpty(event.OrganizationID),
+		ClientIP:       event.ClientIP,
+		UserAgent:      event.UserAgent,
+		RequestID:      event.RequestID,
+		Details:        event.Details,
+	}
+}
+
+var auditEventCSVHeader = []string{"id", "createdAt", "type", "outcome", "actorID", "subjectID", "organizationID", "clientIP", "userAgent", "requestID", "details"}
+
+func auditEventCSVRecord(event dm.AuditEvent) []string {
+	details := ""
+	if len(event.Details) > 0 {
+		encoded, _ := json.Marshal(event.Details)
+		details = string(encoded)
+	}
+	record := []string{
+		event.ObjectID.Hex(),
+		event.CreatedAt.UTC().Format(time.RFC3339),
+		string(event.Type),
+		string(event.Outcome),
+		hexOrEmpty(event.ActorID),
+		hexOrEmpty(event.SubjectID),
+		hexOrEmpty(event.OrganizationID),
+		event.ClientIP,
+		event.UserAgent,
+		event.RequestID,
+		details,
+	}
+	for i, cell := range record {
+		record[i] = escapeCSVFormula(cell)
+	}
+	return record
+}
+
+// escapeCSVFormula prefixes cells that spreadsheet applications would evaluate as a formula, because user agents and
+// details are controlled by the client
+func escapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func hexOrEmpty(id primitive.ObjectID) string {
+	if id == primitive.NilObjectID {
+		return ""
+	}
+	return id.Hex()
+}
@@ -3,7 +3,9 @@ package resource
 import (
 	"net/http"
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/audit"
 	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
@@ -31,11 +33,22 @@ func ChangePassword(ctx *gin.Context, r *dm.RequestContext, requestTO ChangePass
 
 	if !auth.VerifyCredentials(requestTO.OldPassword, user.Credentials) {
 		logger.Info("Password mismatch for user trying to change password", "userID", user.IDHex())
+		if err := audit.Record(ctx, r, dm.AuditEvent{
+			Type:    dm.AuditEventTypePasswordChange,
+			Outcome: dm.AuditOutcomeFailure,
+			Details: map[string]string{"reason": "invalid-password"},
+		}); err != nil {
+			return errs.Wrap("issue recording audit event", err)
+		}
 		ctx.AbortWithStatus(http.StatusBadRequest)
 		return nil
 	}
 
-	newCredentials, err := auth.MakeCredentials(requestTO.NewPassword)
+	if abortOnPasswordPolicyViolations(ctx, r, requestTO.NewPassword, user.Email, user.Name) {
+		return nil
+	}
+
+	newCredentials, err := auth.MakeCredentials(r.Config, requestTO.NewPassword)
 	if err != nil {
 		return errs.Wrap("error making credentials from new password", err)
 	}
@@ -44,5 +57,17 @@ func ChangePassword(ctx *gin.Context, r *dm.RequestContext, requestTO ChangePass
 		return errs.Wrap("issue setting new password hash for user", err)
 	}
 
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"reason": "password-change"},
+	}); err != nil {
+		return errs.Wrap("issue appending ledger entry", err)
+	}
+
+	if err := audit.Record(ctx, r, dm.AuditEvent{Type: dm.AuditEventTypePasswordChange, Outcome: dm.AuditOutcomeSuccess}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+
 	return nil
 }
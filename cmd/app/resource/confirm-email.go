@@ -2,6 +2,7 @@ package resource
 
 import (
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/auth"
 	"user-manager/cmd/app/service/mail"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
@@ -45,11 +46,11 @@ func ConfirmEmail(ctx *gin.Context, r *dm.RequestContext, request EmailConfirmat
 		return EmailConfirmationResponseTO{EmailConfirmationResponseAlreadyConfirmed}, nil
 	}
 
-	if user.EmailVerificationToken == "" {
+	if user.EmailVerificationTokenHash == "" {
 		return EmailConfirmationResponseTO{}, errs.Error("no verification token present on database")
 	}
 
-	if request.Token != user.EmailVerificationToken {
+	if !auth.VerifyTokenHash(r.Config, request.Token, user.EmailVerificationTokenHash) {
 		logger.Info("Invalid email verification token")
 		return EmailConfirmationResponseTO{EmailConfirmationResponseInvalidToken}, nil
 	}
@@ -78,14 +79,12 @@ func RetriggerVerificationEmail(ctx *gin.Context, r *dm.RequestContext) (Retrigg
 		return RetriggerConfirmationEmailResponseTO{Sent: false}, nil
 	}
 
-	if err := users.UpdateUserEmailVerificationToken(ctx, r.Database, user.ID(), random.MakeRandomURLSafeB64(21)); err != nil {
+	verificationToken := random.MakeRandomURLSafeB64(21)
+	if err := users.UpdateUserEmailVerificationTokenHash(ctx, r.Database, user.ID(), auth.HashToken(r.Config, verificationToken)); err != nil {
 		return RetriggerConfirmationEmailResponseTO{}, errs.Wrap("issue updating token", err)
 	}
 
-	if user.EmailVerificationToken == "" {
-		return RetriggerConfirmationEmailResponseTO{}, errs.Errorf("missing email verification token")
-	}
-	if err := mail.SendVerificationEmail(ctx, r, user.Email, user.EmailVerificationToken); err != nil {
+	if err := mail.SendVerificationEmail(ctx, r, user.Email, verificationToken); err != nil {
 		return RetriggerConfirmationEmailResponseTO{}, errs.Wrap("error sending verification email", err)
 	}
 
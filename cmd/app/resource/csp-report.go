@@ -0,0 +1,47 @@
+package resource
+
+import (
+	ginext "user-manager/cmd/app/gin-extensions"
+	dm "user-manager/domain-model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterCspReportResource registers the endpoint browsers send Content-Security-Policy violations to.
+// Browsers send these reports without CSRF tokens, so the group must not use the CSRF middleware.
+func RegisterCspReportResource(group *gin.RouterGroup, path string) {
+	group.POST(path, ginext.WrapEndpointWithoutResponseBody(PostCspReport))
+}
+
+type CspReportTO struct {
+	CspReport CspViolationTO `json:"csp-report"`
+}
+
+type CspViolationTO struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ScriptSample       string `json:"script-sample"`
+	Disposition        string `json:"disposition"`
+}
+
+func PostCspReport(ctx *gin.Context, r *dm.RequestContext, report CspReportTO) error {
+	logger := r.Logger
+
+	violation := report.CspReport
+	logger.Warn("Content-Security-Policy violation",
+		"documentURI", violation.DocumentURI,
+		"violatedDirective", violation.ViolatedDirective,
+		"effectiveDirective", violation.EffectiveDirective,
+		"blockedURI", violation.BlockedURI,
+		"sourceFile", violation.SourceFile,
+		"lineNumber", violation.LineNumber,
+		"scriptSample", violation.ScriptSample,
+		"disposition", violation.Disposition,
+		"userAgent", ctx.GetHeader("User-Agent"))
+	return nil
+}
@@ -0,0 +1,306 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/externallogin"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterExternalLoginResource(group *gin.RouterGroup) {
+	group.GET("external/:provider/login", ginext.WrapTemplWithoutPayload(StartExternalLogin))
+	group.GET("external/:provider/callback", ginext.WrapTemplWithoutPayload(ExternalLoginCallback))
+}
+
+func RegisterExternalIdentityLinkingResource(group *gin.RouterGroup) {
+	group.POST("link-external-identity", ginext.WrapTempl(LinkExternalIdentity))
+	group.POST("unlink-external-identity", ginext.WrapTempl(UnlinkExternalIdentity))
+}
+
+func StartExternalLogin(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+
+	provider, ok := r.Config.ExternalIdentityProviders.Get(ctx.Param("provider"))
+	if !ok {
+		logger.Info("External login with unknown provider", "provider", ctx.Param("provider"))
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	location, err := externallogin.StartExternalLogin(ctx, r.Database, r.Config, provider, dm.ExternalLoginPurposeLogin, dm.UserID(primitive.NilObjectID))
+	if err != nil {
+		return nil, errs.Wrap("issue starting external login", err)
+	}
+	logger.Info("External login started", "provider", provider.Name)
+	ctx.Redirect(http.StatusFound, location)
+	return nil, nil
+}
+
+// ExternalLoginCallback finishes logins and identity linking started here. The provider redirects from another site,
+// so the session cookie is missing and the logged-in user for linking is taken from the stored login instead.
+func ExternalLoginCallback(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+
+	provider, ok := r.Config.ExternalIdentityProviders.Get(ctx.Param("provider"))
+	if !ok {
+		logger.Info("External login callback for unknown provider", "provider", ctx.Param("provider"))
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	login, err := externallogin.FinishExternalLogin(ctx, r.Database, r.Config, provider)
+	if err != nil {
+		return nil, errs.Wrap("issue finishing external login", err)
+	}
+	if !login.IsPresent() {
+		logger.Info("External login callback without matching login", "provider", provider.Name)
+		return render.FullPage(ctx, "Sign-in failed", render.SignInError("The sign-in has expired. Please try again.")), nil
+	}
+	if errorCode := ctx.Query("error"); errorCode != "" {
+		logger.Info("External login declined by provider", "provider", provider.Name, "error", errorCode)
+		return render.FullPage(ctx, "Sign-in failed", render.SignInError("Sign-in with "+provider.DisplayName+" was cancelled.")), nil
+	}
+
+	claims, err := externallogin.RedeemAuthorizationCode(ctx, r.Config, provider, login, ctx.Query("code"))
+	if err != nil {
+		logger.Warn("External login failed verification", "provider", provider.Name, "error", err)
+		return render.FullPage(ctx, "Sign-in failed", render.SignInError("Sign-in with "+provider.DisplayName+" failed. Please try again.")), nil
+	}
+
+	identity := dm.ExternalIdentity{
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now(),
+	}
+	if login.Purpose == dm.ExternalLoginPurposeLink {
+		return finishExternalIdentityLinking(ctx, r, provider, identity, dm.UserID(login.UserID))
+	}
+
+	loginUser, err := users.GetUserForExternalIdentity(ctx, r.Database, provider.Name, claims.Subject)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user for external identity", err)
+	}
+	if !loginUser.IsPresent() && claims.EmailVerified && claims.Email != "" {
+		// Only link by email if both sides verified it, otherwise anyone could take over an account by registering its email at the provider
+		emailUser, err := users.GetUserForEmail(ctx, r.Database, claims.Email)
+		if err != nil {
+			return nil, errs.Wrap("issue fetching user for email", err)
+		}
+		if emailUser.IsPresent() && emailUser.EmailVerified {
+			if err = linkExternalIdentity(ctx, r, emailUser, identity, "verified-email"); err != nil {
+				return nil, errs.Wrap("issue linking external identity", err)
+			}
+			loginUser = emailUser
+		}
+	}
+	method := "external:" + provider.Name
+	if !loginUser.IsPresent() {
+		logger.Info("External login without linked user", "provider", provider.Name)
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:    dm.AuditEventTypeLogin,
+			Outcome: dm.AuditOutcomeFailure,
+			Details: map[string]string{"method": method, "reason": "no-linked-user", "email": claims.Email},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.FullPage(ctx, "Sign-in failed", render.SignInError("No account is linked to this "+provider.DisplayName+" account. "+
+			"Sign in with your password and link it in the settings.")), nil
+	}
+
+	reason := ""
+	switch {
+	case loginUser.IsLocked():
+		reason = "locked"
+	case loginUser.HasPrivilegedRole() && !loginUser.HasSecondFactor():
+		reason = "missing-second-factor"
+	}
+	if reason != "" {
+		logger.Info("External login rejected", "userID", loginUser.IDHex(), "reason", reason)
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeLogin,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: loginUser.ObjectID,
+			Details:   map[string]string{"method": method, "reason": reason},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.FullPage(ctx, "Sign-in failed", render.SignInError("Sign-in is not possible for this account.")), nil
+	}
+
+	session := dm.UserSession{
+		Token:                dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
+		Type:                 dm.UserSessionTypeLogin,
+		RequiresSecondFactor: loginUser.HasSecondFactor(),
+		TimeoutAt:            time.Now().Add(dm.LoginSessionDuration),
+	}
+	if err = auth.InsertSession(ctx, r.Database, r.Config, loginUser.ID(), session); err != nil {
+		return nil, errs.Wrap("error inserting session", err)
+	}
+	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	auth.RotateCsrfToken(ctx, r, session.Token)
+
+	event := dm.AuditEvent{
+		Type:      dm.AuditEventTypeLogin,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: loginUser.ObjectID,
+		Details:   map[string]string{"method": method},
+	}
+	if session.RequiresSecondFactor {
+		event.Details["pending"] = "second-factor"
+	}
+	if err = audit.Record(ctx, r, event); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	if session.RequiresSecondFactor {
+		logger.Info("Login (external) awaiting second factor", "provider", provider.Name)
+		return render.FullPage(ctx, "Login", render.Login2FA()), nil
+	}
+	logger.Info("Login (external)", "provider", provider.Name, "userID", loginUser.IDHex())
+	return render.FullPage(ctx, "Login", render.Continue("/user/home")), nil
+}
+
+func finishExternalIdentityLinking(ctx *gin.Context, r *dm.RequestContext, provider dm.ExternalIdentityProvider, identity dm.ExternalIdentity, userID dm.UserID) (templ.Component, error) {
+	logger := r.Logger
+
+	linkedUser, err := users.GetUserForExternalIdentity(ctx, r.Database, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user for external identity", err)
+	}
+	if linkedUser.IsPresent() {
+		logger.Info("External identity already linked", "provider", provider.Name, "linkedUserID", linkedUser.IDHex())
+		message := "This " + provider.DisplayName + " account is already linked to another account."
+		if linkedUser.ID() == userID {
+			message = "This " + provider.DisplayName + " account is already linked to your account."
+		}
+		return render.FullPage(ctx, "Linking failed", render.SignInError(message)), nil
+	}
+
+	currentUser, err := users.GetUserForID(ctx, r.Database, userID)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("user of external login not found")
+	}
+	if err = linkExternalIdentity(ctx, r, currentUser, identity, "settings"); err != nil {
+		return nil, errs.Wrap("issue linking external identity", err)
+	}
+	return render.FullPage(ctx, "Settings", render.Continue("/user/settings")), nil
+}
+
+func linkExternalIdentity(ctx *gin.Context, r *dm.RequestContext, linkUser dm.User, identity dm.ExternalIdentity, source string) error {
+	logger := r.Logger
+
+	linked, err := users.AddExternalIdentity(ctx, r.Database, linkUser.ID(), identity)
+	if err != nil {
+		return errs.Wrap("issue adding external identity", err)
+	}
+	if !linked {
+		logger.Info("User already has an identity at provider", "provider", identity.Provider, "userID", linkUser.IDHex())
+		return nil
+	}
+
+	logger.Info("External identity linked", "provider", identity.Provider, "userID", linkUser.IDHex(), "source", source)
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  linkUser.ObjectID,
+		Details: map[string]string{"reason": "external-identity-link", "provider": identity.Provider},
+	}); err != nil {
+		return errs.Wrap("issue appending ledger entry", err)
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeExternalIdentityLink,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: linkUser.ObjectID,
+		Details:   map[string]string{"provider": identity.Provider, "email": identity.Email, "source": source},
+	}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+	return nil
+}
+
+type ExternalIdentityTO struct {
+	Provider string `form:"provider"`
+}
+
+func LinkExternalIdentity(ctx *gin.Context, r *dm.RequestContext, requestTO ExternalIdentityTO) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+
+	provider, ok := r.Config.ExternalIdentityProviders.Get(requestTO.Provider)
+	if !ok {
+		logger.Info("Linking with unknown provider", "provider", requestTO.Provider)
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return nil, nil
+	}
+
+	location, err := externallogin.StartExternalLogin(ctx, r.Database, r.Config, provider, dm.ExternalLoginPurposeLink, currentUser.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue starting external login", err)
+	}
+	logger.Info("External identity linking started", "provider", provider.Name)
+	ginext.HXRedirectOrRedirect(ctx, location)
+	return nil, nil
+}
+
+func UnlinkExternalIdentity(ctx *gin.Context, r *dm.RequestContext, requestTO ExternalIdentityTO) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+
+	if _, linked := currentUser.ExternalIdentity(requestTO.Provider); !linked {
+		logger.Info("Unlinking identity that is not linked", "provider", requestTO.Provider)
+		return user.ExternalIdentitySection(r.Config.ExternalIdentityProviders, currentUser.ExternalIdentities), nil
+	}
+
+	if err := users.RemoveExternalIdentity(ctx, r.Database, currentUser.ID(), requestTO.Provider); err != nil {
+		return nil, errs.Wrap("issue removing external identity", err)
+	}
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  currentUser.ObjectID,
+		Details: map[string]string{"reason": "external-identity-unlink", "provider": requestTO.Provider},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeExternalIdentityUnlink,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: currentUser.ObjectID,
+		Details:   map[string]string{"provider": requestTO.Provider},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	var remaining []dm.ExternalIdentity
+	for _, identity := range currentUser.ExternalIdentities {
+		if identity.Provider != requestTO.Provider {
+			remaining = append(remaining, identity)
+		}
+	}
+
+	logger.Info("External identity unlinked", "provider", requestTO.Provider)
+	return user.ExternalIdentitySection(r.Config.ExternalIdentityProviders, remaining), nil
+}
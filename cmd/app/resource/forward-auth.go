@@ -0,0 +1,163 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/roles"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterForwardAuthResource(group *gin.RouterGroup) {
+	group.GET("forward-auth", ginext.WrapTemplWithoutPayload(ForwardAuth))
+}
+
+func RegisterForwardAuthLoginResource(group *gin.RouterGroup) {
+	group.GET("forward-auth/login", ginext.WrapTemplWithoutPayload(ForwardAuthLogin))
+}
+
+// ForwardAuth answers the subrequests of nginx auth_request and Traefik ForwardAuth. The proxy passes the original
+// request in the X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Uri headers, and the forward auth cookie along with it.
+// Unauthenticated requests get a 401, or with ?redirect=true a redirect to the login page for proxies that pass it on.
+func ForwardAuth(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+
+	host := ctx.GetHeader("X-Forwarded-Host")
+	path, _, _ := strings.Cut(ctx.GetHeader("X-Forwarded-Uri"), "?")
+	if path == "" {
+		path = "/"
+	}
+	rule := r.Config.ForwardAuthRules.Match(host, path)
+	if !rule.IsPresent() {
+		logger.Info("Forward auth request without matching rule", "host", host, "path", path)
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return nil, nil
+	}
+
+	user := r.User
+	if !user.IsPresent() {
+		logger.Info("Forward auth request without login session", "host", host)
+		if ctx.Query("redirect") == "true" {
+			ctx.Redirect(http.StatusFound, forwardAuthLoginURL(r.Config, forwardedURL(ctx)))
+			return nil, nil
+		}
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return nil, nil
+	}
+	if !r.Permissions.Has(dm.PermissionAppUse) {
+		logger.Info("Forward auth request of user without app access", "host", host)
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return nil, nil
+	}
+
+	definitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading role definitions", err)
+	}
+	if !rule.Admits(roles.MapRoles(definitions), user.UserRoles) {
+		logger.Info("Forward auth request of user without required role", "host", host, "path", path, "requiredRoles", rule.Roles)
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return nil, nil
+	}
+
+	userRoles := make([]string, len(user.UserRoles))
+	for i, role := range user.UserRoles {
+		userRoles[i] = string(role)
+	}
+	ctx.Header("X-Auth-User", user.IDHex())
+	ctx.Header("X-Auth-Email", user.Email)
+	ctx.Header("X-Auth-Roles", strings.Join(userRoles, ","))
+	ctx.Status(http.StatusOK)
+	return nil, nil
+}
+
+func forwardedURL(ctx *gin.Context) string {
+	scheme := ctx.GetHeader("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.GetHeader("X-Forwarded-Host") + ctx.GetHeader("X-Forwarded-Uri")
+}
+
+func forwardAuthLoginURL(config *dm.Config, returnTo string) string {
+	return config.AppUrl + "/forward-auth/login?" + url.Values{"returnTo": {returnTo}}.Encode()
+}
+
+// ForwardAuthLogin shows the login form and sends the user back to the protected host once logged in. After login,
+// the form reloads this page, which then issues the forward auth cookie and redirects. Only hosts with forward auth
+// rules are accepted as destination.
+func ForwardAuthLogin(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+
+	returnTo, err := url.Parse(ctx.Query("returnTo"))
+	if err != nil || (returnTo.Scheme != "https" && returnTo.Scheme != "http") || !r.Config.ForwardAuthRules.HasHost(returnTo.Host) {
+		logger.Info("Forward auth login with invalid return url", "returnTo", ctx.Query("returnTo"))
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return nil, nil
+	}
+
+	if r.PersonalAccessToken.IsPresent() {
+		logger.Info("Forward auth login with personal access token")
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return nil, nil
+	}
+
+	if !r.User.IsPresent() || !r.Permissions.Has(dm.PermissionAppUse) {
+		return render.FullPage(ctx, "Login", render.LoginForm(r.Config.PasswordlessLoginEnabled, r.Config.MagicLinkLoginEnabled, r.Config.ExternalIdentityProviders)), nil
+	}
+
+	if err = issueForwardAuthSession(ctx, r); err != nil {
+		return nil, errs.Wrap("issue issuing forward auth session", err)
+	}
+
+	logger.Info("Forward auth login, returning to protected host", "host", returnTo.Host)
+	ginext.HXRedirectOrRedirect(ctx, returnTo.String())
+	return nil, nil
+}
+
+// issueForwardAuthSession replaces the browser's forward auth session with one bound to its current login session, so
+// that it ends with a logout or revocation of the login session
+func issueForwardAuthSession(ctx *gin.Context, r *dm.RequestContext) error {
+	loginSessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
+	if err != nil {
+		return errs.Wrap("issue reading login session cookie", err)
+	}
+	loginSession, _, err := auth.GetSessionAndUser(ctx, r.Database, r.Config, loginSessionToken, dm.UserSessionTypeLogin)
+	if err != nil {
+		return errs.Wrap("issue loading login session", err)
+	}
+	if !loginSession.IsPresent() {
+		return errs.Error("forward auth login without login session")
+	}
+
+	previousToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeForwardAuth)
+	if err != nil {
+		return errs.Wrap("issue reading forward auth cookie", err)
+	}
+	if previousToken != "" {
+		if err = auth.DeleteSession(ctx, r.Database, r.Config, previousToken); err != nil {
+			return errs.Wrap("issue deleting previous forward auth session", err)
+		}
+	}
+
+	session := dm.UserSession{
+		Token:          dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
+		Type:           dm.UserSessionTypeForwardAuth,
+		TimeoutAt:      time.Now().Add(dm.LoginSessionDuration),
+		LoginSessionID: loginSession.ObjectID,
+	}
+	if err = auth.InsertSession(ctx, r.Database, r.Config, r.User.ID(), session); err != nil {
+		return errs.Wrap("error inserting forward auth session", err)
+	}
+	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	return nil
+}
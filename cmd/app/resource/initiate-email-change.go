@@ -2,6 +2,8 @@ package resource
 
 import (
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
 	"user-manager/cmd/app/service/mail"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
@@ -33,7 +35,7 @@ func InitiateEmailChange(ctx *gin.Context, r *dm.RequestContext, requestTO Chang
 	logger.Info("Changing user email")
 
 	verificationToken := random.MakeRandomURLSafeB64(21)
-	if err := users.SetNextEmail(ctx, r.Database, user.ID(), nextEmail, verificationToken); err != nil {
+	if err := users.SetNextEmail(ctx, r.Database, user.ID(), nextEmail, auth.HashToken(r.Config, verificationToken)); err != nil {
 		return errs.Wrap("issue setting next email for user", err)
 	}
 
@@ -44,5 +46,13 @@ func InitiateEmailChange(ctx *gin.Context, r *dm.RequestContext, requestTO Chang
 		return errs.Wrap("error sending change notification email", err)
 	}
 
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeEmailChange,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"step": "initiated", "newEmail": nextEmail},
+	}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+
 	return nil
 }
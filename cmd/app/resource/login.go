@@ -1,11 +1,17 @@
 package resource
 
 import (
+	"encoding/json"
 	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 	ginext "user-manager/cmd/app/gin-extensions"
 	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/audit"
 	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/throttling"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
@@ -13,7 +19,6 @@ import (
 	"user-manager/util/random"
 
 	"github.com/gin-gonic/gin"
-	"github.com/pquerna/otp/totp"
 )
 
 func RegisterLoginResource(group *gin.RouterGroup) {
@@ -43,14 +48,48 @@ func PostLogin(ctx *gin.Context, r *dm.RequestContext, requestTO LoginTO) (templ
 	logger := r.Logger
 
 	loginDescription := "Login"
+	auditEventType := dm.AuditEventTypeLogin
 	if requestTO.Sudo {
 		loginDescription = "Sudo-login"
+		auditEventType = dm.AuditEventTypeSudo
 		if !r.User.IsPresent() {
 			logger.Info("Sudo login attempted without valid user.")
 			return render.LoginFormError("Invalid credentials"), nil
 		}
 	}
 
+	ipStatus, err := throttling.GetIPStatus(ctx, r.Database, dm.ThrottlingScopeLogin, ctx.ClientIP())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching throttling status", err)
+	}
+	if ipStatus.IsThrottled() {
+		logger.Info(loginDescription + " attempt from throttled address")
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:    auditEventType,
+			Outcome: dm.AuditOutcomeFailure,
+			Details: map[string]string{"reason": "throttled", "email": requestTO.Email},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.LoginFormThrottled(ipStatus), nil
+	}
+
+	emailStatus, err := throttling.GetEmailStatus(ctx, r.Database, dm.ThrottlingScopeLogin, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching throttling status", err)
+	}
+	if emailStatus.IsThrottled() {
+		logger.Info(loginDescription + " attempt for throttled email")
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:    auditEventType,
+			Outcome: dm.AuditOutcomeFailure,
+			Details: map[string]string{"reason": "throttled", "email": requestTO.Email},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.LoginFormThrottled(emailStatus), nil
+	}
+
 	user, err := users.
 		GetUserForEmail(ctx, r.Database, requestTO.Email)
 	if err != nil {
@@ -58,112 +97,259 @@ func PostLogin(ctx *gin.Context, r *dm.RequestContext, requestTO LoginTO) (templ
 	}
 	if !user.IsPresent() {
 		logger.Info(loginDescription + " attempt for non-existent user")
-		return render.LoginFormError("Invalid credentials"), nil
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:    auditEventType,
+			Outcome: dm.AuditOutcomeFailure,
+			Details: map[string]string{"reason": "unknown-email", "email": requestTO.Email},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return failLoginForEmail(ctx, r, requestTO.Email, primitive.NilObjectID)
 	}
 
-	for _, role := range user.UserRoles {
-		if role != dm.UserRoleUser {
-			logger.Info(loginDescription+" attempt without second factor for non-user", "userID", user.IDHex())
-			return render.LoginFormError("Invalid credentials"), nil
+	throttleStatus, err := throttling.GetStatus(ctx, r.Database, dm.ThrottlingScopeLogin, user, ctx.ClientIP())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching throttling status", err)
+	}
+	if throttleStatus.IsThrottled() {
+		logger.Info(loginDescription+" attempt for throttled user", "userID", user.IDHex(), "locked", throttleStatus.Locked)
+		reason := throttledReason(throttleStatus)
+		if user.IsDeletionScheduled() {
+			// answered like any other locked account, so the password is not needed to learn about the scheduled deletion
+			reason = "deletion-scheduled"
+		}
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      auditEventType,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": reason},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		if throttleStatus.Locked {
+			return failLoginForEmail(ctx, r, requestTO.Email, user.ObjectID)
 		}
+		return render.LoginFormThrottled(throttleStatus), nil
+	}
+
+	if user.HasPrivilegedRole() && !user.HasSecondFactor() {
+		logger.Info(loginDescription+" attempt without second factor for non-user", "userID", user.IDHex())
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      auditEventType,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": "missing-second-factor"},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.LoginFormError("Invalid credentials"), nil
 	}
 
 	if !auth.VerifyCredentials([]byte(requestTO.Password), user.Credentials) {
 		logger.Info("Password mismatch for user", "userID", user.IDHex())
+		if _, err = recordFailedAttempt(ctx, r, dm.ThrottlingScopeLogin, user); err != nil {
+			return nil, errs.Wrap("issue recording failed attempt", err)
+		}
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      auditEventType,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": "invalid-password"},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		// the response only depends on the email counter, just like for unknown emails
+		emailStatus, err = throttling.RecordEmailFailure(ctx, r.Database, dm.ThrottlingScopeLogin, requestTO.Email, user.ObjectID)
+		if err != nil {
+			return nil, errs.Wrap("issue recording failed attempt", err)
+		}
+		if emailStatus.IsThrottled() {
+			return render.LoginFormThrottled(emailStatus), nil
+		}
 		return render.LoginFormError("Invalid credentials"), nil
 	}
 
-	if user.SecondFactorToken != "" {
-		ginext.HXReswap(ctx, "innerHTML")
-		return render.Login2FA(), nil
+	if err = throttling.RecordSuccess(ctx, r.Database, dm.ThrottlingScopeLogin, user.ID()); err != nil {
+		return nil, errs.Wrap("issue resetting throttling", err)
+	}
+	if err = throttling.RecordEmailSuccess(ctx, r.Database, dm.ThrottlingScopeLogin, requestTO.Email); err != nil {
+		return nil, errs.Wrap("issue resetting throttling", err)
+	}
+
+	if auth.NeedsRehash(r.Config, user.Credentials) {
+		credentials, err := auth.MakeCredentials(r.Config, []byte(requestTO.Password))
+		if err != nil {
+			return nil, errs.Wrap("issue rehashing password", err)
+		}
+		if err = users.SetCredentials(ctx, r.Database, user.ID(), credentials); err != nil {
+			return nil, errs.Wrap("issue persisting rehashed password", err)
+		}
+		if err = ledger.Append(ctx, r, dm.LedgerEntry{
+			Type:    dm.LedgerEntryTypeCredentialsChange,
+			UserID:  user.ObjectID,
+			Details: map[string]string{"reason": "rehash", "previousAlgorithm": string(user.Credentials.Algorithm)},
+		}); err != nil {
+			return nil, errs.Wrap("issue appending ledger entry", err)
+		}
+		logger.Info("Password rehashed", "previousAlgorithm", user.Credentials.Algorithm)
 	}
 
-	logger.Info(loginDescription)
 	session := dm.UserSession{
-		Token:     dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
-		Type:      dm.UserSessionTypeLogin,
-		TimeoutAt: time.Now().Add(dm.LoginSessionDuration),
+		Token:                dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
+		Type:                 dm.UserSessionTypeLogin,
+		RequiresSecondFactor: user.HasSecondFactor(),
+		TimeoutAt:            time.Now().Add(dm.LoginSessionDuration),
 	}
 
 	if requestTO.Sudo {
 		session.Type = dm.UserSessionTypeSudo
 		session.TimeoutAt = time.Now().Add(dm.SudoSessionDuration)
 	}
-	if err = auth.InsertSession(ctx, r.Database, user.ID(), session); err != nil {
+	if err = auth.InsertSession(ctx, r.Database, r.Config, user.ID(), session); err != nil {
 		return nil, errs.Wrap("error inserting session", err)
 	}
 
 	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	if session.Type == dm.UserSessionTypeLogin {
+		auth.RotateCsrfToken(ctx, r, session.Token)
+	}
+
+	event := dm.AuditEvent{Type: auditEventType, Outcome: dm.AuditOutcomeSuccess, SubjectID: user.ObjectID}
+	if session.RequiresSecondFactor {
+		event.Details = map[string]string{"pending": "second-factor"}
+	}
+	if err = audit.Record(ctx, r, event); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	if session.RequiresSecondFactor {
+		logger.Info(loginDescription + " awaiting second factor")
+		ginext.HXReswap(ctx, "innerHTML")
+		return render.Login2FA(), nil
+	}
+
+	logger.Info(loginDescription)
 	// Reload current URL (it should now pass the required role check)
 	ginext.HXReload(ctx)
 	return nil, nil
 }
 
 type SecondFactorTO struct {
-	SecondFactor   string `json:"secondFactor"`
-	RememberDevice bool   `json:"rememberDevice"`
-	Sudo           bool   `json:"sudo"`
+	SecondFactor   string          `json:"secondFactor"`
+	RecoveryCode   string          `json:"recoveryCode"`
+	Passkey        json.RawMessage `json:"passkey,omitempty"`
+	RememberDevice bool            `json:"rememberDevice"`
+	Sudo           bool            `json:"sudo"`
 }
 
 type LoginWithSecondFactorResponseTO struct {
 	LoggedIn     bool      `json:"loggedIn"`
 	TimeoutUntil time.Time `json:"timeoutUntil,omitempty"`
+	Locked       bool      `json:"locked,omitempty"`
 }
 
-func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactorTO) (LoginWithSecondFactorResponseTO, error) {
+// getUserAwaitingSecondFactor returns the user whose login or sudo session is still waiting for the second factor
+func getUserAwaitingSecondFactor(ctx *gin.Context, r *dm.RequestContext, sudo bool) (dm.User, dm.UserSessionToken, error) {
 	logger := r.Logger
 
 	loginDescription := "Login (2FA)"
 	sessionType := dm.UserSessionTypeLogin
-	if requestTO.Sudo {
+	if sudo {
 		loginDescription = "Sudo-login (2FA)"
 		sessionType = dm.UserSessionTypeSudo
 	}
 	sessionToken, err := auth.GetSessionCookie(ctx, sessionType)
 
 	if err != nil {
-		return LoginWithSecondFactorResponseTO{}, errs.Wrap("error getting session cookie for second factor auth", err)
+		return dm.User{}, "", errs.Wrap("error getting session cookie for second factor auth", err)
 	}
 	if sessionToken == "" {
 		logger.Info(loginDescription + " attempt without session token")
-		return LoginWithSecondFactorResponseTO{}, nil
+		return dm.User{}, "", nil
 	}
 
-	user, err := auth.GetUserForSessionForSecondFactorVerification(ctx, r.Database, sessionToken, sessionType)
+	user, err := auth.GetUserForSessionForSecondFactorVerification(ctx, r.Database, r.Config, sessionToken, sessionType)
 	if err != nil {
-		return LoginWithSecondFactorResponseTO{}, errs.Wrap("error fetching user for session token", err)
+		return dm.User{}, "", errs.Wrap("error fetching user for session token", err)
 	}
 	if !user.IsPresent() {
 		logger.Info(loginDescription + " attempt for non-existent user")
-		return LoginWithSecondFactorResponseTO{}, nil
+		return dm.User{}, "", nil
 	}
 
-	if requestTO.SecondFactor != "" {
-		throttling := user.SecondFactorThrottling
+	return user, sessionToken, nil
+}
+
+func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactorTO) (LoginWithSecondFactorResponseTO, error) {
+	logger := r.Logger
+
+	user, sessionToken, err := getUserAwaitingSecondFactor(ctx, r, requestTO.Sudo)
+	if err != nil {
+		return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue fetching user awaiting second factor", err)
+	}
+	if !user.IsPresent() {
+		return LoginWithSecondFactorResponseTO{}, nil
+	}
 
-		if throttling.FailuresSinceSuccess != 0 && throttling.TimeoutUntil.After(time.Now()) {
-			logger.Info("Throttled 2FA attempted")
-			return LoginWithSecondFactorResponseTO{TimeoutUntil: throttling.TimeoutUntil}, nil
+	if requestTO.SecondFactor != "" || requestTO.RecoveryCode != "" || len(requestTO.Passkey) != 0 {
+		throttleStatus, err := throttling.GetStatus(ctx, r.Database, dm.ThrottlingScopeSecondFactor, user, ctx.ClientIP())
+		if err != nil {
+			return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue fetching throttling status", err)
+		}
+		if throttleStatus.IsThrottled() {
+			logger.Info("Throttled 2FA attempted", "locked", throttleStatus.Locked)
+			if err = audit.Record(ctx, r, dm.AuditEvent{
+				Type:      dm.AuditEventTypeSecondFactor,
+				Outcome:   dm.AuditOutcomeFailure,
+				SubjectID: user.ObjectID,
+				Details:   map[string]string{"reason": throttledReason(throttleStatus)},
+			}); err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue recording audit event", err)
+			}
+			return LoginWithSecondFactorResponseTO{TimeoutUntil: throttleStatus.BlockedUntil, Locked: throttleStatus.Locked}, nil
 		}
 
-		tokenMatches := user.SecondFactorToken != "" && totp.Validate(requestTO.SecondFactor, user.SecondFactorToken)
+		var tokenMatches bool
+		if len(requestTO.Passkey) != 0 {
+			tokenMatches, err = verifyPasskeySecondFactor(ctx, r, user, requestTO.Passkey)
+			if err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue verifying passkey", err)
+			}
+		} else if requestTO.RecoveryCode != "" {
+			updatedUser, err := users.ConsumeSecondFactorRecoveryCode(ctx, r.Database, user.ID(), auth.HashRecoveryCode(requestTO.RecoveryCode))
+			if err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue consuming recovery code", err)
+			}
+			tokenMatches = updatedUser.IsPresent()
+			if tokenMatches {
+				logger.Info("Recovery code consumed")
+				if err := mail.SendRecoveryCodeUsedEmail(ctx, r, updatedUser.Email, updatedUser.Name, len(updatedUser.SecondFactorRecoveryCodes)); err != nil {
+					return LoginWithSecondFactorResponseTO{}, errs.Wrap("error sending recovery code used email", err)
+				}
+			}
+		} else {
+			tokenMatches = auth.VerifySecondFactorCode(requestTO.SecondFactor, user.SecondFactorToken)
+		}
 
 		if tokenMatches {
-			if err := auth.UpdateSecondFactorThrottling(ctx, r.Database, user.ID(), 0, nil); err != nil {
-				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue resetting throttling in db", err)
+			if err := throttling.RecordSuccess(ctx, r.Database, dm.ThrottlingScopeSecondFactor, user.ID()); err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue resetting throttling", err)
 			}
 		} else {
-			var maybeTimeoutUntil *time.Time
-			failedAttemptsSinceLastSuccess := user.SecondFactorThrottling.FailuresSinceSuccess + 1
-			// TODO: Check this exponential timeout logic
-			if failedAttemptsSinceLastSuccess%5 == 0 {
-				*maybeTimeoutUntil = time.Now().Add(time.Minute * 3 * time.Duration(failedAttemptsSinceLastSuccess))
-			}
-			if err := auth.UpdateSecondFactorThrottling(ctx, r.Database, user.ID(), failedAttemptsSinceLastSuccess, maybeTimeoutUntil); err != nil {
-				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue updating throttling in db", err)
+			throttleStatus, err = recordFailedAttempt(ctx, r, dm.ThrottlingScopeSecondFactor, user)
+			if err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue recording failed attempt", err)
 			}
 			logger.Info("2FA mismatch")
-			return LoginWithSecondFactorResponseTO{}, nil
+			if err = audit.Record(ctx, r, dm.AuditEvent{
+				Type:      dm.AuditEventTypeSecondFactor,
+				Outcome:   dm.AuditOutcomeFailure,
+				SubjectID: user.ObjectID,
+				Details:   map[string]string{"reason": "mismatch", "method": secondFactorMethod(requestTO)},
+			}); err != nil {
+				return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue recording audit event", err)
+			}
+			return LoginWithSecondFactorResponseTO{TimeoutUntil: throttleStatus.BlockedUntil, Locked: throttleStatus.Locked}, nil
 		}
 
 		if requestTO.RememberDevice {
@@ -173,7 +359,7 @@ func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactor
 				Type:      dm.UserSessionTypeRememberDevice,
 				TimeoutAt: time.Now().Add(dm.DeviceSessionDuration),
 			}
-			err = auth.InsertSession(ctx, r.Database, user.ID(), deviceSession)
+			err = auth.InsertSession(ctx, r.Database, r.Config, user.ID(), deviceSession)
 			if err != nil {
 				return LoginWithSecondFactorResponseTO{}, errs.Wrap("error inserting device session", err)
 			}
@@ -181,7 +367,15 @@ func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactor
 			auth.SetSessionCookie(ctx, r.Config, string(deviceSession.Token), deviceSession.Type)
 		}
 
-		logger.Info("Login passed with 2FA token")
+		logger.Info("Login passed with 2FA token, passkey or recovery code")
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeSecondFactor,
+			Outcome:   dm.AuditOutcomeSuccess,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"method": secondFactorMethod(requestTO)},
+		}); err != nil {
+			return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
 	} else {
 		maybeDeviceSessionID, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
 		if err != nil {
@@ -191,7 +385,7 @@ func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactor
 			return LoginWithSecondFactorResponseTO{}, nil
 		}
 
-		deviceSession, err := auth.GetUserForSession(ctx, r.Database, maybeDeviceSessionID, dm.UserSessionTypeRememberDevice)
+		deviceSession, err := auth.GetUserForSession(ctx, r.Database, r.Config, maybeDeviceSessionID, dm.UserSessionTypeRememberDevice)
 
 		if err != nil {
 			return LoginWithSecondFactorResponseTO{}, errs.Wrap("fetching device session failed", err)
@@ -200,11 +394,52 @@ func SecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactor
 			return LoginWithSecondFactorResponseTO{}, nil
 		}
 		logger.Info("Login passed with device token cookie")
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeSecondFactor,
+			Outcome:   dm.AuditOutcomeSuccess,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"method": "remembered-device"},
+		}); err != nil {
+			return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
 	}
 
-	if err = auth.SetSecondFactorVerifiedForSession(ctx, r.Database, sessionToken); err != nil {
+	if err = auth.SetSecondFactorVerifiedForSession(ctx, r.Database, r.Config, sessionToken); err != nil {
 		return LoginWithSecondFactorResponseTO{}, errs.Wrap("issue setting second factor verified in db", err)
 	}
 
 	return LoginWithSecondFactorResponseTO{LoggedIn: true}, nil
 }
+
+func secondFactorMethod(requestTO SecondFactorTO) string {
+	switch {
+	case len(requestTO.Passkey) != 0:
+		return "passkey"
+	case requestTO.RecoveryCode != "":
+		return "recovery-code"
+	}
+	return "totp"
+}
+
+// failLoginForEmail responds the same way for unknown emails and locked accounts, so that the response does not reveal
+// whether an account exists. The failure counts for the address and the submitted email.
+func failLoginForEmail(ctx *gin.Context, r *dm.RequestContext, email string, userID primitive.ObjectID) (templ.Component, error) {
+	if _, err := throttling.RecordIPFailure(ctx, r.Database, dm.ThrottlingScopeLogin, ctx.ClientIP()); err != nil {
+		return nil, errs.Wrap("issue recording failed attempt", err)
+	}
+	status, err := throttling.RecordEmailFailure(ctx, r.Database, dm.ThrottlingScopeLogin, email, userID)
+	if err != nil {
+		return nil, errs.Wrap("issue recording failed attempt", err)
+	}
+	if status.IsThrottled() {
+		return render.LoginFormThrottled(status), nil
+	}
+	return render.LoginFormError("Invalid credentials"), nil
+}
+
+func throttledReason(status dm.ThrottleStatus) string {
+	if status.Locked {
+		return "locked"
+	}
+	return "throttled"
+}
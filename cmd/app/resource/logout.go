@@ -2,6 +2,7 @@ package resource
 
 import (
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/audit"
 	"user-manager/cmd/app/service/auth"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
@@ -21,6 +22,11 @@ func Logout(ctx *gin.Context, r *dm.RequestContext, request LogoutTO) error {
 	logger := r.Logger
 
 	logger.Info("Logout")
+	if r.User.IsPresent() {
+		if err := audit.Record(ctx, r, dm.AuditEvent{Type: dm.AuditEventTypeLogout, Outcome: dm.AuditOutcomeSuccess}); err != nil {
+			return errs.Wrap("issue recording audit event", err)
+		}
+	}
 
 	err := forgetSession(ctx, r, dm.UserSessionTypeLogin)
 	if err != nil {
@@ -32,6 +38,11 @@ func Logout(ctx *gin.Context, r *dm.RequestContext, request LogoutTO) error {
 		return errs.Wrap("issue while forgetting sudo session", err)
 	}
 
+	err = forgetSession(ctx, r, dm.UserSessionTypeForwardAuth)
+	if err != nil {
+		return errs.Wrap("issue while forgetting forward auth session", err)
+	}
+
 	if request.ForgetDevice {
 		err := forgetSession(ctx, r, dm.UserSessionTypeRememberDevice)
 		if err != nil {
@@ -39,6 +50,7 @@ func Logout(ctx *gin.Context, r *dm.RequestContext, request LogoutTO) error {
 		}
 	}
 
+	auth.RotateCsrfToken(ctx, r, "")
 	return nil
 }
 
@@ -49,7 +61,7 @@ func forgetSession(ctx *gin.Context, r *dm.RequestContext, sessionType dm.UserSe
 	}
 	if sessionToken != "" {
 		auth.RemoveSessionCookie(ctx, r.Config, sessionType)
-		if err := auth.DeleteSession(ctx, r.Database, sessionToken); err != nil {
+		if err := auth.DeleteSession(ctx, r.Database, r.Config, sessionToken); err != nil {
 			return errs.Wrap("issue while deleting session", err)
 		}
 	}
@@ -0,0 +1,154 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"net/http"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterMagicLinkRequestResource(group *gin.RouterGroup) {
+	group.POST("request-magic-link", ginext.WrapTempl(RequestMagicLink))
+}
+
+func RegisterMagicLinkLoginResource(group *gin.RouterGroup) {
+	group.GET("magic-link", ginext.WrapTempl(MagicLinkPage))
+	group.POST("magic-link-login", ginext.WrapTempl(MagicLinkLogin))
+}
+
+type MagicLinkRequestTO struct {
+	Email string `form:"email" json:"email"`
+}
+
+// RequestMagicLink answers the same whether the email belongs to an account or not, so it cannot be used to find out registered addresses
+func RequestMagicLink(ctx *gin.Context, r *dm.RequestContext, requestTO MagicLinkRequestTO) (templ.Component, error) {
+	logger := r.Logger
+
+	if !r.Config.MagicLinkLoginEnabled {
+		logger.Info("Magic link requested while disabled")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	component := render.LoginFormInfo("If an account exists for this email, a sign-in link is on its way.")
+	user, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("error finding user for email", err)
+	}
+	if !user.IsPresent() {
+		logger.Info("Magic link request for non-existing email")
+		return component, nil
+	}
+	if user.IsLocked() {
+		logger.Info("Magic link request for locked user", "userID", user.IDHex())
+		return component, nil
+	}
+
+	token := random.MakeRandomURLSafeB64(21)
+	if err = users.SetMagicLinkTokenHash(ctx, r.Database, user.ID(), auth.HashToken(r.Config, token), time.Now().Add(dm.MagicLinkTokenDuration)); err != nil {
+		return nil, errs.Wrap("issue persisting magic link token", err)
+	}
+	if err = mail.SendMagicLinkEmail(ctx, r, user.Email, user.Name, token); err != nil {
+		return nil, errs.Wrap("error sending magic link email", err)
+	}
+
+	logger.Info("Magic link sent", "userID", user.IDHex())
+	return component, nil
+}
+
+type MagicLinkLoginTO struct {
+	Token string `form:"token" json:"token"`
+}
+
+// MagicLinkPage asks for confirmation first, so that mail scanners following the link do not use up the single-use token
+func MagicLinkPage(ctx *gin.Context, r *dm.RequestContext, requestTO MagicLinkLoginTO) (templ.Component, error) {
+	if !r.Config.MagicLinkLoginEnabled {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	return render.FullPage(ctx, "Sign in", render.MagicLinkLogin(requestTO.Token)), nil
+}
+
+func MagicLinkLogin(ctx *gin.Context, r *dm.RequestContext, requestTO MagicLinkLoginTO) (templ.Component, error) {
+	logger := r.Logger
+
+	if !r.Config.MagicLinkLoginEnabled {
+		logger.Info("Magic link login attempted while disabled")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	user, err := users.ConsumeMagicLinkToken(ctx, r.Database, auth.HashToken(r.Config, requestTO.Token))
+	if err != nil {
+		return nil, errs.Wrap("issue consuming magic link token", err)
+	}
+	if !user.IsPresent() {
+		logger.Info("Magic link login with invalid or expired token")
+		return render.MagicLinkInvalid(), nil
+	}
+
+	reason := ""
+	switch {
+	case user.IsLocked():
+		reason = "locked"
+	case user.HasPrivilegedRole() && !user.HasSecondFactor():
+		reason = "missing-second-factor"
+	}
+	if reason != "" {
+		logger.Info("Magic link login rejected", "userID", user.IDHex(), "reason", reason)
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeLogin,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"method": "magic-link", "reason": reason},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return render.MagicLinkInvalid(), nil
+	}
+
+	session := dm.UserSession{
+		Token:                dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
+		Type:                 dm.UserSessionTypeLogin,
+		RequiresSecondFactor: user.HasSecondFactor(),
+		TimeoutAt:            time.Now().Add(dm.LoginSessionDuration),
+	}
+	if err = auth.InsertSession(ctx, r.Database, r.Config, user.ID(), session); err != nil {
+		return nil, errs.Wrap("error inserting session", err)
+	}
+	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	auth.RotateCsrfToken(ctx, r, session.Token)
+
+	event := dm.AuditEvent{
+		Type:      dm.AuditEventTypeLogin,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"method": "magic-link"},
+	}
+	if session.RequiresSecondFactor {
+		event.Details["pending"] = "second-factor"
+	}
+	if err = audit.Record(ctx, r, event); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	if session.RequiresSecondFactor {
+		logger.Info("Login (magic link) awaiting second factor")
+		return render.Login2FA(), nil
+	}
+
+	logger.Info("Login (magic link)", "userID", user.IDHex())
+	ginext.HXLocationOrRedirect(ctx, "/user/home")
+	return nil, nil
+}
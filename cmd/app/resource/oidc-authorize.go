@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/a-h/templ"
+	"net/url"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/oidc"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterOidcAuthorizationResource(group *gin.RouterGroup) {
+	group.GET("authorize", ginext.WrapTempl(Authorize))
+	group.POST("authorize", ginext.WrapTempl(AuthorizeWithConsent))
+}
+
+type AuthorizationRequestTO struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state,omitempty"`
+	Nonce               string `form:"nonce" json:"nonce,omitempty"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Prompt              string `form:"prompt" json:"prompt,omitempty"`
+}
+
+type AuthorizationConsentTO struct {
+	AuthorizationRequestTO
+	Decision string `form:"decision"`
+}
+
+// Authorize implements the authorization endpoint of the authorization code flow. PKCE (S256) is required for all clients.
+// It reuses the login session of the app, so users who are logged in only see the consent screen, and only for scopes they have not granted before.
+func Authorize(ctx *gin.Context, r *dm.RequestContext, requestTO AuthorizationRequestTO) (templ.Component, error) {
+	logger := r.Logger
+
+	client, scopes, valid, component, err := validateAuthorizationRequest(ctx, r, requestTO)
+	if !valid {
+		return component, err
+	}
+
+	if !r.User.IsPresent() {
+		if ctx.GetHeader("Sec-Fetch-Site") == "cross-site" {
+			logger.Info("Authorization request from another site, reloading to receive the session cookie")
+			return render.FullPage(ctx, "Sign in", render.Continue(ctx.Request.URL.RequestURI())), nil
+		}
+		if requestTO.Prompt == "none" {
+			redirectWithAuthorizationError(ctx, requestTO, "login_required")
+			return nil, nil
+		}
+		logger.Info("Authorization request without login session")
+		return render.FullPage(ctx, "Login", render.LoginForm(r.Config.PasswordlessLoginEnabled, r.Config.MagicLinkLoginEnabled, r.Config.ExternalIdentityProviders)), nil
+	}
+	if !r.Permissions.Has(dm.PermissionAppUse) {
+		logger.Info("Authorization request of user without app access")
+		redirectWithAuthorizationError(ctx, requestTO, "access_denied")
+		return nil, nil
+	}
+
+	consent, err := oidc.GetConsent(ctx, r.Database, r.User.ID(), client.ClientID)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching consent", err)
+	}
+	if consent.Covers(scopes) && requestTO.Prompt != "consent" {
+		if err = redirectWithAuthorizationCode(ctx, r, requestTO, scopes); err != nil {
+			return nil, errs.Wrap("issue issuing authorization code", err)
+		}
+		return nil, nil
+	}
+	if requestTO.Prompt == "none" {
+		redirectWithAuthorizationError(ctx, requestTO, "consent_required")
+		return nil, nil
+	}
+
+	requestValues, err := json.Marshal(requestTO)
+	if err != nil {
+		return nil, errs.Wrap("issue marshalling authorization request", err)
+	}
+	return render.FullPage(ctx, "Sign in to "+client.Name, render.OidcConsent(client.Name, scopes, string(requestValues))), nil
+}
+
+func AuthorizeWithConsent(ctx *gin.Context, r *dm.RequestContext, requestTO AuthorizationConsentTO) (templ.Component, error) {
+	logger := r.Logger
+
+	client, scopes, valid, component, err := validateAuthorizationRequest(ctx, r, requestTO.AuthorizationRequestTO)
+	if !valid {
+		return component, err
+	}
+	if !r.User.IsPresent() || !r.Permissions.Has(dm.PermissionAppUse) {
+		logger.Info("Consent without login session or app access")
+		redirectWithAuthorizationError(ctx, requestTO.AuthorizationRequestTO, "access_denied")
+		return nil, nil
+	}
+
+	if requestTO.Decision != "allow" {
+		logger.Info("Consent denied", "clientID", client.ClientID)
+		redirectWithAuthorizationError(ctx, requestTO.AuthorizationRequestTO, "access_denied")
+		return nil, nil
+	}
+
+	if err = oidc.GrantConsent(ctx, r.Database, r.User.ID(), client.ClientID, scopes); err != nil {
+		return nil, errs.Wrap("issue granting consent", err)
+	}
+	logger.Info("Consent granted", "clientID", client.ClientID)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeOidcConsent,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: r.User.ObjectID,
+		Details:   map[string]string{"clientID": client.ClientID, "scope": oidc.FormatScopes(scopes)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	if err = redirectWithAuthorizationCode(ctx, r, requestTO.AuthorizationRequestTO, scopes); err != nil {
+		return nil, errs.Wrap("issue issuing authorization code", err)
+	}
+	return nil, nil
+}
+
+// validateAuthorizationRequest returns the client and requested scopes of a valid request.
+// Without a valid client and redirect URI the error is shown to the user (RFC 6749, section 4.1.2.1), all other errors are sent to the client.
+func validateAuthorizationRequest(ctx *gin.Context, r *dm.RequestContext, requestTO AuthorizationRequestTO) (dm.OidcClient, []dm.OidcScope, bool, templ.Component, error) {
+	logger := r.Logger
+
+	client, err := oidc.GetClient(ctx, r.Database, requestTO.ClientID)
+	if err != nil {
+		return dm.OidcClient{}, nil, false, nil, errs.Wrap("issue fetching oidc client", err)
+	}
+	if !client.IsPresent() || !client.AllowsRedirectURI(requestTO.RedirectURI) {
+		logger.Info("Authorization request with unknown client or redirect URI", "clientID", requestTO.ClientID, "redirectURI", requestTO.RedirectURI)
+		return dm.OidcClient{}, nil, false, render.FullPage(ctx, "Sign-in failed", render.SignInError("The application sent an invalid sign-in request.")), nil
+	}
+
+	scopes, openID := oidc.ParseScopes(requestTO.Scope)
+	errorCode := ""
+	switch {
+	case requestTO.ResponseType != "code":
+		errorCode = "unsupported_response_type"
+	case !openID:
+		errorCode = "invalid_scope"
+	case requestTO.CodeChallenge == "" || requestTO.CodeChallengeMethod != "S256":
+		errorCode = "invalid_request"
+	}
+	if errorCode != "" {
+		logger.Info("Invalid authorization request", "clientID", client.ClientID, "error", errorCode)
+		redirectWithAuthorizationError(ctx, requestTO, errorCode)
+		return dm.OidcClient{}, nil, false, nil, nil
+	}
+	return client, scopes, true, nil, nil
+}
+
+func redirectWithAuthorizationCode(ctx *gin.Context, r *dm.RequestContext, requestTO AuthorizationRequestTO, scopes []dm.OidcScope) error {
+	logger := r.Logger
+
+	sessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
+	if err != nil {
+		return errs.Wrap("issue reading session cookie", err)
+	}
+	session, _, err := auth.GetSessionAndUser(ctx, r.Database, r.Config, sessionToken, dm.UserSessionTypeLogin)
+	if err != nil {
+		return errs.Wrap("issue fetching session", err)
+	}
+
+	code, err := oidc.IssueAuthorizationCode(ctx, r.Database, r.Config, dm.OidcAuthorizationCode{
+		ClientID:      requestTO.ClientID,
+		UserID:        r.User.ObjectID,
+		RedirectURI:   requestTO.RedirectURI,
+		Scopes:        scopes,
+		Nonce:         requestTO.Nonce,
+		CodeChallenge: requestTO.CodeChallenge,
+		AuthTime:      session.CreatedAt,
+	})
+	if err != nil {
+		return errs.Wrap("issue storing authorization code", err)
+	}
+
+	logger.Info("Authorization code issued", "clientID", requestTO.ClientID)
+	redirectToClient(ctx, requestTO, url.Values{"code": {code}})
+	return nil
+}
+
+func redirectWithAuthorizationError(ctx *gin.Context, requestTO AuthorizationRequestTO, errorCode string) {
+	redirectToClient(ctx, requestTO, url.Values{"error": {errorCode}})
+}
+
+func redirectToClient(ctx *gin.Context, requestTO AuthorizationRequestTO, params url.Values) {
+	if requestTO.State != "" {
+		params.Set("state", requestTO.State)
+	}
+	separator := "?"
+	if strings.Contains(requestTO.RedirectURI, "?") {
+		separator = "&"
+	}
+	ginext.HXRedirectOrRedirect(ctx, requestTO.RedirectURI+separator+params.Encode())
+}
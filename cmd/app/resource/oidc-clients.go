@@ -0,0 +1,117 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"net/url"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/oidc"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterOidcClientsResource(group *gin.RouterGroup) {
+	group.GET("oidc-clients", ginext.WrapTemplWithoutPayload(OidcClientsPage))
+	group.POST("create-oidc-client", ginext.WrapTempl(CreateOidcClient))
+	group.POST("delete-oidc-client", ginext.WrapTempl(DeleteOidcClient))
+}
+
+func OidcClientsPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	clientList, err := makeOidcClientList(ctx, r, "", false, "")
+	if err != nil {
+		return nil, errs.Wrap("issue making client list", err)
+	}
+	return render.FullPage(ctx, "OIDC clients", admin.OidcClients(clientList)), nil
+}
+
+type CreateOidcClientTO struct {
+	Name         string `form:"name"`
+	RedirectURIs string `form:"redirectURIs"`
+}
+
+func CreateOidcClient(ctx *gin.Context, r *dm.RequestContext, requestTO CreateOidcClientTO) (templ.Component, error) {
+	logger := r.Logger
+
+	redirectURIs := strings.Fields(requestTO.RedirectURIs)
+	if requestTO.Name == "" || len(redirectURIs) == 0 {
+		return makeOidcClientList(ctx, r, "Name and at least one redirect URI are required.", true, "")
+	}
+	for _, redirectURI := range redirectURIs {
+		if !isValidRedirectURI(redirectURI) {
+			logger.Info("Invalid redirect URI for OIDC client", "redirectURI", redirectURI)
+			return makeOidcClientList(ctx, r, "Invalid redirect URI "+redirectURI+". Redirect URIs must be absolute https URLs without fragment.", true, "")
+		}
+	}
+
+	client, secret, err := oidc.CreateClient(ctx, r.Database, r.Config, requestTO.Name, redirectURIs, r.User.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue creating oidc client", err)
+	}
+
+	logger.Info("OIDC client registered", "clientID", client.ClientID)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeOidcClientCreate,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"clientID": client.ClientID, "name": client.Name},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeOidcClientList(ctx, r, "Registered "+client.Name+" with client ID "+client.ClientID+".", false, secret)
+}
+
+// isValidRedirectURI only accepts https URLs, or http for loopback addresses during development (RFC 8252)
+func isValidRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" {
+		return false
+	}
+	if parsed.Scheme == "http" {
+		hostname := parsed.Hostname()
+		return hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1"
+	}
+	return parsed.Scheme == "https"
+}
+
+type DeleteOidcClientTO struct {
+	ClientID string `form:"clientID"`
+}
+
+func DeleteOidcClient(ctx *gin.Context, r *dm.RequestContext, requestTO DeleteOidcClientTO) (templ.Component, error) {
+	logger := r.Logger
+
+	client, err := oidc.GetClient(ctx, r.Database, requestTO.ClientID)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching oidc client", err)
+	}
+	if !client.IsPresent() {
+		logger.Info("Deletion of unknown OIDC client attempted")
+		return makeOidcClientList(ctx, r, "This client does not exist.", true, "")
+	}
+
+	if err = oidc.DeleteClient(ctx, r.Database, client.ClientID); err != nil {
+		return nil, errs.Wrap("issue deleting oidc client", err)
+	}
+
+	logger.Info("OIDC client deleted", "clientID", client.ClientID)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeOidcClientDelete,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"clientID": client.ClientID, "name": client.Name},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeOidcClientList(ctx, r, "Deleted "+client.Name+".", false, "")
+}
+
+func makeOidcClientList(ctx *gin.Context, r *dm.RequestContext, message string, isError bool, newSecret string) (templ.Component, error) {
+	clients, err := oidc.GetClients(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading oidc clients", err)
+	}
+	return admin.OidcClientList(clients, message, isError, newSecret), nil
+}
@@ -0,0 +1,341 @@
+package resource
+
+import (
+	"encoding/base64"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/oidc"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterOidcResource registers the endpoints relying parties call directly. They authenticate with client credentials or access tokens,
+// so the group must not use the CSRF middleware.
+func RegisterOidcResource(group *gin.RouterGroup) {
+	group.GET(".well-known/openid-configuration", GetOpenIDConfiguration)
+	group.GET("jwks", GetJwks)
+	group.POST("token", PostToken)
+	group.GET("userinfo", GetUserInfo)
+	group.POST("userinfo", GetUserInfo)
+	group.POST("revoke", PostRevoke)
+}
+
+type OpenIDConfigurationTO struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func GetOpenIDConfiguration(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	issuer := oidc.Issuer(r.Config)
+	ctx.JSON(http.StatusOK, OpenIDConfigurationTO{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/jwks",
+		RevocationEndpoint:                issuer + "/revoke",
+		ScopesSupported:                   strings.Fields(oidc.FormatScopes(dm.OidcScopes)),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "name"},
+	})
+}
+
+type JwksTO struct {
+	Keys []JwkTO `json:"keys"`
+}
+
+type JwkTO struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+func GetJwks(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	publicKeys, err := oidc.GetPublicKeys(ctx, r.Database, r.Config)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue loading public keys", err))
+		return
+	}
+
+	jwks := JwksTO{Keys: make([]JwkTO, 0, len(publicKeys))}
+	for _, publicKey := range publicKeys {
+		jwks.Keys = append(jwks.Keys, JwkTO{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			KeyID:     publicKey.KeyID,
+			Modulus:   base64.RawURLEncoding.EncodeToString(publicKey.Key.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.Key.E)).Bytes()),
+		})
+	}
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.JSON(http.StatusOK, jwks)
+}
+
+type TokenRequestTO struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type TokenResponseTO struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+type OAuthErrorTO struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// PostToken exchanges authorization codes and refresh tokens for tokens. Refresh tokens are only issued for the offline_access scope and rotated on every use.
+func PostToken(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	ctx.Header("Cache-Control", "no-store")
+	var requestTO TokenRequestTO
+	if err := ctx.ShouldBind(&requestTO); err != nil {
+		ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_request"})
+		return
+	}
+
+	client, err := authenticateClient(ctx, r, requestTO.ClientID, requestTO.ClientSecret)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue authenticating client", err))
+		return
+	}
+	if !client.IsPresent() {
+		logger.Info("Token request with invalid client credentials")
+		ctx.Header("WWW-Authenticate", `Basic realm="token"`)
+		ctx.JSON(http.StatusUnauthorized, OAuthErrorTO{Error: "invalid_client"})
+		return
+	}
+	logger = logger.With("clientID", client.ClientID)
+
+	var userID dm.UserID
+	var scopes []dm.OidcScope
+	var nonce string
+	var authTime time.Time
+	switch requestTO.GrantType {
+	case "authorization_code":
+		code, err := oidc.RedeemAuthorizationCode(ctx, r.Database, r.Config, requestTO.Code)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue redeeming authorization code", err))
+			return
+		}
+		if !code.IsPresent() || code.ClientID != client.ClientID || code.RedirectURI != requestTO.RedirectURI {
+			logger.Info("Token request with invalid authorization code")
+			ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_grant"})
+			return
+		}
+		if !oidc.VerifyCodeChallenge(code.CodeChallenge, requestTO.CodeVerifier) {
+			logger.Info("Token request with invalid code verifier")
+			ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_grant", ErrorDescription: "code verifier mismatch"})
+			return
+		}
+		userID, scopes, nonce, authTime = dm.UserID(code.UserID), code.Scopes, code.Nonce, code.AuthTime
+	case "refresh_token":
+		refreshToken, err := oidc.RedeemRefreshToken(ctx, r.Database, r.Config, client.ClientID, requestTO.RefreshToken)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue redeeming refresh token", err))
+			return
+		}
+		if !refreshToken.IsPresent() {
+			logger.Info("Token request with invalid refresh token")
+			ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_grant"})
+			return
+		}
+		userID, scopes, authTime = dm.UserID(refreshToken.UserID), refreshToken.Scopes, refreshToken.AuthTime
+	default:
+		ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "unsupported_grant_type"})
+		return
+	}
+
+	user, err := users.GetUserForID(ctx, r.Database, userID)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching user", err))
+		return
+	}
+	if !user.IsPresent() || user.IsLocked() {
+		logger.Info("Token request for missing or locked user")
+		ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_grant"})
+		return
+	}
+
+	response := TokenResponseTO{
+		TokenType: "Bearer",
+		ExpiresIn: int(dm.OidcAccessTokenDuration.Seconds()),
+		Scope:     oidc.FormatScopes(scopes),
+	}
+	if response.AccessToken, err = oidc.MakeAccessToken(ctx, r.Database, r.Config, client.ClientID, user.ID(), scopes); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making access token", err))
+		return
+	}
+	if response.IDToken, err = oidc.MakeIDToken(ctx, r.Database, r.Config, client.ClientID, user, scopes, nonce, authTime); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making id token", err))
+		return
+	}
+	if slices.Contains(scopes, dm.OidcScopeOfflineAccess) {
+		response.RefreshToken, err = oidc.IssueRefreshToken(ctx, r.Database, r.Config, dm.OidcRefreshToken{
+			ClientID: client.ClientID,
+			UserID:   user.ObjectID,
+			Scopes:   scopes,
+			AuthTime: authTime,
+		})
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue issuing refresh token", err))
+			return
+		}
+	}
+
+	logger.Info("Tokens issued", "grantType", requestTO.GrantType, "userID", user.IDHex())
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetUserInfo returns the claims of the user the bearer access token was issued for
+func GetUserInfo(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		ctx.Header("WWW-Authenticate", `Bearer`)
+		ctx.Status(http.StatusUnauthorized)
+		return
+	}
+	claims, valid, err := oidc.ParseAccessToken(ctx, r.Database, r.Config, token)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue parsing access token", err))
+		return
+	}
+	if !valid {
+		logger.Info("Userinfo request with invalid access token")
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.Status(http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("invalid subject in signed access token", err))
+		return
+	}
+	user, err := users.GetUserForID(ctx, r.Database, dm.UserID(userID))
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching user", err))
+		return
+	}
+	if !user.IsPresent() || user.IsLocked() {
+		logger.Info("Userinfo request for missing or locked user")
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.Status(http.StatusUnauthorized)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, struct {
+		Subject string `json:"sub"`
+		oidc.ProfileClaims
+	}{
+		Subject:       user.IDHex(),
+		ProfileClaims: oidc.MakeProfileClaims(user, claims.Scopes()),
+	})
+}
+
+type RevocationRequestTO struct {
+	Token        string `form:"token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// PostRevoke revokes refresh tokens (RFC 7009). Access tokens are short-lived and cannot be revoked, which the RFC allows.
+func PostRevoke(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	var requestTO RevocationRequestTO
+	if err := ctx.ShouldBind(&requestTO); err != nil {
+		ctx.JSON(http.StatusBadRequest, OAuthErrorTO{Error: "invalid_request"})
+		return
+	}
+
+	client, err := authenticateClient(ctx, r, requestTO.ClientID, requestTO.ClientSecret)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue authenticating client", err))
+		return
+	}
+	if !client.IsPresent() {
+		logger.Info("Revocation request with invalid client credentials")
+		ctx.Header("WWW-Authenticate", `Basic realm="revoke"`)
+		ctx.JSON(http.StatusUnauthorized, OAuthErrorTO{Error: "invalid_client"})
+		return
+	}
+
+	if err = oidc.RevokeRefreshToken(ctx, r.Database, r.Config, client.ClientID, requestTO.Token); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue revoking refresh token", err))
+		return
+	}
+	logger.Info("Refresh token revoked", "clientID", client.ClientID)
+	ctx.Status(http.StatusOK)
+}
+
+// authenticateClient supports client_secret_basic and client_secret_post. It returns no client if the credentials are invalid.
+func authenticateClient(ctx *gin.Context, r *dm.RequestContext, clientID string, clientSecret string) (dm.OidcClient, error) {
+	if basicID, basicSecret, ok := ctx.Request.BasicAuth(); ok {
+		clientID, clientSecret = basicID, basicSecret
+	}
+	if clientID == "" || clientSecret == "" {
+		return dm.OidcClient{}, nil
+	}
+
+	client, err := oidc.GetClient(ctx, r.Database, clientID)
+	if err != nil {
+		return dm.OidcClient{}, errs.Wrap("issue fetching oidc client", err)
+	}
+	if !client.IsPresent() || !auth.VerifyTokenHash(r.Config, clientSecret, client.SecretHash) {
+		return dm.OidcClient{}, nil
+	}
+	return client, nil
+}
@@ -0,0 +1,405 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/organizations"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+	"user-manager/util/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterOrganizationsResource(group *gin.RouterGroup) {
+	group.GET("organizations", ginext.WrapTemplWithoutPayload(OrganizationsPage))
+	group.POST("organizations/create", ginext.WrapTempl(CreateOrganization))
+	group.POST("organizations/switch", ginext.WrapTempl(SwitchOrganization))
+	group.GET("organizations/accept-invitation", ginext.WrapTempl(OrganizationInvitationPage))
+	group.POST("organizations/accept-invitation", ginext.WrapTempl(AcceptOrganizationInvitation))
+	group.GET("organizations/:organizationID", ginext.WrapTemplWithoutPayload(OrganizationPage))
+	group.POST("organizations/:organizationID/revoke-invitation", ginext.WrapTempl(RevokeOrganizationInvitation))
+	group.POST("organizations/:organizationID/remove-member", ginext.WrapTempl(RemoveOrganizationMember))
+}
+
+// RegisterOrganizationInvitationResource is separate, because sending invitations is rate limited
+func RegisterOrganizationInvitationResource(group *gin.RouterGroup) {
+	group.POST("organizations/:organizationID/invite", ginext.WrapTempl(InviteToOrganization))
+}
+
+func OrganizationsPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	organizationList, err := makeOrganizationList(ctx, r, r.Organization.ID(), "")
+	if err != nil {
+		return nil, errs.Wrap("issue making organization list", err)
+	}
+	return render.FullPage(ctx, "Organizations", userrender.Organizations(organizationList)), nil
+}
+
+type CreateOrganizationTO struct {
+	Name string `form:"name"`
+}
+
+func CreateOrganization(ctx *gin.Context, r *dm.RequestContext, requestTO CreateOrganizationTO) (templ.Component, error) {
+	logger := r.Logger
+
+	name := strings.TrimSpace(requestTO.Name)
+	if name == "" {
+		return makeOrganizationList(ctx, r, r.Organization.ID(), "Please enter a name.")
+	}
+
+	organization, err := organizations.CreateOrganization(ctx, r.Database, name, r.User.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue creating organization", err)
+	}
+
+	logger.Info("Organization created", "organizationID", organization.IDHex())
+	return makeOrganizationList(ctx, r, r.Organization.ID(), "")
+}
+
+type SwitchOrganizationTO struct {
+	OrganizationID string `form:"organizationID"`
+}
+
+// SwitchOrganization sets the organization that subsequent requests of the current session are scoped to
+func SwitchOrganization(ctx *gin.Context, r *dm.RequestContext, requestTO SwitchOrganizationTO) (templ.Component, error) {
+	logger := r.Logger
+
+	organizationID, err := primitive.ObjectIDFromHex(requestTO.OrganizationID)
+	if err != nil {
+		logger.Info("Organization switch with invalid ID")
+		return makeOrganizationList(ctx, r, r.Organization.ID(), "Unknown organization.")
+	}
+
+	membership, err := organizations.GetMembership(ctx, r.Database, dm.OrganizationID(organizationID), r.User.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching membership", err)
+	}
+	if !membership.IsPresent() {
+		logger.Info("Organization switch without membership", "organizationID", organizationID.Hex())
+		return makeOrganizationList(ctx, r, r.Organization.ID(), "Unknown organization.")
+	}
+
+	sessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
+	if err != nil {
+		return nil, errs.Wrap("issue getting session cookie", err)
+	}
+	if err = auth.SetActiveOrganizationForSession(ctx, r.Database, r.Config, sessionToken, dm.OrganizationID(organizationID)); err != nil {
+		return nil, errs.Wrap("issue setting active organization", err)
+	}
+
+	logger.Info("Organization switched", "organizationID", organizationID.Hex())
+	return makeOrganizationList(ctx, r, dm.OrganizationID(organizationID), "")
+}
+
+func OrganizationPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	organization, membership, err := getOrganizationForMember(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization", err)
+	}
+	if !membership.IsPresent() {
+		return nil, nil
+	}
+
+	members, err := makeOrganizationMembers(ctx, r, organization, membership, "")
+	if err != nil {
+		return nil, errs.Wrap("issue making organization members", err)
+	}
+	return render.FullPage(ctx, organization.Name, userrender.OrganizationPage(organization, members)), nil
+}
+
+type InviteToOrganizationTO struct {
+	Email string              `form:"email"`
+	Role  dm.OrganizationRole `form:"role"`
+}
+
+func InviteToOrganization(ctx *gin.Context, r *dm.RequestContext, requestTO InviteToOrganizationTO) (templ.Component, error) {
+	logger := r.Logger
+
+	organization, membership, err := getOrganizationForMember(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization", err)
+	}
+	if !membership.IsPresent() {
+		return nil, nil
+	}
+
+	if !membership.CanManageMembers() {
+		logger.Info("Organization invitation without permission")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Only owners and admins can invite members.")
+	}
+	if !slices.Contains(dm.OrganizationRoles, requestTO.Role) {
+		logger.Info("Organization invitation with unknown role", "role", requestTO.Role)
+		return makeOrganizationMembers(ctx, r, organization, membership, "Unknown role.")
+	}
+	if requestTO.Role == dm.OrganizationRoleOwner && membership.Role != dm.OrganizationRoleOwner {
+		logger.Info("Organization owner invitation by non-owner")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Only owners can invite owners.")
+	}
+	email := strings.TrimSpace(requestTO.Email)
+	if email == "" {
+		return makeOrganizationMembers(ctx, r, organization, membership, "Please enter an email address.")
+	}
+	if address, err := netmail.ParseAddress(email); err != nil || address.Address != email {
+		logger.Info("Organization invitation with invalid email")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Please enter a valid email address.")
+	}
+
+	token := random.MakeRandomURLSafeB64(21)
+	if err = organizations.InsertInvitation(ctx, r.Database, dm.OrganizationInvitation{
+		OrganizationID: organization.ObjectID,
+		Email:          email,
+		Role:           requestTO.Role,
+		TokenHash:      auth.HashToken(r.Config, token),
+		InvitedBy:      r.User.ObjectID,
+		ValidUntil:     time.Now().Add(dm.OrganizationInvitationDuration),
+	}); err != nil {
+		return nil, errs.Wrap("issue inserting organization invitation", err)
+	}
+
+	if err = mail.SendOrganizationInvitationEmail(ctx, r, email, organization.Name, token); err != nil {
+		return nil, errs.Wrap("error sending organization invitation email", err)
+	}
+
+	logger.Info("Organization invitation sent", "organizationID", organization.IDHex(), "role", requestTO.Role)
+	return makeOrganizationMembers(ctx, r, organization, membership, "")
+}
+
+type RevokeOrganizationInvitationTO struct {
+	InvitationID string `form:"invitationID"`
+}
+
+func RevokeOrganizationInvitation(ctx *gin.Context, r *dm.RequestContext, requestTO RevokeOrganizationInvitationTO) (templ.Component, error) {
+	logger := r.Logger
+
+	organization, membership, err := getOrganizationForMember(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization", err)
+	}
+	if !membership.IsPresent() {
+		return nil, nil
+	}
+
+	if !membership.CanManageMembers() {
+		logger.Info("Organization invitation revocation without permission")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Only owners and admins can revoke invitations.")
+	}
+
+	invitationID, err := primitive.ObjectIDFromHex(requestTO.InvitationID)
+	if err != nil {
+		logger.Info("Organization invitation revocation with invalid ID")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Unknown invitation.")
+	}
+	if err = organizations.DeleteInvitation(ctx, r.Database, organization.ID(), invitationID); err != nil {
+		return nil, errs.Wrap("issue deleting organization invitation", err)
+	}
+
+	logger.Info("Organization invitation revoked", "organizationID", organization.IDHex())
+	return makeOrganizationMembers(ctx, r, organization, membership, "")
+}
+
+type RemoveOrganizationMemberTO struct {
+	UserID string `form:"userID"`
+}
+
+// RemoveOrganizationMember lets owners and admins remove members and everyone leave, as long as an owner remains
+func RemoveOrganizationMember(ctx *gin.Context, r *dm.RequestContext, requestTO RemoveOrganizationMemberTO) (templ.Component, error) {
+	logger := r.Logger
+
+	organization, membership, err := getOrganizationForMember(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization", err)
+	}
+	if !membership.IsPresent() {
+		return nil, nil
+	}
+
+	userID, err := primitive.ObjectIDFromHex(requestTO.UserID)
+	if err != nil {
+		logger.Info("Organization member removal with invalid ID")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Unknown member.")
+	}
+	leaving := userID == r.User.ObjectID
+	if !leaving && !membership.CanManageMembers() {
+		logger.Info("Organization member removal without permission")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Only owners and admins can remove members.")
+	}
+
+	targetMembership, err := organizations.GetMembership(ctx, r.Database, organization.ID(), dm.UserID(userID))
+	if err != nil {
+		return nil, errs.Wrap("issue fetching membership", err)
+	}
+	if !targetMembership.IsPresent() {
+		logger.Info("Organization member removal for non-member")
+		return makeOrganizationMembers(ctx, r, organization, membership, "Unknown member.")
+	}
+	if targetMembership.Role == dm.OrganizationRoleOwner {
+		if !leaving && membership.Role != dm.OrganizationRoleOwner {
+			logger.Info("Organization owner removal by non-owner")
+			return makeOrganizationMembers(ctx, r, organization, membership, "Only owners can remove owners.")
+		}
+		ownerCount, err := organizations.CountMembersWithRole(ctx, r.Database, organization.ID(), dm.OrganizationRoleOwner)
+		if err != nil {
+			return nil, errs.Wrap("issue counting owners", err)
+		}
+		if ownerCount <= 1 {
+			logger.Info("Removal of last organization owner attempted")
+			return makeOrganizationMembers(ctx, r, organization, membership, "The last owner cannot be removed.")
+		}
+	}
+
+	if err = organizations.RemoveMembership(ctx, r.Database, organization.ID(), dm.UserID(userID)); err != nil {
+		return nil, errs.Wrap("issue removing membership", err)
+	}
+
+	logger.Info("Organization member removed", "organizationID", organization.IDHex(), "targetUserID", userID.Hex())
+	if leaving {
+		ginext.HXLocationOrRedirect(ctx, "/user/organizations")
+		return nil, nil
+	}
+	return makeOrganizationMembers(ctx, r, organization, membership, "")
+}
+
+type OrganizationInvitationTokenTO struct {
+	Token string `form:"token"`
+}
+
+func OrganizationInvitationPage(ctx *gin.Context, r *dm.RequestContext, requestTO OrganizationInvitationTokenTO) (templ.Component, error) {
+	invitation, organization, err := getOrganizationInvitation(ctx, r, requestTO.Token)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization invitation", err)
+	}
+	if !invitation.IsPresent() {
+		return render.FullPage(ctx, "Organization invitation", userrender.OrganizationInvitationInvalid()), nil
+	}
+	return render.FullPage(ctx, "Organization invitation", userrender.OrganizationInvitation(requestTO.Token, organization, invitation.Role)), nil
+}
+
+func AcceptOrganizationInvitation(ctx *gin.Context, r *dm.RequestContext, requestTO OrganizationInvitationTokenTO) (templ.Component, error) {
+	logger := r.Logger
+
+	invitation, organization, err := getOrganizationInvitation(ctx, r, requestTO.Token)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching organization invitation", err)
+	}
+	if !invitation.IsPresent() {
+		ginext.HXRetarget(ctx, "#organization-invitation")
+		return userrender.OrganizationInvitationInvalid(), nil
+	}
+
+	if err = organizations.AcceptInvitation(ctx, r.Database, invitation, r.User.ID()); err != nil {
+		return nil, errs.Wrap("issue accepting organization invitation", err)
+	}
+
+	logger.Info("Organization invitation accepted", "organizationID", organization.IDHex())
+	ginext.HXLocationOrRedirect(ctx, "/user/organizations/"+organization.IDHex())
+	return nil, nil
+}
+
+// getOrganizationInvitation returns the invitation only if it is addressed to the current user's verified email
+func getOrganizationInvitation(ctx *gin.Context, r *dm.RequestContext, token string) (dm.OrganizationInvitation, dm.Organization, error) {
+	logger := r.Logger
+
+	invitation, err := organizations.GetInvitationForTokenHash(ctx, r.Database, auth.HashToken(r.Config, token))
+	if err != nil {
+		return dm.OrganizationInvitation{}, dm.Organization{}, errs.Wrap("issue loading organization invitation", err)
+	}
+	if !invitation.IsPresent() {
+		logger.Info("Organization invitation with invalid or expired token")
+		return dm.OrganizationInvitation{}, dm.Organization{}, nil
+	}
+	if !r.User.EmailVerified || !strings.EqualFold(invitation.Email, r.User.Email) {
+		logger.Info("Organization invitation for other email", "organizationID", invitation.OrganizationID.Hex())
+		return dm.OrganizationInvitation{}, dm.Organization{}, nil
+	}
+
+	organization, err := organizations.GetOrganization(ctx, r.Database, dm.OrganizationID(invitation.OrganizationID))
+	if err != nil {
+		return dm.OrganizationInvitation{}, dm.Organization{}, errs.Wrap("issue loading organization", err)
+	}
+	if !organization.IsPresent() {
+		logger.Info("Organization invitation for deleted organization")
+		return dm.OrganizationInvitation{}, dm.Organization{}, nil
+	}
+	return invitation, organization, nil
+}
+
+// getLastOwnedOrganization returns an organization the user is the only owner of, if there is any
+func getLastOwnedOrganization(ctx *gin.Context, r *dm.RequestContext, user dm.User) (dm.Organization, error) {
+	memberships, err := organizations.GetOrganizationsForUser(ctx, r.Database, user.ID())
+	if err != nil {
+		return dm.Organization{}, errs.Wrap("issue fetching organizations", err)
+	}
+	for _, membership := range memberships {
+		if membership.Role != dm.OrganizationRoleOwner {
+			continue
+		}
+		ownerCount, err := organizations.CountMembersWithRole(ctx, r.Database, membership.Organization.ID(), dm.OrganizationRoleOwner)
+		if err != nil {
+			return dm.Organization{}, errs.Wrap("issue counting owners", err)
+		}
+		if ownerCount <= 1 {
+			return membership.Organization, nil
+		}
+	}
+	return dm.Organization{}, nil
+}
+
+// getOrganizationForMember loads the organization named in the path, responding with 404 unless the user is a member
+func getOrganizationForMember(ctx *gin.Context, r *dm.RequestContext) (dm.Organization, dm.Membership, error) {
+	organizationID, err := primitive.ObjectIDFromHex(ctx.Param("organizationID"))
+	if err != nil {
+		r.Logger.Info("Organization request with invalid ID")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return dm.Organization{}, dm.Membership{}, nil
+	}
+
+	membership, err := organizations.GetMembership(ctx, r.Database, dm.OrganizationID(organizationID), r.User.ID())
+	if err != nil {
+		return dm.Organization{}, dm.Membership{}, errs.Wrap("issue loading membership", err)
+	}
+	if !membership.IsPresent() {
+		r.Logger.Info("Organization request by non-member", "organizationID", organizationID.Hex())
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return dm.Organization{}, dm.Membership{}, nil
+	}
+
+	organization, err := organizations.GetOrganization(ctx, r.Database, dm.OrganizationID(organizationID))
+	if err != nil {
+		return dm.Organization{}, dm.Membership{}, errs.Wrap("issue loading organization", err)
+	}
+	return organization, membership, nil
+}
+
+func makeOrganizationList(ctx *gin.Context, r *dm.RequestContext, activeOrganizationID dm.OrganizationID, message string) (templ.Component, error) {
+	memberships, err := organizations.GetOrganizationsForUser(ctx, r.Database, r.User.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue loading organizations", err)
+	}
+	return userrender.OrganizationList(memberships, activeOrganizationID, message), nil
+}
+
+func makeOrganizationMembers(ctx *gin.Context, r *dm.RequestContext, organization dm.Organization, membership dm.Membership, message string) (templ.Component, error) {
+	members, err := organizations.GetMembers(ctx, r.Database, organization.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue loading members", err)
+	}
+
+	var invitations []dm.OrganizationInvitation
+	if membership.CanManageMembers() {
+		invitations, err = organizations.GetInvitationsForOrganization(ctx, r.Database, organization.ID())
+		if err != nil {
+			return nil, errs.Wrap("issue loading invitations", err)
+		}
+	}
+	return userrender.OrganizationMembers(organization, membership, r.User.ID(), members, invitations, message), nil
+}
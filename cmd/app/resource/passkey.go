@@ -0,0 +1,376 @@
+package resource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func RegisterPasskeyRegistrationResource(group *gin.RouterGroup) {
+	group.POST("begin-passkey-registration", ginext.WrapEndpointWithoutRequestBody(BeginPasskeyRegistration))
+	group.POST("finish-passkey-registration", ginext.WrapEndpoint(FinishPasskeyRegistration))
+	group.POST("delete-passkey", ginext.WrapTempl(DeletePasskey))
+}
+
+func RegisterPasskeyLoginResource(group *gin.RouterGroup) {
+	group.POST("begin-passkey-login", ginext.WrapEndpointWithoutRequestBody(BeginPasskeyLogin))
+	group.POST("finish-passkey-login", ginext.WrapEndpoint(FinishPasskeyLogin))
+	group.POST("begin-passkey-second-factor", ginext.WrapEndpoint(BeginPasskeySecondFactor))
+}
+
+func BeginPasskeyRegistration(ctx *gin.Context, r *dm.RequestContext) (*protocol.CredentialCreation, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return nil, errs.Wrap("issue creating webauthn relying party", err)
+	}
+
+	webAuthnUser := auth.WebAuthnUser{User: currentUser}
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range webAuthnUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, sessionData, err := w.BeginRegistration(webAuthnUser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred))
+	if err != nil {
+		return nil, errs.Wrap("issue beginning passkey registration", err)
+	}
+
+	if err = auth.StartWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeRegistration, currentUser.ID(), sessionData); err != nil {
+		return nil, errs.Wrap("issue starting webauthn ceremony", err)
+	}
+
+	logger.Info("Passkey registration initiated")
+	return creation, nil
+}
+
+type FinishPasskeyRegistrationTO struct {
+	Nickname   string          `json:"nickname"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type FinishPasskeyRegistrationResponseTO struct {
+	Registered bool `json:"registered"`
+}
+
+func FinishPasskeyRegistration(ctx *gin.Context, r *dm.RequestContext, requestTO FinishPasskeyRegistrationTO) (FinishPasskeyRegistrationResponseTO, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return FinishPasskeyRegistrationResponseTO{}, errs.Error("missing user")
+	}
+
+	ceremony, sessionData, err := auth.FinishWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeRegistration)
+	if err != nil {
+		return FinishPasskeyRegistrationResponseTO{}, errs.Wrap("issue finishing webauthn ceremony", err)
+	}
+	if !ceremony.IsPresent() || ceremony.UserID != currentUser.ObjectID {
+		logger.Info("Passkey registration without matching ceremony")
+		return FinishPasskeyRegistrationResponseTO{}, nil
+	}
+
+	parsed, err := auth.ParseWebAuthnCreationResponse(requestTO.Credential)
+	if err != nil {
+		logger.Info("Invalid passkey registration response", "error", err)
+		return FinishPasskeyRegistrationResponseTO{}, nil
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return FinishPasskeyRegistrationResponseTO{}, errs.Wrap("issue creating webauthn relying party", err)
+	}
+	credential, err := w.CreateCredential(auth.WebAuthnUser{User: currentUser}, sessionData, parsed)
+	if err != nil {
+		logger.Info("Passkey registration failed verification", "error", err)
+		return FinishPasskeyRegistrationResponseTO{}, nil
+	}
+
+	nickname := requestTO.Nickname
+	if nickname == "" {
+		nickname = "Passkey"
+	}
+	if err = users.AddWebAuthnCredential(ctx, r.Database, currentUser.ID(), auth.MakeWebAuthnCredential(credential, nickname)); err != nil {
+		return FinishPasskeyRegistrationResponseTO{}, errs.Wrap("issue adding webauthn credential", err)
+	}
+
+	logger.Info("Passkey registered")
+	return FinishPasskeyRegistrationResponseTO{Registered: true}, nil
+}
+
+type DeletePasskeyTO struct {
+	CredentialID string `form:"credentialID"`
+}
+
+func DeletePasskey(ctx *gin.Context, r *dm.RequestContext, requestTO DeletePasskeyTO) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(requestTO.CredentialID)
+	if err != nil {
+		logger.Info("Passkey deletion with malformed credential id")
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return nil, nil
+	}
+
+	if err = users.RemoveWebAuthnCredential(ctx, r.Database, currentUser.ID(), credentialID); err != nil {
+		return nil, errs.Wrap("issue removing webauthn credential", err)
+	}
+
+	var remaining []dm.WebAuthnCredential
+	for _, credential := range currentUser.WebAuthnCredentials {
+		if string(credential.ID) != string(credentialID) {
+			remaining = append(remaining, credential)
+		}
+	}
+
+	logger.Info("Passkey deleted")
+	return user.PasskeySection(remaining), nil
+}
+
+func BeginPasskeyLogin(ctx *gin.Context, r *dm.RequestContext) (*protocol.CredentialAssertion, error) {
+	logger := r.Logger
+
+	if !r.Config.PasswordlessLoginEnabled {
+		logger.Info("Passwordless login attempted while disabled")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, nil
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return nil, errs.Wrap("issue creating webauthn relying party", err)
+	}
+
+	assertion, sessionData, err := w.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, errs.Wrap("issue beginning passkey login", err)
+	}
+
+	if err = auth.StartWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeLogin, dm.UserID(primitive.NilObjectID), sessionData); err != nil {
+		return nil, errs.Wrap("issue starting webauthn ceremony", err)
+	}
+
+	return assertion, nil
+}
+
+type PasskeyAssertionTO struct {
+	Credential json.RawMessage `json:"credential"`
+}
+
+type PasskeyLoginResponseTO struct {
+	LoggedIn bool `json:"loggedIn"`
+}
+
+func FinishPasskeyLogin(ctx *gin.Context, r *dm.RequestContext, requestTO PasskeyAssertionTO) (PasskeyLoginResponseTO, error) {
+	logger := r.Logger
+
+	if !r.Config.PasswordlessLoginEnabled {
+		logger.Info("Passwordless login attempted while disabled")
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return PasskeyLoginResponseTO{}, nil
+	}
+
+	ceremony, sessionData, err := auth.FinishWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeLogin)
+	if err != nil {
+		return PasskeyLoginResponseTO{}, errs.Wrap("issue finishing webauthn ceremony", err)
+	}
+	if !ceremony.IsPresent() {
+		logger.Info("Passkey login without matching ceremony")
+		return PasskeyLoginResponseTO{}, nil
+	}
+
+	parsed, err := auth.ParseWebAuthnAssertionResponse(requestTO.Credential)
+	if err != nil {
+		logger.Info("Invalid passkey login response", "error", err)
+		return PasskeyLoginResponseTO{}, nil
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return PasskeyLoginResponseTO{}, errs.Wrap("issue creating webauthn relying party", err)
+	}
+
+	var loginUser dm.User
+	credential, err := w.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		var userID primitive.ObjectID
+		if len(userHandle) != len(userID) {
+			return nil, errs.Error("malformed user handle")
+		}
+		copy(userID[:], userHandle)
+
+		user, err := users.GetUserForID(ctx, r.Database, dm.UserID(userID))
+		if err != nil {
+			return nil, errs.Wrap("issue fetching user for user handle", err)
+		}
+		if !user.IsPresent() {
+			return nil, errs.Error("unknown user handle")
+		}
+		loginUser = user
+		return auth.WebAuthnUser{User: user}, nil
+	}, sessionData, parsed)
+	if err != nil {
+		logger.Info("Passkey login failed verification", "error", err)
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeLogin,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: loginUser.ObjectID,
+			Details:   map[string]string{"method": "passkey", "reason": "verification-failed"},
+		}); err != nil {
+			return PasskeyLoginResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
+		return PasskeyLoginResponseTO{}, nil
+	}
+	if loginUser.IsLocked() {
+		logger.Info("Passkey login for locked user", "userID", loginUser.IDHex())
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeLogin,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: loginUser.ObjectID,
+			Details:   map[string]string{"method": "passkey", "reason": "locked"},
+		}); err != nil {
+			return PasskeyLoginResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
+		return PasskeyLoginResponseTO{}, nil
+	}
+	if credential.Authenticator.CloneWarning {
+		logger.Warn("Passkey login with possibly cloned authenticator", "userID", loginUser.IDHex())
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeLogin,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: loginUser.ObjectID,
+			Details:   map[string]string{"method": "passkey", "reason": "clone-warning"},
+		}); err != nil {
+			return PasskeyLoginResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
+		return PasskeyLoginResponseTO{}, nil
+	}
+
+	if err = users.UpdateWebAuthnCredentialUsage(ctx, r.Database, loginUser.ID(), credential.ID, credential.Authenticator.SignCount); err != nil {
+		return PasskeyLoginResponseTO{}, errs.Wrap("issue updating webauthn credential usage", err)
+	}
+
+	// The login was begun with VerificationRequired, so the authenticator proved possession of the passkey and verified
+	// the user with a PIN or biometrics. This counts as two factors, also for privileged users.
+	logger.Info("Login (passkey)", "userID", loginUser.IDHex())
+	session := dm.UserSession{
+		Token:     dm.UserSessionToken(random.MakeRandomURLSafeB64(21)),
+		Type:      dm.UserSessionTypeLogin,
+		TimeoutAt: time.Now().Add(dm.LoginSessionDuration),
+	}
+	if err = auth.InsertSession(ctx, r.Database, r.Config, loginUser.ID(), session); err != nil {
+		return PasskeyLoginResponseTO{}, errs.Wrap("error inserting session", err)
+	}
+	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	auth.RotateCsrfToken(ctx, r, session.Token)
+
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeLogin,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: loginUser.ObjectID,
+		Details:   map[string]string{"method": "passkey"},
+	}); err != nil {
+		return PasskeyLoginResponseTO{}, errs.Wrap("issue recording audit event", err)
+	}
+
+	return PasskeyLoginResponseTO{LoggedIn: true}, nil
+}
+
+type BeginPasskeySecondFactorTO struct {
+	Sudo bool `json:"sudo"`
+}
+
+func BeginPasskeySecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO BeginPasskeySecondFactorTO) (*protocol.CredentialAssertion, error) {
+	logger := r.Logger
+
+	user, _, err := getUserAwaitingSecondFactor(ctx, r, requestTO.Sudo)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user awaiting second factor", err)
+	}
+	if !user.IsPresent() || len(user.WebAuthnCredentials) == 0 {
+		logger.Info("Passkey second factor attempted without pending login or passkeys")
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return nil, nil
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return nil, errs.Wrap("issue creating webauthn relying party", err)
+	}
+
+	assertion, sessionData, err := w.BeginLogin(auth.WebAuthnUser{User: user})
+	if err != nil {
+		return nil, errs.Wrap("issue beginning passkey second factor", err)
+	}
+
+	if err = auth.StartWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeSecondFactor, user.ID(), sessionData); err != nil {
+		return nil, errs.Wrap("issue starting webauthn ceremony", err)
+	}
+
+	return assertion, nil
+}
+
+func verifyPasskeySecondFactor(ctx *gin.Context, r *dm.RequestContext, user dm.User, assertion json.RawMessage) (bool, error) {
+	logger := r.Logger
+
+	ceremony, sessionData, err := auth.FinishWebAuthnCeremony(ctx, r.Database, r.Config, dm.WebAuthnCeremonyTypeSecondFactor)
+	if err != nil {
+		return false, errs.Wrap("issue finishing webauthn ceremony", err)
+	}
+	if !ceremony.IsPresent() || ceremony.UserID != user.ObjectID {
+		logger.Info("Passkey second factor without matching ceremony")
+		return false, nil
+	}
+
+	parsed, err := auth.ParseWebAuthnAssertionResponse(assertion)
+	if err != nil {
+		logger.Info("Invalid passkey second factor response", "error", err)
+		return false, nil
+	}
+
+	w, err := auth.NewWebAuthn(r.Config)
+	if err != nil {
+		return false, errs.Wrap("issue creating webauthn relying party", err)
+	}
+	credential, err := w.ValidateLogin(auth.WebAuthnUser{User: user}, sessionData, parsed)
+	if err != nil {
+		logger.Info("Passkey second factor failed verification", "error", err)
+		return false, nil
+	}
+	if credential.Authenticator.CloneWarning {
+		logger.Warn("Passkey second factor with possibly cloned authenticator")
+		return false, nil
+	}
+
+	if err = users.UpdateWebAuthnCredentialUsage(ctx, r.Database, user.ID(), credential.ID, credential.Authenticator.SignCount); err != nil {
+		return false, errs.Wrap("issue updating webauthn credential usage", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,25 @@
+package resource
+
+import (
+	"net/http"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordPolicyViolationsTO struct {
+	Violations []dm.PasswordPolicyViolation `json:"violations"`
+}
+
+// abortOnPasswordPolicyViolations responds with 400 and the violated rules if the new password is not acceptable
+func abortOnPasswordPolicyViolations(ctx *gin.Context, r *dm.RequestContext, password []byte, email string, name string) bool {
+	violations := auth.CheckPasswordPolicy(r.Config, password, email, name)
+	if len(violations) == 0 {
+		return false
+	}
+
+	r.Logger.Info("Password rejected by policy", "violations", violations)
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, PasswordPolicyViolationsTO{Violations: violations})
+	return true
+}
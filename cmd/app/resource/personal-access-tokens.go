@@ -0,0 +1,152 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/accesstokens"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/ledger"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterPersonalAccessTokensResource(group *gin.RouterGroup) {
+	group.POST("create-personal-access-token", ginext.WrapTempl(CreatePersonalAccessToken))
+	group.POST("revoke-personal-access-token", ginext.WrapTempl(RevokePersonalAccessToken))
+}
+
+type CreatePersonalAccessTokenTO struct {
+	Name          string          `form:"name" json:"name"`
+	Scopes        []dm.Permission `form:"scopes" json:"scopes"`
+	ExpiresInDays int             `form:"expiresInDays" json:"expiresInDays"`
+}
+
+// CreatePersonalAccessToken shows the new token once. Only scopes the user currently holds can be granted.
+func CreatePersonalAccessToken(ctx *gin.Context, r *dm.RequestContext, requestTO CreatePersonalAccessTokenTO) (templ.Component, error) {
+	logger := r.Logger
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	name := strings.TrimSpace(requestTO.Name)
+	duration := time.Duration(requestTO.ExpiresInDays) * 24 * time.Hour
+	if name == "" || len(name) > 100 || len(requestTO.Scopes) == 0 || duration <= 0 || duration > dm.PersonalAccessTokenMaxDuration {
+		logger.Info("Invalid personal access token request", "scopes", len(requestTO.Scopes), "expiresInDays", requestTO.ExpiresInDays)
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return nil, nil
+	}
+	for _, scope := range requestTO.Scopes {
+		if !r.Permissions.Has(scope) {
+			logger.Info("Personal access token requested with scope the user does not hold", "scope", scope)
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return nil, nil
+		}
+	}
+
+	accessToken, token, err := accesstokens.CreateToken(ctx, r.Database, r.Config, user.ID(), name, requestTO.Scopes, time.Now().Add(duration))
+	if err != nil {
+		return nil, errs.Wrap("issue creating personal access token", err)
+	}
+	logger.Info("Personal access token created", "tokenID", accessToken.IDHex())
+
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"reason": "access-token-create", "tokenID": accessToken.IDHex()},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccessTokenCreate,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"tokenID": accessToken.IDHex(), "name": name, "scopes": joinPermissions(requestTO.Scopes)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	return personalAccessTokenSection(ctx, r, token)
+}
+
+type RevokePersonalAccessTokenTO struct {
+	TokenID string `form:"tokenID" json:"tokenID"`
+}
+
+func RevokePersonalAccessToken(ctx *gin.Context, r *dm.RequestContext, requestTO RevokePersonalAccessTokenTO) (templ.Component, error) {
+	logger := r.Logger
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	tokenID, err := primitive.ObjectIDFromHex(requestTO.TokenID)
+	if err != nil {
+		logger.Info("Attempt to revoke personal access token with invalid ID")
+		return personalAccessTokenSection(ctx, r, "")
+	}
+
+	revoked, err := accesstokens.RevokeToken(ctx, r.Database, user.ID(), tokenID)
+	if err != nil {
+		return nil, errs.Wrap("issue revoking personal access token", err)
+	}
+	if !revoked {
+		logger.Info("Attempt to revoke unknown personal access token", "tokenID", requestTO.TokenID)
+		return personalAccessTokenSection(ctx, r, "")
+	}
+	logger.Info("Personal access token revoked", "tokenID", requestTO.TokenID)
+
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"reason": "access-token-revoke", "tokenID": requestTO.TokenID},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAccessTokenRevoke,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"tokenID": requestTO.TokenID},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+
+	return personalAccessTokenSection(ctx, r, "")
+}
+
+func personalAccessTokenSection(ctx *gin.Context, r *dm.RequestContext, newToken string) (templ.Component, error) {
+	tokens, err := accesstokens.GetTokensForUser(ctx, r.Database, r.User.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching personal access tokens", err)
+	}
+	return userrender.PersonalAccessTokenSection(tokens, grantablePermissions(r.Permissions), newToken), nil
+}
+
+// grantablePermissions lists the permissions a personal access token of the current user may be scoped to
+func grantablePermissions(permissions dm.PermissionSet) []dm.Permission {
+	var grantable []dm.Permission
+	for _, permission := range dm.AllPermissions {
+		if permissions.Has(permission) {
+			grantable = append(grantable, permission)
+		}
+	}
+	return grantable
+}
+
+func joinPermissions(permissions []dm.Permission) string {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		names[i] = string(permission)
+	}
+	return strings.Join(names, ",")
+}
@@ -3,7 +3,9 @@ package resource
 import (
 	"time"
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/audit"
 	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
 	"user-manager/cmd/app/service/mail"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
@@ -36,12 +38,19 @@ func RequestPasswordReset(ctx *gin.Context, r *dm.RequestContext, requestTO *Pas
 		return nil
 	}
 
+	if err = sendPasswordReset(ctx, r, user); err != nil {
+		return errs.Wrap("issue sending password reset", err)
+	}
+	return nil
+}
+
+func sendPasswordReset(ctx *gin.Context, r *dm.RequestContext, user dm.User) error {
 	token := random.MakeRandomURLSafeB64(21)
-	if err := users.SetPasswordResetToken(ctx, r.Database, user.ID(), token, time.Now().Add(dm.PasswordResetTokenDuration)); err != nil {
+	if err := users.SetPasswordResetTokenHash(ctx, r.Database, user.ID(), auth.HashToken(r.Config, token), time.Now().Add(dm.PasswordResetTokenDuration)); err != nil {
 		return errs.Wrap("issue persisting password reset token", err)
 	}
 
-	if err := mail.SendResetPasswordEmail(ctx, r, user.Email, token); err != nil {
+	if err := mail.SendResetPasswordEmail(ctx, r, user.Email, user.Name, token); err != nil {
 		return errs.Wrap("error sending password reset email", err)
 	}
 	return nil
@@ -56,12 +65,14 @@ type ResetPasswordTO struct {
 type ResetPasswordStatus string
 
 const (
-	ResetPasswordResponseSuccess ResetPasswordStatus = "success"
-	ResetPasswordResponseInvalid ResetPasswordStatus = "invalid-token"
+	ResetPasswordResponseSuccess  ResetPasswordStatus = "success"
+	ResetPasswordResponseInvalid  ResetPasswordStatus = "invalid-token"
+	ResetPasswordResponseRejected ResetPasswordStatus = "password-rejected"
 )
 
 type ResetPasswordResponseTO struct {
-	Status ResetPasswordStatus `json:"status"`
+	Status     ResetPasswordStatus          `json:"status"`
+	Violations []dm.PasswordPolicyViolation `json:"violations,omitempty"`
 }
 
 func ResetPassword(ctx *gin.Context, r *dm.RequestContext, requestTO ResetPasswordTO) (ResetPasswordResponseTO, error) {
@@ -75,18 +86,31 @@ func ResetPassword(ctx *gin.Context, r *dm.RequestContext, requestTO ResetPasswo
 
 	if !user.IsPresent() {
 		logger.Info("Password reset attempt for non-existing email")
-		return ResetPasswordResponseTO{ResetPasswordResponseInvalid}, nil
+		return ResetPasswordResponseTO{Status: ResetPasswordResponseInvalid}, nil
 	}
-	if user.PasswordResetToken == "" || user.PasswordResetToken != requestTO.Token {
+	if !auth.VerifyTokenHash(r.Config, requestTO.Token, user.PasswordResetTokenHash) {
 		logger.Info("Password reset attempt with wrong token")
-		return ResetPasswordResponseTO{ResetPasswordResponseInvalid}, nil
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypePasswordReset,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": "invalid-token"},
+		}); err != nil {
+			return ResetPasswordResponseTO{}, errs.Wrap("issue recording audit event", err)
+		}
+		return ResetPasswordResponseTO{Status: ResetPasswordResponseInvalid}, nil
 	}
 	if user.PasswordResetTokenValidUntil.Before(time.Now()) {
 		logger.Info("Password reset attempt with expired token")
-		return ResetPasswordResponseTO{ResetPasswordResponseInvalid}, nil
+		return ResetPasswordResponseTO{Status: ResetPasswordResponseInvalid}, nil
+	}
+
+	if violations := auth.CheckPasswordPolicy(r.Config, requestTO.NewPassword, user.Email, user.Name); len(violations) > 0 {
+		logger.Info("Password reset with password rejected by policy", "violations", violations)
+		return ResetPasswordResponseTO{Status: ResetPasswordResponseRejected, Violations: violations}, nil
 	}
 
-	hash, err := auth.MakeCredentials(requestTO.NewPassword)
+	hash, err := auth.MakeCredentials(r.Config, requestTO.NewPassword)
 	if err != nil {
 		return ResetPasswordResponseTO{}, errs.Wrap("issue making password hash", err)
 	}
@@ -95,5 +119,25 @@ func ResetPassword(ctx *gin.Context, r *dm.RequestContext, requestTO ResetPasswo
 		return ResetPasswordResponseTO{}, errs.Wrap("issue setting password hash", err)
 	}
 
-	return ResetPasswordResponseTO{ResetPasswordResponseSuccess}, nil
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"reason": "password-reset"},
+	}); err != nil {
+		return ResetPasswordResponseTO{}, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	if err = audit.Record(ctx, r, dm.AuditEvent{Type: dm.AuditEventTypePasswordReset, Outcome: dm.AuditOutcomeSuccess, SubjectID: user.ObjectID}); err != nil {
+		return ResetPasswordResponseTO{}, errs.Wrap("issue recording audit event", err)
+	}
+
+	// the reset link proves control over the email address just like the unlock link
+	if user.IsLocked() {
+		if err = unlockAccount(ctx, r, user); err != nil {
+			return ResetPasswordResponseTO{}, errs.Wrap("issue unlocking account", err)
+		}
+		logger.Info("Account unlocked by password reset")
+	}
+
+	return ResetPasswordResponseTO{Status: ResetPasswordResponseSuccess}, nil
 }
@@ -0,0 +1,183 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"regexp"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/middleware"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/roles"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,31}$`)
+
+func RegisterRolesResource(group *gin.RouterGroup) {
+	requireRolesManage := middleware.RequirePermission(dm.PermissionRolesManage)
+
+	group.GET("roles", requireRolesManage, ginext.WrapTemplWithoutPayload(RolesPage))
+	group.POST("save-role", requireRolesManage, ginext.WrapTempl(SaveRole))
+	group.POST("delete-role", requireRolesManage, ginext.WrapTempl(DeleteRole))
+}
+
+func RolesPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	roleList, err := makeRoleList(ctx, r, "", false)
+	if err != nil {
+		return nil, errs.Wrap("issue making role list", err)
+	}
+	return render.FullPage(ctx, "Roles", admin.Roles(roleList)), nil
+}
+
+type SaveRoleTO struct {
+	Name        dm.UserRole     `form:"name"`
+	Description string          `form:"description"`
+	Permissions []dm.Permission `form:"permissions"`
+	Inherits    []dm.UserRole   `form:"inherits"`
+}
+
+// SaveRole creates a role or replaces the definition of an existing one
+func SaveRole(ctx *gin.Context, r *dm.RequestContext, requestTO SaveRoleTO) (templ.Component, error) {
+	logger := r.Logger
+
+	if !roleNamePattern.MatchString(string(requestTO.Name)) {
+		logger.Info("Role with invalid name submitted")
+		return makeRoleList(ctx, r, "Role names consist of 2 to 32 lowercase letters, digits and dashes.", true)
+	}
+	for _, permission := range requestTO.Permissions {
+		if !slices.Contains(dm.AllPermissions, permission) {
+			logger.Info("Role with unknown permission submitted", "permission", permission)
+			return makeRoleList(ctx, r, "Unknown permission "+string(permission)+".", true)
+		}
+	}
+
+	roleDefinitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading roles", err)
+	}
+	definitions := roles.MapRoles(roleDefinitions)
+
+	if definitions[requestTO.Name].Builtin {
+		logger.Info("Change of builtin role attempted", "role", requestTO.Name)
+		return makeRoleList(ctx, r, "Builtin roles cannot be changed.", true)
+	}
+	for _, inherited := range requestTO.Inherits {
+		if _, ok := definitions[inherited]; !ok {
+			logger.Info("Role inheriting unknown role submitted", "inherits", inherited)
+			return makeRoleList(ctx, r, "Unknown role "+string(inherited)+".", true)
+		}
+	}
+
+	role := dm.Role{
+		Name:        requestTO.Name,
+		Description: requestTO.Description,
+		Permissions: requestTO.Permissions,
+		Inherits:    requestTO.Inherits,
+	}
+	definitions[role.Name] = role
+	if dm.InheritsFrom(definitions, role.Name, role.Name) {
+		logger.Info("Role with inheritance cycle submitted", "role", role.Name)
+		return makeRoleList(ctx, r, "A role cannot inherit from itself.", true)
+	}
+	// the editor must not hand out permissions they do not hold, neither directly nor through inheritance
+	for permission := range dm.ResolvePermissions(definitions, []dm.UserRole{role.Name}) {
+		if !r.Permissions.Has(permission) {
+			logger.Info("Role granting permission not held by the editor submitted", "role", role.Name, "permission", permission)
+			return makeRoleList(ctx, r, "You cannot grant the permission "+string(permission)+", because you do not hold it.", true)
+		}
+	}
+
+	if err = roles.SaveRole(ctx, r.Database, role); err != nil {
+		return nil, errs.Wrap("issue saving role", err)
+	}
+
+	logger.Info("Role saved", "role", role.Name, "permissions", role.Permissions, "inherits", role.Inherits)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeRoleDefinitionSave,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{
+			"role":        string(role.Name),
+			"permissions": joinPermissions(role.Permissions),
+			"inherits":    joinRoles(role.Inherits),
+		},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeRoleList(ctx, r, "Role "+string(role.Name)+" saved.", false)
+}
+
+type DeleteRoleTO struct {
+	Name dm.UserRole `form:"name"`
+}
+
+func DeleteRole(ctx *gin.Context, r *dm.RequestContext, requestTO DeleteRoleTO) (templ.Component, error) {
+	logger := r.Logger
+
+	roleDefinitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading roles", err)
+	}
+	definitions := roles.MapRoles(roleDefinitions)
+
+	role, ok := definitions[requestTO.Name]
+	if !ok {
+		logger.Info("Deletion of unknown role attempted")
+		return makeRoleList(ctx, r, "This role does not exist.", true)
+	}
+	if role.Builtin {
+		logger.Info("Deletion of builtin role attempted", "role", role.Name)
+		return makeRoleList(ctx, r, "Builtin roles cannot be deleted.", true)
+	}
+	for _, definition := range roleDefinitions {
+		if slices.Contains(definition.Inherits, role.Name) {
+			logger.Info("Deletion of inherited role attempted", "role", role.Name)
+			return makeRoleList(ctx, r, "The role is inherited by "+string(definition.Name)+".", true)
+		}
+	}
+
+	assignedCount, err := users.CountUsersWithRole(ctx, r.Database, role.Name)
+	if err != nil {
+		return nil, errs.Wrap("issue counting users with role", err)
+	}
+	if assignedCount > 0 {
+		logger.Info("Deletion of assigned role attempted", "role", role.Name)
+		return makeRoleList(ctx, r, "The role is still assigned to users.", true)
+	}
+
+	if err = roles.DeleteRole(ctx, r.Database, role.Name); err != nil {
+		return nil, errs.Wrap("issue deleting role", err)
+	}
+
+	logger.Info("Role deleted", "role", role.Name)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeRoleDefinitionDelete,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"role": string(role.Name)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeRoleList(ctx, r, "Role "+string(role.Name)+" deleted.", false)
+}
+
+func makeRoleList(ctx *gin.Context, r *dm.RequestContext, message string, isError bool) (templ.Component, error) {
+	roleDefinitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading roles", err)
+	}
+	return admin.RoleList(roleDefinitions, dm.AllPermissions, message, isError), nil
+}
+
+func joinRoles(roles []dm.UserRole) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,441 @@
+package resource
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/roles"
+	"user-manager/cmd/app/service/scim"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScimGroupsResource maps SCIM groups onto roles. The displayName is the role name, so groups cannot be renamed.
+// Groups created by the identity provider grant no permissions until a super-admin edits the role.
+func RegisterScimGroupsResource(group *gin.RouterGroup) {
+	group.GET("Groups", ListScimGroups)
+	group.POST("Groups", CreateScimGroup)
+	group.GET("Groups/:id", GetScimGroup)
+	group.PUT("Groups/:id", ReplaceScimGroup)
+	group.PATCH("Groups/:id", PatchScimGroup)
+	group.DELETE("Groups/:id", DeleteScimGroup)
+}
+
+type ScimGroupTO struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id,omitempty"`
+	DisplayName string            `json:"displayName"`
+	Members     []ScimReferenceTO `json:"members,omitempty"`
+	Meta        *ScimMetaTO       `json:"meta,omitempty"`
+}
+
+var scimMemberFilterPattern = regexp.MustCompile(`(?i)^members\[value eq "([0-9a-f]{24})"]$`)
+
+// makeScimGroup lists the members unless the identity provider excluded them, which it does for large groups
+func makeScimGroup(ctx *gin.Context, r *dm.RequestContext, role dm.Role) (ScimGroupTO, error) {
+	groupTO := ScimGroupTO{
+		Schemas:     []string{dm.ScimSchemaGroup},
+		ID:          string(role.Name),
+		DisplayName: string(role.Name),
+		Meta:        &ScimMetaTO{ResourceType: "Group", Location: scimBaseURL(r.Config) + "/Groups/" + string(role.Name)},
+	}
+	if strings.Contains(strings.ToLower(ctx.Query("excludedAttributes")), "members") {
+		return groupTO, nil
+	}
+
+	members, err := getScimGroupMembers(ctx, r, role)
+	if err != nil {
+		return ScimGroupTO{}, errs.Wrap("issue fetching group members", err)
+	}
+	for _, member := range members {
+		groupTO.Members = append(groupTO.Members, ScimReferenceTO{Value: member.IDHex(), Display: member.Email, Ref: scimBaseURL(r.Config) + "/Users/" + member.IDHex()})
+	}
+	return groupTO, nil
+}
+
+// getScimGroupMembers returns the managed users holding the role. Other holders are neither listed nor changed.
+func getScimGroupMembers(ctx *gin.Context, r *dm.RequestContext, role dm.Role) ([]dm.User, error) {
+	members, err := users.GetUsersWithRole(ctx, r.Database, role.Name)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching users with role", err)
+	}
+	return slices.DeleteFunc(members, func(user dm.User) bool { return !user.IsScimManaged() }), nil
+}
+
+func ListScimGroups(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	filter, err := scim.ParseFilter(ctx.Query("filter"), scim.GroupFilterAttributes)
+	if err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidFilter", err.Error()})
+		return
+	}
+	groups, err := scim.GetGroups(ctx, r.Database, filter)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching groups", err))
+		return
+	}
+
+	startIndex, count := scimPage(ctx)
+	page := groups[min(startIndex-1, len(groups)):min(startIndex-1+count, len(groups))]
+	groupTOs := make([]ScimGroupTO, 0, len(page))
+	for _, role := range page {
+		groupTO, err := makeScimGroup(ctx, r, role)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making group", err))
+			return
+		}
+		groupTOs = append(groupTOs, groupTO)
+	}
+	scimJSON(ctx, http.StatusOK, makeScimListResponse(groupTOs, len(groups), startIndex))
+}
+
+// getScimGroup returns the role addressed by the id path parameter or a not found scimError
+func getScimGroup(ctx *gin.Context, r *dm.RequestContext) (dm.Role, error) {
+	name := dm.UserRole(ctx.Param("id"))
+	if name == dm.UserRoleUser {
+		return dm.Role{}, scimError{http.StatusNotFound, "", "group " + ctx.Param("id") + " not found"}
+	}
+	role, err := roles.GetRole(ctx, r.Database, name)
+	if err != nil {
+		return dm.Role{}, errs.Wrap("issue fetching role", err)
+	}
+	if !role.IsPresent() {
+		return dm.Role{}, scimError{http.StatusNotFound, "", "group " + ctx.Param("id") + " not found"}
+	}
+	return role, nil
+}
+
+func GetScimGroup(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	role, err := getScimGroup(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching group", err)
+		return
+	}
+	groupTO, err := makeScimGroup(ctx, r, role)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making group", err))
+		return
+	}
+	scimJSON(ctx, http.StatusOK, groupTO)
+}
+
+func CreateScimGroup(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	var requestTO ScimGroupTO
+	if err := ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a group"})
+		return
+	}
+	name := dm.UserRole(requestTO.DisplayName)
+	if !roleNamePattern.MatchString(string(name)) {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidValue", "displayName must consist of 2 to 32 lowercase letters, digits and dashes"})
+		return
+	}
+
+	existing, err := roles.GetRole(ctx, r.Database, name)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching role", err))
+		return
+	}
+	if existing.IsPresent() {
+		abortWithScimError(ctx, r, "", scimError{http.StatusConflict, "uniqueness", "a group with this displayName exists already"})
+		return
+	}
+
+	role := dm.Role{Name: name, Description: "Provisioned by the identity provider"}
+	if err = roles.SaveRole(ctx, r.Database, role); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue saving role", err))
+		return
+	}
+	logger.Info("Group provisioned", "role", role.Name)
+
+	if err = setScimGroupMembers(ctx, r, role, requestTO.Members); err != nil {
+		abortWithScimError(ctx, r, "issue setting group members", err)
+		return
+	}
+	groupTO, err := makeScimGroup(ctx, r, role)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making group", err))
+		return
+	}
+	ctx.Header("Location", groupTO.Meta.Location)
+	scimJSON(ctx, http.StatusCreated, groupTO)
+}
+
+func ReplaceScimGroup(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	role, err := getScimGroup(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching group", err)
+		return
+	}
+	var requestTO ScimGroupTO
+	if err = ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a group"})
+		return
+	}
+	if requestTO.DisplayName != string(role.Name) {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "mutability", "groups cannot be renamed"})
+		return
+	}
+
+	if err = setScimGroupMembers(ctx, r, role, requestTO.Members); err != nil {
+		abortWithScimError(ctx, r, "issue setting group members", err)
+		return
+	}
+	groupTO, err := makeScimGroup(ctx, r, role)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making group", err))
+		return
+	}
+	scimJSON(ctx, http.StatusOK, groupTO)
+}
+
+// PatchScimGroup supports adding, removing and replacing members, which is what identity providers use to sync memberships
+func PatchScimGroup(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	role, err := getScimGroup(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching group", err)
+		return
+	}
+	var requestTO ScimPatchTO
+	if err = ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a patch"})
+		return
+	}
+
+	currentMembers, err := getScimGroupMembers(ctx, r, role)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching group members", err))
+		return
+	}
+	memberIDs := make([]string, 0, len(currentMembers))
+	for _, member := range currentMembers {
+		memberIDs = append(memberIDs, member.IDHex())
+	}
+	for _, operation := range requestTO.Operations {
+		if memberIDs, err = patchScimGroupMembers(role, memberIDs, operation); err != nil {
+			abortWithScimError(ctx, r, "", err)
+			return
+		}
+	}
+
+	members := make([]ScimReferenceTO, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		members = append(members, ScimReferenceTO{Value: memberID})
+	}
+	if err = setScimGroupMembers(ctx, r, role, members); err != nil {
+		abortWithScimError(ctx, r, "issue setting group members", err)
+		return
+	}
+	groupTO, err := makeScimGroup(ctx, r, role)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue making group", err))
+		return
+	}
+	scimJSON(ctx, http.StatusOK, groupTO)
+}
+
+// patchScimGroupMembers applies an operation to the member ids
+func patchScimGroupMembers(role dm.Role, memberIDs []string, operation ScimPatchOperationTO) ([]string, error) {
+	op := strings.ToLower(operation.Op)
+	path := strings.ToLower(operation.Path)
+
+	if path == "" && op != "remove" {
+		var values map[string]json.RawMessage
+		if json.Unmarshal(operation.Value, &values) != nil {
+			return nil, scimError{http.StatusBadRequest, "invalidValue", "operations without path need an object value"}
+		}
+		for attribute, value := range values {
+			var err error
+			if memberIDs, err = patchScimGroupMembers(role, memberIDs, ScimPatchOperationTO{Op: op, Path: attribute, Value: value}); err != nil {
+				return nil, err
+			}
+		}
+		return memberIDs, nil
+	}
+
+	if path == "displayname" {
+		var displayName string
+		if op == "remove" || json.Unmarshal(operation.Value, &displayName) != nil || displayName != string(role.Name) {
+			return nil, scimError{http.StatusBadRequest, "mutability", "groups cannot be renamed"}
+		}
+		return memberIDs, nil
+	}
+
+	if match := scimMemberFilterPattern.FindStringSubmatch(operation.Path); match != nil && op == "remove" {
+		return slices.DeleteFunc(memberIDs, func(memberID string) bool { return memberID == match[1] }), nil
+	}
+	if path != "members" {
+		return nil, scimError{http.StatusBadRequest, "invalidPath", "unsupported path " + operation.Path}
+	}
+
+	var members []ScimReferenceTO
+	if len(operation.Value) > 0 && json.Unmarshal(operation.Value, &members) != nil {
+		return nil, scimError{http.StatusBadRequest, "invalidValue", "members must be a list"}
+	}
+	switch op {
+	case "add":
+		for _, member := range members {
+			if !slices.Contains(memberIDs, member.Value) {
+				memberIDs = append(memberIDs, member.Value)
+			}
+		}
+	case "replace":
+		memberIDs = []string{}
+		for _, member := range members {
+			memberIDs = append(memberIDs, member.Value)
+		}
+	case "remove":
+		if len(members) == 0 {
+			return []string{}, nil
+		}
+		memberIDs = slices.DeleteFunc(memberIDs, func(memberID string) bool {
+			return slices.ContainsFunc(members, func(member ScimReferenceTO) bool { return member.Value == memberID })
+		})
+	default:
+		return nil, scimError{http.StatusBadRequest, "invalidSyntax", "unsupported operation " + operation.Op}
+	}
+	return memberIDs, nil
+}
+
+// setScimGroupMembers grants and revokes the role so that exactly the members hold it. Builtin roles and roles that
+// grant managing admins or roles, directly or through inheritance, are left to super-admins. Otherwise the same rules
+// apply as for super-admins granting roles: members need a second factor, and the last user able to manage admins keeps its role.
+func setScimGroupMembers(ctx *gin.Context, r *dm.RequestContext, role dm.Role, members []ScimReferenceTO) error {
+	definitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return errs.Wrap("issue loading role definitions", err)
+	}
+	permissions := dm.ResolvePermissions(roles.MapRoles(definitions), []dm.UserRole{role.Name})
+	if role.Builtin || permissions.Has(dm.PermissionAdminsManage) || permissions.Has(dm.PermissionRolesManage) {
+		return scimError{http.StatusBadRequest, "mutability", "members of group " + string(role.Name) + " can only be changed by a super-admin"}
+	}
+
+	currentMembers, err := getScimGroupMembers(ctx, r, role)
+	if err != nil {
+		return errs.Wrap("issue fetching group members", err)
+	}
+
+	var grants []dm.User
+	for _, member := range members {
+		if slices.ContainsFunc(currentMembers, func(user dm.User) bool { return user.IDHex() == member.Value }) ||
+			slices.ContainsFunc(grants, func(user dm.User) bool { return user.IDHex() == member.Value }) {
+			continue
+		}
+		userID, err := primitive.ObjectIDFromHex(member.Value)
+		if err != nil {
+			return scimError{http.StatusBadRequest, "invalidValue", "member " + member.Value + " not found"}
+		}
+		user, err := users.GetUserForID(ctx, r.Database, dm.UserID(userID))
+		if err != nil {
+			return errs.Wrap("issue fetching member", err)
+		}
+		if !user.IsPresent() || !user.IsScimManaged() {
+			return scimError{http.StatusBadRequest, "invalidValue", "member " + member.Value + " not found"}
+		}
+		if !user.HasSecondFactor() {
+			return scimError{http.StatusBadRequest, "invalidValue", "member " + member.Value + " has to enable two-factor authentication first"}
+		}
+		grants = append(grants, user)
+	}
+
+	var revocations []dm.User
+	for _, user := range currentMembers {
+		if slices.ContainsFunc(members, func(member ScimReferenceTO) bool { return member.Value == user.IDHex() }) {
+			continue
+		}
+		remainingRoles := slices.DeleteFunc(slices.Clone(user.UserRoles), func(userRole dm.UserRole) bool { return userRole == role.Name })
+		lastAdminManager, err := wouldRemoveLastAdminManager(ctx, r, user, remainingRoles)
+		if err != nil {
+			return errs.Wrap("issue checking for remaining admin managers", err)
+		}
+		if lastAdminManager {
+			return scimError{http.StatusBadRequest, "mutability", "the last super-admin cannot be removed"}
+		}
+		revocations = append(revocations, user)
+	}
+
+	for _, user := range grants {
+		if err = users.AddUserRole(ctx, r.Database, user.ID(), role.Name); err != nil {
+			return errs.Wrap("issue adding user role", err)
+		}
+		if err = recordScimRoleChange(ctx, r, user, role, dm.LedgerEntryTypeRoleGrant, dm.AuditEventTypeRoleGrant); err != nil {
+			return err
+		}
+	}
+	for _, user := range revocations {
+		if err = users.RemoveUserRole(ctx, r.Database, user.ID(), role.Name); err != nil {
+			return errs.Wrap("issue removing user role", err)
+		}
+		if err = recordScimRoleChange(ctx, r, user, role, dm.LedgerEntryTypeRoleRevoke, dm.AuditEventTypeRoleRevoke); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordScimRoleChange(ctx *gin.Context, r *dm.RequestContext, user dm.User, role dm.Role, ledgerEntryType dm.LedgerEntryType, auditEventType dm.AuditEventType) error {
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    ledgerEntryType,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"role": string(role.Name), "source": "scim"},
+	}); err != nil {
+		return errs.Wrap("issue appending ledger entry", err)
+	}
+
+	r.Logger.Info("Group membership changed", "targetUserID", user.IDHex(), "role", role.Name, "change", auditEventType)
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:      auditEventType,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"role": string(role.Name), "source": "scim"},
+	}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+	return nil
+}
+
+// DeleteScimGroup revokes the role from all members before deleting it. Builtin roles cannot be deleted.
+func DeleteScimGroup(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	role, err := getScimGroup(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching group", err)
+		return
+	}
+	if role.Builtin {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "mutability", "builtin groups cannot be deleted"})
+		return
+	}
+
+	if err = setScimGroupMembers(ctx, r, role, nil); err != nil {
+		abortWithScimError(ctx, r, "issue removing group members", err)
+		return
+	}
+	if err = roles.DeleteRole(ctx, r.Database, role.Name); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue deleting role", err))
+		return
+	}
+	logger.Info("Group deleted", "role", role.Name)
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,576 @@
+package resource
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/accountdeletion"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/scim"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScimUsersResource maps SCIM users onto dm.User. The userName is the email, which the identity provider vouches for.
+// Users that signed up or were created in the app are out of reach, provisioning only sees the users it manages.
+func RegisterScimUsersResource(group *gin.RouterGroup) {
+	group.GET("Users", ListScimUsers)
+	group.POST("Users", CreateScimUser)
+	group.GET("Users/:id", GetScimUser)
+	group.PUT("Users/:id", ReplaceScimUser)
+	group.PATCH("Users/:id", PatchScimUser)
+	group.DELETE("Users/:id", DeleteScimUser)
+}
+
+type ScimNameTO struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimEmailTO struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimReferenceTO struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type ScimUserTO struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id,omitempty"`
+	ExternalID  string            `json:"externalId,omitempty"`
+	UserName    string            `json:"userName"`
+	Name        *ScimNameTO       `json:"name,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Emails      []ScimEmailTO     `json:"emails,omitempty"`
+	Active      *bool             `json:"active,omitempty"`
+	Groups      []ScimReferenceTO `json:"groups,omitempty"`
+	Meta        *ScimMetaTO       `json:"meta,omitempty"`
+}
+
+type ScimPatchTO struct {
+	Schemas    []string               `json:"schemas"`
+	Operations []ScimPatchOperationTO `json:"Operations"`
+}
+
+type ScimPatchOperationTO struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func makeScimUser(config *dm.Config, user dm.User) ScimUserTO {
+	active := !user.IsDeactivated()
+	created := user.ObjectID.Timestamp()
+	userTO := ScimUserTO{
+		Schemas:     []string{dm.ScimSchemaUser},
+		ID:          user.IDHex(),
+		ExternalID:  user.ScimExternalID,
+		UserName:    user.Email,
+		DisplayName: user.Name,
+		Emails:      []ScimEmailTO{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta:        &ScimMetaTO{ResourceType: "User", Created: &created, Location: scimBaseURL(config) + "/Users/" + user.IDHex()},
+	}
+	if user.Name != "" {
+		userTO.Name = &ScimNameTO{Formatted: user.Name}
+	}
+	for _, role := range user.UserRoles {
+		if role != dm.UserRoleUser {
+			userTO.Groups = append(userTO.Groups, ScimReferenceTO{Value: string(role), Display: string(role), Ref: scimBaseURL(config) + "/Groups/" + string(role)})
+		}
+	}
+	return userTO
+}
+
+type scimUserAttributes struct {
+	name       string
+	email      string
+	externalID string
+	active     bool
+}
+
+// readScimUser returns the attributes the app stores. The name is taken from the most specific attribute present.
+func readScimUser(userTO ScimUserTO) (scimUserAttributes, error) {
+	attributes := scimUserAttributes{
+		name:       strings.TrimSpace(userTO.DisplayName),
+		email:      strings.TrimSpace(userTO.UserName),
+		externalID: userTO.ExternalID,
+		active:     userTO.Active == nil || *userTO.Active,
+	}
+	if attributes.name == "" && userTO.Name != nil {
+		attributes.name = strings.TrimSpace(userTO.Name.Formatted)
+		if attributes.name == "" {
+			attributes.name = strings.TrimSpace(userTO.Name.GivenName + " " + userTO.Name.FamilyName)
+		}
+	}
+	if !strings.Contains(attributes.email, "@") {
+		attributes.email = ""
+		for _, email := range userTO.Emails {
+			if strings.Contains(email.Value, "@") && (attributes.email == "" || email.Primary) {
+				attributes.email = strings.TrimSpace(email.Value)
+			}
+		}
+	}
+	if attributes.email == "" {
+		return scimUserAttributes{}, scimError{http.StatusBadRequest, "invalidValue", "userName or a primary email must be an email address"}
+	}
+	return attributes, nil
+}
+
+func ListScimUsers(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	filter, err := scim.ParseFilter(ctx.Query("filter"), scim.UserFilterAttributes)
+	if err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidFilter", err.Error()})
+		return
+	}
+	startIndex, count := scimPage(ctx)
+	matches, total, err := scim.ListUsers(ctx, r.Database, filter, startIndex, count)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue listing users", err))
+		return
+	}
+
+	userTOs := make([]ScimUserTO, 0, len(matches))
+	for _, user := range matches {
+		userTOs = append(userTOs, makeScimUser(r.Config, user))
+	}
+	scimJSON(ctx, http.StatusOK, makeScimListResponse(userTOs, int(total), startIndex))
+}
+
+// getScimUser returns the managed user addressed by the id path parameter or a not found scimError
+func getScimUser(ctx *gin.Context, r *dm.RequestContext) (dm.User, error) {
+	userID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return dm.User{}, scimError{http.StatusNotFound, "", "user " + ctx.Param("id") + " not found"}
+	}
+	user, err := users.GetUserForID(ctx, r.Database, dm.UserID(userID))
+	if err != nil {
+		return dm.User{}, errs.Wrap("issue fetching user", err)
+	}
+	if !user.IsPresent() || !user.IsScimManaged() {
+		return dm.User{}, scimError{http.StatusNotFound, "", "user " + ctx.Param("id") + " not found"}
+	}
+	return user, nil
+}
+
+func GetScimUser(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	user, err := getScimUser(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching user", err)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, makeScimUser(r.Config, user))
+}
+
+func CreateScimUser(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	var requestTO ScimUserTO
+	if err := ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a user"})
+		return
+	}
+	attributes, err := readScimUser(requestTO)
+	if err != nil {
+		abortWithScimError(ctx, r, "", err)
+		return
+	}
+
+	existing, err := users.GetUserForEmail(ctx, r.Database, attributes.email)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue fetching user for email", err))
+		return
+	}
+	if existing.IsPresent() {
+		abortWithScimError(ctx, r, "", scimError{http.StatusConflict, "uniqueness", "a user with this userName exists already"})
+		return
+	}
+
+	user := dm.User{
+		Name:            attributes.name,
+		Email:           attributes.email,
+		EmailVerified:   true,
+		UserRoles:       []dm.UserRole{dm.UserRoleUser},
+		ScimExternalID:  attributes.externalID,
+		ScimProvisioned: true,
+	}
+	if !attributes.active {
+		user.DeactivatedAt = time.Now()
+	}
+	if user, err = users.InsertProvisionedUser(ctx, r.Database, user); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue inserting provisioned user", err))
+		return
+	}
+
+	logger.Info("User provisioned", "userID", user.IDHex())
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeUserProvision,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"operation": "create", "active": strconv.FormatBool(attributes.active)},
+	}); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue recording audit event", err))
+		return
+	}
+
+	userTO := makeScimUser(r.Config, user)
+	ctx.Header("Location", userTO.Meta.Location)
+	scimJSON(ctx, http.StatusCreated, userTO)
+}
+
+func ReplaceScimUser(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	user, err := getScimUser(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching user", err)
+		return
+	}
+	var requestTO ScimUserTO
+	if err = ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a user"})
+		return
+	}
+
+	if user, err = updateScimUser(ctx, r, user, requestTO); err != nil {
+		abortWithScimError(ctx, r, "issue updating user", err)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, makeScimUser(r.Config, user))
+}
+
+// PatchScimUser applies the operations to the current representation of the user, then stores it like a replacement
+func PatchScimUser(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	user, err := getScimUser(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching user", err)
+		return
+	}
+	var requestTO ScimPatchTO
+	if err = ctx.ShouldBindJSON(&requestTO); err != nil {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "invalidSyntax", "request body is not a patch"})
+		return
+	}
+
+	userTO := makeScimUser(r.Config, user)
+	for _, operation := range requestTO.Operations {
+		if err = patchScimUser(&userTO, operation); err != nil {
+			abortWithScimError(ctx, r, "", err)
+			return
+		}
+	}
+
+	if user, err = updateScimUser(ctx, r, user, userTO); err != nil {
+		abortWithScimError(ctx, r, "issue updating user", err)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, makeScimUser(r.Config, user))
+}
+
+// patchScimUser applies an operation to the attributes the app stores. Operations on other attributes are ignored, as
+// identity providers send whatever attributes they have.
+func patchScimUser(userTO *ScimUserTO, operation ScimPatchOperationTO) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimError{http.StatusBadRequest, "invalidSyntax", "unsupported operation " + operation.Op}
+	}
+
+	path := strings.ToLower(operation.Path)
+	if path == "" {
+		var values map[string]json.RawMessage
+		if op == "remove" || json.Unmarshal(operation.Value, &values) != nil {
+			return scimError{http.StatusBadRequest, "invalidValue", "operations without path need an object value"}
+		}
+		for attribute, value := range values {
+			if err := patchScimUser(userTO, ScimPatchOperationTO{Op: op, Path: attribute, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if userTO.Name == nil {
+		userTO.Name = &ScimNameTO{}
+	}
+	switch {
+	case path == "active":
+		if op == "remove" {
+			return scimError{http.StatusBadRequest, "mutability", "active cannot be removed"}
+		}
+		active, err := readScimBool(operation.Value)
+		if err != nil {
+			return err
+		}
+		userTO.Active = &active
+		return nil
+	case path == "username":
+		return patchScimString(&userTO.UserName, op, operation.Value)
+	case path == "externalid":
+		return patchScimString(&userTO.ExternalID, op, operation.Value)
+	case path == "displayname":
+		return patchScimString(&userTO.DisplayName, op, operation.Value)
+	case path == "name":
+		userTO.DisplayName, userTO.Name = "", &ScimNameTO{}
+		if op == "remove" {
+			return nil
+		}
+		if err := json.Unmarshal(operation.Value, userTO.Name); err != nil {
+			return scimError{http.StatusBadRequest, "invalidValue", "name must be an object"}
+		}
+		return nil
+	case path == "name.formatted":
+		userTO.DisplayName = ""
+		return patchScimString(&userTO.Name.Formatted, op, operation.Value)
+	case path == "name.givenname":
+		userTO.DisplayName, userTO.Name.Formatted = "", ""
+		return patchScimString(&userTO.Name.GivenName, op, operation.Value)
+	case path == "name.familyname":
+		userTO.DisplayName, userTO.Name.Formatted = "", ""
+		return patchScimString(&userTO.Name.FamilyName, op, operation.Value)
+	case path == "emails":
+		userTO.Emails = nil
+		if op == "remove" {
+			return nil
+		}
+		if err := json.Unmarshal(operation.Value, &userTO.Emails); err != nil {
+			return scimError{http.StatusBadRequest, "invalidValue", "emails must be a list"}
+		}
+		return nil
+	case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		// The app stores a single email, so every filter addresses it
+		email := ScimEmailTO{Type: "work", Primary: true}
+		if err := patchScimString(&email.Value, op, operation.Value); err != nil {
+			return err
+		}
+		userTO.Emails = []ScimEmailTO{email}
+		return nil
+	}
+	return nil
+}
+
+func patchScimString(target *string, op string, value json.RawMessage) error {
+	if op == "remove" {
+		*target = ""
+		return nil
+	}
+	if err := json.Unmarshal(value, target); err != nil {
+		return scimError{http.StatusBadRequest, "invalidValue", "expected a string value"}
+	}
+	return nil
+}
+
+// readScimBool accepts strings as well, as some identity providers send "False" for booleans
+func readScimBool(value json.RawMessage) (bool, error) {
+	var result bool
+	if err := json.Unmarshal(value, &result); err == nil {
+		return result, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if result, err = strconv.ParseBool(text); err == nil {
+			return result, nil
+		}
+	}
+	return false, scimError{http.StatusBadRequest, "invalidValue", "expected a boolean value"}
+}
+
+// updateScimUser stores the attributes of the SCIM user and returns the updated user
+func updateScimUser(ctx *gin.Context, r *dm.RequestContext, user dm.User, userTO ScimUserTO) (dm.User, error) {
+	logger := r.Logger
+
+	attributes, err := readScimUser(userTO)
+	if err != nil {
+		return dm.User{}, err
+	}
+
+	if !strings.EqualFold(attributes.email, user.Email) {
+		existing, err := users.GetUserForEmail(ctx, r.Database, attributes.email)
+		if err != nil {
+			return dm.User{}, errs.Wrap("issue fetching user for email", err)
+		}
+		if existing.IsPresent() {
+			return dm.User{}, scimError{http.StatusConflict, "uniqueness", "a user with this userName exists already"}
+		}
+	}
+
+	if attributes.name != user.Name || attributes.email != user.Email || attributes.externalID != user.ScimExternalID {
+		if err = users.SetProvisionedAttributes(ctx, r.Database, user.ID(), attributes.name, attributes.email, attributes.externalID); err != nil {
+			return dm.User{}, errs.Wrap("issue setting provisioned attributes", err)
+		}
+		logger.Info("Provisioned user updated", "userID", user.IDHex())
+		details := map[string]string{"operation": "update"}
+		if attributes.email != user.Email {
+			details["previousEmail"], details["newEmail"] = user.Email, attributes.email
+		}
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeUserProvision,
+			Outcome:   dm.AuditOutcomeSuccess,
+			SubjectID: user.ObjectID,
+			Details:   details,
+		}); err != nil {
+			return dm.User{}, errs.Wrap("issue recording audit event", err)
+		}
+	}
+
+	if attributes.email != user.Email {
+		if err = changeScimUserEmail(ctx, r, user, attributes.email); err != nil {
+			return dm.User{}, err
+		}
+	}
+
+	if attributes.active == user.IsDeactivated() {
+		if err = setScimUserActive(ctx, r, user, attributes.active); err != nil {
+			return dm.User{}, err
+		}
+	}
+
+	user, err = users.GetUserForID(ctx, r.Database, user.ID())
+	if err != nil {
+		return dm.User{}, errs.Wrap("issue fetching updated user", err)
+	}
+	return user, nil
+}
+
+// changeScimUserEmail treats a changed email like a confirmed email change: it is recorded in the ledger and the previous
+// address is notified. Sessions and the tokens sent to the previous address stop working.
+func changeScimUserEmail(ctx *gin.Context, r *dm.RequestContext, user dm.User, newEmail string) error {
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeEmailChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"previousEmail": user.Email, "newEmail": newEmail, "source": "scim"},
+	}); err != nil {
+		return errs.Wrap("issue appending ledger entry", err)
+	}
+	if err := mail.SendProvisionedEmailChangeEmail(ctx, r, user.Email, user.Name, newEmail); err != nil {
+		return errs.Wrap("error sending provisioned email change email", err)
+	}
+	if err := auth.DeleteSessionsForUser(ctx, r.Database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting sessions", err)
+	}
+	if err := users.ClearEmailedTokens(ctx, r.Database, user.ID()); err != nil {
+		return errs.Wrap("issue clearing emailed tokens", err)
+	}
+	r.Logger.Info("Provisioned user email changed, sessions ended", "userID", user.IDHex())
+	return nil
+}
+
+// setScimUserActive deactivates or reactivates the user. Deactivation ends all sessions, and is refused for the last user able to manage admins.
+func setScimUserActive(ctx *gin.Context, r *dm.RequestContext, user dm.User, active bool) error {
+	logger := r.Logger
+
+	if active {
+		if err := users.SetDeactivated(ctx, r.Database, user.ID(), false); err != nil {
+			return errs.Wrap("issue reactivating user", err)
+		}
+		logger.Info("Provisioned user reactivated", "userID", user.IDHex())
+		if err := audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeUserProvision,
+			Outcome:   dm.AuditOutcomeSuccess,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"operation": "reactivate"},
+		}); err != nil {
+			return errs.Wrap("issue recording audit event", err)
+		}
+		return nil
+	}
+
+	lastAdminManager, err := wouldRemoveLastAdminManager(ctx, r, user, nil)
+	if err != nil {
+		return errs.Wrap("issue checking for remaining admin managers", err)
+	}
+	if lastAdminManager {
+		return scimError{http.StatusBadRequest, "mutability", "the last super-admin cannot be deactivated"}
+	}
+
+	if err = users.SetDeactivated(ctx, r.Database, user.ID(), true); err != nil {
+		return errs.Wrap("issue deactivating user", err)
+	}
+	if err = auth.DeleteSessionsForUser(ctx, r.Database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting sessions", err)
+	}
+	logger.Info("Provisioned user deactivated", "userID", user.IDHex())
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeUserDeprovision,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"operation": "deactivate"},
+	}); err != nil {
+		return errs.Wrap("issue recording audit event", err)
+	}
+	return nil
+}
+
+func DeleteScimUser(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+	logger := r.Logger
+
+	user, err := getScimUser(ctx, r)
+	if err != nil {
+		abortWithScimError(ctx, r, "issue fetching user", err)
+		return
+	}
+
+	lastAdminManager, err := wouldRemoveLastAdminManager(ctx, r, user, nil)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue checking for remaining admin managers", err))
+		return
+	}
+	if lastAdminManager {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "mutability", "the last super-admin cannot be deleted"})
+		return
+	}
+
+	lastOwnedOrganization, err := getLastOwnedOrganization(ctx, r, user)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue checking for owned organizations", err))
+		return
+	}
+	if lastOwnedOrganization.IsPresent() {
+		abortWithScimError(ctx, r, "", scimError{http.StatusBadRequest, "mutability", "the last owner of an organization cannot be deleted"})
+		return
+	}
+
+	if err = accountdeletion.DeleteUserData(ctx, r.Database, user); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue deleting data of user", err))
+		return
+	}
+	if err = users.DeleteUser(ctx, r.Database, user.ID()); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue deleting user", err))
+		return
+	}
+
+	logger.Info("Provisioned user deleted", "userID", user.IDHex())
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeUserDeprovision,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+		Details:   map[string]string{"operation": "delete", "email": user.Email},
+	}); err != nil {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue recording audit event", err))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,270 @@
+package resource
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScimResource registers the SCIM 2.0 (RFC 7643, RFC 7644) discovery endpoints. Identity providers call them
+// with a bearer token, so the group must not use the CSRF middleware.
+func RegisterScimResource(group *gin.RouterGroup) {
+	group.GET("ServiceProviderConfig", GetScimServiceProviderConfig)
+	group.GET("ResourceTypes", GetScimResourceTypes)
+	group.GET("Schemas", GetScimSchemas)
+}
+
+type ScimMetaTO struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location"`
+}
+
+type ScimListResponseTO[resourceTO interface{}] struct {
+	Schemas      []string     `json:"schemas"`
+	TotalResults int          `json:"totalResults"`
+	StartIndex   int          `json:"startIndex"`
+	ItemsPerPage int          `json:"itemsPerPage"`
+	Resources    []resourceTO `json:"Resources"`
+}
+
+type ScimErrorTO struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimError is returned by the SCIM handlers' helpers for requests the identity provider has to fix
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e scimError) Error() string {
+	return e.detail
+}
+
+func scimJSON(ctx *gin.Context, status int, body interface{}) {
+	ctx.Header("Content-Type", "application/scim+json")
+	ctx.JSON(status, body)
+}
+
+// abortWithScimError answers scimErrors in the SCIM error format. Other errors are internal.
+func abortWithScimError(ctx *gin.Context, r *dm.RequestContext, reason string, err error) {
+	var rejection scimError
+	if !errors.As(err, &rejection) {
+		_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap(reason, err))
+		return
+	}
+	r.Logger.Info("SCIM request rejected", "status", rejection.status, "scimType", rejection.scimType, "detail", rejection.detail)
+	scimJSON(ctx, rejection.status, ScimErrorTO{
+		Schemas:  []string{dm.ScimSchemaError},
+		Status:   strconv.Itoa(rejection.status),
+		ScimType: rejection.scimType,
+		Detail:   rejection.detail,
+	})
+	ctx.Abort()
+}
+
+func scimBaseURL(config *dm.Config) string {
+	return config.AppUrl + "/scim/v2"
+}
+
+// scimPage reads startIndex and count. Both are clamped to valid values instead of being rejected, as RFC 7644 asks.
+func scimPage(ctx *gin.Context) (int, int) {
+	startIndex, err := strconv.Atoi(ctx.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(ctx.Query("count"))
+	if err != nil || count > dm.ScimMaxResults {
+		count = dm.ScimMaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+	return startIndex, count
+}
+
+func makeScimListResponse[resourceTO interface{}](resources []resourceTO, totalResults int, startIndex int) ScimListResponseTO[resourceTO] {
+	return ScimListResponseTO[resourceTO]{
+		Schemas:      []string{dm.ScimSchemaListResponse},
+		TotalResults: totalResults,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+type ScimSupportedTO struct {
+	Supported bool `json:"supported"`
+}
+
+type ScimFilterSupportTO struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type ScimBulkSupportTO struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type ScimAuthenticationSchemeTO struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ScimServiceProviderConfigTO struct {
+	Schemas               []string                     `json:"schemas"`
+	Patch                 ScimSupportedTO              `json:"patch"`
+	Bulk                  ScimBulkSupportTO            `json:"bulk"`
+	Filter                ScimFilterSupportTO          `json:"filter"`
+	ChangePassword        ScimSupportedTO              `json:"changePassword"`
+	Sort                  ScimSupportedTO              `json:"sort"`
+	Etag                  ScimSupportedTO              `json:"etag"`
+	AuthenticationSchemes []ScimAuthenticationSchemeTO `json:"authenticationSchemes"`
+	Meta                  ScimMetaTO                   `json:"meta"`
+}
+
+func GetScimServiceProviderConfig(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	scimJSON(ctx, http.StatusOK, ScimServiceProviderConfigTO{
+		Schemas: []string{dm.ScimSchemaServiceProviderConfig},
+		Patch:   ScimSupportedTO{Supported: true},
+		Filter:  ScimFilterSupportTO{Supported: true, MaxResults: dm.ScimMaxResults},
+		AuthenticationSchemes: []ScimAuthenticationSchemeTO{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "The token configured as SCIM_BEARER_TOKEN in the Authorization header",
+		}},
+		Meta: ScimMetaTO{ResourceType: "ServiceProviderConfig", Location: scimBaseURL(r.Config) + "/ServiceProviderConfig"},
+	})
+}
+
+type ScimResourceTypeTO struct {
+	Schemas     []string   `json:"schemas"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Endpoint    string     `json:"endpoint"`
+	Description string     `json:"description"`
+	Schema      string     `json:"schema"`
+	Meta        ScimMetaTO `json:"meta"`
+}
+
+func GetScimResourceTypes(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	resourceTypes := []ScimResourceTypeTO{
+		{
+			Schemas:     []string{dm.ScimSchemaResourceType},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "User account",
+			Schema:      dm.ScimSchemaUser,
+			Meta:        ScimMetaTO{ResourceType: "ResourceType", Location: scimBaseURL(r.Config) + "/ResourceTypes/User"},
+		},
+		{
+			Schemas:     []string{dm.ScimSchemaResourceType},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Role granted to its members",
+			Schema:      dm.ScimSchemaGroup,
+			Meta:        ScimMetaTO{ResourceType: "ResourceType", Location: scimBaseURL(r.Config) + "/ResourceTypes/Group"},
+		},
+	}
+	scimJSON(ctx, http.StatusOK, makeScimListResponse(resourceTypes, len(resourceTypes), 1))
+}
+
+type ScimAttributeTO struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	MultiValued   bool              `json:"multiValued"`
+	Required      bool              `json:"required"`
+	CaseExact     bool              `json:"caseExact"`
+	Mutability    string            `json:"mutability"`
+	Returned      string            `json:"returned"`
+	Uniqueness    string            `json:"uniqueness"`
+	SubAttributes []ScimAttributeTO `json:"subAttributes,omitempty"`
+}
+
+type ScimSchemaTO struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Attributes  []ScimAttributeTO `json:"attributes"`
+	Meta        ScimMetaTO        `json:"meta"`
+}
+
+func scimAttribute(name string, attributeType string, mutability string, subAttributes ...ScimAttributeTO) ScimAttributeTO {
+	return ScimAttributeTO{Name: name, Type: attributeType, Mutability: mutability, Returned: "default", Uniqueness: "none", SubAttributes: subAttributes}
+}
+
+// GetScimSchemas describes the attributes the app stores. Identity providers may send others, they are ignored.
+func GetScimSchemas(ctx *gin.Context) {
+	r := ginext.GetRequestContext(ctx)
+
+	userName := scimAttribute("userName", "string", "readWrite")
+	userName.Required, userName.Uniqueness = true, "server"
+	emails := scimAttribute("emails", "complex", "readWrite",
+		scimAttribute("value", "string", "readWrite"),
+		scimAttribute("type", "string", "readWrite"),
+		scimAttribute("primary", "boolean", "readWrite"))
+	emails.MultiValued = true
+	userGroups := scimAttribute("groups", "complex", "readOnly",
+		scimAttribute("value", "string", "readOnly"),
+		scimAttribute("display", "string", "readOnly"),
+		scimAttribute("$ref", "reference", "readOnly"))
+	userGroups.MultiValued = true
+	displayName := scimAttribute("displayName", "string", "immutable")
+	displayName.Required, displayName.Uniqueness = true, "server"
+	members := scimAttribute("members", "complex", "readWrite",
+		scimAttribute("value", "string", "immutable"),
+		scimAttribute("display", "string", "readOnly"),
+		scimAttribute("$ref", "reference", "immutable"))
+	members.MultiValued = true
+
+	schemas := []ScimSchemaTO{
+		{
+			Schemas:     []string{dm.ScimSchemaSchema},
+			ID:          dm.ScimSchemaUser,
+			Name:        "User",
+			Description: "User account. The userName is the email address.",
+			Attributes: []ScimAttributeTO{
+				userName,
+				scimAttribute("name", "complex", "readWrite",
+					scimAttribute("formatted", "string", "readWrite"),
+					scimAttribute("givenName", "string", "readWrite"),
+					scimAttribute("familyName", "string", "readWrite")),
+				scimAttribute("displayName", "string", "readWrite"),
+				emails,
+				scimAttribute("active", "boolean", "readWrite"),
+				userGroups,
+			},
+			Meta: ScimMetaTO{ResourceType: "Schema", Location: scimBaseURL(r.Config) + "/Schemas/" + dm.ScimSchemaUser},
+		},
+		{
+			Schemas:     []string{dm.ScimSchemaSchema},
+			ID:          dm.ScimSchemaGroup,
+			Name:        "Group",
+			Description: "Role granted to its members. The displayName is the role name.",
+			Attributes:  []ScimAttributeTO{displayName, members},
+			Meta:        ScimMetaTO{ResourceType: "Schema", Location: scimBaseURL(r.Config) + "/Schemas/" + dm.ScimSchemaGroup},
+		},
+	}
+	scimJSON(ctx, http.StatusOK, makeScimListResponse(schemas, len(schemas), 1))
+}
@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSecondFactorEnrollmentResource(group *gin.RouterGroup) {
+	group.POST("initiate-second-factor-enrollment", ginext.WrapTemplWithoutPayload(InitiateSecondFactorEnrollment))
+	group.POST("confirm-second-factor-enrollment", ginext.WrapTempl(ConfirmSecondFactorEnrollment))
+	group.POST("disable-second-factor", ginext.WrapTempl(DisableSecondFactor))
+	group.POST("regenerate-recovery-codes", ginext.WrapTemplWithoutPayload(RegenerateRecoveryCodes))
+}
+
+func InitiateSecondFactorEnrollment(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+	if currentUser.SecondFactorToken != "" {
+		logger.Info("Second factor enrollment attempted with second factor already enabled")
+		return user.SecondFactorSection(true, len(currentUser.SecondFactorRecoveryCodes)), nil
+	}
+
+	key, err := auth.GenerateSecondFactorKey(r.Config, currentUser.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue generating second factor key", err)
+	}
+	qrCode, err := auth.MakeQRCodeDataURI(key)
+	if err != nil {
+		return nil, errs.Wrap("issue making qr code", err)
+	}
+
+	if err = users.SetTemporarySecondFactorToken(ctx, r.Database, currentUser.ID(), key.Secret()); err != nil {
+		return nil, errs.Wrap("issue persisting temporary second factor token", err)
+	}
+
+	logger.Info("Second factor enrollment initiated")
+	return user.SecondFactorEnrollment(qrCode, key.URL(), key.Secret()), nil
+}
+
+type SecondFactorCodeTO struct {
+	Code string `form:"code"`
+}
+
+func ConfirmSecondFactorEnrollment(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactorCodeTO) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+	if currentUser.TemporarySecondFactorToken == "" {
+		logger.Info("Second factor enrollment confirmation without enrollment in progress")
+		return user.SecondFactorError("No two-factor setup in progress. Please start again."), nil
+	}
+
+	if !auth.VerifySecondFactorCode(requestTO.Code, currentUser.TemporarySecondFactorToken) {
+		logger.Info("Second factor enrollment confirmation with wrong code")
+		return user.SecondFactorError("Invalid code"), nil
+	}
+
+	recoveryCodes, recoveryCodeHashes := auth.MakeRecoveryCodes()
+	if err := users.EnableSecondFactor(ctx, r.Database, currentUser.ID(), currentUser.TemporarySecondFactorToken, recoveryCodeHashes); err != nil {
+		return nil, errs.Wrap("issue enabling second factor", err)
+	}
+
+	logger.Info("Second factor enabled")
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeSecondFactorChange,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"action": "enabled"},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	ginext.HXRetarget(ctx, "#second-factor-section")
+	return user.SecondFactorEnabled(recoveryCodes), nil
+}
+
+func DisableSecondFactor(ctx *gin.Context, r *dm.RequestContext, requestTO SecondFactorCodeTO) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+	if currentUser.SecondFactorToken == "" {
+		logger.Info("Second factor disabling attempted without second factor enabled")
+		ginext.HXRetarget(ctx, "#second-factor-section")
+		return user.SecondFactorSection(false, 0), nil
+	}
+
+	if currentUser.HasPrivilegedRole() && len(currentUser.WebAuthnCredentials) == 0 {
+		logger.Info("Second factor disabling attempted by privileged user without passkey")
+		return user.SecondFactorError("Administrators cannot disable their only second factor"), nil
+	}
+
+	if !auth.VerifySecondFactorCode(requestTO.Code, currentUser.SecondFactorToken) {
+		logger.Info("Second factor disabling attempted with wrong code")
+		return user.SecondFactorError("Invalid code"), nil
+	}
+
+	if err := users.DisableSecondFactor(ctx, r.Database, currentUser.ID()); err != nil {
+		return nil, errs.Wrap("issue disabling second factor", err)
+	}
+
+	logger.Info("Second factor disabled")
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeSecondFactorChange,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"action": "disabled"},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	ginext.HXRetarget(ctx, "#second-factor-section")
+	return user.SecondFactorSection(false, 0), nil
+}
+
+func RegenerateRecoveryCodes(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+	currentUser := r.User
+
+	if !currentUser.IsPresent() {
+		return nil, errs.Error("missing user")
+	}
+	if currentUser.SecondFactorToken == "" {
+		logger.Info("Recovery code regeneration attempted without second factor enabled")
+		ginext.HXRetarget(ctx, "#second-factor-section")
+		return user.SecondFactorSection(false, 0), nil
+	}
+
+	recoveryCodes, recoveryCodeHashes := auth.MakeRecoveryCodes()
+	if err := users.SetSecondFactorRecoveryCodes(ctx, r.Database, currentUser.ID(), recoveryCodeHashes); err != nil {
+		return nil, errs.Wrap("issue setting recovery codes", err)
+	}
+
+	logger.Info("Recovery codes regenerated")
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeSecondFactorChange,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"action": "recovery-codes-regenerated"},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  currentUser.ObjectID,
+		Details: map[string]string{"reason": "recovery-codes-regenerated"},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+	return user.RecoveryCodes(recoveryCodes), nil
+}
@@ -0,0 +1,34 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/audit"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+const securityActivityLimit = 50
+
+func RegisterSecurityActivityResource(group *gin.RouterGroup) {
+	group.GET("security-activity", ginext.WrapTemplWithoutPayload(SecurityActivityPage))
+}
+
+func SecurityActivityPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	events, err := audit.GetRecentEventsForUser(ctx, r.Database, user.ID(), securityActivityLimit)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching audit events", err)
+	}
+
+	return render.FullPage(ctx, "Security activity", userrender.SecurityActivity(events)), nil
+}
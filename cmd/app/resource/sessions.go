@@ -0,0 +1,127 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSessionsResource(group *gin.RouterGroup) {
+	group.GET("sessions", ginext.WrapTemplWithoutPayload(SessionsPage))
+	group.POST("revoke-session", ginext.WrapTempl(RevokeSession))
+	group.POST("revoke-other-sessions", ginext.WrapTemplWithoutPayload(RevokeOtherSessions))
+}
+
+func SessionsPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	sessions, currentSessionIDs, err := getSessions(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching sessions", err)
+	}
+
+	return render.FullPage(ctx, "Sessions", userrender.Sessions(sessions, currentSessionIDs)), nil
+}
+
+type RevokeSessionTO struct {
+	SessionID string `form:"sessionID"`
+}
+
+func RevokeSession(ctx *gin.Context, r *dm.RequestContext, requestTO RevokeSessionTO) (templ.Component, error) {
+	logger := r.Logger
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	_, currentSessionIDs, err := getSessions(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching sessions", err)
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(requestTO.SessionID)
+	if err != nil {
+		logger.Info("Attempt to revoke session with invalid ID")
+	} else if slices.Contains(currentSessionIDs, requestTO.SessionID) {
+		logger.Info("Attempt to revoke current session")
+	} else {
+		if err = auth.DeleteSessionForUser(ctx, r.Database, user.ID(), sessionID); err != nil {
+			return nil, errs.Wrap("issue deleting session", err)
+		}
+		logger.Info("Session revoked")
+	}
+
+	remainingSessions, currentSessionIDs, err := getSessions(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching sessions", err)
+	}
+	return userrender.SessionList(remainingSessions, currentSessionIDs), nil
+}
+
+func RevokeOtherSessions(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	logger := r.Logger
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	sessions, currentSessionIDs, err := getSessions(ctx, r)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching sessions", err)
+	}
+
+	remainingSessions := make([]dm.UserSession, 0, len(currentSessionIDs))
+	for _, session := range sessions {
+		if slices.Contains(currentSessionIDs, session.IDHex()) {
+			remainingSessions = append(remainingSessions, session)
+			continue
+		}
+		if err = auth.DeleteSessionForUser(ctx, r.Database, user.ID(), session.ObjectID); err != nil {
+			return nil, errs.Wrap("issue deleting session", err)
+		}
+	}
+
+	logger.Info("Other sessions revoked", "count", len(sessions)-len(remainingSessions))
+	return userrender.SessionList(remainingSessions, currentSessionIDs), nil
+}
+
+// getSessions returns the user's active sessions and the IDs of those held by the requesting browser
+func getSessions(ctx *gin.Context, r *dm.RequestContext) ([]dm.UserSession, []string, error) {
+	sessions, err := auth.GetSessionsForUser(ctx, r.Database, r.User.ID())
+	if err != nil {
+		return nil, nil, errs.Wrap("issue loading sessions", err)
+	}
+
+	var currentTokenHashes []string
+	for _, sessionType := range []dm.UserSessionType{dm.UserSessionTypeLogin, dm.UserSessionTypeSudo, dm.UserSessionTypeRememberDevice, dm.UserSessionTypeForwardAuth} {
+		sessionToken, err := auth.GetSessionCookie(ctx, sessionType)
+		if err != nil {
+			return nil, nil, errs.Wrap("issue reading session cookie", err)
+		}
+		if sessionToken != "" {
+			currentTokenHashes = append(currentTokenHashes, auth.HashToken(r.Config, string(sessionToken)))
+		}
+	}
+
+	var currentSessionIDs []string
+	for _, session := range sessions {
+		if slices.Contains(currentTokenHashes, session.TokenHash) {
+			currentSessionIDs = append(currentSessionIDs, session.IDHex())
+		}
+	}
+	return sessions, currentSessionIDs, nil
+}
@@ -1,9 +1,17 @@
 package resource
 
 import (
+	"github.com/a-h/templ"
+	"net/http"
 	"time"
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	userrender "user-manager/cmd/app/router/render/user"
+	"user-manager/cmd/app/service/accesstokens"
+	"user-manager/cmd/app/service/audit"
 	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/throttling"
 	"user-manager/cmd/app/service/users"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
@@ -14,29 +22,89 @@ import (
 )
 
 func RegisterSettingsResource(group *gin.RouterGroup) {
+	group.GET("", ginext.WrapTemplWithoutPayload(SettingsPage))
 	group.POST("confirm-email-change", ginext.WrapEndpoint(ConfirmEmailChange))
 	group.POST("enter-sudo-mode", ginext.WrapEndpoint(EnterSudoMode))
 }
 
+func SettingsPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	user := r.User
+
+	if !user.IsPresent() {
+		return nil, errs.Error("no user")
+	}
+
+	accessTokens, err := accesstokens.GetTokensForUser(ctx, r.Database, user.ID())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching personal access tokens", err)
+	}
+
+	return render.FullPage(ctx, "Settings", userrender.Settings(user.SecondFactorToken != "", len(user.SecondFactorRecoveryCodes), user.WebAuthnCredentials,
+		r.Config.ExternalIdentityProviders, user.ExternalIdentities, accessTokens, grantablePermissions(r.Permissions), r.Config.AccountDeletionGraceDays)), nil
+}
+
 type SudoTO struct {
 	Password []byte `json:"password"`
 }
 
 type SudoResponseTO struct {
-	Success bool `json:"success"`
+	Success      bool      `json:"success"`
+	TimeoutUntil time.Time `json:"timeoutUntil,omitempty"`
+	Locked       bool      `json:"locked,omitempty"`
 }
 
+// EnterSudoMode is only available to browser sessions. A personal access token together with the password must not
+// unlock the sensitive settings. Failed attempts are throttled and lock the account just like failed logins.
 func EnterSudoMode(ctx *gin.Context, r *dm.RequestContext, requestTO *SudoTO) (*SudoResponseTO, error) {
 	logger := r.Logger
 	user := r.User
 
+	if r.PersonalAccessToken.IsPresent() {
+		logger.Info("Sudo attempt with personal access token")
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return nil, nil
+	}
+
 	if !user.IsPresent() {
 		return nil, errs.Error("no user")
 	}
 
+	throttleStatus, err := throttling.GetStatus(ctx, r.Database, dm.ThrottlingScopeLogin, user, ctx.ClientIP())
+	if err != nil {
+		return nil, errs.Wrap("issue fetching throttling status", err)
+	}
+	if throttleStatus.IsThrottled() {
+		logger.Info("Sudo attempt for throttled user", "userID", user.IDHex(), "locked", throttleStatus.Locked)
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeSudo,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": throttledReason(throttleStatus)},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return &SudoResponseTO{TimeoutUntil: throttleStatus.BlockedUntil, Locked: throttleStatus.Locked}, nil
+	}
+
 	if !auth.VerifyCredentials(requestTO.Password, user.Credentials) {
 		logger.Info("Password mismatch in sudo attempt", "userID", user.IDHex())
-		return &SudoResponseTO{}, nil
+		throttleStatus, err = recordFailedAttempt(ctx, r, dm.ThrottlingScopeLogin, user)
+		if err != nil {
+			return nil, errs.Wrap("issue recording failed attempt", err)
+		}
+		if err = audit.Record(ctx, r, dm.AuditEvent{
+			Type:      dm.AuditEventTypeSudo,
+			Outcome:   dm.AuditOutcomeFailure,
+			SubjectID: user.ObjectID,
+			Details:   map[string]string{"reason": "invalid-password"},
+		}); err != nil {
+			return nil, errs.Wrap("issue recording audit event", err)
+		}
+		return &SudoResponseTO{TimeoutUntil: throttleStatus.BlockedUntil, Locked: throttleStatus.Locked}, nil
+	}
+
+	if err = throttling.RecordSuccess(ctx, r.Database, dm.ThrottlingScopeLogin, user.ID()); err != nil {
+		return nil, errs.Wrap("issue resetting throttling", err)
 	}
 
 	logger.Info("Entering sudo mode")
@@ -45,11 +113,18 @@ func EnterSudoMode(ctx *gin.Context, r *dm.RequestContext, requestTO *SudoTO) (*
 		Type:      dm.UserSessionTypeSudo,
 		TimeoutAt: time.Now().Add(dm.SudoSessionDuration),
 	}
-	if err := auth.InsertSession(ctx, r.Database, user.ID(), session); err != nil {
+	if err = auth.InsertSession(ctx, r.Database, r.Config, user.ID(), session); err != nil {
 		return nil, errs.Wrap("error inserting session", err)
 	}
 
 	auth.SetSessionCookie(ctx, r.Config, string(session.Token), session.Type)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeSudo,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: user.ObjectID,
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
 	return &SudoResponseTO{Success: true}, nil
 }
 
@@ -73,7 +148,7 @@ func ConfirmEmailChange(ctx *gin.Context, r *dm.RequestContext, request EmailCha
 	logger := r.Logger
 	user := r.User
 
-	if user.IsPresent() {
+	if !user.IsPresent() {
 		return EmailChangeConfirmationResponseTO{}, errs.Error("no user")
 	}
 
@@ -81,11 +156,11 @@ func ConfirmEmailChange(ctx *gin.Context, r *dm.RequestContext, request EmailCha
 		return EmailChangeConfirmationResponseTO{EmailChangeResponseNoChangeInProgress}, nil
 	}
 
-	if user.EmailVerificationToken == "" {
+	if user.EmailVerificationTokenHash == "" {
 		return EmailChangeConfirmationResponseTO{}, errs.Error("no verification token present on database")
 	}
 
-	if request.Token != user.EmailVerificationToken {
+	if !auth.VerifyTokenHash(r.Config, request.Token, user.EmailVerificationTokenHash) {
 		logger.Info("Invalid email verification token")
 		return EmailChangeConfirmationResponseTO{EmailChangeResponseInvalidToken}, nil
 	}
@@ -94,5 +169,21 @@ func ConfirmEmailChange(ctx *gin.Context, r *dm.RequestContext, request EmailCha
 		return EmailChangeConfirmationResponseTO{}, errs.Wrap("issue setting email ", err)
 	}
 
+	if err := ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeEmailChange,
+		UserID:  user.ObjectID,
+		Details: map[string]string{"previousEmail": user.Email, "newEmail": user.NextEmail},
+	}); err != nil {
+		return EmailChangeConfirmationResponseTO{}, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	if err := audit.Record(ctx, r, dm.AuditEvent{
+		Type:    dm.AuditEventTypeEmailChange,
+		Outcome: dm.AuditOutcomeSuccess,
+		Details: map[string]string{"step": "confirmed", "previousEmail": user.Email, "newEmail": user.NextEmail},
+	}); err != nil {
+		return EmailChangeConfirmationResponseTO{}, errs.Wrap("issue recording audit event", err)
+	}
+
 	return EmailChangeConfirmationResponseTO{EmailChangeResponseNewEmailConfirmed}, nil
 }
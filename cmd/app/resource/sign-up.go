@@ -25,6 +25,10 @@ type SignUpTO struct {
 func SignUp(ctx *gin.Context, r *dm.RequestContext, requestTO SignUpTO) error {
 	logger := r.Logger
 
+	if abortOnPasswordPolicyViolations(ctx, r, requestTO.Password, requestTO.Email, requestTO.UserName) {
+		return nil
+	}
+
 	user, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
 	if err != nil {
 		return errs.Wrap("error fetching user", err)
@@ -37,18 +41,18 @@ func SignUp(ctx *gin.Context, r *dm.RequestContext, requestTO SignUpTO) error {
 		return nil
 	}
 
-	credentials, err := auth.MakeCredentials(requestTO.Password)
+	credentials, err := auth.MakeCredentials(r.Config, requestTO.Password)
 	if err != nil {
 		return errs.Wrap("error hashing password", err)
 	}
 
 	verificationToken := random.MakeRandomURLSafeB64(21)
 	if err = users.InsertUser(ctx, r.Database, dm.UserInsert{
-		UserName:               requestTO.UserName,
-		Credentials:            credentials,
-		Email:                  requestTO.Email,
-		EmailVerificationToken: verificationToken,
-		UserRoles:              []dm.UserRole{dm.UserRoleUser},
+		UserName:                   requestTO.UserName,
+		Credentials:                credentials,
+		Email:                      requestTO.Email,
+		EmailVerificationTokenHash: auth.HashToken(r.Config, verificationToken),
+		UserRoles:                  []dm.UserRole{dm.UserRoleUser},
 	}); err != nil {
 		return errs.Wrap("error inserting user", err)
 	}
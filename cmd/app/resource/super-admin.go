@@ -0,0 +1,322 @@
+package resource
+
+import (
+	"github.com/a-h/templ"
+	"time"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/router/render/admin"
+	"user-manager/cmd/app/service/audit"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/roles"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+	"user-manager/util/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSuperAdminResource(group *gin.RouterGroup) {
+	group.GET("admins", ginext.WrapTemplWithoutPayload(AdminsPage))
+	group.POST("add-admin-user", ginext.WrapTempl(AddAdminUser))
+	group.POST("grant-role", ginext.WrapTempl(GrantRole))
+	group.POST("revoke-role", ginext.WrapTempl(RevokeRole))
+	group.POST("reset-admin-credentials", ginext.WrapTempl(ResetAdminCredentials))
+}
+
+func AdminsPage(ctx *gin.Context, r *dm.RequestContext) (templ.Component, error) {
+	roleDefinitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading roles", err)
+	}
+	adminList, err := makeAdminList(ctx, r, "", false)
+	if err != nil {
+		return nil, errs.Wrap("issue making admin list", err)
+	}
+	return render.FullPage(ctx, "Admins", admin.Admins(roleDefinitions, adminList)), nil
+}
+
+type AddAdminUserTO struct {
+	Email string `form:"email"`
+	Name  string `form:"name"`
+}
+
+func AddAdminUser(ctx *gin.Context, r *dm.RequestContext, requestTO AddAdminUserTO) (templ.Component, error) {
+	logger := r.Logger
+
+	if requestTO.Email == "" || requestTO.Name == "" {
+		return makeAdminList(ctx, r, "Email and name are required.", true)
+	}
+
+	existingUser, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue checking for existing user", err)
+	}
+	if existingUser.IsPresent() {
+		logger.Info("Admin invitation for existing user", "targetUserID", existingUser.IDHex())
+		return makeAdminList(ctx, r, "A user with this email already exists. Grant them the admin role instead.", true)
+	}
+
+	invitationToken := random.MakeRandomURLSafeB64(21)
+	if err = users.InsertUser(ctx, r.Database, dm.UserInsert{
+		UserName:                  requestTO.Name,
+		Email:                     requestTO.Email,
+		AdminInvitationTokenHash:  auth.HashToken(r.Config, invitationToken),
+		AdminInvitationValidUntil: time.Now().Add(dm.AdminInvitationDuration),
+		UserRoles:                 []dm.UserRole{dm.UserRoleAdmin},
+	}); err != nil {
+		return nil, errs.Wrap("error inserting admin user", err)
+	}
+	invitedUser, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching invited admin", err)
+	}
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeRoleGrant,
+		UserID:  invitedUser.ObjectID,
+		Details: map[string]string{"role": string(dm.UserRoleAdmin)},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	if err = mail.SendAdminInvitationEmail(ctx, r, requestTO.Email, requestTO.Name, invitationToken); err != nil {
+		return nil, errs.Wrap("error sending admin invitation email", err)
+	}
+
+	logger.Info("Admin user invited")
+	return makeAdminList(ctx, r, "Invitation sent to "+requestTO.Email+".", false)
+}
+
+type AdminRoleChangeTO struct {
+	Email string      `form:"email"`
+	Role  dm.UserRole `form:"role"`
+}
+
+func GrantRole(ctx *gin.Context, r *dm.RequestContext, requestTO AdminRoleChangeTO) (templ.Component, error) {
+	logger := r.Logger
+
+	role, err := roles.GetRole(ctx, r.Database, requestTO.Role)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching role", err)
+	}
+	if !role.IsPresent() {
+		logger.Info("Grant of unknown role attempted", "role", requestTO.Role)
+		return makeAdminList(ctx, r, "This role does not exist.", true)
+	}
+
+	targetUser, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !targetUser.IsPresent() {
+		logger.Info("Role grant for non-existent user")
+		return makeAdminList(ctx, r, "No user with this email exists.", true)
+	}
+	if role.Name != dm.UserRoleUser && !targetUser.HasSecondFactor() {
+		logger.Info("Role grant for user without second factor", "targetUserID", targetUser.IDHex())
+		return makeAdminList(ctx, r, "The user has to enable two-factor authentication first.", true)
+	}
+
+	if err = users.AddUserRole(ctx, r.Database, targetUser.ID(), role.Name); err != nil {
+		return nil, errs.Wrap("issue adding user role", err)
+	}
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeRoleGrant,
+		UserID:  targetUser.ObjectID,
+		Details: map[string]string{"role": string(role.Name)},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	logger.Info("Role granted", "targetUserID", targetUser.IDHex(), "role", role.Name)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeRoleGrant,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: targetUser.ObjectID,
+		Details:   map[string]string{"role": string(role.Name)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeAdminList(ctx, r, "Granted "+string(role.Name)+" to "+targetUser.Email+".", false)
+}
+
+func RevokeRole(ctx *gin.Context, r *dm.RequestContext, requestTO AdminRoleChangeTO) (templ.Component, error) {
+	logger := r.Logger
+
+	targetUser, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !targetUser.IsPresent() || !slices.Contains(targetUser.UserRoles, requestTO.Role) {
+		logger.Info("Role revocation for user without role")
+		return makeAdminList(ctx, r, "The user does not have this role.", true)
+	}
+
+	revoked, err := revokeRoleKeepingAdminManager(ctx, r, targetUser, requestTO.Role)
+	if err != nil {
+		return nil, errs.Wrap("issue revoking role", err)
+	}
+	if !revoked {
+		logger.Info("Revocation of last super-admin attempted", "targetUserID", targetUser.IDHex())
+		return makeAdminList(ctx, r, "The last super-admin cannot be removed.", true)
+	}
+
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeRoleRevoke,
+		UserID:  targetUser.ObjectID,
+		Details: map[string]string{"role": string(requestTO.Role)},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+
+	logger.Info("Role revoked", "targetUserID", targetUser.IDHex(), "role", requestTO.Role)
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeRoleRevoke,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: targetUser.ObjectID,
+		Details:   map[string]string{"role": string(requestTO.Role)},
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeAdminList(ctx, r, "Revoked "+string(requestTO.Role)+" from "+targetUser.Email+".", false)
+}
+
+// wouldRemoveLastAdminManager reports whether nobody could manage admins anymore if the user only held the remaining roles
+func wouldRemoveLastAdminManager(ctx *gin.Context, r *dm.RequestContext, user dm.User, remainingRoles []dm.UserRole) (bool, error) {
+	definitions, managingRoles, err := getAdminManagingRoles(ctx, r)
+	if err != nil {
+		return false, errs.Wrap("issue loading admin managing roles", err)
+	}
+	if !dm.ResolvePermissions(definitions, user.UserRoles).Has(dm.PermissionAdminsManage) ||
+		dm.ResolvePermissions(definitions, remainingRoles).Has(dm.PermissionAdminsManage) {
+		return false, nil
+	}
+
+	otherManagers, err := users.CountOtherUsersWithAnyRole(ctx, r.Database, managingRoles, user.ID())
+	if err != nil {
+		return false, errs.Wrap("issue counting admin managers", err)
+	}
+	return otherManagers == 0, nil
+}
+
+// revokeRoleKeepingAdminManager removes the role unless nobody could manage admins anymore. As concurrent revocations
+// could all pass a check made before, the managers are counted again after the removal, and the role is restored if
+// none are left.
+func revokeRoleKeepingAdminManager(ctx *gin.Context, r *dm.RequestContext, user dm.User, role dm.UserRole) (bool, error) {
+	var remainingRoles []dm.UserRole
+	for _, userRole := range user.UserRoles {
+		if userRole != role {
+			remainingRoles = append(remainingRoles, userRole)
+		}
+	}
+	lastAdminManager, err := wouldRemoveLastAdminManager(ctx, r, user, remainingRoles)
+	if err != nil {
+		return false, errs.Wrap("issue checking for remaining admin managers", err)
+	}
+	if lastAdminManager {
+		return false, nil
+	}
+
+	if err = users.RemoveUserRole(ctx, r.Database, user.ID(), role); err != nil {
+		return false, errs.Wrap("issue removing user role", err)
+	}
+
+	definitions, managingRoles, err := getAdminManagingRoles(ctx, r)
+	if err != nil {
+		return false, errs.Wrap("issue loading admin managing roles", err)
+	}
+	if !dm.ResolvePermissions(definitions, []dm.UserRole{role}).Has(dm.PermissionAdminsManage) {
+		return true, nil
+	}
+	managers, err := users.CountUsersWithAnyRole(ctx, r.Database, managingRoles)
+	if err != nil {
+		return false, errs.Wrap("issue counting admin managers", err)
+	}
+	if managers > 0 {
+		return true, nil
+	}
+	if err = users.AddUserRole(ctx, r.Database, user.ID(), role); err != nil {
+		return false, errs.Wrap("issue restoring user role", err)
+	}
+	return false, nil
+}
+
+// getAdminManagingRoles returns the role definitions and the roles that grant dm.PermissionAdminsManage
+func getAdminManagingRoles(ctx *gin.Context, r *dm.RequestContext) (map[dm.UserRole]dm.Role, []dm.UserRole, error) {
+	roleDefinitions, err := roles.GetRoles(ctx, r.Database)
+	if err != nil {
+		return nil, nil, errs.Wrap("issue loading roles", err)
+	}
+	definitions := roles.MapRoles(roleDefinitions)
+
+	var managingRoles []dm.UserRole
+	for _, definition := range roleDefinitions {
+		if dm.ResolvePermissions(definitions, []dm.UserRole{definition.Name}).Has(dm.PermissionAdminsManage) {
+			managingRoles = append(managingRoles, definition.Name)
+		}
+	}
+	return definitions, managingRoles, nil
+}
+
+type ResetAdminCredentialsTO struct {
+	Email string `form:"email"`
+}
+
+// ResetAdminCredentials removes password, second factor and passkeys of an admin and sends a new invitation
+func ResetAdminCredentials(ctx *gin.Context, r *dm.RequestContext, requestTO ResetAdminCredentialsTO) (templ.Component, error) {
+	logger := r.Logger
+
+	targetUser, err := users.GetUserForEmail(ctx, r.Database, requestTO.Email)
+	if err != nil {
+		return nil, errs.Wrap("issue fetching user", err)
+	}
+	if !targetUser.IsPresent() || !targetUser.HasPrivilegedRole() {
+		logger.Info("Credential reset for non-admin attempted")
+		return makeAdminList(ctx, r, "Credentials can only be reset for admins.", true)
+	}
+	if targetUser.ObjectID == r.User.ObjectID {
+		logger.Info("Credential reset for own account attempted")
+		return makeAdminList(ctx, r, "You cannot reset your own credentials.", true)
+	}
+
+	invitationToken := random.MakeRandomURLSafeB64(21)
+	if err = users.ResetAdminCredentials(ctx, r.Database, targetUser.ID(), auth.HashToken(r.Config, invitationToken), time.Now().Add(dm.AdminInvitationDuration)); err != nil {
+		return nil, errs.Wrap("issue resetting admin credentials", err)
+	}
+	if err = ledger.Append(ctx, r, dm.LedgerEntry{
+		Type:    dm.LedgerEntryTypeCredentialsChange,
+		UserID:  targetUser.ObjectID,
+		Details: map[string]string{"reason": "admin-credentials-reset"},
+	}); err != nil {
+		return nil, errs.Wrap("issue appending ledger entry", err)
+	}
+	if err = auth.DeleteSessionsForUser(ctx, r.Database, targetUser.ID()); err != nil {
+		return nil, errs.Wrap("issue deleting sessions", err)
+	}
+
+	if err = mail.SendAdminInvitationEmail(ctx, r, targetUser.Email, targetUser.Name, invitationToken); err != nil {
+		return nil, errs.Wrap("error sending admin invitation email", err)
+	}
+
+	logger.Info("Admin credentials reset", "targetUserID", targetUser.IDHex())
+	if err = audit.Record(ctx, r, dm.AuditEvent{
+		Type:      dm.AuditEventTypeAdminCredentialsReset,
+		Outcome:   dm.AuditOutcomeSuccess,
+		SubjectID: targetUser.ObjectID,
+	}); err != nil {
+		return nil, errs.Wrap("issue recording audit event", err)
+	}
+	return makeAdminList(ctx, r, "Credentials of "+targetUser.Email+" have been reset and a new invitation was sent.", false)
+}
+
+func makeAdminList(ctx *gin.Context, r *dm.RequestContext, message string, isError bool) (templ.Component, error) {
+	admins, err := users.GetUsersWithPrivilegedRole(ctx, r.Database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading admins", err)
+	}
+	return admin.AdminList(admins, r.User.ID(), message, isError), nil
+}
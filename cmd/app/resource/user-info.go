@@ -11,8 +11,10 @@ func RegisterUserInfoResource(group *gin.RouterGroup) {
 }
 
 type UserInfoTO struct {
-	Roles         []dm.UserRole `json:"roles"`
-	EmailVerified bool          `json:"emailVerified"`
+	Roles            []dm.UserRole       `json:"roles"`
+	EmailVerified    bool                `json:"emailVerified"`
+	Organization     string              `json:"organization,omitempty"`
+	OrganizationRole dm.OrganizationRole `json:"organizationRole,omitempty"`
 }
 
 func Get(_ *gin.Context, r *dm.RequestContext) (UserInfoTO, error) {
@@ -23,7 +25,9 @@ func Get(_ *gin.Context, r *dm.RequestContext) (UserInfoTO, error) {
 	}
 
 	return UserInfoTO{
-		Roles:         user.UserRoles,
-		EmailVerified: user.EmailVerified,
+		Roles:            user.UserRoles,
+		EmailVerified:    user.EmailVerified,
+		Organization:     r.Organization.Name,
+		OrganizationRole: r.Membership.Role,
 	}, nil
 }
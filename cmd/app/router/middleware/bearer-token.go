@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/accesstokens"
+	"user-manager/cmd/app/service/roles"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterBearerTokenMiddleware authenticates requests carrying a personal access token in the Authorization header.
+// Such requests skip the login session and CSRF middlewares, as browsers never attach the header on their own.
+func RegisterBearerTokenMiddleware(group *gin.RouterGroup) {
+	group.Use(func(ctx *gin.Context) {
+		r := ginext.GetRequestContext(ctx)
+
+		token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !found {
+			return
+		}
+
+		accessToken, err := accesstokens.GetTokenForValue(ctx, r.Database, r.Config, strings.TrimSpace(token))
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching personal access token failed", err))
+			return
+		}
+		if !accessToken.IsPresent() {
+			r.Logger.Info("Invalid or expired personal access token")
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		user, err := users.GetUserForID(ctx, r.Database, dm.UserID(accessToken.UserID))
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching user for personal access token failed", err))
+			return
+		}
+		if !user.IsPresent() || user.IsLocked() {
+			r.Logger.Info("Personal access token of missing or locked user", "tokenID", accessToken.IDHex())
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		permissions, err := roles.GetPermissionsForRoles(ctx, r.Database, user.UserRoles)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("resolving permissions failed", err))
+			return
+		}
+
+		r.User = user
+		r.Permissions = permissions.Restrict(accessToken.Scopes)
+		r.PersonalAccessToken = accessToken
+		r.Logger = r.Logger.With("userID", user.IDHex(), "tokenID", accessToken.IDHex())
+		r.Logger.Info("Personal access token found", "scopes", accessToken.Scopes)
+
+		if err = accesstokens.UpdateLastUsed(ctx, r.Database, accessToken); err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue updating personal access token usage", err))
+			return
+		}
+	})
+}
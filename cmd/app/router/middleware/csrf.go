@@ -1,32 +1,50 @@
 package middleware
 
 import (
-	"errors"
 	"net/http"
 	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
 	"user-manager/util/errs"
 	"user-manager/util/slices"
 
 	"github.com/gin-gonic/gin"
 )
 
+// RegisterCsrfMiddleware implements signed double-submit CSRF protection.
+// Safe requests are issued a token (bound to the login session) if they don't carry a valid one yet,
+// unsafe requests must echo the cookie in the X-CSRF-Token header. Requests authenticated by personal access token are exempt.
 func RegisterCsrfMiddleware(group *gin.RouterGroup) {
 	group.Use(func(ctx *gin.Context) {
-		if slices.Contains([]string{"GET", "OPTIONS", "HEAD"}, ctx.Request.Method) {
+		r := ginext.GetRequestContext(ctx)
+		config := r.Config
+
+		if r.PersonalAccessToken.IsPresent() {
 			return
 		}
-		config := ginext.GetRequestContext(ctx).Config
 
-		cookieName := "__Host-CSRF-Token"
-		if config.IsLocalEnv() {
-			cookieName = "CSRF-Token"
-		}
-		cookie, err := ctx.Cookie(cookieName)
-		if err != nil && !errors.Is(err, http.ErrNoCookie) {
+		cookie, err := auth.GetCsrfCookie(ctx, config)
+		if err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("getting CSRF cookie failed", err))
 			return
 		}
-		header := ctx.GetHeader("X-CSRF-Token")
+		sessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("getting session cookie failed", err))
+			return
+		}
+		validCookie := cookie != "" && auth.VerifyCsrfToken(config, cookie, sessionToken)
+
+		if slices.Contains([]string{"GET", "OPTIONS", "HEAD"}, ctx.Request.Method) {
+			if validCookie {
+				r.CsrfToken = cookie
+			} else {
+				auth.RotateCsrfToken(ctx, r, sessionToken)
+			}
+			return
+		}
+
+		header := ctx.GetHeader(auth.CsrfHeaderName)
 		if header == "" || cookie == "" {
 			_ = ctx.AbortWithError(http.StatusBadRequest, errs.Error("missing tokens"))
 			return
@@ -36,5 +54,11 @@ func RegisterCsrfMiddleware(group *gin.RouterGroup) {
 			_ = ctx.AbortWithError(http.StatusBadRequest, errs.Error("mismatching csrf tokens"))
 			return
 		}
+
+		if !validCookie {
+			_ = ctx.AbortWithError(http.StatusBadRequest, errs.Error("invalid csrf token"))
+			return
+		}
+		r.CsrfToken = cookie
 	})
 }
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/roles"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterExtractForwardAuthSessionMiddleware identifies the user by the forward auth cookie. Login sessions are not
+// accepted, their cookie never leaves the app's host.
+func RegisterExtractForwardAuthSessionMiddleware(group *gin.RouterGroup) {
+	group.Use(func(ctx *gin.Context) {
+		r := ginext.GetRequestContext(ctx)
+
+		sessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeForwardAuth)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("getting forward auth cookie failed", err))
+			return
+		}
+
+		if sessionToken == "" {
+			return
+		}
+
+		user, err := auth.GetUserForSession(ctx, r.Database, r.Config, sessionToken, dm.UserSessionTypeForwardAuth)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching user for forward auth session failed", err))
+			return
+		}
+
+		if user.IsPresent() {
+			r.User = user
+			r.Logger = r.Logger.With("userID", user.IDHex())
+
+			permissions, err := roles.GetPermissionsForRoles(ctx, r.Database, user.UserRoles)
+			if err != nil {
+				_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("resolving permissions failed", err))
+				return
+			}
+			r.Permissions = permissions
+		}
+	})
+}
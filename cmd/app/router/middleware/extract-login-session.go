@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"time"
 	ginext "user-manager/cmd/app/gin-extensions"
 	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/organizations"
+	"user-manager/cmd/app/service/roles"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
 
@@ -15,6 +18,10 @@ func RegisterExtractLoginSessionMiddleware(group *gin.RouterGroup) {
 	group.Use(func(ctx *gin.Context) {
 		r := ginext.GetRequestContext(ctx)
 
+		if r.PersonalAccessToken.IsPresent() {
+			return
+		}
+
 		sessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeLogin)
 		if err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("getting session cookie failed", err))
@@ -25,7 +32,7 @@ func RegisterExtractLoginSessionMiddleware(group *gin.RouterGroup) {
 			return
 		}
 
-		user, err := auth.GetUserForSession(ctx, r.Database, sessionToken, dm.UserSessionTypeLogin)
+		session, user, err := auth.GetSessionAndUser(ctx, r.Database, r.Config, sessionToken, dm.UserSessionTypeLogin)
 		if err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching user for sessionToken failed", err))
 			return
@@ -37,7 +44,33 @@ func RegisterExtractLoginSessionMiddleware(group *gin.RouterGroup) {
 			r.Logger = r.Logger.With("userID", user.IDHex())
 			r.Logger.Info("User session found", "roles", user.UserRoles)
 
-			if err := auth.UpdateSessionTimeout(ctx, r.Database, sessionToken, time.Now().Add(dm.LoginSessionDuration)); err != nil {
+			permissions, err := roles.GetPermissionsForRoles(ctx, r.Database, user.UserRoles)
+			if err != nil {
+				_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("resolving permissions failed", err))
+				return
+			}
+			r.Permissions = permissions
+
+			if session.ActiveOrganizationID != primitive.NilObjectID {
+				organizationID := dm.OrganizationID(session.ActiveOrganizationID)
+				membership, err := organizations.GetMembership(ctx, r.Database, organizationID, user.ID())
+				if err != nil {
+					_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching membership of active organization failed", err))
+					return
+				}
+				if membership.IsPresent() {
+					organization, err := organizations.GetOrganization(ctx, r.Database, organizationID)
+					if err != nil {
+						_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching active organization failed", err))
+						return
+					}
+					r.Organization = organization
+					r.Membership = membership
+					r.Logger = r.Logger.With("organizationID", organization.IDHex())
+				}
+			}
+
+			if err := auth.UpdateSessionTimeout(ctx, r.Database, r.Config, sessionToken, time.Now().Add(dm.LoginSessionDuration)); err != nil {
 				_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue updating session timeout in db", err))
 				return
 			}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+	"user-manager/cmd/app/service/ratelimit"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateLimitBodySize bounds the body RateLimitKeyEmail reads before the request reached its handler
+const maxRateLimitBodySize = 64 << 10
+
+// RateLimitKeyFunc returns the key whose bucket a request takes its token from
+type RateLimitKeyFunc func(ctx *gin.Context) string
+
+func RateLimitKeyIP(ctx *gin.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// RateLimitKeyUserID falls back to the IP for requests without a logged-in user
+func RateLimitKeyUserID(ctx *gin.Context) string {
+	r := ginext.GetRequestContext(ctx)
+	if !r.User.IsPresent() {
+		return RateLimitKeyIP(ctx)
+	}
+	return "user:" + r.User.IDHex()
+}
+
+// RateLimitKeyEmail uses the email field of a JSON or form body and falls back to the IP if there is none. The body
+// is restored for the handler. A body larger than maxRateLimitBodySize is not restored, so the handler fails to read it.
+func RateLimitKeyEmail(ctx *gin.Context) string {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxRateLimitBodySize)
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return RateLimitKeyIP(ctx)
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var email string
+	if ctx.ContentType() == gin.MIMEJSON {
+		var payload struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			email = payload.Email
+		}
+	} else if values, err := url.ParseQuery(string(body)); err == nil {
+		email = values.Get("email")
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return RateLimitKeyIP(ctx)
+	}
+	return "email:" + email
+}
+
+func RegisterRateLimitMiddleware(group *gin.RouterGroup, store ratelimit.Store, limit dm.RateLimit, keyFunc RateLimitKeyFunc) {
+	group.Use(func(ctx *gin.Context) {
+		r := ginext.GetRequestContext(ctx)
+		logger := r.Logger
+
+		allowed, retryAfter, err := store.Take(ctx, limit.Name+":"+keyFunc(ctx), limit)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("taking rate limit token failed", err))
+			return
+		}
+		if !allowed {
+			logger.Info("Rate limit exceeded", "limit", limit.Name)
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			_ = ctx.AbortWithError(http.StatusTooManyRequests, errs.Error("rate limit exceeded"))
+			return
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitKeyEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    string
+		restored    bool
+	}{
+		{"json", gin.MIMEJSON, `{"email": " User@Example.com "}`, "email:user@example.com", true},
+		{"form", gin.MIMEPOSTForm, "email=user%40example.com&password=x", "email:user@example.com", true},
+		{"no email", gin.MIMEJSON, `{"name": "user"}`, "ip:192.0.2.1", true},
+		{"invalid json", gin.MIMEJSON, `{"email":`, "ip:192.0.2.1", true},
+		{"body too large", gin.MIMEJSON, `{"email": "user@example.com", "padding": "` + strings.Repeat("x", maxRateLimitBodySize) + `"}`, "ip:192.0.2.1", false},
+	}
+
+	for _, test := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		ctx.Request.Header.Set("Content-Type", test.contentType)
+		ctx.Request.RemoteAddr = "192.0.2.1:1234"
+
+		actual := RateLimitKeyEmail(ctx)
+		if actual != test.expected {
+			t.Errorf("%s: expected key %s, got %s", test.name, test.expected, actual)
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if test.restored && (err != nil || string(body) != test.body) {
+			t.Errorf("%s: expected body to be restored, got %q, %v", test.name, body, err)
+		}
+		if !test.restored && err == nil {
+			t.Errorf("%s: expected reading the body to fail", test.name)
+		}
+	}
+}
@@ -8,32 +8,35 @@ import (
 	"user-manager/cmd/app/router/render"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
-	"user-manager/util/slices"
 )
 
-func RegisterLoginRedirectIfRoleMissingMiddleware(group *gin.RouterGroup, requiredRole dm.UserRole) {
-	group.Use(func(ctx *gin.Context) {
+func RegisterLoginRedirectIfPermissionMissingMiddleware(group *gin.RouterGroup, requiredPermission dm.Permission) {
+	group.Use(RequirePermission(requiredPermission))
+}
+
+// RequirePermission sends the login page unless the logged-in user holds the permission through one of its roles
+func RequirePermission(requiredPermission dm.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
 		r := ginext.GetRequestContext(ctx)
 		logger := r.Logger
 		user := r.User
 		if !user.IsPresent() {
-			logger.Info(fmt.Sprintf("Not a %s: unauthenticated", requiredRole))
+			logger.Info(fmt.Sprintf("Missing %s: unauthenticated", requiredPermission))
 			abortAndSendLoginPage(ctx, r)
 			return
 		}
 
-		receivedRoles := user.UserRoles
-		if !slices.Contains(receivedRoles, requiredRole) {
-			logger.Info(fmt.Sprintf("Not a %s: %s", requiredRole, receivedRoles))
+		if !r.Permissions.Has(requiredPermission) {
+			logger.Info(fmt.Sprintf("Missing %s: %s", requiredPermission, user.UserRoles))
 			abortAndSendLoginPage(ctx, r)
 			return
 		}
-	})
+	}
 }
 
 func abortAndSendLoginPage(ctx *gin.Context, r *dm.RequestContext) {
 	ginext.HXRetarget(ctx, "closest body")
-	component := render.FullPage(ctx, "Login", render.LoginForm())
+	component := render.FullPage(ctx, "Login", render.LoginForm(r.Config.PasswordlessLoginEnabled, r.Config.MagicLinkLoginEnabled, r.Config.ExternalIdentityProviders))
 
 	ctx.Set("Content-Type", "text/html")
 	if err := component.Render(ctx, ctx.Writer); err != nil {
@@ -10,9 +10,16 @@ import (
 	"user-manager/util/errs"
 )
 
+// RegisterRequireSudoModeMiddleware protects the sensitive settings. Requests authenticated by a personal access token
+// are refused even with a sudo session cookie.
 func RegisterRequireSudoModeMiddleware(group *gin.RouterGroup) {
 	group.Use(func(ctx *gin.Context) {
 		r := ginext.GetRequestContext(ctx)
+		if r.PersonalAccessToken.IsPresent() {
+			_ = ctx.AbortWithError(http.StatusForbidden, errs.Error("sudo mode is not available to personal access tokens"))
+			return
+		}
+
 		sudoSessionToken, err := auth.GetSessionCookie(ctx, dm.UserSessionTypeSudo)
 		if err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("getting session cookie failed", err))
@@ -24,20 +31,18 @@ func RegisterRequireSudoModeMiddleware(group *gin.RouterGroup) {
 			return
 		}
 
-		sessionIsValid := false
-		for _, session := range r.User.Sessions {
-			if session.Token == sudoSessionToken && session.Type == dm.UserSessionTypeSudo && session.TimeoutAt.After(time.Now()) {
-				sessionIsValid = true
-				break
-			}
+		sudoUser, err := auth.GetUserForSession(ctx, r.Database, r.Config, sudoSessionToken, dm.UserSessionTypeSudo)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("fetching user for sudo session failed", err))
+			return
 		}
 
-		if !sessionIsValid {
+		if !sudoUser.IsPresent() || sudoUser.ObjectID != r.User.ObjectID {
 			_ = ctx.AbortWithError(http.StatusForbidden, errs.Error("sudo session not valid"))
 			return
 		}
 
-		if err := auth.UpdateSessionTimeout(ctx, r.Database, sudoSessionToken, time.Now().Add(dm.SudoSessionDuration)); err != nil {
+		if err := auth.UpdateSessionTimeout(ctx, r.Database, r.Config, sudoSessionToken, time.Now().Add(dm.SudoSessionDuration)); err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, errs.Wrap("issue updating session timeout in db", err))
 			return
 		}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	ginext "user-manager/cmd/app/gin-extensions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScimTokenMiddleware authenticates the identity provider by the bearer token configured in SCIM_BEARER_TOKEN.
+// Without a configured token, provisioning is disabled.
+func RegisterScimTokenMiddleware(group *gin.RouterGroup) {
+	group.Use(func(ctx *gin.Context) {
+		r := ginext.GetRequestContext(ctx)
+
+		if r.Config.ScimBearerToken == "" {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		expected := sha256.Sum256([]byte(r.Config.ScimBearerToken))
+		received := sha256.Sum256([]byte(token))
+		if !found || subtle.ConstantTimeCompare(expected[:], received[:]) != 1 {
+			r.Logger.Info("SCIM request with invalid bearer token")
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/slices"
+)
+
+func yesNo(value bool) string {
+	if value {
+		return "Yes"
+	}
+	return "No"
+}
+
+func formatRoles(roles []dm.UserRole) string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	return strings.Join(names, ", ")
+}
+
+func describePasswordPolicyViolation(violation dm.PasswordPolicyViolation) string {
+	switch violation {
+	case dm.PasswordPolicyViolationTooShort:
+		return "The password is too short."
+	case dm.PasswordPolicyViolationTooLong:
+		return "The password is too long."
+	case dm.PasswordPolicyViolationContainsEmail:
+		return "The password must not contain your email address."
+	case dm.PasswordPolicyViolationContainsName:
+		return "The password must not contain your name."
+	case dm.PasswordPolicyViolationBlocklisted:
+		return "The password is too common."
+	case dm.PasswordPolicyViolationBreached:
+		return "The password appeared in a data breach."
+	}
+	return string(violation)
+}
+
+func adminStatus(user dm.User) string {
+	if user.AdminInvitationTokenHash != "" {
+		return "Invitation pending"
+	}
+	if !user.HasSecondFactor() {
+		return "No second factor"
+	}
+	return "Active"
+}
+
+func roleChangeValues(email string, role dm.UserRole) string {
+	values, _ := json.Marshal(map[string]string{"email": email, "role": string(role)})
+	return string(values)
+}
+
+func adminEmailValues(email string) string {
+	values, _ := json.Marshal(map[string]string{"email": email})
+	return string(values)
+}
+
+func oidcClientIDValues(clientID string) string {
+	values, _ := json.Marshal(map[string]string{"clientID": clientID})
+	return string(values)
+}
+
+func roleNameValues(name dm.UserRole) string {
+	values, _ := json.Marshal(map[string]string{"name": string(name)})
+	return string(values)
+}
+
+func containsPermission(permissions []dm.Permission, permission dm.Permission) bool {
+	return slices.Contains(permissions, permission)
+}
+
+func containsRole(roles []dm.UserRole, role dm.UserRole) bool {
+	return slices.Contains(roles, role)
+}
+
+// AuditEventFilterValues are the submitted filter inputs, shown again in the filter form
+type AuditEventFilterValues struct {
+	Type    string
+	Outcome string
+	Email   string
+	From    string
+	To      string
+}
+
+func (v AuditEventFilterValues) exportURL(format string) string {
+	query := url.Values{}
+	for key, value := range map[string]string{"type": v.Type, "outcome": v.Outcome, "email": v.Email, "from": v.From, "to": v.To} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	query.Set("format", format)
+	return "/admin/audit-events/export?" + query.Encode()
+}
+
+func formatAuditEventTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func formatAuditEventDetails(details map[string]string) string {
+	parts := make([]string, 0, len(details))
+	for key, value := range details {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
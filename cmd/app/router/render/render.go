@@ -4,11 +4,12 @@ import (
 	"fmt"
 	"github.com/a-h/templ"
 	"github.com/gin-gonic/gin"
+	"math"
+	"time"
 	ginext "user-manager/cmd/app/gin-extensions"
 	"user-manager/cmd/app/router/render/layout"
 	"user-manager/cmd/app/router/render/user"
 	dm "user-manager/domain-model"
-	"user-manager/util/random"
 )
 
 // TODO: Move to 'resource' package (also rename that package)
@@ -22,17 +23,26 @@ func FullPage(ctx *gin.Context, title string, component templ.Component) templ.C
 	r := ginext.GetRequestContext(ctx)
 	config := r.Config
 
-	nonce := random.MakeRandomURLSafeB64(21)
-	if ginext.HXIsFullPageLoad(ctx) {
-		// TODO: This severly slows down the pageload.
-		ctx.Header("Content-Security-Policy", fmt.Sprintf(`
-           default-src 'self';
-           script-src 'strict-dynamic' 'nonce-%s' 'unsafe-inline';
-           object-src 'none';
-           base-uri 'none';
-           connect-src 'self';
-           style-src 'self' 'sha256-d7rFBVhb3n/Drrf+EpNWYdITkos3kQRFpB0oSOycXg4=' 'sha256-bsV5JivYxvGywDAZ22EZJKBFip65Ng9xoJVLbBg7bdo=';
-       `, nonce))
+	return layout.Page(config.ServiceName, title, r.CspNonce, r.CsrfToken, func() templ.Component { return component })
+}
+
+func formatRetryAfter(retryAfter time.Duration) string {
+	if retryAfter < time.Minute {
+		return fmt.Sprintf("%d seconds", int(retryAfter.Seconds()))
+	}
+	return fmt.Sprintf("%d minutes", int(math.Ceil(retryAfter.Minutes())))
+}
+
+func describeOidcScope(scope dm.OidcScope) string {
+	switch scope {
+	case dm.OidcScopeOpenID:
+		return "Confirm your identity"
+	case dm.OidcScopeProfile:
+		return "See your name"
+	case dm.OidcScopeEmail:
+		return "See your email address and whether it is verified"
+	case dm.OidcScopeOfflineAccess:
+		return "Keep access while you are not using it"
 	}
-	return layout.Page(config.ServiceName, title, nonce, config.Environment, func() templ.Component { return component })
+	return string(scope)
 }
@@ -0,0 +1,12 @@
+package user
+
+import dm "user-manager/domain-model"
+
+func findExternalIdentity(identities []dm.ExternalIdentity, provider string) (dm.ExternalIdentity, bool) {
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			return identity, true
+		}
+	}
+	return dm.ExternalIdentity{}, false
+}
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"time"
+	dm "user-manager/domain-model"
+)
+
+func sessionTypeDescription(sessionType dm.UserSessionType) string {
+	switch sessionType {
+	case dm.UserSessionTypeLogin:
+		return "Login"
+	case dm.UserSessionTypeSudo:
+		return "Sensitive settings access"
+	case dm.UserSessionTypeRememberDevice:
+		return "Remembered device"
+	case dm.UserSessionTypeForwardAuth:
+		return "Protected sites access"
+	}
+	return string(sessionType)
+}
+
+func formatSessionTime(t time.Time) string {
+	if t.IsZero() {
+		return "at an unknown time"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func auditEventDescription(eventType dm.AuditEventType) string {
+	switch eventType {
+	case dm.AuditEventTypeLogin:
+		return "Sign-in"
+	case dm.AuditEventTypeSecondFactor:
+		return "Two-factor verification"
+	case dm.AuditEventTypeSudo:
+		return "Sensitive settings access"
+	case dm.AuditEventTypeLogout:
+		return "Sign-out"
+	case dm.AuditEventTypePasswordChange:
+		return "Password change"
+	case dm.AuditEventTypePasswordReset:
+		return "Password reset"
+	case dm.AuditEventTypeEmailChange:
+		return "Email change"
+	case dm.AuditEventTypeSecondFactorChange:
+		return "Two-factor settings change"
+	case dm.AuditEventTypeRoleGrant:
+		return "Role granted"
+	case dm.AuditEventTypeRoleRevoke:
+		return "Role revoked"
+	case dm.AuditEventTypeAccountLock:
+		return "Account locked"
+	case dm.AuditEventTypeAccountUnlock:
+		return "Account unlocked"
+	case dm.AuditEventTypeOidcConsent:
+		return "Access granted to an application"
+	case dm.AuditEventTypeOidcClientCreate:
+		return "Application registered"
+	case dm.AuditEventTypeOidcClientDelete:
+		return "Application removed"
+	case dm.AuditEventTypeExternalIdentityLink:
+		return "External account linked"
+	case dm.AuditEventTypeExternalIdentityUnlink:
+		return "External account unlinked"
+	case dm.AuditEventTypeAccessTokenCreate:
+		return "Personal access token created"
+	case dm.AuditEventTypeAccessTokenRevoke:
+		return "Personal access token revoked"
+	case dm.AuditEventTypeUserProvision:
+		return "Account provisioned by your organization"
+	case dm.AuditEventTypeUserDeprovision:
+		return "Account deactivated by your organization"
+	case dm.AuditEventTypeAccountDeletionRequest:
+		return "Account deletion requested"
+	case dm.AuditEventTypeAccountDeletionCancel:
+		return "Account deletion canceled"
+	case dm.AuditEventTypeRoleDefinitionSave:
+		return "Role definition saved"
+	case dm.AuditEventTypeRoleDefinitionDelete:
+		return "Role definition deleted"
+	case dm.AuditEventTypeAdminCredentialsReset:
+		return "Admin credentials reset"
+	}
+	return string(eventType)
+}
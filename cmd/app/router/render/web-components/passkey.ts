@@ -0,0 +1,117 @@
+// Usage: <passkey-registration/> (settings, requires sudo mode) and <passkey-login/> (login form)
+function base64UrlToBuffer(value: string): ArrayBuffer {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "=".repeat((4 - base64.length % 4) % 4);
+    return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0)).buffer;
+}
+
+function bufferToBase64Url(value: ArrayBuffer): string {
+    let binary = "";
+    new Uint8Array(value).forEach((b) => binary += String.fromCharCode(b));
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function csrfToken(): string {
+    return document.querySelector('meta[name="csrf-token"]')?.getAttribute("content") ?? "";
+}
+
+function updateCsrfToken(response: Response) {
+    const token = response.headers.get("X-CSRF-Token");
+    if (!token) {
+        return;
+    }
+    document.querySelector('meta[name="csrf-token"]')?.setAttribute("content", token);
+    document.body.setAttribute("hx-headers", JSON.stringify({"X-CSRF-Token": token}));
+}
+
+async function postJson(url: string, payload: unknown): Promise<any> {
+    const response = await fetch(url, {
+        method: "POST",
+        headers: {"Content-Type": "application/json", "X-CSRF-Token": csrfToken()},
+        body: JSON.stringify(payload),
+    });
+    if (!response.ok) {
+        throw new Error(`${url} failed with status ${response.status}`);
+    }
+    updateCsrfToken(response);
+    return response.status === 204 ? null : response.json();
+}
+
+function serializeCredential(credential: PublicKeyCredential): unknown {
+    const response = credential.response as any;
+    const serialized: any = {
+        id: credential.id,
+        rawId: bufferToBase64Url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64Url(response.clientDataJSON),
+        },
+    };
+    if (response.attestationObject) {
+        serialized.response.attestationObject = bufferToBase64Url(response.attestationObject);
+        serialized.response.transports = response.getTransports ? response.getTransports() : [];
+    } else {
+        serialized.response.authenticatorData = bufferToBase64Url(response.authenticatorData);
+        serialized.response.signature = bufferToBase64Url(response.signature);
+        serialized.response.userHandle = response.userHandle ? bufferToBase64Url(response.userHandle) : null;
+    }
+    return serialized;
+}
+
+class PasskeyRegistration extends HTMLElement {
+    connectedCallback() {
+        this.innerHTML = `
+            <form class="flex flex-col gap-4">
+                <input name="nickname" class="input input-bordered" placeholder="Name for this passkey"/>
+                <button type="submit" class="btn btn-primary">Add passkey</button>
+                <div data-role="error" class="hidden alert alert-error">Adding the passkey failed.</div>
+            </form>
+        `;
+        this.querySelector("form").addEventListener("submit", async (event) => {
+            event.preventDefault();
+            const nickname = (this.querySelector('input[name="nickname"]') as HTMLInputElement).value;
+            try {
+                const options = await postJson("/user/settings/sensitive-settings/begin-passkey-registration", null);
+                const publicKey = options.publicKey;
+                publicKey.challenge = base64UrlToBuffer(publicKey.challenge);
+                publicKey.user.id = base64UrlToBuffer(publicKey.user.id);
+                (publicKey.excludeCredentials ?? []).forEach((c: any) => c.id = base64UrlToBuffer(c.id));
+                const credential = await navigator.credentials.create({publicKey}) as PublicKeyCredential;
+                const result = await postJson("/user/settings/sensitive-settings/finish-passkey-registration", {
+                    nickname,
+                    credential: serializeCredential(credential),
+                });
+                if (!result.registered) {
+                    throw new Error("passkey not registered");
+                }
+                window.location.reload();
+            } catch (e) {
+                this.querySelector('div[data-role="error"]').classList.remove("hidden");
+            }
+        });
+    }
+}
+
+class PasskeyLogin extends HTMLElement {
+    connectedCallback() {
+        this.innerHTML = `<button type="button" class="btn btn-outline w-full">Sign in with a passkey</button>`;
+        this.querySelector("button").addEventListener("click", async () => {
+            try {
+                const options = await postJson("/auth/begin-passkey-login", null);
+                const publicKey = options.publicKey;
+                publicKey.challenge = base64UrlToBuffer(publicKey.challenge);
+                (publicKey.allowCredentials ?? []).forEach((c: any) => c.id = base64UrlToBuffer(c.id));
+                const credential = await navigator.credentials.get({publicKey}) as PublicKeyCredential;
+                const result = await postJson("/auth/finish-passkey-login", {credential: serializeCredential(credential)});
+                if (result.loggedIn) {
+                    window.location.reload();
+                }
+            } catch (e) {
+                console.error(e);
+            }
+        });
+    }
+}
+
+customElements.define("passkey-registration", PasskeyRegistration);
+customElements.define("passkey-login", PasskeyLogin);
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"embed"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,6 +12,7 @@ import (
 	"user-manager/cmd/app/resource"
 	"user-manager/cmd/app/router/middleware"
 	"user-manager/cmd/app/router/render"
+	"user-manager/cmd/app/service/ratelimit"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
 )
@@ -18,15 +20,31 @@ import (
 //go:embed assets/*
 var assetsFS embed.FS
 
+var (
+	authRateLimit              = dm.RateLimit{Name: "auth", Capacity: 30, RefillInterval: 2 * time.Second}
+	mailIPRateLimit            = dm.RateLimit{Name: "mail-ip", Capacity: 10, RefillInterval: time.Minute}
+	mailEmailRateLimit         = dm.RateLimit{Name: "mail-email", Capacity: 5, RefillInterval: 10 * time.Minute}
+	confirmationEmailRateLimit = dm.RateLimit{Name: "confirmation-email", Capacity: 3, RefillInterval: 10 * time.Minute}
+	invitationRateLimit        = dm.RateLimit{Name: "organization-invitation", Capacity: 10, RefillInterval: 10 * time.Minute}
+	cspReportRateLimit         = dm.RateLimit{Name: "csp-report", Capacity: 20, RefillInterval: 30 * time.Second}
+	oidcRateLimit              = dm.RateLimit{Name: "oidc", Capacity: 60, RefillInterval: time.Second}
+)
+
 func New(config *dm.Config, database *mongo.Database) (*gin.Engine, error) {
 	r := gin.New()
+	// ClientIP is used for throttling, rate limits and the audit log, so X-Forwarded-For is only taken from known proxies
+	err := r.SetTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, errs.Wrap("cannot set trusted proxies", err)
+	}
 
-	err := middleware.RegisterRequestContextMiddleware(r, database, config)
+	err = middleware.RegisterRequestContextMiddleware(r, database, config)
 	if err != nil {
 		return nil, errs.Wrap("cannot setup RequestContextMiddleware", err)
 	}
 	middleware.RegisterLoggerMiddleware(r)
 	middleware.RegisterRecoveryMiddleware(r)
+	middleware.RegisterSecurityHeadersMiddleware(r)
 
 	err = registerAssets(r)
 	if err != nil {
@@ -36,7 +54,17 @@ func New(config *dm.Config, database *mongo.Database) (*gin.Engine, error) {
 	r.NoRoute(func(c *gin.Context) {
 		ginext.HXLocationOrRedirect(c, "/user/home")
 	})
-	err = registerGroups(r.Group(""))
+	rateLimitStore, err := ratelimit.NewStore(context.Background(), config, database)
+	if err != nil {
+		return nil, errs.Wrap("cannot setup rate limit store", err)
+	}
+
+	registerCspReportGroup(r.Group(""), rateLimitStore)
+	registerOidcGroup(r.Group(""), rateLimitStore)
+	registerForwardAuthGroup(r.Group(""))
+	registerScimGroup(r.Group("scim/v2"))
+
+	err = registerGroups(r.Group(""), rateLimitStore)
 	if err != nil {
 		return nil, errs.Wrap("cannot setup ApiGroup", err)
 	}
@@ -54,29 +82,83 @@ func registerAssets(r *gin.Engine) error {
 	return nil
 }
 
-func registerGroups(root *gin.RouterGroup) error {
+func registerCspReportGroup(cspReport *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterRateLimitMiddleware(cspReport, rateLimitStore, cspReportRateLimit, middleware.RateLimitKeyIP)
+
+	resource.RegisterCspReportResource(cspReport, middleware.CspReportPath)
+}
+
+// registerOidcGroup registers the endpoints relying parties call from their backends
+func registerOidcGroup(oidc *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterRateLimitMiddleware(oidc, rateLimitStore, oidcRateLimit, middleware.RateLimitKeyIP)
+
+	resource.RegisterOidcResource(oidc)
+}
+
+// registerForwardAuthGroup registers the endpoint reverse proxies call for every request to the hosts they protect.
+// The proxy forwards the headers of the original request, so authorization headers meant for the protected host are not interpreted here.
+func registerForwardAuthGroup(forwardAuth *gin.RouterGroup) {
+	middleware.RegisterExtractForwardAuthSessionMiddleware(forwardAuth)
+
+	resource.RegisterForwardAuthResource(forwardAuth)
+}
+
+// registerScimGroup registers the SCIM endpoints the organization's identity provider uses to provision users and groups
+func registerScimGroup(scim *gin.RouterGroup) {
+	middleware.RegisterScimTokenMiddleware(scim)
+
+	resource.RegisterScimResource(scim)
+	resource.RegisterScimUsersResource(scim)
+	resource.RegisterScimGroupsResource(scim)
+}
+
+func registerGroups(root *gin.RouterGroup, rateLimitStore ratelimit.Store) error {
+	middleware.RegisterBearerTokenMiddleware(root)
 	middleware.RegisterCsrfMiddleware(root)
 	middleware.RegisterExtractLoginSessionMiddleware(root)
 
 	resource.RegisterUserInfoResource(root)
+	resource.RegisterOidcAuthorizationResource(root)
+	resource.RegisterForwardAuthLoginResource(root)
 
-	registerAuthGroup(root.Group("auth"))
+	registerAuthGroup(root.Group("auth"), rateLimitStore)
 	registerAdminGroup(root.Group("admin"))
-	registerUserGroup(root.Group("user"))
+	registerUserGroup(root.Group("user"), rateLimitStore)
 	return nil
 }
 
-func registerAuthGroup(auth *gin.RouterGroup) {
+func registerAuthGroup(auth *gin.RouterGroup, rateLimitStore ratelimit.Store) {
 	middleware.RegisterTimingObfuscationMiddleware(auth, 400*time.Millisecond)
+	middleware.RegisterRateLimitMiddleware(auth, rateLimitStore, authRateLimit, middleware.RateLimitKeyIP)
 
-	resource.RegisterSignUpResource(auth)
 	resource.RegisterLoginResource(auth)
+	resource.RegisterPasskeyLoginResource(auth)
 	resource.RegisterLogoutResource(auth)
-	resource.RegisterResetPasswordResource(auth)
+	resource.RegisterAdminInvitationResource(auth)
+	resource.RegisterAccountUnlockResource(auth)
+	resource.RegisterAccountDeletionCancelResource(auth)
+	resource.RegisterExternalLoginResource(auth)
+	resource.RegisterMagicLinkLoginResource(auth)
+
+	registerMailSendingAuthGroup(auth.Group(""), rateLimitStore)
+}
+
+// registerMailSendingAuthGroup registers endpoints that send emails to arbitrary addresses
+func registerMailSendingAuthGroup(mailSending *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterRateLimitMiddleware(mailSending, rateLimitStore, mailIPRateLimit, middleware.RateLimitKeyIP)
+	middleware.RegisterRateLimitMiddleware(mailSending, rateLimitStore, mailEmailRateLimit, middleware.RateLimitKeyEmail)
+
+	resource.RegisterSignUpResource(mailSending)
+	resource.RegisterResetPasswordResource(mailSending)
+	resource.RegisterMagicLinkRequestResource(mailSending)
 }
 
 func registerAdminGroup(admin *gin.RouterGroup) {
-	middleware.RegisterLoginRedirectIfRoleMissingMiddleware(admin, dm.UserRoleAdmin)
+	middleware.RegisterLoginRedirectIfPermissionMissingMiddleware(admin, dm.PermissionUsersRead)
+
+	resource.RegisterAdminUsersResource(admin)
+	resource.RegisterRolesResource(admin)
+	resource.RegisterAuditEventsResource(admin)
 
 	registerSuperAdminGroup(admin.Group("super-admin"))
 
@@ -84,28 +166,43 @@ func registerAdminGroup(admin *gin.RouterGroup) {
 }
 
 func registerSuperAdminGroup(superAdmin *gin.RouterGroup) {
-	middleware.RegisterLoginRedirectIfRoleMissingMiddleware(superAdmin, dm.UserRoleSuperAdmin)
+	middleware.RegisterLoginRedirectIfPermissionMissingMiddleware(superAdmin, dm.PermissionAdminsManage)
 
-	// POST("add-admin-user", todo).
-	// POST("change-password", todo)
+	resource.RegisterSuperAdminResource(superAdmin)
+	resource.RegisterOidcClientsResource(superAdmin)
 }
 
-func registerUserGroup(user *gin.RouterGroup) {
-	middleware.RegisterLoginRedirectIfRoleMissingMiddleware(user, dm.UserRoleUser)
+func registerUserGroup(user *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterLoginRedirectIfPermissionMissingMiddleware(user, dm.PermissionAppUse)
 
-	resource.RegisterEmailConfirmationResource(user)
+	registerEmailConfirmationGroup(user.Group(""), rateLimitStore)
 	user.GET("home", ginext.WrapTemplWithoutPayload(render.UserHome))
+	resource.RegisterOrganizationsResource(user)
+	registerOrganizationInvitationGroup(user.Group(""), rateLimitStore)
 
 	registerSettingsGroup(user.Group("settings"))
 
 	// TODO: Add redirect middleware for unmatched paths
 }
 
+func registerEmailConfirmationGroup(emailConfirmation *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterRateLimitMiddleware(emailConfirmation, rateLimitStore, confirmationEmailRateLimit, middleware.RateLimitKeyUserID)
+
+	resource.RegisterEmailConfirmationResource(emailConfirmation)
+}
+
+func registerOrganizationInvitationGroup(organizationInvitation *gin.RouterGroup, rateLimitStore ratelimit.Store) {
+	middleware.RegisterRateLimitMiddleware(organizationInvitation, rateLimitStore, invitationRateLimit, middleware.RateLimitKeyUserID)
+
+	resource.RegisterOrganizationInvitationResource(organizationInvitation)
+}
+
 func registerSettingsGroup(settings *gin.RouterGroup) {
 	middleware.RegisterVerifiedEmailAuthorizationMiddleware(settings)
 
 	resource.RegisterSettingsResource(settings)
-	// POST("generate-temporary-second-factor-token"
+	resource.RegisterSessionsResource(settings)
+	resource.RegisterSecurityActivityResource(settings)
 
 	registerSensitiveSettingsGroup(settings.Group("sensitive-settings"))
 }
@@ -114,5 +211,9 @@ func registerSensitiveSettingsGroup(sensitiveSettings *gin.RouterGroup) {
 
 	resource.RegisterSensitiveSettingsResource(sensitiveSettings)
 	resource.RegisterChangePasswordResource(sensitiveSettings)
-	// 	POST("second-factor", todo)
+	resource.RegisterSecondFactorEnrollmentResource(sensitiveSettings)
+	resource.RegisterPasskeyRegistrationResource(sensitiveSettings)
+	resource.RegisterExternalIdentityLinkingResource(sensitiveSettings)
+	resource.RegisterPersonalAccessTokensResource(sensitiveSettings)
+	resource.RegisterAccountDeletionResource(sensitiveSettings)
 }
@@ -0,0 +1,131 @@
+package accesstokens
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+// CreatePersonalAccessTokenIndexes sets up the token lookup. Expired tokens are removed by Mongo's TTL monitor.
+func CreatePersonalAccessTokenIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.PersonalAccessTokenCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create personal access token indexes", err)
+	}
+	return nil
+}
+
+// CreateToken stores a new token for the user. The token is returned once, the database only stores its hash.
+func CreateToken(ctx context.Context, database *mongo.Database, config *dm.Config, userID dm.UserID, name string, scopes []dm.Permission, expiresAt time.Time) (dm.PersonalAccessToken, string, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	token := dm.PersonalAccessTokenPrefix + random.MakeRandomURLSafeB64(30)
+	accessToken := dm.PersonalAccessToken{
+		ObjectID:  primitive.NewObjectID(),
+		UserID:    primitive.ObjectID(userID),
+		Name:      name,
+		TokenHash: auth.HashToken(config, token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if _, err := database.Collection(dm.PersonalAccessTokenCollectionName).InsertOne(queryCtx, accessToken); err != nil {
+		return dm.PersonalAccessToken{}, "", errs.Wrap("cannot insert personal access token", err)
+	}
+	return accessToken, token, nil
+}
+
+func GetTokensForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) ([]dm.PersonalAccessToken, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.PersonalAccessTokenCollectionName).Find(queryCtx,
+		bson.M{"userID": primitive.ObjectID(userID), "expiresAt": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query personal access tokens", err)
+	}
+	var tokens []dm.PersonalAccessToken
+	if err = cursor.All(queryCtx, &tokens); err != nil {
+		return nil, errs.Wrap("cannot decode personal access tokens", err)
+	}
+	return tokens, nil
+}
+
+// GetTokenForValue returns the unexpired token matching the value of an Authorization header
+func GetTokenForValue(ctx context.Context, database *mongo.Database, config *dm.Config, token string) (dm.PersonalAccessToken, error) {
+	if !strings.HasPrefix(token, dm.PersonalAccessTokenPrefix) {
+		return dm.PersonalAccessToken{}, nil
+	}
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var accessToken dm.PersonalAccessToken
+	err := database.Collection(dm.PersonalAccessTokenCollectionName).FindOne(queryCtx,
+		bson.M{"tokenHash": auth.HashToken(config, token), "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&accessToken)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return accessToken, nil
+		}
+		return accessToken, errs.Wrap("error loading personal access token", err)
+	}
+	return accessToken, nil
+}
+
+// UpdateLastUsed records the usage of the token, at most once per PersonalAccessTokenUsageResolution
+func UpdateLastUsed(ctx context.Context, database *mongo.Database, accessToken dm.PersonalAccessToken) error {
+	if time.Since(accessToken.LastUsedAt) < dm.PersonalAccessTokenUsageResolution {
+		return nil
+	}
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.PersonalAccessTokenCollectionName).UpdateByID(queryCtx, accessToken.ObjectID, bson.M{"$set": bson.M{"lastUsedAt": time.Now()}})
+	if err != nil {
+		return errs.Wrap("cannot update personal access token usage", err)
+	}
+	return nil
+}
+
+// RevokeToken deletes the token if it belongs to the user. It reports whether a token was revoked.
+func RevokeToken(ctx context.Context, database *mongo.Database, userID dm.UserID, tokenID primitive.ObjectID) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.PersonalAccessTokenCollectionName).DeleteOne(queryCtx, bson.M{"_id": tokenID, "userID": primitive.ObjectID(userID)})
+	if err != nil {
+		return false, errs.Wrap("cannot delete personal access token", err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+func DeleteTokensForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.PersonalAccessTokenCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)})
+	if err != nil {
+		return errs.Wrap("cannot delete personal access tokens", err)
+	}
+	return nil
+}
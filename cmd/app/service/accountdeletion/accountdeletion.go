@@ -0,0 +1,46 @@
+package accountdeletion
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"user-manager/cmd/app/service/accesstokens"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/mail"
+	"user-manager/cmd/app/service/oidc"
+	"user-manager/cmd/app/service/organizations"
+	"user-manager/cmd/app/service/throttling"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+// DeleteUserData removes everything that refers to the user. The user document itself is left to the caller and has to
+// be deleted afterwards, so that an interrupted deletion can be run again.
+func DeleteUserData(ctx context.Context, database *mongo.Database, user dm.User) error {
+	if err := auth.DeleteSessionsForUser(ctx, database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting sessions", err)
+	}
+	if err := accesstokens.DeleteTokensForUser(ctx, database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting personal access tokens", err)
+	}
+	if err := oidc.DeleteGrantsForUser(ctx, database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting oidc grants", err)
+	}
+	if err := organizations.DeleteMembershipsForUser(ctx, database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting memberships", err)
+	}
+	if err := organizations.DeleteInvitationsForUser(ctx, database, user.ID(), user.Email); err != nil {
+		return errs.Wrap("issue deleting organization invitations", err)
+	}
+	if err := throttling.ResetAccount(ctx, database, user.ID()); err != nil {
+		return errs.Wrap("issue deleting throttles", err)
+	}
+	if err := mail.DeleteMailsForAddress(ctx, database, user.Email); err != nil {
+		return errs.Wrap("issue deleting mails", err)
+	}
+	if user.NextEmail != "" {
+		if err := mail.DeleteMailsForAddress(ctx, database, user.NextEmail); err != nil {
+			return errs.Wrap("issue deleting mails to next email", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+func CreateAuditEventIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.AuditEventCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "subjectID", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "actorID", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create audit event indexes", err)
+	}
+	return nil
+}
+
+// Record appends the event to the audit log. Client details, request ID, actor and active organization are taken from
+// the request. Without an explicit subject, the actor is the subject.
+func Record(ctx *gin.Context, r *dm.RequestContext, event dm.AuditEvent) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if event.ActorID == primitive.NilObjectID && r.User.IsPresent() {
+		event.ActorID = r.User.ObjectID
+	}
+	if event.SubjectID == primitive.NilObjectID {
+		event.SubjectID = event.ActorID
+	}
+	if event.OrganizationID == primitive.NilObjectID && r.Organization.IsPresent() {
+		event.OrganizationID = r.Organization.ObjectID
+	}
+	event.ClientIP = ctx.ClientIP()
+	event.UserAgent = ctx.Request.UserAgent()
+	event.RequestID = r.RequestID
+	event.CreatedAt = time.Now()
+
+	if _, err := r.Database.Collection(dm.AuditEventCollectionName).InsertOne(queryCtx, event); err != nil {
+		return errs.Wrap("cannot insert audit event", err)
+	}
+	return nil
+}
+
+// GetRecentEventsForUser returns the newest events concerning the user
+func GetRecentEventsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID, limit int64) ([]dm.AuditEvent, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.AuditEventCollectionName).Find(queryCtx,
+		bson.M{"subjectID": primitive.ObjectID(userID)},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, errs.Wrap("cannot query audit events", err)
+	}
+
+	events := []dm.AuditEvent{}
+	if err = cursor.All(queryCtx, &events); err != nil {
+		return nil, errs.Wrap("cannot decode audit events", err)
+	}
+	return events, nil
+}
+
+// QueryEvents returns the newest events matching the filter
+func QueryEvents(ctx context.Context, database *mongo.Database, filter dm.AuditEventFilter, limit int64) ([]dm.AuditEvent, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.AuditEventCollectionName).Find(queryCtx,
+		makeQuery(filter),
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, errs.Wrap("cannot query audit events", err)
+	}
+
+	events := []dm.AuditEvent{}
+	if err = cursor.All(queryCtx, &events); err != nil {
+		return nil, errs.Wrap("cannot decode audit events", err)
+	}
+	return events, nil
+}
+
+// ForEachEvent calls fn for all events matching the filter, oldest first, without loading them into memory at once
+func ForEachEvent(ctx context.Context, database *mongo.Database, filter dm.AuditEventFilter, fn func(event dm.AuditEvent) error) error {
+	cursor, err := database.Collection(dm.AuditEventCollectionName).Find(ctx,
+		makeQuery(filter),
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return errs.Wrap("cannot query audit events", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event dm.AuditEvent
+		if err = cursor.Decode(&event); err != nil {
+			return errs.Wrap("cannot decode audit event", err)
+		}
+		if err = fn(event); err != nil {
+			return errs.Wrap("issue processing audit event", err)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return errs.Wrap("issue iterating audit events", err)
+	}
+	return nil
+}
+
+func makeQuery(filter dm.AuditEventFilter) bson.M {
+	query := bson.M{}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.Outcome != "" {
+		query["outcome"] = filter.Outcome
+	}
+	if filter.UserID != primitive.NilObjectID {
+		query["$or"] = bson.A{bson.M{"actorID": filter.UserID}, bson.M{"subjectID": filter.UserID}}
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+	return query
+}
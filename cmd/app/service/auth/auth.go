@@ -1,41 +1,19 @@
 package auth
 
 import (
-	"crypto/rand"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	dm "user-manager/domain-model"
 	"user-manager/util/errs"
-
-	"golang.org/x/crypto/argon2"
 )
 
-func MakeCredentials(password []byte) (dm.UserCredentials, error) {
-	if len(password) < 8 {
-		return dm.UserCredentials{}, errs.Error("password too short")
-	}
-	salt := make([]byte, 16)
-	_, err := rand.Read(salt)
-	if err != nil {
-		return dm.UserCredentials{}, errs.Wrap("error generating salt", err)
-	}
-
-	// See https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html#input-limits
-	key := argon2.IDKey(password, salt, 1, 64*1024, 4, 32)
-
-	return dm.UserCredentials{Key: key, Salt: salt}, nil
-}
-
-func VerifyCredentials(password []byte, credentials dm.UserCredentials) bool {
-	key := argon2.IDKey(password, credentials.Salt, 1, 64*1024, 4, 32)
-	return string(key) == string(credentials.Key)
-}
-
 func RemoveSessionCookie(ctx *gin.Context, config *dm.Config, sessionType dm.UserSessionType) {
 	SetSessionCookie(ctx, config, "", sessionType)
 }
 
+// SetSessionCookie scopes the cookie to the app's host. Only the forward auth cookie is scoped to ForwardAuthCookieDomain
+// if configured, so that reverse proxies of sibling hosts receive it. It cannot be used anywhere else in the app.
 func SetSessionCookie(ctx *gin.Context, config *dm.Config, sessionID string, sessionType dm.UserSessionType) {
 
 	maxAge := -1
@@ -48,8 +26,14 @@ func SetSessionCookie(ctx *gin.Context, config *dm.Config, sessionID string, ses
 	if config.IsLocalEnv() {
 		secure = false
 	}
-	ctx.SetCookie(getCookieName(sessionType), value, maxAge, "", "", secure, true)
+	// SetSameSite only applies to cookies set after it. Strict keeps the session off requests started on other sites,
+	// which is why the external login callback and the OIDC authorization continue on a page of the app.
+	domain := ""
+	if sessionType == dm.UserSessionTypeForwardAuth {
+		domain = config.ForwardAuthCookieDomain
+	}
 	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(getCookieName(sessionType), value, maxAge, "", domain, secure, true)
 }
 
 func GetSessionCookie(ctx *gin.Context, sessionType dm.UserSessionType) (dm.UserSessionToken, error) {
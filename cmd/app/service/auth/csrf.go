@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+const (
+	CsrfHeaderName  = "X-CSRF-Token"
+	csrfTokenMaxAge = 24 * 60 * 60
+)
+
+// MakeCsrfToken creates a signed double-submit token bound to the given login session token.
+// The session token is empty for anonymous visitors, so logging in or out invalidates previously issued CSRF tokens.
+func MakeCsrfToken(config *dm.Config, sessionToken dm.UserSessionToken) string {
+	nonce := random.MakeRandomURLSafeB64(21)
+	return nonce + "." + signCsrfNonce(config, nonce, sessionToken)
+}
+
+func VerifyCsrfToken(config *dm.Config, token string, sessionToken dm.UserSessionToken) bool {
+	nonce, signature, found := strings.Cut(token, ".")
+	if !found || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signCsrfNonce(config, nonce, sessionToken)))
+}
+
+func signCsrfNonce(config *dm.Config, nonce string, sessionToken dm.UserSessionToken) string {
+	mac := hmac.New(sha256.New, []byte(config.TokenHashSecret))
+	mac.Write([]byte("csrf:" + nonce + ":" + string(sessionToken)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RotateCsrfToken issues a new CSRF token for the given login session and exposes it to the page through the response header
+func RotateCsrfToken(ctx *gin.Context, r *dm.RequestContext, sessionToken dm.UserSessionToken) {
+	token := MakeCsrfToken(r.Config, sessionToken)
+	SetCsrfCookie(ctx, r.Config, token)
+	ctx.Header(CsrfHeaderName, token)
+	r.CsrfToken = token
+}
+
+func SetCsrfCookie(ctx *gin.Context, config *dm.Config, token string) {
+	secure := true
+	if config.IsLocalEnv() {
+		secure = false
+	}
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(getCsrfCookieName(config), token, csrfTokenMaxAge, "/", "", secure, true)
+}
+
+func GetCsrfCookie(ctx *gin.Context, config *dm.Config) (string, error) {
+	cookie, err := ctx.Request.Cookie(getCsrfCookieName(config))
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			return "", nil
+		}
+		return "", errs.Wrap("issue reading cookie", err)
+	}
+	return cookie.Value, nil
+}
+
+func getCsrfCookieName(config *dm.Config) string {
+	if config.IsLocalEnv() {
+		return "CSRF-Token"
+	}
+	return "__Host-CSRF-Token"
+}
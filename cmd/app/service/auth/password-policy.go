@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type PasswordCheckInput struct {
+	Password string
+	Email    string
+	Name     string
+}
+
+// PasswordRule returns a violation if the (normalized) password does not satisfy the rule
+type PasswordRule func(config *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool)
+
+var passwordRules = []PasswordRule{
+	minLengthRule,
+	maxLengthRule,
+	notContainingEmailRule,
+	notContainingNameRule,
+	notBlocklistedRule,
+	notBreachedRule,
+}
+
+// NormalizePassword applies NFKC normalization so the same password typed on different devices hashes identically
+func NormalizePassword(password []byte) []byte {
+	return norm.NFKC.Bytes(password)
+}
+
+func CheckPasswordPolicy(config *dm.Config, password []byte, email string, name string) []dm.PasswordPolicyViolation {
+	input := PasswordCheckInput{
+		Password: string(NormalizePassword(password)),
+		Email:    email,
+		Name:     name,
+	}
+	var violations []dm.PasswordPolicyViolation
+	for _, rule := range passwordRules {
+		if violation, violated := rule(config, input); violated {
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+func minLengthRule(config *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	return dm.PasswordPolicyViolationTooShort, utf8.RuneCountInString(input.Password) < config.PasswordMinLength
+}
+
+func maxLengthRule(config *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	return dm.PasswordPolicyViolationTooLong, config.PasswordMaxLength > 0 && utf8.RuneCountInString(input.Password) > config.PasswordMaxLength
+}
+
+func notContainingEmailRule(_ *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	password := strings.ToLower(input.Password)
+	localPart, _, _ := strings.Cut(strings.ToLower(input.Email), "@")
+	return dm.PasswordPolicyViolationContainsEmail, len(localPart) >= 3 && strings.Contains(password, localPart)
+}
+
+func notContainingNameRule(_ *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	password := strings.ToLower(input.Password)
+	for _, namePart := range strings.Fields(strings.ToLower(input.Name)) {
+		if utf8.RuneCountInString(namePart) >= 3 && strings.Contains(password, namePart) {
+			return dm.PasswordPolicyViolationContainsName, true
+		}
+	}
+	return dm.PasswordPolicyViolationContainsName, false
+}
+
+func notBlocklistedRule(config *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	password := strings.ToLower(input.Password)
+	for _, blocked := range config.PasswordBlocklist {
+		if blocked != "" && password == strings.ToLower(blocked) {
+			return dm.PasswordPolicyViolationBlocklisted, true
+		}
+	}
+	return dm.PasswordPolicyViolationBlocklisted, false
+}
+
+func notBreachedRule(_ *dm.Config, input PasswordCheckInput) (dm.PasswordPolicyViolation, bool) {
+	return dm.PasswordPolicyViolationBreached, breachedPasswords.contains(input.Password)
+}
+
+const breachedPasswordPrefixLength = 5
+
+// breachedPasswordRanges mirrors the range API of Have I Been Pwned: SHA-1 hashes are bucketed by their first
+// five hex characters, and a lookup only ever touches the suffixes within one bucket.
+type breachedPasswordRanges map[string][]string
+
+var breachedPasswords = breachedPasswordRanges{}
+
+func (b breachedPasswordRanges) contains(password string) bool {
+	if len(b) == 0 {
+		return false
+	}
+	hash := sha1.Sum([]byte(password))
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	suffixes := b[hexHash[:breachedPasswordPrefixLength]]
+	i := sort.SearchStrings(suffixes, hexHash[breachedPasswordPrefixLength:])
+	return i < len(suffixes) && suffixes[i] == hexHash[breachedPasswordPrefixLength:]
+}
+
+// LoadBreachedPasswords reads a file in the Have I Been Pwned download format, i.e. one "SHA1:count" per line.
+// Without a file configured, the breached password check is skipped.
+func LoadBreachedPasswords(config *dm.Config) error {
+	if config.BreachedPasswordsFile == "" {
+		slog.Info("No breached passwords file configured, skipping breached password check")
+		return nil
+	}
+
+	file, err := os.Open(config.BreachedPasswordsFile)
+	if err != nil {
+		return errs.Wrap("cannot open breached passwords file", err)
+	}
+	defer file.Close()
+
+	ranges := breachedPasswordRanges{}
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != 2*sha1.Size {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+		prefix := hash[:breachedPasswordPrefixLength]
+		ranges[prefix] = append(ranges[prefix], hash[breachedPasswordPrefixLength:])
+		count++
+	}
+	if err = scanner.Err(); err != nil {
+		return errs.Wrap("issue reading breached passwords file", err)
+	}
+	for _, suffixes := range ranges {
+		sort.Strings(suffixes)
+	}
+
+	breachedPasswords = ranges
+	slog.Info("Breached passwords loaded", "count", count)
+	return nil
+}
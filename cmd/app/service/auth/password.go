@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+func targetArgon2idParams(config *dm.Config) dm.PasswordHashParams {
+	return dm.PasswordHashParams{
+		Time:      config.Argon2idTime,
+		MemoryKiB: config.Argon2idMemoryKiB,
+		Threads:   config.Argon2idThreads,
+		KeyLength: passwordKeyLength,
+	}
+}
+
+// MakeCredentials hashes the normalized password. Use CheckPasswordPolicy before accepting a new password.
+func MakeCredentials(config *dm.Config, password []byte) (dm.UserCredentials, error) {
+	if len(password) == 0 {
+		return dm.UserCredentials{}, errs.Error("empty password")
+	}
+	salt := make([]byte, passwordSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return dm.UserCredentials{}, errs.Wrap("error generating salt", err)
+	}
+
+	// See https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html#input-limits
+	params := targetArgon2idParams(config)
+	key := argon2.IDKey(NormalizePassword(password), salt, params.Time, params.MemoryKiB, params.Threads, params.KeyLength)
+
+	return dm.UserCredentials{
+		Algorithm: dm.PasswordHashAlgorithmArgon2id,
+		Params:    params,
+		Key:       key,
+		Salt:      salt,
+	}, nil
+}
+
+// VerifyCredentials checks the normalized password and, for hashes made before normalization was introduced, the raw one
+func VerifyCredentials(password []byte, credentials dm.UserCredentials) bool {
+	normalized := NormalizePassword(password)
+	if verifyPassword(normalized, credentials) {
+		return true
+	}
+	return string(normalized) != string(password) && verifyPassword(password, credentials)
+}
+
+func verifyPassword(password []byte, credentials dm.UserCredentials) bool {
+	if len(credentials.Key) == 0 {
+		return false
+	}
+
+	switch credentials.Algorithm {
+	case "":
+		params := dm.LegacyArgon2idParams
+		key := argon2.IDKey(password, credentials.Salt, params.Time, params.MemoryKiB, params.Threads, params.KeyLength)
+		return subtle.ConstantTimeCompare(key, credentials.Key) == 1
+	case dm.PasswordHashAlgorithmArgon2id:
+		params := credentials.Params
+		if params.Time < 1 || params.Threads < 1 {
+			// argon2 panics on these, the stored parameters are broken
+			return false
+		}
+		key := argon2.IDKey(password, credentials.Salt, params.Time, params.MemoryKiB, params.Threads, params.KeyLength)
+		return subtle.ConstantTimeCompare(key, credentials.Key) == 1
+	case dm.PasswordHashAlgorithmBcrypt:
+		return bcrypt.CompareHashAndPassword(credentials.Key, password) == nil
+	case dm.PasswordHashAlgorithmScrypt:
+		params := credentials.Params
+		key, err := scrypt.Key(password, credentials.Salt, params.N, params.R, params.P, int(params.KeyLength))
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare(key, credentials.Key) == 1
+	}
+	return false
+}
+
+// NeedsRehash reports whether the credentials were made with another algorithm or other parameters than configured
+func NeedsRehash(config *dm.Config, credentials dm.UserCredentials) bool {
+	return credentials.Algorithm != dm.PasswordHashAlgorithmArgon2id || credentials.Params != targetArgon2idParams(config)
+}
+
+// ParseImportedPasswordHash converts a password hash exported from a legacy system.
+// Supported are bcrypt hashes ($2a$, $2b$, $2y$) and scrypt hashes in the format
+// $scrypt$ln=<log2(N)>,r=<r>,p=<p>$<base64 salt>$<base64 key>.
+func ParseImportedPasswordHash(hash string) (dm.UserCredentials, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return dm.UserCredentials{}, errs.Wrap("invalid bcrypt hash", err)
+		}
+		return dm.UserCredentials{Algorithm: dm.PasswordHashAlgorithmBcrypt, Key: []byte(hash)}, nil
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return parseScryptHash(hash)
+	}
+	return dm.UserCredentials{}, errs.Error("unsupported password hash format")
+}
+
+func parseScryptHash(hash string) (dm.UserCredentials, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return dm.UserCredentials{}, errs.Error("invalid scrypt hash")
+	}
+
+	var logN, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return dm.UserCredentials{}, errs.Wrap("invalid scrypt parameters", err)
+	}
+	if logN < 1 || logN > 30 || r < 1 || p < 1 {
+		return dm.UserCredentials{}, errs.Errorf("scrypt parameters out of range: ln=%d,r=%d,p=%d", logN, r, p)
+	}
+
+	salt, err := decodeImportedBase64(parts[3])
+	if err != nil {
+		return dm.UserCredentials{}, errs.Wrap("invalid scrypt salt", err)
+	}
+	key, err := decodeImportedBase64(parts[4])
+	if err != nil {
+		return dm.UserCredentials{}, errs.Wrap("invalid scrypt key", err)
+	}
+
+	return dm.UserCredentials{
+		Algorithm: dm.PasswordHashAlgorithmScrypt,
+		Params:    dm.PasswordHashParams{N: 1 << logN, R: r, P: p, KeyLength: uint32(len(key))},
+		Key:       key,
+		Salt:      salt,
+	}, nil
+}
+
+// decodeImportedBase64 accepts standard base64 with or without padding, as well as the "." variant used by passlib
+func decodeImportedBase64(value string) ([]byte, error) {
+	normalized := strings.TrimRight(strings.ReplaceAll(value, ".", "+"), "=")
+	return base64.RawStdEncoding.DecodeString(normalized)
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	dm "user-manager/domain-model"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// knownBcryptHash is the OpenBSD test vector for the password "U*U"
+const knownBcryptHash = "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"
+
+// knownScryptHash is the RFC 7914 test vector for the password "password" with salt "NaCl", N=1024, r=8 and p=16
+const knownScryptHash = "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA"
+
+func testPasswordConfig() *dm.Config {
+	return &dm.Config{Argon2idTime: 1, Argon2idMemoryKiB: 1024, Argon2idThreads: 1}
+}
+
+func mustParseImportedPasswordHash(t *testing.T, hash string) dm.UserCredentials {
+	credentials, err := ParseImportedPasswordHash(hash)
+	if err != nil {
+		t.Fatalf("expected %s to parse, got %v", hash, err)
+	}
+	return credentials
+}
+
+func TestVerifyPassword(t *testing.T) {
+	argon2idCredentials, err := MakeCredentials(testPasswordConfig(), []byte("password"))
+	if err != nil {
+		t.Fatalf("expected credentials, got %v", err)
+	}
+	legacyCredentials := dm.UserCredentials{Salt: []byte("legacy-salt-0123")}
+	params := dm.LegacyArgon2idParams
+	legacyCredentials.Key = argon2.IDKey([]byte("password"), legacyCredentials.Salt, params.Time, params.MemoryKiB, params.Threads, params.KeyLength)
+	bcryptCredentials := mustParseImportedPasswordHash(t, knownBcryptHash)
+	scryptCredentials := mustParseImportedPasswordHash(t, knownScryptHash)
+
+	withAlgorithm := func(credentials dm.UserCredentials, algorithm dm.PasswordHashAlgorithm) dm.UserCredentials {
+		credentials.Algorithm = algorithm
+		return credentials
+	}
+
+	tests := []struct {
+		name        string
+		password    string
+		credentials dm.UserCredentials
+		expected    bool
+	}{
+		{"argon2id", "password", argon2idCredentials, true},
+		{"argon2id wrong password", "Password", argon2idCredentials, false},
+		{"legacy argon2id", "password", legacyCredentials, true},
+		{"legacy argon2id wrong password", "Password", legacyCredentials, false},
+		{"bcrypt", "U*U", bcryptCredentials, true},
+		{"bcrypt wrong password", "U*V", bcryptCredentials, false},
+		{"scrypt", "password", scryptCredentials, true},
+		{"scrypt wrong password", "Password", scryptCredentials, false},
+		{"argon2id key as legacy", "password", withAlgorithm(argon2idCredentials, ""), false},
+		{"legacy key as argon2id", "password", withAlgorithm(legacyCredentials, dm.PasswordHashAlgorithmArgon2id), false},
+		{"scrypt key as argon2id", "password", withAlgorithm(scryptCredentials, dm.PasswordHashAlgorithmArgon2id), false},
+		{"bcrypt hash as scrypt", "U*U", withAlgorithm(bcryptCredentials, dm.PasswordHashAlgorithmScrypt), false},
+		{"unknown algorithm", "password", withAlgorithm(argon2idCredentials, "md5"), false},
+		{"no key", "password", dm.UserCredentials{Algorithm: dm.PasswordHashAlgorithmArgon2id}, false},
+	}
+
+	for _, test := range tests {
+		actual := verifyPassword([]byte(test.password), test.credentials)
+		if actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	config := testPasswordConfig()
+	credentials, err := MakeCredentials(config, []byte("password"))
+	if err != nil {
+		t.Fatalf("expected credentials, got %v", err)
+	}
+
+	changedTime := *config
+	changedTime.Argon2idTime = 2
+	changedMemory := *config
+	changedMemory.Argon2idMemoryKiB = 2048
+	changedThreads := *config
+	changedThreads.Argon2idThreads = 2
+
+	tests := []struct {
+		name        string
+		config      *dm.Config
+		credentials dm.UserCredentials
+		expected    bool
+	}{
+		{"current parameters", config, credentials, false},
+		{"changed time", &changedTime, credentials, true},
+		{"changed memory", &changedMemory, credentials, true},
+		{"changed threads", &changedThreads, credentials, true},
+		{"legacy argon2id", config, dm.UserCredentials{Key: credentials.Key, Salt: credentials.Salt}, true},
+		{"bcrypt", config, mustParseImportedPasswordHash(t, knownBcryptHash), true},
+		{"scrypt", config, mustParseImportedPasswordHash(t, knownScryptHash), true},
+	}
+
+	for _, test := range tests {
+		actual := NeedsRehash(test.config, test.credentials)
+		if actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestParseImportedPasswordHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+	}{
+		{"bcrypt", knownBcryptHash, "U*U"},
+		{"bcrypt 2b", strings.Replace(knownBcryptHash, "$2a$", "$2b$", 1), "U*U"},
+		{"scrypt", knownScryptHash, "password"},
+		{"scrypt with padding", strings.Replace(knownScryptHash, "$TmFDbA$", "$TmFDbA==$", 1), "password"},
+		{"scrypt passlib base64", strings.ReplaceAll(knownScryptHash, "+", "."), "password"},
+	}
+
+	for _, test := range tests {
+		credentials, err := ParseImportedPasswordHash(test.hash)
+		if err != nil {
+			t.Errorf("%s: expected hash to parse, got %v", test.name, err)
+			continue
+		}
+		if !VerifyCredentials([]byte(test.password), credentials) {
+			t.Errorf("%s: expected password to match", test.name)
+		}
+	}
+
+	scryptCredentials := mustParseImportedPasswordHash(t, knownScryptHash)
+	expectedParams := dm.PasswordHashParams{N: 1024, R: 8, P: 16, KeyLength: 64}
+	if scryptCredentials.Params != expectedParams {
+		t.Errorf("scrypt parameters: expected %+v, got %+v", expectedParams, scryptCredentials.Params)
+	}
+
+	invalidHashes := []string{
+		"",
+		"plain-text",
+		"$2a$05$too-short",
+		"$scrypt$ln=10,r=8$TmFDbA$/bq+HJ00",
+		"$scrypt$ln=31,r=8,p=16$TmFDbA$/bq+HJ00",
+		"$scrypt$ln=10,r=0,p=16$TmFDbA$/bq+HJ00",
+		"$scrypt$ln=10,r=8,p=16$not*base64$/bq+HJ00",
+		"$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$a2V5",
+	}
+	for _, hash := range invalidHashes {
+		if _, err := ParseImportedPasswordHash(hash); err == nil {
+			t.Errorf("%q: expected hash to be rejected", hash)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"strings"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const qrCodeSize = 200
+
+func GenerateSecondFactorKey(config *dm.Config, accountName string) (*otp.Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.ServiceName,
+		AccountName: accountName,
+	})
+	if err != nil {
+		return nil, errs.Wrap("issue generating totp key", err)
+	}
+	return key, nil
+}
+
+// SecondFactorKeyForSecret rebuilds the key of a secret generated before, so that its QR code can be shown again
+func SecondFactorKeyForSecret(config *dm.Config, accountName string, secret string) (*otp.Key, error) {
+	secretBytes, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, errs.Wrap("issue decoding totp secret", err)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.ServiceName,
+		AccountName: accountName,
+		Secret:      secretBytes,
+	})
+	if err != nil {
+		return nil, errs.Wrap("issue rebuilding totp key", err)
+	}
+	return key, nil
+}
+
+func MakeQRCodeDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return "", errs.Wrap("issue rendering qr code", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = png.Encode(buf, img); err != nil {
+		return "", errs.Wrap("issue encoding qr code as png", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func VerifySecondFactorCode(code string, secondFactorToken string) bool {
+	return secondFactorToken != "" && code != "" && totp.Validate(code, secondFactorToken)
+}
+
+const (
+	recoveryCodeCount      = 10
+	recoveryCodeByteLength = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MakeRecoveryCodes returns freshly generated recovery codes for display and their hashes for persistence.
+func MakeRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		randomBytes := make([]byte, recoveryCodeByteLength)
+		if _, err := rand.Read(randomBytes); err != nil {
+			panic(errs.Wrap("issue reading random bytes", err))
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(randomBytes))
+		code := encoded[:len(encoded)/2] + "-" + encoded[len(encoded)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// HashRecoveryCode hashes a recovery code after normalizing case and separators.
+// Recovery codes carry enough entropy that a fast hash is sufficient.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
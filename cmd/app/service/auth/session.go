@@ -3,87 +3,139 @@ package auth
 import (
 	"context"
 	"errors"
+	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 	dm "user-manager/domain-model"
 	"user-manager/util/db"
 	"user-manager/util/errs"
 )
 
-func GetUserForSession(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType) (dm.User, error) {
-	return getUserForSession(ctx, database, sessionToken, sessionType, false)
+// CreateSessionIndexes sets up the indexes for session lookups. Expired sessions are removed by Mongo's TTL monitor.
+func CreateSessionIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.SessionCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+		{Keys: bson.D{{Key: "loginSessionID", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "timeoutAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create session indexes", err)
+	}
+	return nil
 }
-func GetUserForSessionForSecondFactorVerification(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType) (dm.User, error) {
-	return getUserForSession(ctx, database, sessionToken, sessionType, true)
+
+func GetUserForSession(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType) (dm.User, error) {
+	_, user, err := getSessionAndUser(ctx, database, config, sessionToken, sessionType, false)
+	return user, err
 }
-func getUserForSession(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType, requiresSecondFactor bool) (dm.User, error) {
+func GetUserForSessionForSecondFactorVerification(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType) (dm.User, error) {
+	_, user, err := getSessionAndUser(ctx, database, config, sessionToken, sessionType, true)
+	return user, err
+}
+
+// GetSessionAndUser is GetUserForSession for callers that also need the session itself, e.g. its active organization
+func GetSessionAndUser(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType) (dm.UserSession, dm.User, error) {
+	return getSessionAndUser(ctx, database, config, sessionToken, sessionType, false)
+}
+func getSessionAndUser(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, sessionType dm.UserSessionType, requiresSecondFactor bool) (dm.UserSession, dm.User, error) {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	var user dm.User
-	sessionMatches := bson.M{"token": sessionToken, "type": sessionType}
-	requiresSecondFactorMatches := bson.M{"requiresSecondFactor": bson.M{"$exists": false}}
+	filter := bson.M{
+		"tokenHash":            HashToken(config, string(sessionToken)),
+		"type":                 sessionType,
+		"timeoutAt":            bson.M{"$gt": time.Now()},
+		"requiresSecondFactor": bson.M{"$exists": false},
+	}
 	if requiresSecondFactor {
-		requiresSecondFactorMatches = bson.M{"requiresSecondFactor": true}
+		filter["requiresSecondFactor"] = true
 	}
-	notExpired := bson.M{"timeoutAt": bson.M{"$gt": time.Now()}}
 
-	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{"sessions": bson.M{"$elemMatch": bson.M{"$and": bson.A{sessionMatches, notExpired, requiresSecondFactorMatches}}}}).
-		Decode(&user)
+	var session dm.UserSession
+	err := database.Collection(dm.SessionCollectionName).FindOne(queryCtx, filter).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dm.UserSession{}, dm.User{}, nil
+		}
+		return dm.UserSession{}, dm.User{}, errs.Wrap("error loading session", err)
+	}
 
+	var user dm.User
+	err = database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{"_id": session.UserID}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return dm.User{}, nil
+			return dm.UserSession{}, dm.User{}, nil
 		}
-		return dm.User{}, errs.Wrap("error loading user for session", err)
+		return dm.UserSession{}, dm.User{}, errs.Wrap("error loading user for session", err)
 	}
-	return user, nil
+	return session, user, nil
 }
 
-func InsertSession(ctx context.Context, database *mongo.Database, userId dm.UserID, session dm.UserSession) error {
+func GetSessionsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) ([]dm.UserSession, error) {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userId), bson.M{"$push": bson.M{"sessions": session}})
-
+	cursor, err := database.Collection(dm.SessionCollectionName).Find(queryCtx,
+		bson.M{"userID": primitive.ObjectID(userID), "timeoutAt": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "lastActivityAt", Value: -1}}))
 	if err != nil {
-		return errs.Wrap("error inserting session", err)
+		return nil, errs.Wrap("error loading sessions", err)
 	}
 
-	// Prune old sessions
-	queryCtx, cancel = db.DefaultQueryContext(ctx)
+	sessions := []dm.UserSession{}
+	if err = cursor.All(queryCtx, &sessions); err != nil {
+		return nil, errs.Wrap("error decoding sessions", err)
+	}
+	return sessions, nil
+}
+
+// InsertSession stores the session together with details about the client it was issued to
+func InsertSession(ctx *gin.Context, database *mongo.Database, config *dm.Config, userId dm.UserID, session dm.UserSession) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
-	_, err = database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userId), bson.M{"$pull": bson.M{"sessions": bson.M{"timeoutAt": bson.M{"$lt": time.Now()}}}})
 
+	now := time.Now()
+	session.TokenHash = HashToken(config, string(session.Token))
+	session.UserID = primitive.ObjectID(userId)
+	session.CreatedAt = now
+	session.LastActivityAt = now
+	session.UserAgent = ctx.Request.UserAgent()
+	session.ClientIP = ctx.ClientIP()
+
+	_, err := database.Collection(dm.SessionCollectionName).InsertOne(queryCtx, session)
 	if err != nil {
-		return errs.Wrap("error pruning old sessions", err)
+		return errs.Wrap("error inserting session", err)
 	}
-
 	return nil
 }
 
-func SetSecondFactorVerifiedForSession(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken) error {
+func SetSecondFactorVerifiedForSession(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
-		bson.M{"sessions": bson.M{"$elemMatch": bson.M{"token": sessionToken}}},
-		bson.M{"$unset": "sessions.$.requiresSecondFactor"})
+	_, err := database.Collection(dm.SessionCollectionName).UpdateOne(queryCtx,
+		bson.M{"tokenHash": HashToken(config, string(sessionToken))},
+		bson.M{"$unset": bson.M{"requiresSecondFactor": ""}})
 
 	if err != nil {
 		return errs.Wrap("error updating session timeout", err)
 	}
 	return nil
 }
-func UpdateSessionTimeout(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken, timeout time.Time) error {
+func UpdateSessionTimeout(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, timeout time.Time) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
-		bson.M{"sessions": bson.M{"$elemMatch": bson.M{"token": sessionToken}}},
-		bson.M{"$set": bson.M{"sessions.$.timeoutAt": timeout}})
+	_, err := database.Collection(dm.SessionCollectionName).UpdateOne(queryCtx,
+		bson.M{"tokenHash": HashToken(config, string(sessionToken))},
+		bson.M{"$set": bson.M{"timeoutAt": timeout, "lastActivityAt": time.Now()}})
 
 	if err != nil {
 		return errs.Wrap("error updating session timeout", err)
@@ -91,17 +143,57 @@ func UpdateSessionTimeout(ctx context.Context, database *mongo.Database, session
 	return nil
 }
 
-func DeleteSession(ctx context.Context, database *mongo.Database, sessionToken dm.UserSessionToken) error {
+func SetActiveOrganizationForSession(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken, organizationID dm.OrganizationID) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateOne(
-		queryCtx,
-		bson.M{"sessions": bson.M{"$elemMatch": bson.M{"token": sessionToken}}},
-		bson.M{"$pull": bson.M{"sessions": bson.M{"token": sessionToken}}})
+	_, err := database.Collection(dm.SessionCollectionName).UpdateOne(queryCtx,
+		bson.M{"tokenHash": HashToken(config, string(sessionToken))},
+		bson.M{"$set": bson.M{"activeOrganizationID": primitive.ObjectID(organizationID)}})
 
 	if err != nil {
+		return errs.Wrap("error setting active organization", err)
+	}
+	return nil
+}
+
+func DeleteSession(ctx context.Context, database *mongo.Database, config *dm.Config, sessionToken dm.UserSessionToken) error {
+	return deleteSession(ctx, database, bson.M{"tokenHash": HashToken(config, string(sessionToken))})
+}
+
+// DeleteSessionForUser deletes a session by its ID, provided it belongs to the given user
+func DeleteSessionForUser(ctx context.Context, database *mongo.Database, userID dm.UserID, sessionID primitive.ObjectID) error {
+	return deleteSession(ctx, database, bson.M{"_id": sessionID, "userID": primitive.ObjectID(userID)})
+}
+
+// deleteSession deletes the matching session along with the forward auth sessions issued for it
+func deleteSession(ctx context.Context, database *mongo.Database, filter bson.M) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var session dm.UserSession
+	err := database.Collection(dm.SessionCollectionName).FindOneAndDelete(queryCtx, filter).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
 		return errs.Wrap("error deleting session", err)
 	}
+
+	_, err = database.Collection(dm.SessionCollectionName).DeleteMany(queryCtx, bson.M{"loginSessionID": session.ObjectID})
+	if err != nil {
+		return errs.Wrap("error deleting forward auth sessions", err)
+	}
+	return nil
+}
+
+func DeleteSessionsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.SessionCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)})
+	if err != nil {
+		return errs.Wrap("error deleting sessions", err)
+	}
 	return nil
 }
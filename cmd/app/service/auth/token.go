@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	dm "user-manager/domain-model"
+)
+
+// HashToken computes the keyed hash under which bearer tokens are stored, so a database dump does not reveal usable tokens
+func HashToken(config *dm.Config, token string) string {
+	mac := hmac.New(sha256.New, []byte(config.TokenHashSecret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyTokenHash(config *dm.Config, token string, tokenHash string) bool {
+	if token == "" || tokenHash == "" {
+		return false
+	}
+	return hmac.Equal([]byte(HashToken(config, token)), []byte(tokenHash))
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/url"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webAuthnCeremonyCookieName = "WEBAUTHN_CEREMONY_TOKEN"
+
+func NewWebAuthn(config *dm.Config) (*webauthn.WebAuthn, error) {
+	appUrl, err := url.Parse(config.AppUrl)
+	if err != nil {
+		return nil, errs.Wrap("cannot parse app url", err)
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          appUrl.Hostname(),
+		RPDisplayName: config.ServiceName,
+		RPOrigins:     []string{appUrl.Scheme + "://" + appUrl.Host},
+	})
+	if err != nil {
+		return nil, errs.Wrap("cannot create webauthn relying party", err)
+	}
+	return w, nil
+}
+
+// WebAuthnUser adapts a dm.User to the interface expected by the webauthn library.
+// The user handle is the user's ObjectID, so discoverable logins can be mapped back to the user.
+type WebAuthnUser struct {
+	User dm.User
+}
+
+func (u WebAuthnUser) WebAuthnID() []byte {
+	return u.User.ObjectID[:]
+}
+
+func (u WebAuthnUser) WebAuthnName() string {
+	return u.User.Email
+}
+
+func (u WebAuthnUser) WebAuthnDisplayName() string {
+	if u.User.Name != "" {
+		return u.User.Name
+	}
+	return u.User.Email
+}
+
+func (u WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.User.WebAuthnCredentials))
+	for _, c := range u.User.WebAuthnCredentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+func MakeWebAuthnCredential(credential *webauthn.Credential, nickname string) dm.WebAuthnCredential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	return dm.WebAuthnCredential{
+		ID:              credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Nickname:        nickname,
+		CreatedAt:       time.Now(),
+	}
+}
+
+func ParseWebAuthnCreationResponse(body json.RawMessage) (*protocol.ParsedCredentialCreationData, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, errs.Wrap("cannot parse credential creation response", err)
+	}
+	return parsed, nil
+}
+
+func ParseWebAuthnAssertionResponse(body json.RawMessage) (*protocol.ParsedCredentialAssertionData, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, errs.Wrap("cannot parse credential assertion response", err)
+	}
+	return parsed, nil
+}
+
+// StartWebAuthnCeremony persists the ceremony's session data and binds it to the browser via cookie.
+func StartWebAuthnCeremony(ctx *gin.Context, database *mongo.Database, config *dm.Config, ceremonyType dm.WebAuthnCeremonyType, userID dm.UserID, sessionData *webauthn.SessionData) error {
+	serialized, err := json.Marshal(sessionData)
+	if err != nil {
+		return errs.Wrap("cannot serialize webauthn session data", err)
+	}
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	token := random.MakeRandomURLSafeB64(21)
+	_, err = database.Collection(dm.WebAuthnCeremonyCollectionName).InsertOne(queryCtx, dm.WebAuthnCeremony{
+		TokenHash:   HashToken(config, token),
+		Type:        ceremonyType,
+		UserID:      primitive.ObjectID(userID),
+		SessionData: serialized,
+		TimeoutAt:   time.Now().Add(dm.WebAuthnCeremonyDuration),
+	})
+	if err != nil {
+		return errs.Wrap("cannot insert webauthn ceremony", err)
+	}
+
+	// Prune abandoned ceremonies
+	_, err = database.Collection(dm.WebAuthnCeremonyCollectionName).DeleteMany(queryCtx, bson.M{"timeoutAt": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return errs.Wrap("cannot prune webauthn ceremonies", err)
+	}
+
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(webAuthnCeremonyCookieName, token, int(dm.WebAuthnCeremonyDuration.Seconds()), "", "", !config.IsLocalEnv(), true)
+	return nil
+}
+
+// FinishWebAuthnCeremony consumes the ceremony bound to the browser. The ceremony can only be finished once.
+func FinishWebAuthnCeremony(ctx *gin.Context, database *mongo.Database, config *dm.Config, ceremonyType dm.WebAuthnCeremonyType) (dm.WebAuthnCeremony, webauthn.SessionData, error) {
+	cookie, err := ctx.Request.Cookie(webAuthnCeremonyCookieName)
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			return dm.WebAuthnCeremony{}, webauthn.SessionData{}, nil
+		}
+		return dm.WebAuthnCeremony{}, webauthn.SessionData{}, errs.Wrap("issue reading cookie", err)
+	}
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(webAuthnCeremonyCookieName, "", -1, "", "", !config.IsLocalEnv(), true)
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var ceremony dm.WebAuthnCeremony
+	err = database.Collection(dm.WebAuthnCeremonyCollectionName).FindOneAndDelete(queryCtx, bson.M{
+		"tokenHash": HashToken(config, cookie.Value),
+		"type":      ceremonyType,
+		"timeoutAt": bson.M{"$gt": time.Now()},
+	}).Decode(&ceremony)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dm.WebAuthnCeremony{}, webauthn.SessionData{}, nil
+		}
+		return dm.WebAuthnCeremony{}, webauthn.SessionData{}, errs.Wrap("cannot load webauthn ceremony", err)
+	}
+
+	var sessionData webauthn.SessionData
+	if err = json.Unmarshal(ceremony.SessionData, &sessionData); err != nil {
+		return dm.WebAuthnCeremony{}, webauthn.SessionData{}, errs.Wrap("cannot deserialize webauthn session data", err)
+	}
+	return ceremony, sessionData, nil
+}
@@ -0,0 +1,134 @@
+package externallogin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+const (
+	stateCookieName = "EXTERNAL_LOGIN_STATE"
+	stateCookiePath = "/auth/external"
+)
+
+// CreateExternalLoginIndexes sets up the lookup of users by linked identity. Abandoned logins are removed by Mongo's TTL monitor.
+func CreateExternalLoginIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.ExternalLoginCollectionName).Indexes().CreateOne(queryCtx,
+		mongo.IndexModel{Keys: bson.D{{Key: "timeoutAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)})
+	if err != nil {
+		return errs.Wrap("cannot create external login indexes", err)
+	}
+
+	_, err = database.Collection(dm.UserCollectionName).Indexes().CreateOne(queryCtx,
+		mongo.IndexModel{Keys: bson.D{{Key: "externalIdentities.provider", Value: 1}, {Key: "externalIdentities.subject", Value: 1}}})
+	if err != nil {
+		return errs.Wrap("cannot create external identity indexes", err)
+	}
+	return nil
+}
+
+// RedirectURI is the callback the provider sends the user back to. It must be registered with the provider.
+func RedirectURI(config *dm.Config, provider dm.ExternalIdentityProvider) string {
+	return strings.TrimSuffix(config.AppUrl, "/") + stateCookiePath + "/" + url.PathEscape(provider.Name) + "/callback"
+}
+
+// StartExternalLogin persists the state of an authorization request, binds it to the browser via cookie and returns the provider's authorization URL.
+// The user id is only set when linking an identity to the logged-in user.
+func StartExternalLogin(ctx *gin.Context, database *mongo.Database, config *dm.Config, provider dm.ExternalIdentityProvider, purpose dm.ExternalLoginPurpose, userID dm.UserID) (string, error) {
+	discovery, err := discover(ctx, provider)
+	if err != nil {
+		return "", errs.Wrap("issue discovering provider configuration", err)
+	}
+
+	state := random.MakeRandomURLSafeB64(21)
+	login := dm.ExternalLogin{
+		StateHash:    auth.HashToken(config, state),
+		Provider:     provider.Name,
+		Purpose:      purpose,
+		UserID:       primitive.ObjectID(userID),
+		Nonce:        random.MakeRandomURLSafeB64(21),
+		CodeVerifier: random.MakeRandomURLSafeB64(33),
+		TimeoutAt:    time.Now().Add(dm.ExternalLoginDuration),
+	}
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err = database.Collection(dm.ExternalLoginCollectionName).InsertOne(queryCtx, login); err != nil {
+		return "", errs.Wrap("cannot insert external login", err)
+	}
+
+	// The provider redirects back with a cross-site top-level navigation, which only carries Lax cookies
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(stateCookieName, state, int(dm.ExternalLoginDuration.Seconds()), stateCookiePath, "", !config.IsLocalEnv(), true)
+
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {RedirectURI(config, provider)},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// FinishExternalLogin consumes the login bound to the browser if the state returned by the provider matches. It can only be finished once.
+func FinishExternalLogin(ctx *gin.Context, database *mongo.Database, config *dm.Config, provider dm.ExternalIdentityProvider) (dm.ExternalLogin, error) {
+	cookie, err := ctx.Request.Cookie(stateCookieName)
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			return dm.ExternalLogin{}, nil
+		}
+		return dm.ExternalLogin{}, errs.Wrap("issue reading cookie", err)
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(stateCookieName, "", -1, stateCookiePath, "", !config.IsLocalEnv(), true)
+
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(ctx.Query("state"))) != 1 {
+		return dm.ExternalLogin{}, nil
+	}
+
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var login dm.ExternalLogin
+	err = database.Collection(dm.ExternalLoginCollectionName).FindOneAndDelete(queryCtx, bson.M{
+		"stateHash": auth.HashToken(config, cookie.Value),
+		"provider":  provider.Name,
+		"timeoutAt": bson.M{"$gt": time.Now()},
+	}).Decode(&login)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dm.ExternalLogin{}, nil
+		}
+		return dm.ExternalLogin{}, errs.Wrap("cannot load external login", err)
+	}
+	return login, nil
+}
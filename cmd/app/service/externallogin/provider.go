@@ -0,0 +1,160 @@
+package externallogin
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	dm "user-manager/domain-model"
+	openidprovider "user-manager/third-party-models/openid-provider"
+	"user-manager/util/errs"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// IdentityClaims are the claims of a verified ID token issued by an external provider
+type IdentityClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// RedeemAuthorizationCode exchanges the code for an ID token and verifies its signature, issuer, audience and nonce.
+// The provider configuration and keys are fetched for every login, so key rotations at the provider need no caching logic.
+func RedeemAuthorizationCode(ctx context.Context, config *dm.Config, provider dm.ExternalIdentityProvider, login dm.ExternalLogin, code string) (IdentityClaims, error) {
+	discovery, err := discover(ctx, provider)
+	if err != nil {
+		return IdentityClaims{}, errs.Wrap("issue discovering provider configuration", err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {RedirectURI(config, provider)},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+		"code_verifier": {login.CodeVerifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IdentityClaims{}, errs.Wrap("cannot build token request", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	var tokenResponse openidprovider.TokenResponseTO
+	if err = doJSONRequest(request, &tokenResponse); err != nil {
+		return IdentityClaims{}, errs.Wrap("token request failed", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return IdentityClaims{}, errs.Error("token response without id token")
+	}
+
+	publicKeys, err := fetchPublicKeys(ctx, discovery.JwksURI)
+	if err != nil {
+		return IdentityClaims{}, errs.Wrap("issue fetching provider keys", err)
+	}
+
+	var claims IdentityClaims
+	_, err = jwt.ParseWithClaims(tokenResponse.IDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if publicKey, ok := publicKeys[kid]; ok {
+			return publicKey, nil
+		}
+		// Providers publishing a single key may omit the key id
+		if kid == "" && len(publicKeys) == 1 {
+			for _, publicKey := range publicKeys {
+				return publicKey, nil
+			}
+		}
+		return nil, errs.Error("unknown signing key")
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(provider.Issuer), jwt.WithAudience(provider.ClientID), jwt.WithExpirationRequired())
+	if err != nil {
+		return IdentityClaims{}, errs.Wrap("invalid id token", err)
+	}
+	if claims.Nonce != login.Nonce {
+		return IdentityClaims{}, errs.Error("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return IdentityClaims{}, errs.Error("id token without subject")
+	}
+	return claims, nil
+}
+
+func discover(ctx context.Context, provider dm.ExternalIdentityProvider) (openidprovider.DiscoveryTO, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(provider.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return openidprovider.DiscoveryTO{}, errs.Wrap("cannot build discovery request", err)
+	}
+
+	var discovery openidprovider.DiscoveryTO
+	if err = doJSONRequest(request, &discovery); err != nil {
+		return openidprovider.DiscoveryTO{}, errs.Wrap("discovery request failed", err)
+	}
+	// OpenID Connect Discovery 1.0, section 4.3
+	if discovery.Issuer != provider.Issuer {
+		return openidprovider.DiscoveryTO{}, errs.Errorf("discovered issuer %s does not match configured issuer %s", discovery.Issuer, provider.Issuer)
+	}
+	return discovery, nil
+}
+
+// fetchPublicKeys returns the provider's RSA signing keys by key id
+func fetchPublicKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, errs.Wrap("cannot build jwks request", err)
+	}
+
+	var jwks openidprovider.JwksTO
+	if err = doJSONRequest(request, &jwks); err != nil {
+		return nil, errs.Wrap("jwks request failed", err)
+	}
+
+	publicKeys := map[string]*rsa.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, errs.Wrap("cannot decode key modulus", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, errs.Wrap("cannot decode key exponent", err)
+		}
+		publicKeys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return publicKeys, nil
+}
+
+func doJSONRequest(request *http.Request, target interface{}) error {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return errs.Wrap("error making request", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return errs.Wrap("issue reading response", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		var errorTO openidprovider.TokenErrorTO
+		_ = json.Unmarshal(body, &errorTO)
+		return errs.Errorf("unexpected status %d: %s", response.StatusCode, errorTO.Error)
+	}
+	if err = json.Unmarshal(body, target); err != nil {
+		return errs.Wrap("cannot decode response", err)
+	}
+	return nil
+}
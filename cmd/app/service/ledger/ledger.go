@@ -0,0 +1,284 @@
+package ledger
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+// appendAttempts limits the retries when concurrent appends compete for the same sequence number or an insert fails
+const appendAttempts = 5
+
+// appendRetryDelay is multiplied by the attempt number before retrying a failed insert
+const appendRetryDelay = 100 * time.Millisecond
+
+// ParseSigningKey decodes the ed25519 seed from the config
+func ParseSigningKey(config *dm.Config) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(config.LedgerSigningKey)
+	if err != nil {
+		return nil, errs.Wrap("ledger signing key is not valid base64", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errs.Errorf("ledger signing key must be %d bytes long", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey decodes the ed25519 public key that checkpoints are verified with
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errs.Wrap("ledger public key is not valid base64", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errs.Errorf("ledger public key must be %d bytes long", ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+// Append adds the entry to the end of the chain. The actor is taken from the request if not set. Every
+// LedgerCheckpointInterval entries a signed checkpoint is written.
+func Append(ctx *gin.Context, r *dm.RequestContext, entry dm.LedgerEntry) error {
+	if entry.ActorID == primitive.NilObjectID && r.User.IsPresent() {
+		entry.ActorID = r.User.ObjectID
+	}
+	return AppendEntry(ctx, r.Database, r.Config, entry)
+}
+
+// AppendEntry is called after the change it records has been saved, so it does not give up when the request is
+// canceled and retries failed inserts. A checkpoint that could not be written is written by a later append.
+func AppendEntry(ctx context.Context, database *mongo.Database, config *dm.Config, entry dm.LedgerEntry) error {
+	ctx = context.WithoutCancel(ctx)
+	// Mongo stores milliseconds, the hash has to match what is read back
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	for attempt := 1; ; attempt++ {
+		last, err := getLastEntry(ctx, database)
+		if err != nil {
+			if attempt == appendAttempts {
+				return errs.Wrap("issue fetching last ledger entry", err)
+			}
+			time.Sleep(time.Duration(attempt) * appendRetryDelay)
+			continue
+		}
+		if attempt > 1 && last.Hash == entry.Hash {
+			// the previous attempt was inserted although it reported an error
+			break
+		}
+		entry.Sequence = last.Sequence + 1
+		entry.PreviousHash = last.Hash
+		entry.Hash = HashEntry(entry)
+
+		err = insertEntry(ctx, database, entry)
+		if err == nil {
+			break
+		}
+		if attempt == appendAttempts {
+			return errs.Wrap("issue inserting ledger entry", err)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			time.Sleep(time.Duration(attempt) * appendRetryDelay)
+		}
+	}
+
+	if err := insertMissingCheckpoints(ctx, database, config, entry.Sequence); err != nil {
+		return errs.Wrap("issue inserting ledger checkpoints", err)
+	}
+	return nil
+}
+
+// hashedEntry is the canonical form of an entry's content. Details are encoded with sorted keys.
+type hashedEntry struct {
+	Sequence     int64             `json:"sequence"`
+	Type         string            `json:"type"`
+	UserID       string            `json:"userID"`
+	ActorID      string            `json:"actorID"`
+	Details      map[string]string `json:"details"`
+	CreatedAt    int64             `json:"createdAt"`
+	PreviousHash string            `json:"previousHash"`
+}
+
+// HashEntry computes the hash over the entry's content and the hash of its predecessor
+func HashEntry(entry dm.LedgerEntry) string {
+	details := entry.Details
+	if len(details) == 0 {
+		// empty details are not stored and read back as nil
+		details = nil
+	}
+	content, err := json.Marshal(hashedEntry{
+		Sequence:     entry.Sequence,
+		Type:         string(entry.Type),
+		UserID:       entry.UserID.Hex(),
+		ActorID:      entry.ActorID.Hex(),
+		Details:      details,
+		CreatedAt:    entry.CreatedAt.UnixMilli(),
+		PreviousHash: entry.PreviousHash,
+	})
+	if err != nil {
+		panic(errs.Wrap("cannot encode ledger entry", err))
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifyCheckpoint checks the signature of the checkpoint
+func VerifyCheckpoint(publicKey ed25519.PublicKey, checkpoint dm.LedgerCheckpoint) bool {
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, checkpointMessage(checkpoint.Sequence, checkpoint.Hash), signature)
+}
+
+// ForEachEntry calls fn for all entries in chain order
+func ForEachEntry(ctx context.Context, database *mongo.Database, fn func(entry dm.LedgerEntry) error) error {
+	cursor, err := database.Collection(dm.LedgerEntryCollectionName).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return errs.Wrap("cannot query ledger entries", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry dm.LedgerEntry
+		if err = cursor.Decode(&entry); err != nil {
+			return errs.Wrap("cannot decode ledger entry", err)
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return errs.Wrap("issue iterating ledger entries", err)
+	}
+	return nil
+}
+
+func GetCheckpoints(ctx context.Context, database *mongo.Database) ([]dm.LedgerCheckpoint, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.LedgerCheckpointCollectionName).Find(queryCtx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query ledger checkpoints", err)
+	}
+
+	checkpoints := []dm.LedgerCheckpoint{}
+	if err = cursor.All(queryCtx, &checkpoints); err != nil {
+		return nil, errs.Wrap("cannot decode ledger checkpoints", err)
+	}
+	return checkpoints, nil
+}
+
+func getLastEntry(ctx context.Context, database *mongo.Database) (dm.LedgerEntry, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var entry dm.LedgerEntry
+	err := database.Collection(dm.LedgerEntryCollectionName).FindOne(queryCtx, bson.M{},
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entry, nil
+		}
+		return entry, errs.Wrap("error loading last ledger entry", err)
+	}
+	return entry, nil
+}
+
+func insertEntry(ctx context.Context, database *mongo.Database, entry dm.LedgerEntry) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.LedgerEntryCollectionName).InsertOne(queryCtx, entry)
+	return err
+}
+
+// insertMissingCheckpoints signs every entry up to lastSequence that is due for a checkpoint but has none yet
+func insertMissingCheckpoints(ctx context.Context, database *mongo.Database, config *dm.Config, lastSequence int64) error {
+	lastCheckpoint, err := getLastCheckpoint(ctx, database)
+	if err != nil {
+		return errs.Wrap("issue fetching last ledger checkpoint", err)
+	}
+
+	for sequence := lastCheckpoint.Sequence + dm.LedgerCheckpointInterval; sequence <= lastSequence; sequence += dm.LedgerCheckpointInterval {
+		entry, err := getEntry(ctx, database, sequence)
+		if err != nil {
+			return errs.Wrap("issue fetching ledger entry", err)
+		}
+		// concurrent appends may write the same checkpoint
+		if err = insertCheckpoint(ctx, database, config, entry); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return errs.Wrap("cannot insert ledger checkpoint", err)
+		}
+	}
+	return nil
+}
+
+func getLastCheckpoint(ctx context.Context, database *mongo.Database) (dm.LedgerCheckpoint, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var checkpoint dm.LedgerCheckpoint
+	err := database.Collection(dm.LedgerCheckpointCollectionName).FindOne(queryCtx, bson.M{},
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&checkpoint)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return checkpoint, nil
+		}
+		return checkpoint, errs.Wrap("error loading last ledger checkpoint", err)
+	}
+	return checkpoint, nil
+}
+
+func getEntry(ctx context.Context, database *mongo.Database, sequence int64) (dm.LedgerEntry, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var entry dm.LedgerEntry
+	if err := database.Collection(dm.LedgerEntryCollectionName).FindOne(queryCtx, bson.M{"_id": sequence}).Decode(&entry); err != nil {
+		return entry, errs.Wrap("error loading ledger entry", err)
+	}
+	return entry, nil
+}
+
+func insertCheckpoint(ctx context.Context, database *mongo.Database, config *dm.Config, entry dm.LedgerEntry) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	signingKey, err := ParseSigningKey(config)
+	if err != nil {
+		return errs.Wrap("issue parsing ledger signing key", err)
+	}
+
+	_, err = database.Collection(dm.LedgerCheckpointCollectionName).InsertOne(queryCtx, SignCheckpoint(signingKey, entry))
+	return err
+}
+
+// SignCheckpoint creates the checkpoint for the entry
+func SignCheckpoint(signingKey ed25519.PrivateKey, entry dm.LedgerEntry) dm.LedgerCheckpoint {
+	return dm.LedgerCheckpoint{
+		Sequence:  entry.Sequence,
+		Hash:      entry.Hash,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, checkpointMessage(entry.Sequence, entry.Hash))),
+		CreatedAt: time.Now(),
+	}
+}
+
+func checkpointMessage(sequence int64, hash string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", sequence, hash))
+}
@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"testing"
+	"time"
+	dm "user-manager/domain-model"
+)
+
+var testSigningKey = ed25519.NewKeyFromSeed([]byte("ledger-test-signing-key-seed-032"))
+
+// testChain appends count entries the way AppendEntry does and signs the due checkpoints
+func testChain(count int64) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+	entries := []dm.LedgerEntry{}
+	checkpoints := []dm.LedgerCheckpoint{}
+	previous := dm.LedgerEntry{}
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for sequence := int64(1); sequence <= count; sequence++ {
+		entry := dm.LedgerEntry{
+			Sequence:     sequence,
+			Type:         dm.LedgerEntryTypeCredentialsChange,
+			UserID:       primitive.NewObjectID(),
+			Details:      map[string]string{"reason": "password-change", "attempt": strconv.FormatInt(sequence, 10)},
+			CreatedAt:    createdAt.Add(time.Duration(sequence) * time.Second),
+			PreviousHash: previous.Hash,
+		}
+		entry.Hash = HashEntry(entry)
+		entries = append(entries, entry)
+		if sequence%dm.LedgerCheckpointInterval == 0 {
+			checkpoints = append(checkpoints, SignCheckpoint(testSigningKey, entry))
+		}
+		previous = entry
+	}
+	return entries, checkpoints
+}
+
+// rehashFrom recomputes the hashes from the entry with the sequence on, like someone without the signing key would
+func rehashFrom(entries []dm.LedgerEntry, sequence int64) {
+	for i := sequence - 1; i < int64(len(entries)); i++ {
+		if i > 0 {
+			entries[i].PreviousHash = entries[i-1].Hash
+		}
+		entries[i].Hash = HashEntry(entries[i])
+	}
+}
+
+func verify(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) (int64, int64, error) {
+	verifier, err := NewVerifier(testSigningKey.Public().(ed25519.PublicKey), checkpoints)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		if err = verifier.Check(entry); err != nil {
+			return 0, 0, err
+		}
+	}
+	return verifier.Finish()
+}
+
+func TestVerifierIntactChain(t *testing.T) {
+	entries, checkpoints := testChain(250)
+
+	count, unsigned, err := verify(entries, checkpoints)
+	if err != nil {
+		t.Fatalf("expected intact chain, got %v", err)
+	}
+	if count != 250 || unsigned != 50 {
+		t.Errorf("expected 250 entries with 50 unsigned, got %d with %d unsigned", count, unsigned)
+	}
+}
+
+func TestVerifierBrokenLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint)
+		expected int64
+	}{
+		{"edited details", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			entries[41].Details["reason"] = "rehash"
+			return entries, checkpoints
+		}, 42},
+		{"edited user", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			entries[219].UserID = primitive.NewObjectID()
+			return entries, checkpoints
+		}, 220},
+		{"edited entry with recomputed hash", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			entries[149].Type = dm.LedgerEntryTypeRoleGrant
+			entries[149].Hash = HashEntry(entries[149])
+			return entries, checkpoints
+		}, 151},
+		{"edited entry with recomputed chain", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			entries[149].Type = dm.LedgerEntryTypeRoleGrant
+			rehashFrom(entries, 150)
+			return entries, checkpoints
+		}, 200},
+		{"removed entry", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			return append(entries[:59], entries[60:]...), checkpoints
+		}, 60},
+		{"removed checkpoint", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			return entries, checkpoints[1:]
+		}, 100},
+	}
+
+	for _, test := range tests {
+		entries, checkpoints := test.tamper(testChain(250))
+
+		_, _, err := verify(entries, checkpoints)
+		var brokenLink BrokenLinkError
+		if !errors.As(err, &brokenLink) {
+			t.Errorf("%s: expected broken link, got %v", test.name, err)
+			continue
+		}
+		if brokenLink.Sequence != test.expected {
+			t.Errorf("%s: expected broken link at entry %d, got %v", test.name, test.expected, brokenLink)
+		}
+	}
+}
+
+func TestVerifierInvalidCheckpoint(t *testing.T) {
+	otherSigningKey := ed25519.NewKeyFromSeed([]byte("other-ledger-signing-key-seed-32"))
+
+	tests := []struct {
+		name   string
+		tamper func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint)
+	}{
+		{"bad signature", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			checkpoints[1].Signature = checkpoints[0].Signature
+			return entries, checkpoints
+		}},
+		{"malformed signature", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			checkpoints[0].Signature = "not base64"
+			return entries, checkpoints
+		}},
+		{"signed by other key", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			checkpoints[1] = SignCheckpoint(otherSigningKey, entries[199])
+			return entries, checkpoints
+		}},
+		{"signed hash changed", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			entries[199].Type = dm.LedgerEntryTypeRoleGrant
+			rehashFrom(entries, 200)
+			checkpoints[1].Hash = entries[199].Hash
+			return entries, checkpoints
+		}},
+		{"truncated after checkpoint", func(entries []dm.LedgerEntry, checkpoints []dm.LedgerCheckpoint) ([]dm.LedgerEntry, []dm.LedgerCheckpoint) {
+			return entries[:150], checkpoints
+		}},
+	}
+
+	for _, test := range tests {
+		entries, checkpoints := test.tamper(testChain(250))
+
+		_, _, err := verify(entries, checkpoints)
+		if err == nil {
+			t.Errorf("%s: expected verification to fail", test.name)
+			continue
+		}
+		if errors.As(err, &BrokenLinkError{}) {
+			t.Errorf("%s: expected invalid checkpoint, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+// BrokenLinkError names the first entry at which the chain does not hold
+type BrokenLinkError struct {
+	Sequence int64
+	Reason   string
+}
+
+func (e BrokenLinkError) Error() string {
+	return fmt.Sprintf("broken link at entry %d: %s", e.Sequence, e.Reason)
+}
+
+// Verifier checks the chain one entry at a time, so that the ledger does not have to be loaded at once. Entries have to
+// be passed to Check in chain order.
+type Verifier struct {
+	checkpoints            []dm.LedgerCheckpoint
+	checkpointsBySequence  map[int64]dm.LedgerCheckpoint
+	lastCheckpointSequence int64
+	previous               dm.LedgerEntry
+	lastSignedSequence     int64
+}
+
+// NewVerifier fails if any of the checkpoints is not signed by the key belonging to publicKey
+func NewVerifier(publicKey ed25519.PublicKey, checkpoints []dm.LedgerCheckpoint) (*Verifier, error) {
+	verifier := &Verifier{
+		checkpoints:           checkpoints,
+		checkpointsBySequence: make(map[int64]dm.LedgerCheckpoint, len(checkpoints)),
+	}
+	for _, checkpoint := range checkpoints {
+		if !VerifyCheckpoint(publicKey, checkpoint) {
+			return nil, errs.Errorf("checkpoint %d has an invalid signature", checkpoint.Sequence)
+		}
+		verifier.checkpointsBySequence[checkpoint.Sequence] = checkpoint
+		verifier.lastCheckpointSequence = max(verifier.lastCheckpointSequence, checkpoint.Sequence)
+	}
+	return verifier, nil
+}
+
+// Check returns a BrokenLinkError if the entry does not continue the chain. A checkpoint that is due after the last one
+// is written by the next append and is not treated as missing.
+func (v *Verifier) Check(entry dm.LedgerEntry) error {
+	previous := v.previous
+	if entry.Sequence != previous.Sequence+1 {
+		return BrokenLinkError{previous.Sequence + 1, fmt.Sprintf("entries %d to %d are missing", previous.Sequence+1, entry.Sequence-1)}
+	}
+	if entry.PreviousHash != previous.Hash {
+		return BrokenLinkError{entry.Sequence, fmt.Sprintf("previous hash does not match entry %d", previous.Sequence)}
+	}
+	if HashEntry(entry) != entry.Hash {
+		return BrokenLinkError{entry.Sequence, "content does not match its hash"}
+	}
+
+	checkpoint, ok := v.checkpointsBySequence[entry.Sequence]
+	if ok {
+		if checkpoint.Hash != entry.Hash {
+			return BrokenLinkError{entry.Sequence, "hash differs from signed checkpoint"}
+		}
+		v.lastSignedSequence = entry.Sequence
+	} else if entry.Sequence%dm.LedgerCheckpointInterval == 0 && entry.Sequence < v.lastCheckpointSequence {
+		return BrokenLinkError{entry.Sequence, "checkpoint is missing"}
+	}
+
+	v.previous = entry
+	return nil
+}
+
+// Finish is called after the last entry. It fails if entries signed by a checkpoint were cut off the end of the chain
+// and returns how many entries are only protected by the hash chain.
+func (v *Verifier) Finish() (entries int64, unsignedEntries int64, err error) {
+	for _, checkpoint := range v.checkpoints {
+		if checkpoint.Sequence > v.previous.Sequence {
+			return 0, 0, errs.Errorf("checkpoint %d exists but the ledger ends at entry %d", checkpoint.Sequence, v.previous.Sequence)
+		}
+	}
+	return v.previous.Sequence, v.previous.Sequence - v.lastSignedSequence, nil
+}
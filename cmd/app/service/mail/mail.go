@@ -3,20 +3,26 @@ package mail
 import (
 	"context"
 	"embed"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"html/template"
 	"strings"
+	"time"
 	dm "user-manager/domain-model"
 	"user-manager/util/db"
 	"user-manager/util/errs"
 )
 
 type TemplateData struct {
-	AppUrl      string
-	ServiceName string
-	Name        string
-	NewEmail    string
-	Token       string
+	AppUrl                 string
+	ServiceName            string
+	Name                   string
+	NewEmail               string
+	Token                  string
+	RemainingRecoveryCodes int
+	OrganizationName       string
+	InviterName            string
+	DeletionDate           string
 }
 
 const templatesPattern = "templates/*"
@@ -33,6 +39,13 @@ func init() {
 	emailChangeVerificationTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(emailChangeVerificationFS, templatesPattern))
 	emailChangeNotificationTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(emailChangeNotificationFS, templatesPattern))
 	passwordResetTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(passwordResetFS, templatesPattern))
+	magicLinkTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(magicLinkFS, templatesPattern))
+	recoveryCodeUsedTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(recoveryCodeUsedFS, templatesPattern))
+	adminInvitationTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(adminInvitationFS, templatesPattern))
+	organizationInvitationTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(organizationInvitationFS, templatesPattern))
+	accountLockedTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(accountLockedFS, templatesPattern))
+	accountDeletionScheduledTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(accountDeletionScheduledFS, templatesPattern))
+	provisionedEmailChangeTemplate = template.Must(template.Must(layoutTemplate.Clone()).ParseFS(provisionedEmailChangeFS, templatesPattern))
 }
 
 //go:embed templates/email-verification.tmpl
@@ -143,7 +156,7 @@ func SendChangeNotificationEmail(ctx context.Context, r *dm.RequestContext, emai
 var passwordResetFS embed.FS
 var passwordResetTemplate *template.Template
 
-func SendResetPasswordEmail(ctx context.Context, r *dm.RequestContext, email string, resetToken string) error {
+func SendResetPasswordEmail(ctx context.Context, r *dm.RequestContext, email string, name string, resetToken string) error {
 	config := r.Config
 
 	if err := enqueueBasicEmail(
@@ -153,7 +166,7 @@ func SendResetPasswordEmail(ctx context.Context, r *dm.RequestContext, email str
 		TemplateData{
 			AppUrl:      config.AppUrl,
 			ServiceName: config.ServiceName,
-			Name:        r.User.Name,
+			Name:        name,
 			Token:       resetToken,
 		},
 		config.EmailFrom,
@@ -165,6 +178,201 @@ func SendResetPasswordEmail(ctx context.Context, r *dm.RequestContext, email str
 	return nil
 }
 
+//go:embed templates/magic-link.tmpl
+var magicLinkFS embed.FS
+var magicLinkTemplate *template.Template
+
+func SendMagicLinkEmail(ctx context.Context, r *dm.RequestContext, email string, name string, loginToken string) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		magicLinkTemplate,
+		TemplateData{
+			AppUrl:      config.AppUrl,
+			ServiceName: config.ServiceName,
+			Name:        name,
+			Token:       loginToken,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/recovery-code-used.tmpl
+var recoveryCodeUsedFS embed.FS
+var recoveryCodeUsedTemplate *template.Template
+
+func SendRecoveryCodeUsedEmail(ctx context.Context, r *dm.RequestContext, email string, name string, remainingRecoveryCodes int) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		recoveryCodeUsedTemplate,
+		TemplateData{
+			AppUrl:                 config.AppUrl,
+			ServiceName:            config.ServiceName,
+			Name:                   name,
+			RemainingRecoveryCodes: remainingRecoveryCodes,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/admin-invitation.tmpl
+var adminInvitationFS embed.FS
+var adminInvitationTemplate *template.Template
+
+func SendAdminInvitationEmail(ctx context.Context, r *dm.RequestContext, email string, name string, invitationToken string) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		adminInvitationTemplate,
+		TemplateData{
+			AppUrl:      config.AppUrl,
+			ServiceName: config.ServiceName,
+			Name:        name,
+			Token:       invitationToken,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/organization-invitation.tmpl
+var organizationInvitationFS embed.FS
+var organizationInvitationTemplate *template.Template
+
+func SendOrganizationInvitationEmail(ctx context.Context, r *dm.RequestContext, email string, organizationName string, invitationToken string) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		organizationInvitationTemplate,
+		TemplateData{
+			AppUrl:           config.AppUrl,
+			ServiceName:      config.ServiceName,
+			OrganizationName: organizationName,
+			InviterName:      r.User.Name,
+			Token:            invitationToken,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/account-locked.tmpl
+var accountLockedFS embed.FS
+var accountLockedTemplate *template.Template
+
+func SendAccountLockedEmail(ctx context.Context, r *dm.RequestContext, email string, unlockToken string) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		accountLockedTemplate,
+		TemplateData{
+			AppUrl:      config.AppUrl,
+			ServiceName: config.ServiceName,
+			Token:       unlockToken,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/account-deletion-scheduled.tmpl
+var accountDeletionScheduledFS embed.FS
+var accountDeletionScheduledTemplate *template.Template
+
+func SendAccountDeletionScheduledEmail(ctx context.Context, r *dm.RequestContext, email string, name string, cancelToken string, deleteAt time.Time) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		accountDeletionScheduledTemplate,
+		TemplateData{
+			AppUrl:       config.AppUrl,
+			ServiceName:  config.ServiceName,
+			Name:         name,
+			Token:        cancelToken,
+			DeletionDate: deleteAt.UTC().Format("January 2, 2006 15:04 MST"),
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+//go:embed templates/provisioned-email-change.tmpl
+var provisionedEmailChangeFS embed.FS
+var provisionedEmailChangeTemplate *template.Template
+
+func SendProvisionedEmailChangeEmail(ctx context.Context, r *dm.RequestContext, email string, name string, newEmail string) error {
+	config := r.Config
+
+	if err := enqueueBasicEmail(
+		ctx,
+		r.Database,
+		provisionedEmailChangeTemplate,
+		TemplateData{
+			AppUrl:      config.AppUrl,
+			ServiceName: config.ServiceName,
+			Name:        name,
+			NewEmail:    newEmail,
+		},
+		config.EmailFrom,
+		email,
+		dm.MailQueuePrioHigh,
+	); err != nil {
+		return errs.Wrap("error enqueuing basic email", err)
+	}
+	return nil
+}
+
+// DeleteMailsForAddress removes all mails to the address from the queue, including the ones sent already
+func DeleteMailsForAddress(ctx context.Context, database *mongo.Database, email string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.MailQueueCollectionName).DeleteMany(queryCtx, bson.M{"to": email})
+	if err != nil {
+		return errs.Wrap("issue deleting emails from db", err)
+	}
+	return nil
+}
+
 func enqueueBasicEmail(
 	ctx context.Context,
 	database *mongo.Database,
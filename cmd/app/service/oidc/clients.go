@@ -0,0 +1,125 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+// CreateOidcIndexes sets up the indexes for client, grant and key lookups. Expired codes, refresh tokens and retired keys are removed by Mongo's TTL monitor.
+func CreateOidcIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.OidcClientCollectionName).Indexes().CreateOne(queryCtx,
+		mongo.IndexModel{Keys: bson.D{{Key: "clientID", Value: 1}}, Options: options.Index().SetUnique(true)})
+	if err != nil {
+		return errs.Wrap("cannot create oidc client indexes", err)
+	}
+
+	_, err = database.Collection(dm.OidcAuthorizationCodeCollectionName).Indexes().CreateOne(queryCtx,
+		mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)})
+	if err != nil {
+		return errs.Wrap("cannot create oidc authorization code indexes", err)
+	}
+
+	_, err = database.Collection(dm.OidcRefreshTokenCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "clientID", Value: 1}, {Key: "userID", Value: 1}}},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create oidc refresh token indexes", err)
+	}
+
+	_, err = database.Collection(dm.OidcConsentCollectionName).Indexes().CreateOne(queryCtx,
+		mongo.IndexModel{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "clientID", Value: 1}}, Options: options.Index().SetUnique(true)})
+	if err != nil {
+		return errs.Wrap("cannot create oidc consent indexes", err)
+	}
+
+	_, err = database.Collection(dm.OidcSigningKeyCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(dm.OidcKeyRetention.Seconds()))},
+		{Keys: bson.D{{Key: "rotationSlot", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create oidc signing key indexes", err)
+	}
+	return nil
+}
+
+// CreateClient registers a client with a random ID and secret. The secret is returned once, the database only stores its hash.
+func CreateClient(ctx context.Context, database *mongo.Database, config *dm.Config, name string, redirectURIs []string, createdBy dm.UserID) (dm.OidcClient, string, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	secret := random.MakeRandomURLSafeB64(30)
+	client := dm.OidcClient{
+		ObjectID:     primitive.NewObjectID(),
+		ClientID:     random.MakeRandomURLSafeB64(15),
+		Name:         name,
+		SecretHash:   auth.HashToken(config, secret),
+		RedirectURIs: redirectURIs,
+		CreatedBy:    primitive.ObjectID(createdBy),
+		CreatedAt:    time.Now(),
+	}
+	if _, err := database.Collection(dm.OidcClientCollectionName).InsertOne(queryCtx, client); err != nil {
+		return dm.OidcClient{}, "", errs.Wrap("cannot insert oidc client", err)
+	}
+	return client, secret, nil
+}
+
+func GetClients(ctx context.Context, database *mongo.Database) ([]dm.OidcClient, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.OidcClientCollectionName).Find(queryCtx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query oidc clients", err)
+	}
+	var clients []dm.OidcClient
+	if err = cursor.All(queryCtx, &clients); err != nil {
+		return nil, errs.Wrap("cannot decode oidc clients", err)
+	}
+	return clients, nil
+}
+
+func GetClient(ctx context.Context, database *mongo.Database, clientID string) (dm.OidcClient, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var client dm.OidcClient
+	err := database.Collection(dm.OidcClientCollectionName).FindOne(queryCtx, bson.M{"clientID": clientID}).Decode(&client)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return client, nil
+		}
+		return client, errs.Wrap("error loading oidc client", err)
+	}
+	return client, nil
+}
+
+// DeleteClient removes the client together with all consents and refresh tokens issued to it
+func DeleteClient(ctx context.Context, database *mongo.Database, clientID string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.OidcClientCollectionName).DeleteOne(queryCtx, bson.M{"clientID": clientID}); err != nil {
+		return errs.Wrap("cannot delete oidc client", err)
+	}
+	if _, err := database.Collection(dm.OidcConsentCollectionName).DeleteMany(queryCtx, bson.M{"clientID": clientID}); err != nil {
+		return errs.Wrap("cannot delete oidc consents", err)
+	}
+	if _, err := database.Collection(dm.OidcRefreshTokenCollectionName).DeleteMany(queryCtx, bson.M{"clientID": clientID}); err != nil {
+		return errs.Wrap("cannot delete oidc refresh tokens", err)
+	}
+	return nil
+}
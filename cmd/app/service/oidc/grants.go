@@ -0,0 +1,160 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+// IssueAuthorizationCode stores a code for the token endpoint and returns it. The database only stores its hash.
+func IssueAuthorizationCode(ctx context.Context, database *mongo.Database, config *dm.Config, code dm.OidcAuthorizationCode) (string, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	token := random.MakeRandomURLSafeB64(30)
+	code.CodeHash = auth.HashToken(config, token)
+	code.ExpiresAt = time.Now().Add(dm.OidcAuthorizationCodeDuration)
+	if _, err := database.Collection(dm.OidcAuthorizationCodeCollectionName).InsertOne(queryCtx, code); err != nil {
+		return "", errs.Wrap("cannot insert authorization code", err)
+	}
+	return token, nil
+}
+
+// RedeemAuthorizationCode deletes the code and returns it, so it cannot be redeemed twice. Expired codes are not returned.
+func RedeemAuthorizationCode(ctx context.Context, database *mongo.Database, config *dm.Config, token string) (dm.OidcAuthorizationCode, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var code dm.OidcAuthorizationCode
+	err := database.Collection(dm.OidcAuthorizationCodeCollectionName).FindOneAndDelete(queryCtx, bson.M{
+		"_id":       auth.HashToken(config, token),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&code)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return code, nil
+		}
+		return code, errs.Wrap("error redeeming authorization code", err)
+	}
+	return code, nil
+}
+
+// VerifyCodeChallenge checks the PKCE code verifier against the S256 challenge the code was issued for
+func VerifyCodeChallenge(codeChallenge string, codeVerifier string) bool {
+	if codeChallenge == "" || codeVerifier == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(hash[:])), []byte(codeChallenge)) == 1
+}
+
+// IssueRefreshToken stores a refresh token and returns it. The database only stores its hash.
+func IssueRefreshToken(ctx context.Context, database *mongo.Database, config *dm.Config, refreshToken dm.OidcRefreshToken) (string, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	token := random.MakeRandomURLSafeB64(30)
+	refreshToken.TokenHash = auth.HashToken(config, token)
+	refreshToken.ExpiresAt = time.Now().Add(dm.OidcRefreshTokenDuration)
+	if _, err := database.Collection(dm.OidcRefreshTokenCollectionName).InsertOne(queryCtx, refreshToken); err != nil {
+		return "", errs.Wrap("cannot insert refresh token", err)
+	}
+	return token, nil
+}
+
+// RedeemRefreshToken deletes the refresh token of the client and returns it. The caller issues a new one, so refresh tokens are rotated on every use.
+func RedeemRefreshToken(ctx context.Context, database *mongo.Database, config *dm.Config, clientID string, token string) (dm.OidcRefreshToken, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var refreshToken dm.OidcRefreshToken
+	err := database.Collection(dm.OidcRefreshTokenCollectionName).FindOneAndDelete(queryCtx, bson.M{
+		"_id":       auth.HashToken(config, token),
+		"clientID":  clientID,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&refreshToken)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return refreshToken, nil
+		}
+		return refreshToken, errs.Wrap("error redeeming refresh token", err)
+	}
+	return refreshToken, nil
+}
+
+// RevokeRefreshToken deletes the refresh token if it was issued to the client. Unknown tokens are ignored.
+func RevokeRefreshToken(ctx context.Context, database *mongo.Database, config *dm.Config, clientID string, token string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.OidcRefreshTokenCollectionName).DeleteOne(queryCtx, bson.M{
+		"_id":      auth.HashToken(config, token),
+		"clientID": clientID,
+	})
+	if err != nil {
+		return errs.Wrap("cannot delete refresh token", err)
+	}
+	return nil
+}
+
+// DeleteGrantsForUser removes the consents and refresh tokens of the user for all clients
+func DeleteGrantsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.OidcConsentCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)}); err != nil {
+		return errs.Wrap("cannot delete oidc consents", err)
+	}
+	if _, err := database.Collection(dm.OidcRefreshTokenCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)}); err != nil {
+		return errs.Wrap("cannot delete oidc refresh tokens", err)
+	}
+	return nil
+}
+
+func GetConsent(ctx context.Context, database *mongo.Database, userID dm.UserID, clientID string) (dm.OidcConsent, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var consent dm.OidcConsent
+	err := database.Collection(dm.OidcConsentCollectionName).FindOne(queryCtx, bson.M{
+		"userID":   primitive.ObjectID(userID),
+		"clientID": clientID,
+	}).Decode(&consent)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return consent, nil
+		}
+		return consent, errs.Wrap("error loading consent", err)
+	}
+	return consent, nil
+}
+
+// GrantConsent adds the scopes to the ones the user previously granted to the client
+func GrantConsent(ctx context.Context, database *mongo.Database, userID dm.UserID, clientID string, scopes []dm.OidcScope) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.OidcConsentCollectionName).UpdateOne(queryCtx,
+		bson.M{"userID": primitive.ObjectID(userID), "clientID": clientID},
+		bson.M{
+			"$addToSet": bson.M{"scopes": bson.M{"$each": scopes}},
+			"$set":      bson.M{"grantedAt": time.Now()},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return errs.Wrap("cannot grant consent", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+const signingKeyBits = 2048
+
+// signingKeyEncryptionLabel separates the key derived from OidcKeyEncryptionSecret from other uses of the secret
+const signingKeyEncryptionLabel = "oidc-signing-key-encryption"
+
+type PublicKey struct {
+	KeyID string
+	Key   *rsa.PublicKey
+}
+
+// GetSigningKey returns the key tokens are currently signed with.
+// A key is created at the start of every rotation interval and takes over OidcKeyPublishDelay later, so it is already published when the first tokens are signed with it.
+func GetSigningKey(ctx context.Context, database *mongo.Database, config *dm.Config) (string, *rsa.PrivateKey, error) {
+	keys, err := getPublishedKeys(ctx, database)
+	if err != nil {
+		return "", nil, errs.Wrap("issue loading signing keys", err)
+	}
+
+	now := time.Now()
+	slot := now.Unix() / int64(dm.OidcKeyRotationInterval.Seconds())
+	if len(keys) == 0 || keys[0].RotationSlot < slot {
+		// a concurrent request may have created the key of the slot first, so the keys are loaded again either way
+		if err = createSigningKey(ctx, database, config, slot); err != nil {
+			return "", nil, errs.Wrap("issue creating signing key", err)
+		}
+		keys, err = getPublishedKeys(ctx, database)
+		if err != nil {
+			return "", nil, errs.Wrap("issue loading signing keys", err)
+		}
+		if len(keys) == 0 {
+			return "", nil, errs.Error("no signing key found after creating one")
+		}
+	}
+
+	// Keys are sorted newest first. Without a published key yet (initial setup) the newest one is used right away.
+	signingKey := keys[0]
+	for _, key := range keys {
+		if key.CreatedAt.Before(now.Add(-dm.OidcKeyPublishDelay)) {
+			signingKey = key
+			break
+		}
+	}
+
+	privateKey, err := decryptSigningKey(config, signingKey)
+	if err != nil {
+		return "", nil, errs.Wrap("cannot decrypt signing key", err)
+	}
+	return signingKey.KeyID, privateKey, nil
+}
+
+// GetPublicKeys returns the keys relying parties may see tokens signed with
+func GetPublicKeys(ctx context.Context, database *mongo.Database, config *dm.Config) ([]PublicKey, error) {
+	keys, err := getPublishedKeys(ctx, database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading signing keys", err)
+	}
+
+	publicKeys := make([]PublicKey, 0, len(keys))
+	for _, key := range keys {
+		privateKey, err := decryptSigningKey(config, key)
+		if err != nil {
+			return nil, errs.Wrap("cannot decrypt signing key", err)
+		}
+		publicKeys = append(publicKeys, PublicKey{KeyID: key.KeyID, Key: &privateKey.PublicKey})
+	}
+	return publicKeys, nil
+}
+
+// getPublishedKeys returns the keys within their retention period, newest first. Mongo's TTL monitor may lag behind, so the retention is checked here as well.
+func getPublishedKeys(ctx context.Context, database *mongo.Database) ([]dm.OidcSigningKey, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.OidcSigningKeyCollectionName).Find(queryCtx,
+		bson.M{"createdAt": bson.M{"$gt": time.Now().Add(-dm.OidcKeyRetention)}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query signing keys", err)
+	}
+	var keys []dm.OidcSigningKey
+	if err = cursor.All(queryCtx, &keys); err != nil {
+		return nil, errs.Wrap("cannot decode signing keys", err)
+	}
+	return keys, nil
+}
+
+// createSigningKey inserts the key of the rotation slot unless it already exists
+func createSigningKey(ctx context.Context, database *mongo.Database, config *dm.Config, slot int64) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return errs.Wrap("cannot generate rsa key", err)
+	}
+	keyID := random.MakeRandomURLSafeB64(12)
+	encryptedPrivateKey, err := EncryptSigningKey(config, keyID, x509.MarshalPKCS1PrivateKey(privateKey))
+	if err != nil {
+		return errs.Wrap("cannot encrypt signing key", err)
+	}
+	key := dm.OidcSigningKey{
+		KeyID:               keyID,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		CreatedAt:           time.Now(),
+		RotationSlot:        slot,
+	}
+	if _, err = database.Collection(dm.OidcSigningKeyCollectionName).InsertOne(queryCtx, key); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return errs.Wrap("cannot insert signing key", err)
+	}
+	return nil
+}
+
+// EncryptSigningKey seals the PKCS #1 encoded private key with AES-GCM. The key ID is authenticated as well, so an
+// encrypted key cannot be moved to another key document.
+func EncryptSigningKey(config *dm.Config, keyID string, privateKey []byte) ([]byte, error) {
+	aead, err := signingKeyCipher(config)
+	if err != nil {
+		return nil, errs.Wrap("cannot create cipher", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, errs.Wrap("cannot generate nonce", err)
+	}
+	return aead.Seal(nonce, nonce, privateKey, []byte(keyID)), nil
+}
+
+func decryptSigningKey(config *dm.Config, key dm.OidcSigningKey) (*rsa.PrivateKey, error) {
+	aead, err := signingKeyCipher(config)
+	if err != nil {
+		return nil, errs.Wrap("cannot create cipher", err)
+	}
+	if len(key.EncryptedPrivateKey) < aead.NonceSize() {
+		return nil, errs.Error("encrypted signing key is too short")
+	}
+	nonce, ciphertext := key.EncryptedPrivateKey[:aead.NonceSize()], key.EncryptedPrivateKey[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key.KeyID))
+	if err != nil {
+		return nil, errs.Wrap("cannot open encrypted signing key", err)
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(plaintext)
+	if err != nil {
+		return nil, errs.Wrap("cannot parse signing key", err)
+	}
+	return privateKey, nil
+}
+
+// signingKeyCipher derives the AES-256 key from the configured secret with an HMAC, like tokens are hashed with
+// TokenHashSecret
+func signingKeyCipher(config *dm.Config) (cipher.AEAD, error) {
+	if config.OidcKeyEncryptionSecret == "" {
+		return nil, errs.Error("oidc key encryption secret is not configured")
+	}
+	mac := hmac.New(sha256.New, []byte(config.OidcKeyEncryptionSecret))
+	mac.Write([]byte(signingKeyEncryptionLabel))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, errs.Wrap("cannot create aes cipher", err)
+	}
+	return cipher.NewGCM(block)
+}
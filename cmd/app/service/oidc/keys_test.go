@@ -0,0 +1,51 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	dm "user-manager/domain-model"
+)
+
+func TestSigningKeyEncryption(t *testing.T) {
+	config := &dm.Config{OidcKeyEncryptionSecret: "test-oidc-key-encryption-secret"}
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("expected rsa key, got %v", err)
+	}
+	encoded := x509.MarshalPKCS1PrivateKey(privateKey)
+
+	encrypted, err := EncryptSigningKey(config, "key-1", encoded)
+	if err != nil {
+		t.Fatalf("expected encrypted key, got %v", err)
+	}
+	decrypted, err := decryptSigningKey(config, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: encrypted})
+	if err != nil {
+		t.Fatalf("expected decrypted key, got %v", err)
+	}
+	if !decrypted.Equal(privateKey) {
+		t.Errorf("expected decrypted key to equal the original key")
+	}
+
+	tamperedKey := append([]byte{}, encrypted...)
+	tamperedKey[len(tamperedKey)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		config *dm.Config
+		key    dm.OidcSigningKey
+	}{
+		{"plain text", config, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: encoded}},
+		{"other secret", &dm.Config{OidcKeyEncryptionSecret: "other-secret"}, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: encrypted}},
+		{"no secret", &dm.Config{}, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: encrypted}},
+		{"other key ID", config, dm.OidcSigningKey{KeyID: "key-2", EncryptedPrivateKey: encrypted}},
+		{"tampered", config, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: tamperedKey}},
+		{"too short", config, dm.OidcSigningKey{KeyID: "key-1", EncryptedPrivateKey: encrypted[:4]}},
+	}
+	for _, test := range tests {
+		if _, err = decryptSigningKey(test.config, test.key); err == nil {
+			t.Errorf("%s: expected decryption to fail", test.name)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+	"user-manager/util/slices"
+)
+
+// accessTokenType distinguishes access tokens from ID tokens (RFC 9068), so an ID token cannot be used as bearer token
+const accessTokenType = "at+jwt"
+
+// ProfileClaims are the user claims released according to the granted scopes, both in the ID token and by the userinfo endpoint
+type ProfileClaims struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	ProfileClaims
+	AuthTime *jwt.NumericDate `json:"auth_time"`
+	Nonce    string           `json:"nonce,omitempty"`
+}
+
+type AccessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+func (c AccessTokenClaims) Scopes() []dm.OidcScope {
+	scopes, _ := ParseScopes(c.Scope)
+	return scopes
+}
+
+// Issuer is the identifier relying parties expect in the iss claim and derive the discovery document location from
+func Issuer(config *dm.Config) string {
+	return strings.TrimSuffix(config.AppUrl, "/")
+}
+
+// ParseScopes returns the supported scopes of a space-delimited scope parameter. Unknown scopes are ignored, as allowed by RFC 6749.
+// The result reports whether the openid scope was requested.
+func ParseScopes(scope string) ([]dm.OidcScope, bool) {
+	var scopes []dm.OidcScope
+	for _, value := range strings.Fields(scope) {
+		scope := dm.OidcScope(value)
+		if slices.Contains(dm.OidcScopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, slices.Contains(scopes, dm.OidcScopeOpenID)
+}
+
+func FormatScopes(scopes []dm.OidcScope) string {
+	values := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		values = append(values, string(scope))
+	}
+	return strings.Join(values, " ")
+}
+
+func MakeProfileClaims(user dm.User, scopes []dm.OidcScope) ProfileClaims {
+	var claims ProfileClaims
+	if slices.Contains(scopes, dm.OidcScopeEmail) {
+		emailVerified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &emailVerified
+	}
+	if slices.Contains(scopes, dm.OidcScopeProfile) {
+		claims.Name = user.Name
+	}
+	return claims
+}
+
+func MakeIDToken(ctx context.Context, database *mongo.Database, config *dm.Config, clientID string, user dm.User, scopes []dm.OidcScope, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(config),
+			Subject:   user.IDHex(),
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(dm.OidcIDTokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		ProfileClaims: MakeProfileClaims(user, scopes),
+		AuthTime:      jwt.NewNumericDate(authTime),
+		Nonce:         nonce,
+	}
+	return signToken(ctx, database, config, jwt.NewWithClaims(jwt.SigningMethodRS256, claims))
+}
+
+func MakeAccessToken(ctx context.Context, database *mongo.Database, config *dm.Config, clientID string, userID dm.UserID, scopes []dm.OidcScope) (string, error) {
+	now := time.Now()
+	claims := AccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(config),
+			Subject:   primitive.ObjectID(userID).Hex(),
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(dm.OidcAccessTokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        random.MakeRandomURLSafeB64(15),
+		},
+		ClientID: clientID,
+		Scope:    FormatScopes(scopes),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["typ"] = accessTokenType
+	return signToken(ctx, database, config, token)
+}
+
+func signToken(ctx context.Context, database *mongo.Database, config *dm.Config, token *jwt.Token) (string, error) {
+	keyID, privateKey, err := GetSigningKey(ctx, database, config)
+	if err != nil {
+		return "", errs.Wrap("issue getting signing key", err)
+	}
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", errs.Wrap("cannot sign token", err)
+	}
+	return signed, nil
+}
+
+// ParseAccessToken verifies signature, issuer, type and expiry of the access token. Invalid tokens are reported by the second result.
+func ParseAccessToken(ctx context.Context, database *mongo.Database, config *dm.Config, token string) (AccessTokenClaims, bool, error) {
+	publicKeys, err := GetPublicKeys(ctx, database, config)
+	if err != nil {
+		return AccessTokenClaims{}, false, errs.Wrap("issue loading public keys", err)
+	}
+
+	var claims AccessTokenClaims
+	_, err = jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != accessTokenType {
+			return nil, errs.Error("not an access token")
+		}
+		for _, publicKey := range publicKeys {
+			if token.Header["kid"] == publicKey.KeyID {
+				return publicKey.Key, nil
+			}
+		}
+		return nil, errs.Error("unknown signing key")
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(Issuer(config)), jwt.WithExpirationRequired())
+	if err != nil {
+		return AccessTokenClaims{}, false, nil
+	}
+	return claims, true, nil
+}
@@ -0,0 +1,310 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+// CreateOrganizationIndexes sets up the indexes for membership and invitation lookups. Expired invitations are removed by Mongo's TTL monitor.
+func CreateOrganizationIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.MembershipCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "organizationID", Value: 1}, {Key: "userID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create membership indexes", err)
+	}
+
+	_, err = database.Collection(dm.OrganizationInvitationCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "organizationID", Value: 1}}},
+		{Keys: bson.D{{Key: "validUntil", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create organization invitation indexes", err)
+	}
+	return nil
+}
+
+// CreateOrganization inserts the organization and makes the user its owner
+func CreateOrganization(ctx context.Context, database *mongo.Database, name string, ownerID dm.UserID) (dm.Organization, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	organization := dm.Organization{
+		ObjectID:  primitive.NewObjectID(),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	if _, err := database.Collection(dm.OrganizationCollectionName).InsertOne(queryCtx, organization); err != nil {
+		return dm.Organization{}, errs.Wrap("cannot insert organization", err)
+	}
+
+	if err := insertMembership(queryCtx, database, organization.ID(), ownerID, dm.OrganizationRoleOwner); err != nil {
+		return dm.Organization{}, errs.Wrap("cannot insert owner membership", err)
+	}
+	return organization, nil
+}
+
+func GetOrganization(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID) (dm.Organization, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var organization dm.Organization
+	err := database.Collection(dm.OrganizationCollectionName).FindOne(queryCtx, bson.M{"_id": primitive.ObjectID(organizationID)}).Decode(&organization)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return organization, nil
+		}
+		return organization, errs.Wrap("error loading organization", err)
+	}
+	return organization, nil
+}
+
+func GetMembership(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID, userID dm.UserID) (dm.Membership, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var membership dm.Membership
+	err := database.Collection(dm.MembershipCollectionName).FindOne(queryCtx, bson.M{
+		"organizationID": primitive.ObjectID(organizationID),
+		"userID":         primitive.ObjectID(userID),
+	}).Decode(&membership)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return membership, nil
+		}
+		return membership, errs.Wrap("error loading membership", err)
+	}
+	return membership, nil
+}
+
+// GetOrganizationsForUser returns all organizations the user is a member of, sorted by name
+func GetOrganizationsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) ([]dm.OrganizationMembership, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.MembershipCollectionName).Find(queryCtx, bson.M{"userID": primitive.ObjectID(userID)})
+	if err != nil {
+		return nil, errs.Wrap("cannot query memberships", err)
+	}
+	var memberships []dm.Membership
+	if err = cursor.All(queryCtx, &memberships); err != nil {
+		return nil, errs.Wrap("cannot decode memberships", err)
+	}
+
+	roles := make(map[primitive.ObjectID]dm.OrganizationRole, len(memberships))
+	organizationIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.OrganizationID] = membership.Role
+		organizationIDs = append(organizationIDs, membership.OrganizationID)
+	}
+
+	cursor, err = database.Collection(dm.OrganizationCollectionName).Find(queryCtx,
+		bson.M{"_id": bson.M{"$in": organizationIDs}},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query organizations", err)
+	}
+	var organizations []dm.Organization
+	if err = cursor.All(queryCtx, &organizations); err != nil {
+		return nil, errs.Wrap("cannot decode organizations", err)
+	}
+
+	result := make([]dm.OrganizationMembership, 0, len(organizations))
+	for _, organization := range organizations {
+		result = append(result, dm.OrganizationMembership{Organization: organization, Role: roles[organization.ObjectID]})
+	}
+	return result, nil
+}
+
+// GetMembers returns the members of the organization together with their user documents, sorted by email
+func GetMembers(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID) ([]dm.OrganizationMember, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.MembershipCollectionName).Find(queryCtx, bson.M{"organizationID": primitive.ObjectID(organizationID)})
+	if err != nil {
+		return nil, errs.Wrap("cannot query memberships", err)
+	}
+	var memberships []dm.Membership
+	if err = cursor.All(queryCtx, &memberships); err != nil {
+		return nil, errs.Wrap("cannot decode memberships", err)
+	}
+
+	membershipsByUser := make(map[primitive.ObjectID]dm.Membership, len(memberships))
+	userIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		membershipsByUser[membership.UserID] = membership
+		userIDs = append(userIDs, membership.UserID)
+	}
+
+	cursor, err = database.Collection(dm.UserCollectionName).Find(queryCtx,
+		bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query members", err)
+	}
+	var users []dm.User
+	if err = cursor.All(queryCtx, &users); err != nil {
+		return nil, errs.Wrap("cannot decode members", err)
+	}
+
+	result := make([]dm.OrganizationMember, 0, len(users))
+	for _, user := range users {
+		result = append(result, dm.OrganizationMember{User: user, Membership: membershipsByUser[user.ObjectID]})
+	}
+	return result, nil
+}
+
+func CountMembersWithRole(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID, role dm.OrganizationRole) (int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	count, err := database.Collection(dm.MembershipCollectionName).CountDocuments(queryCtx, bson.M{"organizationID": primitive.ObjectID(organizationID), "role": role})
+	if err != nil {
+		return 0, errs.Wrap("cannot count members with role", err)
+	}
+	return count, nil
+}
+
+func RemoveMembership(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.MembershipCollectionName).DeleteOne(queryCtx, bson.M{
+		"organizationID": primitive.ObjectID(organizationID),
+		"userID":         primitive.ObjectID(userID),
+	})
+	if err != nil {
+		return errs.Wrap("cannot remove membership", err)
+	}
+	return nil
+}
+
+// DeleteMembershipsForUser removes the user from all organizations
+func DeleteMembershipsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.MembershipCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)})
+	if err != nil {
+		return errs.Wrap("cannot delete memberships", err)
+	}
+	return nil
+}
+
+// DeleteInvitationsForUser removes the invitations addressed to the email and the ones the user sent
+func DeleteInvitationsForUser(ctx context.Context, database *mongo.Database, userID dm.UserID, email string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.OrganizationInvitationCollectionName).DeleteMany(queryCtx, bson.M{"$or": bson.A{
+		bson.M{"email": email},
+		bson.M{"invitedBy": primitive.ObjectID(userID)},
+	}})
+	if err != nil {
+		return errs.Wrap("cannot delete organization invitations", err)
+	}
+	return nil
+}
+
+func InsertInvitation(ctx context.Context, database *mongo.Database, invitation dm.OrganizationInvitation) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.OrganizationInvitationCollectionName).InsertOne(queryCtx, invitation); err != nil {
+		return errs.Wrap("cannot insert organization invitation", err)
+	}
+	return nil
+}
+
+func GetInvitationsForOrganization(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID) ([]dm.OrganizationInvitation, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.OrganizationInvitationCollectionName).Find(queryCtx,
+		bson.M{"organizationID": primitive.ObjectID(organizationID), "validUntil": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query organization invitations", err)
+	}
+
+	invitations := []dm.OrganizationInvitation{}
+	if err = cursor.All(queryCtx, &invitations); err != nil {
+		return nil, errs.Wrap("cannot decode organization invitations", err)
+	}
+	return invitations, nil
+}
+
+func GetInvitationForTokenHash(ctx context.Context, database *mongo.Database, tokenHash string) (dm.OrganizationInvitation, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var invitation dm.OrganizationInvitation
+	err := database.Collection(dm.OrganizationInvitationCollectionName).FindOne(queryCtx, bson.M{
+		"tokenHash":  tokenHash,
+		"validUntil": bson.M{"$gt": time.Now()},
+	}).Decode(&invitation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return invitation, nil
+		}
+		return invitation, errs.Wrap("error loading organization invitation", err)
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation turns the invitation into a membership of the user. Existing memberships keep their role.
+func AcceptInvitation(ctx context.Context, database *mongo.Database, invitation dm.OrganizationInvitation, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.MembershipCollectionName).UpdateOne(queryCtx,
+		bson.M{"organizationID": invitation.OrganizationID, "userID": primitive.ObjectID(userID)},
+		bson.M{"$setOnInsert": bson.M{"role": invitation.Role, "createdAt": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return errs.Wrap("cannot insert membership", err)
+	}
+
+	if _, err = database.Collection(dm.OrganizationInvitationCollectionName).DeleteOne(queryCtx, bson.M{"_id": invitation.ObjectID}); err != nil {
+		return errs.Wrap("cannot delete organization invitation", err)
+	}
+	return nil
+}
+
+func DeleteInvitation(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID, invitationID primitive.ObjectID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.OrganizationInvitationCollectionName).DeleteOne(queryCtx, bson.M{"_id": invitationID, "organizationID": primitive.ObjectID(organizationID)})
+	if err != nil {
+		return errs.Wrap("cannot delete organization invitation", err)
+	}
+	return nil
+}
+
+func insertMembership(ctx context.Context, database *mongo.Database, organizationID dm.OrganizationID, userID dm.UserID, role dm.OrganizationRole) error {
+	_, err := database.Collection(dm.MembershipCollectionName).InsertOne(ctx, dm.Membership{
+		OrganizationID: primitive.ObjectID(organizationID),
+		UserID:         primitive.ObjectID(userID),
+		Role:           role,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		return errs.Wrap("cannot insert membership", err)
+	}
+	return nil
+}
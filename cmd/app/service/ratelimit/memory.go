@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+	dm "user-manager/domain-model"
+)
+
+// pruneEvery is the number of new buckets after which full buckets are dropped from memory
+const pruneEvery = 1000
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+type MemoryStore struct {
+	mutex      sync.Mutex
+	buckets    map[string]*memoryBucket
+	newBuckets int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit dm.RateLimit) (bool, time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Capacity), updatedAt: now}
+		s.buckets[key] = bucket
+		s.newBuckets++
+		if s.newBuckets >= pruneEvery {
+			s.prune(now)
+		}
+	}
+
+	bucket.tokens = limit.Refill(bucket.tokens, now.Sub(bucket.updatedAt))
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.fullAt = now.Add(time.Duration((float64(limit.Capacity) - bucket.tokens) * float64(limit.RefillInterval)))
+
+	if !allowed {
+		return false, limit.TimeUntilToken(bucket.tokens), nil
+	}
+	return true, 0, nil
+}
+
+// prune drops buckets that refilled completely, they behave exactly like new ones
+func (s *MemoryStore) prune(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !bucket.fullAt.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.newBuckets = 0
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+	dm "user-manager/domain-model"
+)
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	store := NewMemoryStore()
+	limit := dm.RateLimit{Name: "test", Capacity: 3, RefillInterval: time.Hour}
+
+	for i := 0; i < limit.Capacity; i++ {
+		allowed, retryAfter, err := store.Take(context.Background(), "key", limit)
+		if err != nil || !allowed || retryAfter != 0 {
+			t.Fatalf("request %d: expected to be allowed, got %t, %s, %v", i+1, allowed, retryAfter, err)
+		}
+	}
+
+	allowed, retryAfter, err := store.Take(context.Background(), "key", limit)
+	if err != nil || allowed {
+		t.Fatalf("expected request beyond capacity to be refused, got %t, %v", allowed, err)
+	}
+	if retryAfter <= 59*time.Minute || retryAfter > time.Hour {
+		t.Errorf("expected retry after about %s, got %s", limit.RefillInterval, retryAfter)
+	}
+
+	allowed, _, err = store.Take(context.Background(), "other-key", limit)
+	if err != nil || !allowed {
+		t.Errorf("expected other key to have its own bucket, got %t, %v", allowed, err)
+	}
+	allowed, _, err = store.Take(context.Background(), "key", limit)
+	if err != nil || allowed {
+		t.Errorf("expected bucket to stay empty, got %t, %v", allowed, err)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store := NewMemoryStore()
+	limit := dm.RateLimit{Name: "test", Capacity: 2, RefillInterval: 100 * time.Millisecond}
+
+	for i := 0; i < limit.Capacity; i++ {
+		if allowed, _, _ := store.Take(context.Background(), "key", limit); !allowed {
+			t.Fatalf("request %d: expected to be allowed", i+1)
+		}
+	}
+
+	allowed, retryAfter, _ := store.Take(context.Background(), "key", limit)
+	if allowed {
+		t.Fatalf("expected empty bucket to refuse the request")
+	}
+	if retryAfter <= 0 || retryAfter > limit.RefillInterval {
+		t.Errorf("expected retry after at most %s, got %s", limit.RefillInterval, retryAfter)
+	}
+
+	time.Sleep(retryAfter)
+	allowed, retryAfter, _ = store.Take(context.Background(), "key", limit)
+	if !allowed || retryAfter != 0 {
+		t.Errorf("expected request after retry after to be allowed, got %t, %s", allowed, retryAfter)
+	}
+	if allowed, _, _ = store.Take(context.Background(), "key", limit); allowed {
+		t.Errorf("expected a single token to be refilled")
+	}
+
+	// The bucket does not fill up beyond its capacity
+	time.Sleep(time.Duration(limit.Capacity+2) * limit.RefillInterval)
+	for i := 0; i < limit.Capacity; i++ {
+		if allowed, _, _ = store.Take(context.Background(), "key", limit); !allowed {
+			t.Fatalf("request %d after refill: expected to be allowed", i+1)
+		}
+	}
+	if allowed, _, _ = store.Take(context.Background(), "key", limit); allowed {
+		t.Errorf("expected refilled bucket to hold no more than its capacity")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	limit := dm.RateLimit{Name: "test", Capacity: 5, RefillInterval: 10 * time.Second}
+
+	tests := []struct {
+		name     string
+		tokens   float64
+		expected time.Duration
+	}{
+		{"empty bucket", 0, 10 * time.Second},
+		{"almost a token", 0.75, 2500 * time.Millisecond},
+		{"half a token", 0.5, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		actual := limit.TimeUntilToken(test.tokens)
+		if actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+type MongoStore struct {
+	database *mongo.Database
+}
+
+func NewMongoStore(ctx context.Context, database *mongo.Database) (*MongoStore, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.RateLimitBucketCollectionName).Indexes().CreateOne(queryCtx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, errs.Wrap("cannot create rate limit bucket indexes", err)
+	}
+	return &MongoStore{database: database}, nil
+}
+
+// Take refills and takes from the bucket in a single update, so that concurrent requests on different replicas cannot
+// take the same token
+func (s *MongoStore) Take(ctx context.Context, key string, limit dm.RateLimit) (bool, time.Duration, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	capacity := float64(limit.Capacity)
+	// subtracting dates yields milliseconds
+	elapsed := bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updatedAt", now}}}}
+	refilled := bson.M{"$min": bson.A{capacity, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+		bson.M{"$divide": bson.A{elapsed, float64(limit.RefillInterval.Milliseconds())}},
+	}}}}
+
+	var bucket dm.RateLimitBucket
+	err := s.database.Collection(dm.RateLimitBucketCollectionName).FindOneAndUpdate(queryCtx,
+		bson.M{"_id": key},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"tokens": refilled, "updatedAt": now}}},
+			{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+			{{Key: "$set", Value: bson.M{
+				"tokens":    bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+				"expiresAt": now.Add(limit.FullAfter()),
+			}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&bucket)
+	if err != nil {
+		return false, 0, errs.Wrap("cannot take rate limit token", err)
+	}
+
+	if !bucket.Allowed {
+		return false, limit.TimeUntilToken(bucket.Tokens), nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+const (
+	StoreTypeMemory = "memory"
+	StoreTypeMongo  = "mongo"
+)
+
+// Store keeps the token buckets. Use the memory store for a single app instance and the Mongo store when several
+// replicas have to share their limits.
+type Store interface {
+	// Take removes a token from the bucket of the key. If the bucket is empty, it returns false and the time until the
+	// next token is available.
+	Take(ctx context.Context, key string, limit dm.RateLimit) (bool, time.Duration, error)
+}
+
+func NewStore(ctx context.Context, config *dm.Config, database *mongo.Database) (Store, error) {
+	switch config.RateLimitStore {
+	case StoreTypeMemory:
+		return NewMemoryStore(), nil
+	case StoreTypeMongo:
+		store, err := NewMongoStore(ctx, database)
+		if err != nil {
+			return nil, errs.Wrap("cannot create mongo store", err)
+		}
+		return store, nil
+	}
+	return nil, errs.Errorf("unknown rate limit store %s", config.RateLimitStore)
+}
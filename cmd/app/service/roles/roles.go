@@ -0,0 +1,86 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+func GetRoles(ctx context.Context, database *mongo.Database) ([]dm.Role, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.RoleCollectionName).Find(queryCtx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query roles", err)
+	}
+
+	result := []dm.Role{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, errs.Wrap("cannot decode roles", err)
+	}
+	return result, nil
+}
+
+func GetRole(ctx context.Context, database *mongo.Database, name dm.UserRole) (dm.Role, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var role dm.Role
+	err := database.Collection(dm.RoleCollectionName).FindOne(queryCtx, bson.M{"_id": name}).Decode(&role)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return role, nil
+		}
+		return role, errs.Wrap("error loading role", err)
+	}
+	return role, nil
+}
+
+// GetPermissionsForRoles resolves the permissions granted by the roles, including inherited ones
+func GetPermissionsForRoles(ctx context.Context, database *mongo.Database, userRoles []dm.UserRole) (dm.PermissionSet, error) {
+	if len(userRoles) == 0 {
+		return dm.PermissionSet{}, nil
+	}
+
+	definitions, err := GetRoles(ctx, database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading role definitions", err)
+	}
+	return dm.ResolvePermissions(MapRoles(definitions), userRoles), nil
+}
+
+func MapRoles(definitions []dm.Role) map[dm.UserRole]dm.Role {
+	result := make(map[dm.UserRole]dm.Role, len(definitions))
+	for _, definition := range definitions {
+		result[definition.Name] = definition
+	}
+	return result
+}
+
+func SaveRole(ctx context.Context, database *mongo.Database, role dm.Role) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.RoleCollectionName).ReplaceOne(queryCtx, bson.M{"_id": role.Name}, role, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errs.Wrap("cannot save role", err)
+	}
+	return nil
+}
+
+func DeleteRole(ctx context.Context, database *mongo.Database, name dm.UserRole) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.RoleCollectionName).DeleteOne(queryCtx, bson.M{"_id": name, "builtin": bson.M{"$ne": true}})
+	if err != nil {
+		return errs.Wrap("cannot delete role", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"slices"
+	"strings"
+	"user-manager/cmd/app/service/roles"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+// Filter is an equality comparison, the only kind of filter identity providers send to look up resources before provisioning them
+type Filter struct {
+	// Attribute is lower case, as SCIM attribute names are case-insensitive
+	Attribute string
+	Value     string
+}
+
+func (f Filter) IsPresent() bool {
+	return f.Attribute != ""
+}
+
+var filterPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9.]*)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// userFilterFields maps the filterable user attributes to the fields of dm.User. The id is handled separately.
+var userFilterFields = map[string]string{
+	"username":       "email",
+	"emails":         "email",
+	"emails.value":   "email",
+	"externalid":     "scimExternalID",
+	"displayname":    "name",
+	"name.formatted": "name",
+}
+
+// managedUsersQuery matches the users dm.User.IsScimManaged reports
+var managedUsersQuery = bson.A{bson.M{"scimProvisioned": true}, bson.M{"scimExternalID": bson.M{"$exists": true}}}
+
+var UserFilterAttributes = []string{"id", "username", "emails", "emails.value", "externalid", "displayname", "name.formatted"}
+var GroupFilterAttributes = []string{"id", "displayname"}
+
+// ParseFilter parses filters like userName eq "jane@example.com". Other operators and attributes are rejected.
+func ParseFilter(filter string, attributes []string) (Filter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return Filter{}, nil
+	}
+	match := filterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return Filter{}, errs.Errorf("unsupported filter %s", filter)
+	}
+	parsed := Filter{Attribute: strings.ToLower(match[1])}
+	if !slices.Contains(attributes, parsed.Attribute) {
+		return Filter{}, errs.Errorf("unsupported filter attribute %s", match[1])
+	}
+	if err := json.Unmarshal([]byte(match[2]), &parsed.Value); err != nil {
+		return Filter{}, errs.Wrap("invalid filter value", err)
+	}
+	return parsed, nil
+}
+
+// ListUsers returns a page of the managed users matching the filter. Like SCIM, startIndex counts from 1.
+func ListUsers(ctx context.Context, database *mongo.Database, filter Filter, startIndex int, count int) ([]dm.User, int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	query := bson.M{"$or": managedUsersQuery}
+	if filter.Attribute == "id" {
+		id, err := primitive.ObjectIDFromHex(filter.Value)
+		if err != nil {
+			return []dm.User{}, 0, nil
+		}
+		query["_id"] = id
+	} else if filter.IsPresent() {
+		query[userFilterFields[filter.Attribute]] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.Value) + "$", Options: "i"}
+	}
+
+	total, err := database.Collection(dm.UserCollectionName).CountDocuments(queryCtx, query)
+	if err != nil {
+		return nil, 0, errs.Wrap("cannot count users", err)
+	}
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(queryCtx, query, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(startIndex-1)).
+		SetLimit(int64(count)))
+	if err != nil {
+		return nil, 0, errs.Wrap("cannot list users", err)
+	}
+
+	result := []dm.User{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, 0, errs.Wrap("cannot decode users", err)
+	}
+	return result, total, nil
+}
+
+// GetGroups returns the roles matching the filter. Every user holds dm.UserRoleUser, so it is not exposed as a group.
+func GetGroups(ctx context.Context, database *mongo.Database, filter Filter) ([]dm.Role, error) {
+	definitions, err := roles.GetRoles(ctx, database)
+	if err != nil {
+		return nil, errs.Wrap("issue loading roles", err)
+	}
+
+	groups := []dm.Role{}
+	for _, definition := range definitions {
+		if definition.Name == dm.UserRoleUser || (filter.IsPresent() && !strings.EqualFold(string(definition.Name), filter.Value)) {
+			continue
+		}
+		groups = append(groups, definition)
+	}
+	return groups, nil
+}
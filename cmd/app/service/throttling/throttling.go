@@ -0,0 +1,198 @@
+package throttling
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+)
+
+func CreateThrottleIndexes(ctx context.Context, database *mongo.Database) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.ThrottleCollectionName).Indexes().CreateMany(queryCtx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return errs.Wrap("cannot create throttle indexes", err)
+	}
+	return nil
+}
+
+func accountKey(scope dm.ThrottlingScope, userID dm.UserID) string {
+	return string(scope) + ":account:" + primitive.ObjectID(userID).Hex()
+}
+
+func ipKey(scope dm.ThrottlingScope, clientIP string) string {
+	return string(scope) + ":ip:" + clientIP
+}
+
+func emailKey(scope dm.ThrottlingScope, email string) string {
+	return string(scope) + ":email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// GetIPStatus returns whether attempts from the address are currently throttled
+func GetIPStatus(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, clientIP string) (dm.ThrottleStatus, error) {
+	throttle, err := getThrottle(ctx, database, ipKey(scope, clientIP))
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue fetching ip throttle", err)
+	}
+	return dm.ThrottleStatus{BlockedUntil: throttle.BlockedUntil}, nil
+}
+
+// GetEmailStatus returns whether attempts for the submitted email address are currently throttled. It does not depend
+// on whether an account uses the address, so that throttling does not reveal which accounts exist.
+func GetEmailStatus(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, email string) (dm.ThrottleStatus, error) {
+	throttle, err := getThrottle(ctx, database, emailKey(scope, email))
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue fetching email throttle", err)
+	}
+	return dm.ThrottleStatus{BlockedUntil: throttle.BlockedUntil}, nil
+}
+
+// GetStatus returns whether attempts for the user from the address are currently throttled
+func GetStatus(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, user dm.User, clientIP string) (dm.ThrottleStatus, error) {
+	if user.IsLocked() {
+		return dm.ThrottleStatus{Locked: true}, nil
+	}
+
+	status, err := GetIPStatus(ctx, database, scope, clientIP)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue fetching ip status", err)
+	}
+	throttle, err := getThrottle(ctx, database, accountKey(scope, user.ID()))
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue fetching account throttle", err)
+	}
+	if throttle.BlockedUntil.After(status.BlockedUntil) {
+		status.BlockedUntil = throttle.BlockedUntil
+	}
+	return status, nil
+}
+
+// RecordIPFailure counts a failed attempt from the address that cannot be attributed to an account
+func RecordIPFailure(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, clientIP string) (dm.ThrottleStatus, error) {
+	throttle, err := recordFailure(ctx, database, ipKey(scope, clientIP), primitive.NilObjectID, dm.IPThrottlingPolicy)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording ip failure", err)
+	}
+	return dm.ThrottleStatus{BlockedUntil: throttle.BlockedUntil}, nil
+}
+
+// RecordEmailFailure counts a failed attempt for the submitted email address with the same policy as accounts. The
+// userID is set if an account uses the address, so that ResetAccount clears the counter as well.
+func RecordEmailFailure(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, email string, userID primitive.ObjectID) (dm.ThrottleStatus, error) {
+	throttle, err := recordFailure(ctx, database, emailKey(scope, email), userID, dm.AccountThrottlingPolicy)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording email failure", err)
+	}
+	return dm.ThrottleStatus{BlockedUntil: throttle.BlockedUntil}, nil
+}
+
+// RecordFailure counts a failed attempt for the user and the address. The returned status is locked once the account
+// reached lockThreshold consecutive failures, the caller is responsible for locking the account. A threshold of 0
+// disables locking.
+func RecordFailure(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, user dm.User, clientIP string, lockThreshold int) (dm.ThrottleStatus, error) {
+	status, err := RecordIPFailure(ctx, database, scope, clientIP)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording ip failure", err)
+	}
+	throttle, err := recordFailure(ctx, database, accountKey(scope, user.ID()), user.ObjectID, dm.AccountThrottlingPolicy)
+	if err != nil {
+		return dm.ThrottleStatus{}, errs.Wrap("issue recording account failure", err)
+	}
+	if throttle.BlockedUntil.After(status.BlockedUntil) {
+		status.BlockedUntil = throttle.BlockedUntil
+	}
+	status.Locked = lockThreshold > 0 && int(throttle.Failures) >= lockThreshold
+	return status, nil
+}
+
+// RecordSuccess resets the account's counter. The address keeps its counter so that a single valid account cannot be
+// used to lift the throttling of an address.
+func RecordSuccess(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.ThrottleCollectionName).DeleteOne(queryCtx, bson.M{"_id": accountKey(scope, userID)}); err != nil {
+		return errs.Wrap("cannot reset account throttle", err)
+	}
+	return nil
+}
+
+// RecordEmailSuccess resets the counter of the submitted email address
+func RecordEmailSuccess(ctx context.Context, database *mongo.Database, scope dm.ThrottlingScope, email string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.ThrottleCollectionName).DeleteOne(queryCtx, bson.M{"_id": emailKey(scope, email)}); err != nil {
+		return errs.Wrap("cannot reset email throttle", err)
+	}
+	return nil
+}
+
+// ResetAccount removes all counters of the user, e.g. after the account was unlocked
+func ResetAccount(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	if _, err := database.Collection(dm.ThrottleCollectionName).DeleteMany(queryCtx, bson.M{"userID": primitive.ObjectID(userID)}); err != nil {
+		return errs.Wrap("cannot reset account throttles", err)
+	}
+	return nil
+}
+
+func getThrottle(ctx context.Context, database *mongo.Database, key string) (dm.Throttle, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var throttle dm.Throttle
+	err := database.Collection(dm.ThrottleCollectionName).FindOne(queryCtx, bson.M{"_id": key}).Decode(&throttle)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return throttle, nil
+		}
+		return throttle, errs.Wrap("error loading throttle", err)
+	}
+	return throttle, nil
+}
+
+func recordFailure(ctx context.Context, database *mongo.Database, key string, userID primitive.ObjectID, policy dm.ThrottlingPolicy) (dm.Throttle, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"expiresAt": now.Add(dm.ThrottleRetention)}
+	if userID != primitive.NilObjectID {
+		set["userID"] = userID
+	}
+
+	var throttle dm.Throttle
+	err := database.Collection(dm.ThrottleCollectionName).FindOneAndUpdate(queryCtx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": set},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&throttle)
+	if err != nil {
+		return dm.Throttle{}, errs.Wrap("cannot increment failures", err)
+	}
+
+	delay := policy.Delay(throttle.Failures)
+	if delay == 0 {
+		return throttle, nil
+	}
+	throttle.BlockedUntil = now.Add(delay)
+	if _, err = database.Collection(dm.ThrottleCollectionName).UpdateByID(queryCtx, key, bson.M{"$set": bson.M{"blockedUntil": throttle.BlockedUntil}}); err != nil {
+		return dm.Throttle{}, errs.Wrap("cannot set blocked until", err)
+	}
+	return throttle, nil
+}
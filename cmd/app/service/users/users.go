@@ -6,6 +6,8 @@ import (
 	bson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
 	"time"
 	dm "user-manager/domain-model"
 	"user-manager/util/db"
@@ -29,11 +31,11 @@ func GetUserForEmail(ctx context.Context, database *mongo.Database, email string
 	return user, nil
 }
 
-func UpdateUserEmailVerificationToken(ctx context.Context, database *mongo.Database, userID dm.UserID, token string) error {
+func UpdateUserEmailVerificationTokenHash(ctx context.Context, database *mongo.Database, userID dm.UserID, tokenHash string) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"emailVerificationToken": token}})
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"emailVerificationTokenHash": tokenHash}})
 	if err != nil {
 		return errs.Wrap("cannot update email verification token", err)
 	}
@@ -53,11 +55,11 @@ func SetEmailToVerified(ctx context.Context, database *mongo.Database, userID dm
 	return nil
 }
 
-func SetNextEmail(ctx context.Context, database *mongo.Database, userID dm.UserID, nextEmail string, verificationToken string) error {
+func SetNextEmail(ctx context.Context, database *mongo.Database, userID dm.UserID, nextEmail string, verificationTokenHash string) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"nextEmail": nextEmail, "emailVerificationToken": verificationToken}})
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"nextEmail": nextEmail, "emailVerificationTokenHash": verificationTokenHash}})
 	if err != nil {
 		return errs.Wrap("cannot set next email", err)
 	}
@@ -78,11 +80,11 @@ func SetEmailAndClearNextEmail(ctx context.Context, database *mongo.Database, us
 
 }
 
-func SetPasswordResetToken(ctx context.Context, database *mongo.Database, userID dm.UserID, token string, validUntil time.Time) error {
+func SetPasswordResetTokenHash(ctx context.Context, database *mongo.Database, userID dm.UserID, tokenHash string, validUntil time.Time) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
 
-	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"passwordResetToken": token, "passwordResetTokenValidUntil": validUntil}})
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"passwordResetTokenHash": tokenHash, "passwordResetTokenValidUntil": validUntil}})
 	if err != nil {
 		return errs.Wrap("cannot set password reset token", err)
 	}
@@ -90,6 +92,38 @@ func SetPasswordResetToken(ctx context.Context, database *mongo.Database, userID
 	return nil
 }
 
+func SetMagicLinkTokenHash(ctx context.Context, database *mongo.Database, userID dm.UserID, tokenHash string, validUntil time.Time) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"magicLinkTokenHash": tokenHash, "magicLinkTokenValidUntil": validUntil}})
+	if err != nil {
+		return errs.Wrap("cannot set magic link token", err)
+	}
+
+	return nil
+}
+
+// ConsumeMagicLinkToken returns the user the unexpired token was issued to and removes the token, so each link can only be used once
+func ConsumeMagicLinkToken(ctx context.Context, database *mongo.Database, tokenHash string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOneAndUpdate(queryCtx,
+		bson.M{"magicLinkTokenHash": tokenHash, "magicLinkTokenValidUntil": bson.M{"$gt": time.Now()}},
+		bson.M{"$unset": bson.M{"magicLinkTokenHash": "", "magicLinkTokenValidUntil": ""}}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error consuming magic link token", err)
+	}
+
+	return user, nil
+}
+
 func SetCredentials(ctx context.Context, database *mongo.Database, userId dm.UserID, credentials dm.UserCredentials) error {
 	queryCtx, cancel := db.DefaultQueryContext(ctx)
 	defer cancel()
@@ -106,16 +140,589 @@ func InsertUser(ctx context.Context, database *mongo.Database, user dm.UserInser
 	defer cancel()
 
 	_, err := database.Collection(dm.UserCollectionName).InsertOne(queryCtx, dm.User{
-		Name:                   user.UserName,
-		Credentials:            user.Credentials,
-		Email:                  user.Email,
-		EmailVerified:          user.EmailVerified,
-		EmailVerificationToken: user.EmailVerificationToken,
-		UserRoles:              user.UserRoles,
-		Sessions:               []dm.UserSession{},
+		Name:                       user.UserName,
+		Credentials:                user.Credentials,
+		Email:                      user.Email,
+		EmailVerified:              user.EmailVerified,
+		EmailVerificationTokenHash: user.EmailVerificationTokenHash,
+		AdminInvitationTokenHash:   user.AdminInvitationTokenHash,
+		AdminInvitationValidUntil:  user.AdminInvitationValidUntil,
+		UserRoles:                  user.UserRoles,
 	})
 	if err != nil {
 		return errs.Wrap("cannot insert user", err)
 	}
 	return nil
 }
+
+func SetTemporarySecondFactorToken(ctx context.Context, database *mongo.Database, userID dm.UserID, token string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"temporarySecondFactorToken": token}})
+	if err != nil {
+		return errs.Wrap("cannot set temporary second factor token", err)
+	}
+
+	return nil
+}
+
+func EnableSecondFactor(ctx context.Context, database *mongo.Database, userID dm.UserID, token string, recoveryCodeHashes []string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{
+		"$set":   bson.M{"secondFactorToken": token, "secondFactorRecoveryCodes": recoveryCodeHashes},
+		"$unset": bson.M{"temporarySecondFactorToken": ""},
+	})
+	if err != nil {
+		return errs.Wrap("cannot enable second factor", err)
+	}
+
+	return nil
+}
+
+func DisableSecondFactor(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{
+		"$unset": bson.M{"secondFactorToken": "", "temporarySecondFactorToken": "", "secondFactorRecoveryCodes": ""},
+	})
+	if err != nil {
+		return errs.Wrap("cannot disable second factor", err)
+	}
+
+	return nil
+}
+
+func SetSecondFactorRecoveryCodes(ctx context.Context, database *mongo.Database, userID dm.UserID, recoveryCodeHashes []string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$set": bson.M{"secondFactorRecoveryCodes": recoveryCodeHashes}})
+	if err != nil {
+		return errs.Wrap("cannot set second factor recovery codes", err)
+	}
+
+	return nil
+}
+
+// ConsumeSecondFactorRecoveryCode removes the recovery code from the user and returns the updated user. The user is
+// not present if the code was not.
+func ConsumeSecondFactorRecoveryCode(ctx context.Context, database *mongo.Database, userID dm.UserID, recoveryCodeHash string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOneAndUpdate(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "secondFactorRecoveryCodes": recoveryCodeHash},
+		bson.M{"$pull": bson.M{"secondFactorRecoveryCodes": recoveryCodeHash}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("cannot consume second factor recovery code", err)
+	}
+
+	return user, nil
+}
+
+func AddWebAuthnCredential(ctx context.Context, database *mongo.Database, userID dm.UserID, credential dm.WebAuthnCredential) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$push": bson.M{"webAuthnCredentials": credential}})
+	if err != nil {
+		return errs.Wrap("cannot add webauthn credential", err)
+	}
+
+	return nil
+}
+
+func UpdateWebAuthnCredentialUsage(ctx context.Context, database *mongo.Database, userID dm.UserID, credentialID []byte, signCount uint32) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "webAuthnCredentials.id": credentialID},
+		bson.M{"$set": bson.M{"webAuthnCredentials.$.signCount": signCount, "webAuthnCredentials.$.lastUsedAt": time.Now()}})
+	if err != nil {
+		return errs.Wrap("cannot update webauthn credential usage", err)
+	}
+
+	return nil
+}
+
+func RemoveWebAuthnCredential(ctx context.Context, database *mongo.Database, userID dm.UserID, credentialID []byte) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$pull": bson.M{"webAuthnCredentials": bson.M{"id": credentialID}}})
+	if err != nil {
+		return errs.Wrap("cannot remove webauthn credential", err)
+	}
+
+	return nil
+}
+
+func GetUserForID(ctx context.Context, database *mongo.Database, userID dm.UserID) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{"_id": primitive.ObjectID(userID)}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error loading user for id", err)
+	}
+
+	return user, nil
+}
+
+// SearchUsers finds users whose email or name contains the query, ordered by email
+func SearchUsers(ctx context.Context, database *mongo.Database, query string, page int, pageSize int) ([]dm.User, int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	if query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		filter = bson.M{"$or": bson.A{bson.M{"email": pattern}, bson.M{"name": pattern}}}
+	}
+
+	total, err := database.Collection(dm.UserCollectionName).CountDocuments(queryCtx, filter)
+	if err != nil {
+		return nil, 0, errs.Wrap("cannot count users", err)
+	}
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(queryCtx, filter, options.Find().
+		SetSort(bson.D{{Key: "email", Value: 1}}).
+		SetSkip(int64(page*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		return nil, 0, errs.Wrap("cannot search users", err)
+	}
+
+	result := []dm.User{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, 0, errs.Wrap("cannot decode users", err)
+	}
+	return result, total, nil
+}
+
+func GetUserForAdminInvitationTokenHash(ctx context.Context, database *mongo.Database, tokenHash string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{
+		"adminInvitationTokenHash":  tokenHash,
+		"adminInvitationValidUntil": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error loading user for admin invitation", err)
+	}
+
+	return user, nil
+}
+
+// GetUsersWithPrivilegedRole returns all users holding a role besides dm.UserRoleUser
+func GetUsersWithPrivilegedRole(ctx context.Context, database *mongo.Database) ([]dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(queryCtx,
+		bson.M{"userRoles": bson.M{"$elemMatch": bson.M{"$ne": dm.UserRoleUser}}},
+		options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query users with roles", err)
+	}
+
+	result := []dm.User{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, errs.Wrap("cannot decode users", err)
+	}
+	return result, nil
+}
+
+func CountUsersWithRole(ctx context.Context, database *mongo.Database, role dm.UserRole) (int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	count, err := database.Collection(dm.UserCollectionName).CountDocuments(queryCtx, bson.M{"userRoles": role})
+	if err != nil {
+		return 0, errs.Wrap("cannot count users with role", err)
+	}
+	return count, nil
+}
+
+func CountUsersWithAnyRole(ctx context.Context, database *mongo.Database, roles []dm.UserRole) (int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	count, err := database.Collection(dm.UserCollectionName).CountDocuments(queryCtx, bson.M{"userRoles": bson.M{"$in": roles}})
+	if err != nil {
+		return 0, errs.Wrap("cannot count users with roles", err)
+	}
+	return count, nil
+}
+
+func CountOtherUsersWithAnyRole(ctx context.Context, database *mongo.Database, roles []dm.UserRole, excludedUserID dm.UserID) (int64, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	count, err := database.Collection(dm.UserCollectionName).CountDocuments(queryCtx, bson.M{
+		"_id":       bson.M{"$ne": primitive.ObjectID(excludedUserID)},
+		"userRoles": bson.M{"$in": roles},
+	})
+	if err != nil {
+		return 0, errs.Wrap("cannot count users with roles", err)
+	}
+	return count, nil
+}
+
+func AddUserRole(ctx context.Context, database *mongo.Database, userID dm.UserID, role dm.UserRole) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$addToSet": bson.M{"userRoles": role}})
+	if err != nil {
+		return errs.Wrap("cannot add user role", err)
+	}
+	return nil
+}
+
+func RemoveUserRole(ctx context.Context, database *mongo.Database, userID dm.UserID, role dm.UserRole) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$pull": bson.M{"userRoles": role}})
+	if err != nil {
+		return errs.Wrap("cannot remove user role", err)
+	}
+	return nil
+}
+
+// ResetAdminCredentials removes all means of logging in and issues a new admin invitation
+func ResetAdminCredentials(ctx context.Context, database *mongo.Database, userID dm.UserID, invitationTokenHash string, validUntil time.Time) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{
+		"$set": bson.M{"adminInvitationTokenHash": invitationTokenHash, "adminInvitationValidUntil": validUntil},
+		"$unset": bson.M{
+			"credentials":                "",
+			"secondFactorToken":          "",
+			"temporarySecondFactorToken": "",
+			"secondFactorRecoveryCodes":  "",
+			"webAuthnCredentials":        "",
+			"passwordResetTokenHash":     "",
+		},
+	})
+	if err != nil {
+		return errs.Wrap("cannot reset admin credentials", err)
+	}
+	return nil
+}
+
+// AcceptAdminInvitation sets the admin's password and second factor in one step, so an admin can never exist without 2FA
+func AcceptAdminInvitation(ctx context.Context, database *mongo.Database, userID dm.UserID, credentials dm.UserCredentials, secondFactorToken string, recoveryCodeHashes []string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{
+		"$set": bson.M{
+			"credentials":               credentials,
+			"secondFactorToken":         secondFactorToken,
+			"secondFactorRecoveryCodes": recoveryCodeHashes,
+			"emailVerified":             true,
+		},
+		"$unset": bson.M{"adminInvitationTokenHash": "", "adminInvitationValidUntil": "", "temporarySecondFactorToken": ""},
+	})
+	if err != nil {
+		return errs.Wrap("cannot accept admin invitation", err)
+	}
+	return nil
+}
+
+// LockUser locks the account unless it is locked already. It reports whether the account was locked by this call.
+func LockUser(ctx context.Context, database *mongo.Database, userID dm.UserID, unlockTokenHash string) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "lockedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"lockedAt": time.Now(), "unlockTokenHash": unlockTokenHash}})
+	if err != nil {
+		return false, errs.Wrap("cannot lock user", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func UnlockUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$unset": bson.M{"lockedAt": "", "unlockTokenHash": ""}})
+	if err != nil {
+		return errs.Wrap("cannot unlock user", err)
+	}
+	return nil
+}
+
+func GetUserForUnlockTokenHash(ctx context.Context, database *mongo.Database, tokenHash string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{"unlockTokenHash": tokenHash}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error loading user for unlock token", err)
+	}
+
+	return user, nil
+}
+
+func GetUserForExternalIdentity(ctx context.Context, database *mongo.Database, provider string, subject string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{
+		"externalIdentities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error loading user for external identity", err)
+	}
+
+	return user, nil
+}
+
+// AddExternalIdentity links the identity unless the user already has one at the same provider. It reports whether the identity was linked by this call.
+func AddExternalIdentity(ctx context.Context, database *mongo.Database, userID dm.UserID, identity dm.ExternalIdentity) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "externalIdentities.provider": bson.M{"$ne": identity.Provider}},
+		bson.M{"$push": bson.M{"externalIdentities": identity}})
+	if err != nil {
+		return false, errs.Wrap("cannot add external identity", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func RemoveExternalIdentity(ctx context.Context, database *mongo.Database, userID dm.UserID, provider string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$pull": bson.M{"externalIdentities": bson.M{"provider": provider}}})
+	if err != nil {
+		return errs.Wrap("cannot remove external identity", err)
+	}
+
+	return nil
+}
+
+// InsertProvisionedUser creates a user on behalf of the identity provider. Provisioned users have no password, they sign in
+// through the provider, a magic link or after a password reset.
+func InsertProvisionedUser(ctx context.Context, database *mongo.Database, user dm.User) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	user.ObjectID = primitive.NewObjectID()
+	if _, err := database.Collection(dm.UserCollectionName).InsertOne(queryCtx, user); err != nil {
+		return dm.User{}, errs.Wrap("cannot insert provisioned user", err)
+	}
+	return user, nil
+}
+
+// SetProvisionedAttributes overwrites the attributes the identity provider manages. The provider vouches for the email.
+func SetProvisionedAttributes(ctx context.Context, database *mongo.Database, userID dm.UserID, name string, email string, externalID string) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"name": name, "email": email, "emailVerified": true}}
+	if externalID == "" {
+		update["$unset"] = bson.M{"scimExternalID": ""}
+	} else {
+		update["$set"].(bson.M)["scimExternalID"] = externalID
+	}
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), update)
+	if err != nil {
+		return errs.Wrap("cannot set provisioned attributes", err)
+	}
+	return nil
+}
+
+// ClearEmailedTokens invalidates the password reset and magic link tokens and any pending email change, as they were
+// sent to an address the user may no longer control
+func ClearEmailedTokens(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), bson.M{"$unset": bson.M{
+		"passwordResetTokenHash":       "",
+		"passwordResetTokenValidUntil": "",
+		"magicLinkTokenHash":           "",
+		"magicLinkTokenValidUntil":     "",
+		"nextEmail":                    "",
+		"emailVerificationTokenHash":   "",
+	}})
+	if err != nil {
+		return errs.Wrap("cannot clear emailed tokens", err)
+	}
+	return nil
+}
+
+func SetDeactivated(ctx context.Context, database *mongo.Database, userID dm.UserID, deactivated bool) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"deactivatedAt": ""}}
+	if deactivated {
+		update = bson.M{"$set": bson.M{"deactivatedAt": time.Now()}}
+	}
+	_, err := database.Collection(dm.UserCollectionName).UpdateByID(queryCtx, primitive.ObjectID(userID), update)
+	if err != nil {
+		return errs.Wrap("cannot set user deactivation", err)
+	}
+	return nil
+}
+
+func DeleteUser(ctx context.Context, database *mongo.Database, userID dm.UserID) error {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	_, err := database.Collection(dm.UserCollectionName).DeleteOne(queryCtx, bson.M{"_id": primitive.ObjectID(userID)})
+	if err != nil {
+		return errs.Wrap("cannot delete user", err)
+	}
+	return nil
+}
+
+func GetUsersWithRole(ctx context.Context, database *mongo.Database, role dm.UserRole) ([]dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(queryCtx, bson.M{"userRoles": role}, options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, errs.Wrap("cannot query users with role", err)
+	}
+
+	result := []dm.User{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, errs.Wrap("cannot decode users", err)
+	}
+	return result, nil
+}
+
+// ScheduleDeletion returns false if the deletion of the user was scheduled already
+func ScheduleDeletion(ctx context.Context, database *mongo.Database, userID dm.UserID, deleteAt time.Time, cancelTokenHash string) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "deletionScheduledFor": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletionScheduledFor": deleteAt, "deletionCancelTokenHash": cancelTokenHash}})
+	if err != nil {
+		return false, errs.Wrap("cannot schedule user deletion", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// CancelDeletion returns false if the account-deletion-job started deleting the user already
+func CancelDeletion(ctx context.Context, database *mongo.Database, userID dm.UserID, cancelTokenHash string) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "deletionCancelTokenHash": cancelTokenHash},
+		bson.M{"$unset": bson.M{"deletionScheduledFor": "", "deletionCancelTokenHash": ""}})
+	if err != nil {
+		return false, errs.Wrap("cannot cancel user deletion", err)
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func GetUserForDeletionCancelTokenHash(ctx context.Context, database *mongo.Database, tokenHash string) (dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	var user dm.User
+
+	err := database.Collection(dm.UserCollectionName).FindOne(queryCtx, bson.M{"deletionCancelTokenHash": tokenHash}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, nil
+		}
+		return user, errs.Wrap("error loading user for deletion cancel token", err)
+	}
+
+	return user, nil
+}
+
+// GetUsersDueForDeletion returns the users whose grace period ended before the given time
+func GetUsersDueForDeletion(ctx context.Context, database *mongo.Database, now time.Time) ([]dm.User, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(queryCtx, bson.M{"deletionScheduledFor": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, errs.Wrap("cannot query users due for deletion", err)
+	}
+
+	result := []dm.User{}
+	if err = cursor.All(queryCtx, &result); err != nil {
+		return nil, errs.Wrap("cannot decode users", err)
+	}
+	return result, nil
+}
+
+// StartDueDeletion removes the cancel token, so that the deletion can no longer be canceled while the user's data is
+// removed. It returns false if the deletion was canceled or is not due yet, and true again for a deletion started before.
+func StartDueDeletion(ctx context.Context, database *mongo.Database, userID dm.UserID, now time.Time) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).UpdateOne(queryCtx,
+		bson.M{"_id": primitive.ObjectID(userID), "deletionScheduledFor": bson.M{"$lte": now}},
+		bson.M{"$unset": bson.M{"deletionCancelTokenHash": ""}})
+	if err != nil {
+		return false, errs.Wrap("cannot start user deletion", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// DeleteUserDueForDeletion returns false if the deletion was canceled or is not due yet
+func DeleteUserDueForDeletion(ctx context.Context, database *mongo.Database, userID dm.UserID, now time.Time) (bool, error) {
+	queryCtx, cancel := db.DefaultQueryContext(ctx)
+	defer cancel()
+
+	result, err := database.Collection(dm.UserCollectionName).DeleteOne(queryCtx, bson.M{"_id": primitive.ObjectID(userID), "deletionScheduledFor": bson.M{"$lte": now}})
+	if err != nil {
+		return false, errs.Wrap("cannot delete user", err)
+	}
+	return result.DeletedCount == 1, nil
+}
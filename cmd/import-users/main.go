@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"user-manager/cmd/app/service/auth"
+	"user-manager/cmd/app/service/users"
+	dm "user-manager/domain-model"
+	"user-manager/util/command"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/logger"
+)
+
+// ImportedUserTO is one line of the import file (JSON Lines)
+type ImportedUserTO struct {
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	PasswordHash  string `json:"passwordHash"`
+	EmailVerified bool   `json:"emailVerified"`
+}
+
+func main() {
+	slog.SetDefault(logger.NewLogger(false))
+	command.Run(importUsers)
+}
+
+// importUsers imports users with bcrypt or scrypt password hashes from a legacy system.
+// Their passwords are rehashed with argon2id on their first login.
+func importUsers() error {
+	if len(os.Args) != 2 {
+		return errs.Error("usage: import-users <users.jsonl>")
+	}
+
+	config, err := dm.GetConfig()
+	if err != nil {
+		return errs.Wrap("cannot read config", err)
+	}
+
+	if !config.IsLocalEnv() {
+		slog.SetDefault(logger.NewLogger(true))
+	}
+
+	database, err := db.OpenDbConnection(config.DbInfo)
+	if err != nil {
+		return errs.Wrap("issue opening db connection", err)
+	}
+	defer db.CloseOrPanic(database.Client())
+
+	file, err := os.Open(os.Args[1])
+	if err != nil {
+		return errs.Wrap("cannot open import file", err)
+	}
+	defer file.Close()
+
+	ctx := context.Background()
+	imported, skipped := 0, 0
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var importedUser ImportedUserTO
+		if err = json.Unmarshal(scanner.Bytes(), &importedUser); err != nil {
+			return errs.Wrap(fmt.Sprintf("cannot parse line %d", lineNumber), err)
+		}
+
+		credentials, err := auth.ParseImportedPasswordHash(importedUser.PasswordHash)
+		if err != nil {
+			return errs.Wrap("cannot parse password hash of "+importedUser.Email, err)
+		}
+
+		existingUser, err := users.GetUserForEmail(ctx, database, importedUser.Email)
+		if err != nil {
+			return errs.Wrap("issue checking for existing user", err)
+		}
+		if existingUser.IsPresent() {
+			slog.Info("Skipping already existing user", "email", importedUser.Email)
+			skipped++
+			continue
+		}
+
+		if err = users.InsertUser(ctx, database, dm.UserInsert{
+			UserName:      importedUser.Name,
+			Credentials:   credentials,
+			Email:         importedUser.Email,
+			EmailVerified: importedUser.EmailVerified,
+			UserRoles:     []dm.UserRole{dm.UserRoleUser},
+		}); err != nil {
+			return errs.Wrap("issue inserting user "+importedUser.Email, err)
+		}
+		imported++
+	}
+	if err = scanner.Err(); err != nil {
+		return errs.Wrap("issue reading import file", err)
+	}
+
+	slog.Info("Import finished", "imported", imported, "skipped", skipped)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"time"
+	dm "user-manager/domain-model"
+	"user-manager/util/command"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/logger"
+)
+
+const migrationCollectionName = "migrations"
+
+type migration struct {
+	name string
+	run  func(ctx context.Context, database *mongo.Database, config *dm.Config) error
+}
+
+type appliedMigration struct {
+	Name      string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// Migrations are applied in order and each of them only once. Never reorder or rename existing entries.
+var migrations = []migration{
+	{name: "001-move-sessions-to-own-collection", run: moveSessionsToOwnCollection},
+	{name: "002-hash-tokens-at-rest", run: hashTokensAtRest},
+	{name: "003-seed-default-roles", run: seedDefaultRoles},
+	{name: "004-grant-audit-read-to-admins", run: grantAuditReadToAdmins},
+	{name: "005-remove-legacy-second-factor-throttling", run: removeLegacySecondFactorThrottling},
+	{name: "006-encrypt-oidc-signing-keys", run: encryptOidcSigningKeys},
+}
+
+func main() {
+	slog.SetDefault(logger.NewLogger(false))
+	command.Run(runMigrations)
+}
+
+func runMigrations() error {
+	slog.Info("Starting up")
+
+	config, err := dm.GetConfig()
+	if err != nil {
+		return errs.Wrap("cannot read config", err)
+	}
+
+	if !config.IsLocalEnv() {
+		slog.SetDefault(logger.NewLogger(true))
+	}
+
+	database, err := db.OpenDbConnection(config.DbInfo)
+	if err != nil {
+		return errs.Wrap("issue opening db connection", err)
+	}
+	defer db.CloseOrPanic(database.Client())
+
+	ctx := context.Background()
+	for _, m := range migrations {
+		err = database.Collection(migrationCollectionName).FindOne(ctx, bson.M{"_id": m.name}).Err()
+		if err == nil {
+			slog.Info("Migration already applied", "migration", m.name)
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return errs.Wrap("issue checking migration status", err)
+		}
+
+		slog.Info("Applying migration", "migration", m.name)
+		if err = m.run(ctx, database, config); err != nil {
+			return errs.Wrap("issue applying migration "+m.name, err)
+		}
+		if _, err = database.Collection(migrationCollectionName).InsertOne(ctx, appliedMigration{Name: m.name, AppliedAt: time.Now()}); err != nil {
+			return errs.Wrap("issue recording migration "+m.name, err)
+		}
+	}
+
+	slog.Info("All migrations applied")
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"user-manager/cmd/app/service/oidc"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+type legacyOidcSigningKey struct {
+	KeyID      string `bson:"_id"`
+	PrivateKey []byte `bson:"privateKey"`
+}
+
+// encryptOidcSigningKeys encrypts the signing keys stored in plain text so far. The keys stay the same, so tokens
+// signed before remain valid.
+func encryptOidcSigningKeys(ctx context.Context, database *mongo.Database, config *dm.Config) error {
+	cursor, err := database.Collection(dm.OidcSigningKeyCollectionName).Find(ctx, bson.M{"privateKey": bson.M{"$exists": true}})
+	if err != nil {
+		return errs.Wrap("issue querying plaintext signing keys", err)
+	}
+	defer cursor.Close(ctx)
+
+	migratedKeys := 0
+	for cursor.Next(ctx) {
+		var key legacyOidcSigningKey
+		if err = cursor.Decode(&key); err != nil {
+			return errs.Wrap("issue decoding signing key", err)
+		}
+
+		encryptedPrivateKey, err := oidc.EncryptSigningKey(config, key.KeyID, key.PrivateKey)
+		if err != nil {
+			return errs.Wrap("issue encrypting signing key", err)
+		}
+		_, err = database.Collection(dm.OidcSigningKeyCollectionName).UpdateByID(ctx, key.KeyID, bson.M{
+			"$set":   bson.M{"encryptedPrivateKey": encryptedPrivateKey},
+			"$unset": bson.M{"privateKey": ""},
+		})
+		if err != nil {
+			return errs.Wrap("issue storing encrypted signing key", err)
+		}
+		migratedKeys++
+	}
+	if err = cursor.Err(); err != nil {
+		return errs.Wrap("issue iterating signing keys", err)
+	}
+
+	slog.Info("OIDC signing keys encrypted", "keys", migratedKeys)
+	return nil
+}
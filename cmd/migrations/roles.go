@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+// seedDefaultRoles inserts the definitions of the roles that used to be hard-coded. Existing definitions are kept.
+func seedDefaultRoles(ctx context.Context, database *mongo.Database, _ *dm.Config) error {
+	for _, role := range dm.DefaultRoles {
+		result, err := database.Collection(dm.RoleCollectionName).UpdateOne(ctx,
+			bson.M{"_id": role.Name},
+			bson.M{"$setOnInsert": bson.M{
+				"description": role.Description,
+				"permissions": role.Permissions,
+				"inherits":    role.Inherits,
+				"builtin":     role.Builtin,
+			}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return errs.Wrap("issue seeding role "+string(role.Name), err)
+		}
+		if result.UpsertedCount > 0 {
+			slog.Info("Seeded role", "role", role.Name)
+		}
+	}
+	return nil
+}
+
+// grantAuditReadToAdmins adds the permission introduced with the audit log to the seeded admin role
+func grantAuditReadToAdmins(ctx context.Context, database *mongo.Database, _ *dm.Config) error {
+	_, err := database.Collection(dm.RoleCollectionName).UpdateOne(ctx,
+		bson.M{"_id": dm.UserRoleAdmin},
+		bson.M{"$addToSet": bson.M{"permissions": dm.PermissionAuditRead}})
+	if err != nil {
+		return errs.Wrap("issue granting audit read to admins", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+type legacyUserWithSessions struct {
+	ObjectID primitive.ObjectID `bson:"_id"`
+	Sessions []struct {
+		Token                dm.UserSessionToken `bson:"token"`
+		Type                 dm.UserSessionType  `bson:"type"`
+		RequiresSecondFactor bool                `bson:"requiresSecondFactor"`
+		TimeoutAt            time.Time           `bson:"timeoutAt"`
+		CreatedAt            time.Time           `bson:"createdAt"`
+		LastActivityAt       time.Time           `bson:"lastActivityAt"`
+		UserAgent            string              `bson:"userAgent"`
+		ClientIP             string              `bson:"clientIP"`
+	} `bson:"sessions"`
+}
+
+// moveSessionsToOwnCollection copies unexpired sessions embedded in user documents to the sessions collection
+func moveSessionsToOwnCollection(ctx context.Context, database *mongo.Database, _ *dm.Config) error {
+	if err := auth.CreateSessionIndexes(ctx, database); err != nil {
+		return errs.Wrap("issue creating session indexes", err)
+	}
+
+	cursor, err := database.Collection(dm.UserCollectionName).Find(ctx, bson.M{"sessions": bson.M{"$exists": true}})
+	if err != nil {
+		return errs.Wrap("issue querying users with sessions", err)
+	}
+	defer cursor.Close(ctx)
+
+	migratedSessions := 0
+	for cursor.Next(ctx) {
+		var user legacyUserWithSessions
+		if err = cursor.Decode(&user); err != nil {
+			return errs.Wrap("issue decoding user", err)
+		}
+
+		for _, legacySession := range user.Sessions {
+			if legacySession.Token == "" || legacySession.TimeoutAt.Before(time.Now()) {
+				continue
+			}
+			session := dm.UserSession{
+				TokenHash:            unkeyedSessionTokenHash(legacySession.Token),
+				UserID:               user.ObjectID,
+				Type:                 legacySession.Type,
+				RequiresSecondFactor: legacySession.RequiresSecondFactor,
+				TimeoutAt:            legacySession.TimeoutAt,
+				CreatedAt:            legacySession.CreatedAt,
+				LastActivityAt:       legacySession.LastActivityAt,
+				UserAgent:            legacySession.UserAgent,
+				ClientIP:             legacySession.ClientIP,
+			}
+			_, err = database.Collection(dm.SessionCollectionName).UpdateOne(ctx,
+				bson.M{"tokenHash": session.TokenHash},
+				bson.M{"$setOnInsert": session},
+				options.Update().SetUpsert(true))
+			if err != nil {
+				return errs.Wrap("issue inserting session", err)
+			}
+			migratedSessions++
+		}
+
+		if _, err = database.Collection(dm.UserCollectionName).UpdateByID(ctx, user.ObjectID, bson.M{"$unset": bson.M{"sessions": ""}}); err != nil {
+			return errs.Wrap("issue removing embedded sessions", err)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return errs.Wrap("issue iterating users", err)
+	}
+
+	slog.Info("Sessions moved to own collection", "count", migratedSessions)
+	return nil
+}
+
+// unkeyedSessionTokenHash is how session tokens were hashed before keyed hashes were introduced
+func unkeyedSessionTokenHash(sessionToken dm.UserSessionToken) string {
+	hash := sha256.Sum256([]byte(sessionToken))
+	return hex.EncodeToString(hash[:])
+}
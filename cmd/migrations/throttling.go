@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+// removeLegacySecondFactorThrottling drops the per-user second factor counters, which are replaced by the throttles
+// collection. They were written to the top level of the user document by mistake.
+func removeLegacySecondFactorThrottling(ctx context.Context, database *mongo.Database, _ *dm.Config) error {
+	result, err := database.Collection(dm.UserCollectionName).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{
+		"secondFactorThrottling":         "",
+		"failedAttemptsSinceLastSuccess": "",
+		"timeoutUntil":                   "",
+		"updatedAt":                      "",
+	}})
+	if err != nil {
+		return errs.Wrap("issue removing legacy second factor throttling", err)
+	}
+	slog.Info("Removed legacy second factor throttling", "users", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"user-manager/cmd/app/service/auth"
+	dm "user-manager/domain-model"
+	"user-manager/util/errs"
+)
+
+type legacyUserWithTokens struct {
+	ObjectID               primitive.ObjectID `bson:"_id"`
+	EmailVerificationToken string             `bson:"emailVerificationToken"`
+	PasswordResetToken     string             `bson:"passwordResetToken"`
+}
+
+// hashTokensAtRest replaces plaintext verification and reset tokens with keyed hashes.
+// Sessions and WebAuthn ceremonies stored so far cannot be rehashed and are dropped, which logs everybody out once.
+func hashTokensAtRest(ctx context.Context, database *mongo.Database, config *dm.Config) error {
+	cursor, err := database.Collection(dm.UserCollectionName).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"emailVerificationToken": bson.M{"$exists": true}},
+		bson.M{"passwordResetToken": bson.M{"$exists": true}},
+	}})
+	if err != nil {
+		return errs.Wrap("issue querying users with plaintext tokens", err)
+	}
+	defer cursor.Close(ctx)
+
+	migratedUsers := 0
+	for cursor.Next(ctx) {
+		var user legacyUserWithTokens
+		if err = cursor.Decode(&user); err != nil {
+			return errs.Wrap("issue decoding user", err)
+		}
+
+		set := bson.M{}
+		if user.EmailVerificationToken != "" {
+			set["emailVerificationTokenHash"] = auth.HashToken(config, user.EmailVerificationToken)
+		}
+		if user.PasswordResetToken != "" {
+			set["passwordResetTokenHash"] = auth.HashToken(config, user.PasswordResetToken)
+		}
+		update := bson.M{"$unset": bson.M{"emailVerificationToken": "", "passwordResetToken": ""}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if _, err = database.Collection(dm.UserCollectionName).UpdateByID(ctx, user.ObjectID, update); err != nil {
+			return errs.Wrap("issue hashing user tokens", err)
+		}
+		migratedUsers++
+	}
+	if err = cursor.Err(); err != nil {
+		return errs.Wrap("issue iterating users", err)
+	}
+
+	deletedSessions, err := database.Collection(dm.SessionCollectionName).DeleteMany(ctx, bson.M{})
+	if err != nil {
+		return errs.Wrap("issue dropping sessions with unkeyed token hashes", err)
+	}
+	if _, err = database.Collection(dm.WebAuthnCeremonyCollectionName).DeleteMany(ctx, bson.M{}); err != nil {
+		return errs.Wrap("issue dropping webauthn ceremonies with plaintext tokens", err)
+	}
+
+	slog.Info("Tokens hashed at rest", "users", migratedUsers, "droppedSessions", deletedSessions.DeletedCount)
+	return nil
+}
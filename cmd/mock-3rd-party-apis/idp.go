@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	openidprovider "user-manager/third-party-models/openid-provider"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+const mockIdpKeyID = "mock-idp-key"
+
+type mockAuthorization struct {
+	identity      openidprovider.IdentityTO
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// mockIdentityProvider is an OpenID Connect provider that signs in as whatever identity was set last, without asking the user
+type mockIdentityProvider struct {
+	config         *Config
+	key            *rsa.PrivateKey
+	mutex          sync.Mutex
+	identity       *openidprovider.IdentityTO
+	authorizations map[string]mockAuthorization
+}
+
+func registerMockIdentityProvider(app *gin.Engine, config *Config) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return errs.Wrap("cannot generate mock idp key", err)
+	}
+	idp := &mockIdentityProvider{config: config, key: key, authorizations: map[string]mockAuthorization{}}
+
+	group := app.Group("/mock-idp")
+	group.GET("/.well-known/openid-configuration", idp.getConfiguration)
+	group.GET("/authorize", idp.authorize)
+	group.POST("/token", idp.token)
+	group.GET("/jwks", idp.jwks)
+	group.POST("/identity", idp.setIdentity)
+	return nil
+}
+
+func (idp *mockIdentityProvider) getConfiguration(c *gin.Context) {
+	c.JSON(http.StatusOK, openidprovider.DiscoveryTO{
+		Issuer:                idp.config.IdpIssuer,
+		AuthorizationEndpoint: idp.config.IdpIssuer + "/authorize",
+		TokenEndpoint:         idp.config.IdpIssuer + "/token",
+		JwksURI:               idp.config.IdpIssuer + "/jwks",
+	})
+}
+
+func (idp *mockIdentityProvider) setIdentity(c *gin.Context) {
+	var identity openidprovider.IdentityTO
+	if err := c.BindJSON(&identity); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, errs.Wrap("cannot bind to IdentityTO", err))
+		return
+	}
+	idp.mutex.Lock()
+	defer idp.mutex.Unlock()
+	idp.identity = &identity
+	slog.Info("Mock idp identity set", "sub", identity.Subject, "email", identity.Email)
+}
+
+func (idp *mockIdentityProvider) authorize(c *gin.Context) {
+	redirectURI := c.Query("redirect_uri")
+	if c.Query("client_id") != idp.config.IdpClientID || redirectURI == "" {
+		c.String(http.StatusBadRequest, "invalid client or redirect uri")
+		return
+	}
+
+	params := url.Values{"state": {c.Query("state")}}
+	idp.mutex.Lock()
+	switch {
+	case c.Query("response_type") != "code" || c.Query("code_challenge_method") != "S256":
+		params.Set("error", "invalid_request")
+	case idp.identity == nil:
+		params.Set("error", "access_denied")
+	default:
+		code := random.MakeRandomURLSafeB64(21)
+		idp.authorizations[code] = mockAuthorization{
+			identity:      *idp.identity,
+			redirectURI:   redirectURI,
+			nonce:         c.Query("nonce"),
+			codeChallenge: c.Query("code_challenge"),
+		}
+		params.Set("code", code)
+	}
+	idp.mutex.Unlock()
+
+	c.Redirect(http.StatusFound, redirectURI+"?"+params.Encode())
+}
+
+func (idp *mockIdentityProvider) token(c *gin.Context) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if clientID != idp.config.IdpClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(idp.config.IdpClientSecret)) != 1 {
+		c.JSON(http.StatusUnauthorized, openidprovider.TokenErrorTO{Error: "invalid_client"})
+		return
+	}
+
+	idp.mutex.Lock()
+	authorization, found := idp.authorizations[c.PostForm("code")]
+	delete(idp.authorizations, c.PostForm("code"))
+	idp.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+	if c.PostForm("grant_type") != "authorization_code" || !found || authorization.redirectURI != c.PostForm("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.codeChallenge {
+		c.JSON(http.StatusBadRequest, openidprovider.TokenErrorTO{Error: "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.config.IdpIssuer,
+		"sub":            authorization.identity.Subject,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          authorization.nonce,
+		"email":          authorization.identity.Email,
+		"email_verified": authorization.identity.EmailVerified,
+		"name":           authorization.identity.Name,
+	})
+	token.Header["kid"] = mockIdpKeyID
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, errs.Wrap("cannot sign id token", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, openidprovider.TokenResponseTO{
+		AccessToken: random.MakeRandomURLSafeB64(21),
+		TokenType:   "Bearer",
+		ExpiresIn:   300,
+		IDToken:     idToken,
+	})
+}
+
+func (idp *mockIdentityProvider) jwks(c *gin.Context) {
+	publicKey := idp.key.PublicKey
+	c.JSON(http.StatusOK, openidprovider.JwksTO{Keys: []openidprovider.JwkTO{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: mockIdpKeyID,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}}})
+}
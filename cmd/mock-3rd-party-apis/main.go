@@ -17,7 +17,10 @@ import (
 type Emails map[string][]emailapi.EmailTO
 
 type Config struct {
-	Port string `env:"MOCK_API_PORT"`
+	Port            string `env:"MOCK_API_PORT"`
+	IdpIssuer       string `env:"MOCK_IDP_ISSUER" envDefault:"http://localhost:8081/mock-idp"`
+	IdpClientID     string `env:"MOCK_IDP_CLIENT_ID" envDefault:"mock-client"`
+	IdpClientSecret string `env:"MOCK_IDP_CLIENT_SECRET" envDefault:"mock-client-secret"`
 }
 
 func main() {
@@ -69,6 +72,10 @@ func startServer() error {
 
 	})
 
+	if err := registerMockIdentityProvider(app, config); err != nil {
+		return errs.Wrap("cannot setup mock identity provider", err)
+	}
+
 	if err := httputil.RunHttpServer(&http.Server{
 		Addr:    ":" + config.Port,
 		Handler: app,
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"github.com/caarlos0/env/v6"
+	"log/slog"
+	"user-manager/cmd/app/service/ledger"
+	"user-manager/util/command"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/logger"
+)
+
+// Config does not contain the signing key, so the ledger can be verified by someone who cannot write checkpoints
+type Config struct {
+	DbInfo          db.Info
+	Environment     string `env:"ENVIRONMENT"`
+	LedgerPublicKey string `env:"LEDGER_PUBLIC_KEY"`
+}
+
+func main() {
+	slog.SetDefault(logger.NewLogger(false))
+	command.Run(verifyLedger)
+}
+
+// verifyLedger walks the security ledger from its first entry and fails with the first broken link. Entries after the
+// last checkpoint are only protected by the hash chain and are reported as unsigned.
+func verifyLedger() error {
+	config := Config{}
+	if err := env.Parse(&config, env.Options{RequiredIfNoDef: true}); err != nil {
+		return errs.Wrap("error parsing env", err)
+	}
+
+	if config.Environment != "local" {
+		slog.SetDefault(logger.NewLogger(true))
+	}
+
+	publicKey, err := ledger.ParsePublicKey(config.LedgerPublicKey)
+	if err != nil {
+		return errs.Wrap("cannot parse ledger public key", err)
+	}
+
+	database, err := db.OpenDbConnection(config.DbInfo)
+	if err != nil {
+		return errs.Wrap("issue opening db connection", err)
+	}
+	defer db.CloseOrPanic(database.Client())
+
+	ctx := context.Background()
+	checkpoints, err := ledger.GetCheckpoints(ctx, database)
+	if err != nil {
+		return errs.Wrap("issue loading checkpoints", err)
+	}
+	verifier, err := ledger.NewVerifier(publicKey, checkpoints)
+	if err != nil {
+		return errs.Wrap("ledger verification failed", err)
+	}
+	if err = ledger.ForEachEntry(ctx, database, verifier.Check); err != nil {
+		return errs.Wrap("ledger verification failed", err)
+	}
+	entries, unsignedEntries, err := verifier.Finish()
+	if err != nil {
+		return errs.Wrap("ledger verification failed", err)
+	}
+
+	slog.Info("Ledger intact", "entries", entries, "checkpoints", len(checkpoints), "unsignedEntries", unsignedEntries)
+	return nil
+}
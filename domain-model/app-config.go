@@ -8,12 +8,34 @@ import (
 )
 
 type Config struct {
-	DbInfo      db.Info
-	AppPort     string `env:"PORT"`
-	AppUrl      string `env:"APP_URL"`
-	ServiceName string `env:"SERVICE_NAME"`
-	EmailFrom   string `env:"EMAIL_FROM"`
-	Environment string `env:"ENVIRONMENT"`
+	DbInfo                    db.Info
+	AppPort                   string                    `env:"PORT"`
+	AppUrl                    string                    `env:"APP_URL"`
+	TrustedProxies            []string                  `env:"TRUSTED_PROXIES" envDefault:""`
+	ServiceName               string                    `env:"SERVICE_NAME"`
+	EmailFrom                 string                    `env:"EMAIL_FROM"`
+	Environment               string                    `env:"ENVIRONMENT"`
+	PasswordlessLoginEnabled  bool                      `env:"PASSWORDLESS_LOGIN_ENABLED" envDefault:"false"`
+	MagicLinkLoginEnabled     bool                      `env:"MAGIC_LINK_LOGIN_ENABLED" envDefault:"false"`
+	TokenHashSecret           string                    `env:"TOKEN_HASH_SECRET"`
+	Argon2idTime              uint32                    `env:"ARGON2ID_TIME" envDefault:"1"`
+	Argon2idMemoryKiB         uint32                    `env:"ARGON2ID_MEMORY_KIB" envDefault:"65536"`
+	Argon2idThreads           uint8                     `env:"ARGON2ID_THREADS" envDefault:"4"`
+	PasswordMinLength         int                       `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	PasswordMaxLength         int                       `env:"PASSWORD_MAX_LENGTH" envDefault:"128"`
+	PasswordBlocklist         []string                  `env:"PASSWORD_BLOCKLIST" envDefault:""`
+	BreachedPasswordsFile     string                    `env:"BREACHED_PASSWORDS_FILE" envDefault:""`
+	AccountLockThreshold      int                       `env:"ACCOUNT_LOCK_THRESHOLD" envDefault:"20"`
+	RateLimitStore            string                    `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	LedgerSigningKey          string                    `env:"LEDGER_SIGNING_KEY"`
+	OidcKeyEncryptionSecret   string                    `env:"OIDC_KEY_ENCRYPTION_SECRET"`
+	CspReportOnly             bool                      `env:"CSP_REPORT_ONLY" envDefault:"false"`
+	HstsMaxAge                int                       `env:"HSTS_MAX_AGE" envDefault:"31536000"`
+	ExternalIdentityProviders ExternalIdentityProviders `env:"EXTERNAL_IDENTITY_PROVIDERS" envDefault:""`
+	ForwardAuthRules          ForwardAuthRules          `env:"FORWARD_AUTH_RULES" envDefault:""`
+	ForwardAuthCookieDomain   string                    `env:"FORWARD_AUTH_COOKIE_DOMAIN" envDefault:""`
+	ScimBearerToken           string                    `env:"SCIM_BEARER_TOKEN" envDefault:""`
+	AccountDeletionGraceDays  int                       `env:"ACCOUNT_DELETION_GRACE_DAYS" envDefault:"14"`
 }
 
 const (
@@ -21,6 +43,8 @@ const (
 	SudoSessionDuration        = 10 * time.Minute
 	DeviceSessionDuration      = 30 * 24 * time.Hour
 	PasswordResetTokenDuration = 1 * time.Hour
+	MagicLinkTokenDuration     = 15 * time.Minute
+	AdminInvitationDuration    = 72 * time.Hour
 )
 
 func (conf *Config) IsLocalEnv() bool {
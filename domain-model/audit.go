@@ -0,0 +1,97 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type AuditEventType string
+type AuditOutcome string
+
+const (
+	AuditEventTypeLogin                  AuditEventType = "login"
+	AuditEventTypeSecondFactor           AuditEventType = "second-factor"
+	AuditEventTypeSudo                   AuditEventType = "sudo"
+	AuditEventTypeLogout                 AuditEventType = "logout"
+	AuditEventTypePasswordChange         AuditEventType = "password-change"
+	AuditEventTypePasswordReset          AuditEventType = "password-reset"
+	AuditEventTypeEmailChange            AuditEventType = "email-change"
+	AuditEventTypeSecondFactorChange     AuditEventType = "second-factor-change"
+	AuditEventTypeRoleGrant              AuditEventType = "role-grant"
+	AuditEventTypeRoleRevoke             AuditEventType = "role-revoke"
+	AuditEventTypeAccountLock            AuditEventType = "account-lock"
+	AuditEventTypeAccountUnlock          AuditEventType = "account-unlock"
+	AuditEventTypeOidcConsent            AuditEventType = "oidc-consent"
+	AuditEventTypeOidcClientCreate       AuditEventType = "oidc-client-create"
+	AuditEventTypeOidcClientDelete       AuditEventType = "oidc-client-delete"
+	AuditEventTypeExternalIdentityLink   AuditEventType = "external-identity-link"
+	AuditEventTypeExternalIdentityUnlink AuditEventType = "external-identity-unlink"
+	AuditEventTypeAccessTokenCreate      AuditEventType = "access-token-create"
+	AuditEventTypeAccessTokenRevoke      AuditEventType = "access-token-revoke"
+	AuditEventTypeUserProvision          AuditEventType = "user-provision"
+	AuditEventTypeUserDeprovision        AuditEventType = "user-deprovision"
+	AuditEventTypeAccountDeletionRequest AuditEventType = "account-deletion-request"
+	AuditEventTypeAccountDeletionCancel  AuditEventType = "account-deletion-cancel"
+	AuditEventTypeRoleDefinitionSave     AuditEventType = "role-definition-save"
+	AuditEventTypeRoleDefinitionDelete   AuditEventType = "role-definition-delete"
+	AuditEventTypeAdminCredentialsReset  AuditEventType = "admin-credentials-reset"
+
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+
+	AuditEventCollectionName = "auditEvents"
+)
+
+var AuditEventTypes = []AuditEventType{
+	AuditEventTypeLogin,
+	AuditEventTypeSecondFactor,
+	AuditEventTypeSudo,
+	AuditEventTypeLogout,
+	AuditEventTypePasswordChange,
+	AuditEventTypePasswordReset,
+	AuditEventTypeEmailChange,
+	AuditEventTypeSecondFactorChange,
+	AuditEventTypeRoleGrant,
+	AuditEventTypeRoleRevoke,
+	AuditEventTypeAccountLock,
+	AuditEventTypeAccountUnlock,
+	AuditEventTypeOidcConsent,
+	AuditEventTypeOidcClientCreate,
+	AuditEventTypeOidcClientDelete,
+	AuditEventTypeExternalIdentityLink,
+	AuditEventTypeExternalIdentityUnlink,
+	AuditEventTypeAccessTokenCreate,
+	AuditEventTypeAccessTokenRevoke,
+	AuditEventTypeUserProvision,
+	AuditEventTypeUserDeprovision,
+	AuditEventTypeAccountDeletionRequest,
+	AuditEventTypeAccountDeletionCancel,
+	AuditEventTypeRoleDefinitionSave,
+	AuditEventTypeRoleDefinitionDelete,
+	AuditEventTypeAdminCredentialsReset,
+}
+
+// AuditEvent records a security-relevant action. Events are only ever inserted, never updated or deleted.
+type AuditEvent struct {
+	ObjectID       primitive.ObjectID `bson:"_id,omitempty"`
+	Type           AuditEventType     `bson:"type"`
+	Outcome        AuditOutcome       `bson:"outcome"`
+	ActorID        primitive.ObjectID `bson:"actorID,omitempty"`
+	SubjectID      primitive.ObjectID `bson:"subjectID,omitempty"`
+	OrganizationID primitive.ObjectID `bson:"organizationID,omitempty"`
+	ClientIP       string             `bson:"clientIP"`
+	UserAgent      string             `bson:"userAgent"`
+	RequestID      string             `bson:"requestID"`
+	Details        map[string]string  `bson:"details,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+}
+
+// AuditEventFilter narrows down audit event queries. Zero values do not filter.
+type AuditEventFilter struct {
+	Type    AuditEventType
+	Outcome AuditOutcome
+	// UserID matches events where the user is either actor or subject
+	UserID primitive.ObjectID
+	From   time.Time
+	To     time.Time
+}
@@ -0,0 +1,77 @@
+package domain_model
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+	"user-manager/util/errs"
+)
+
+type ExternalLoginPurpose string
+
+const (
+	ExternalLoginPurposeLogin ExternalLoginPurpose = "LOGIN"
+	ExternalLoginPurposeLink  ExternalLoginPurpose = "LINK"
+
+	ExternalLoginCollectionName = "externalLogins"
+	ExternalLoginDuration       = 10 * time.Minute
+)
+
+// ExternalIdentityProvider is an OpenID Connect provider users can sign in with, e.g. a corporate Google or Microsoft tenant
+type ExternalIdentityProvider struct {
+	Name         string `json:"name"`
+	DisplayName  string `json:"displayName"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// ExternalIdentityProviders is configured as a JSON array of ExternalIdentityProvider
+type ExternalIdentityProviders []ExternalIdentityProvider
+
+func (p *ExternalIdentityProviders) UnmarshalText(text []byte) error {
+	var providers []ExternalIdentityProvider
+	if err := json.Unmarshal(text, &providers); err != nil {
+		return errs.Wrap("cannot parse external identity providers", err)
+	}
+	for _, provider := range providers {
+		if provider.Name == "" || provider.Issuer == "" || provider.ClientID == "" {
+			return errs.Error("external identity providers require a name, issuer and client id")
+		}
+	}
+	*p = providers
+	return nil
+}
+
+func (p ExternalIdentityProviders) Get(name string) (ExternalIdentityProvider, bool) {
+	for _, provider := range p {
+		if provider.Name == name {
+			return provider, true
+		}
+	}
+	return ExternalIdentityProvider{}, false
+}
+
+// ExternalIdentity links an account at an external provider to a user. The subject is the provider's stable identifier for the account.
+type ExternalIdentity struct {
+	Provider string    `bson:"provider"`
+	Subject  string    `bson:"subject"`
+	Email    string    `bson:"email,omitempty"`
+	LinkedAt time.Time `bson:"linkedAt"`
+}
+
+// ExternalLogin holds the state of an authorization request to an external provider until the provider redirects back
+type ExternalLogin struct {
+	ObjectID     primitive.ObjectID   `bson:"_id,omitempty"`
+	StateHash    string               `bson:"stateHash"`
+	Provider     string               `bson:"provider"`
+	Purpose      ExternalLoginPurpose `bson:"purpose"`
+	UserID       primitive.ObjectID   `bson:"userID,omitempty"`
+	Nonce        string               `bson:"nonce"`
+	CodeVerifier string               `bson:"codeVerifier"`
+	TimeoutAt    time.Time            `bson:"timeoutAt"`
+}
+
+func (l ExternalLogin) IsPresent() bool {
+	return l.ObjectID != primitive.NilObjectID
+}
@@ -0,0 +1,91 @@
+package domain_model
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"user-manager/util/errs"
+)
+
+// ForwardAuthRule protects a host behind a reverse proxy. Requests below PathPrefix require one of Roles, directly or
+// through inheritance. Without roles, every user with app access is admitted. The prefix matches whole path segments,
+// so /admin covers /admin/settings but not /administration.
+type ForwardAuthRule struct {
+	Host       string     `json:"host"`
+	PathPrefix string     `json:"pathPrefix"`
+	Roles      []UserRole `json:"roles"`
+}
+
+func (r ForwardAuthRule) IsPresent() bool {
+	return r.Host != ""
+}
+
+func (r ForwardAuthRule) Admits(definitions map[UserRole]Role, userRoles []UserRole) bool {
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, userRole := range userRoles {
+		for _, required := range r.Roles {
+			if userRole == required || InheritsFrom(definitions, userRole, required) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ForwardAuthRules is configured as a JSON array of ForwardAuthRule
+type ForwardAuthRules []ForwardAuthRule
+
+func (rules *ForwardAuthRules) UnmarshalText(text []byte) error {
+	var parsed []ForwardAuthRule
+	if err := json.Unmarshal(text, &parsed); err != nil {
+		return errs.Wrap("cannot parse forward auth rules", err)
+	}
+	for i, rule := range parsed {
+		if rule.Host == "" {
+			return errs.Error("forward auth rules require a host")
+		}
+		if rule.PathPrefix != "" {
+			if !strings.HasPrefix(rule.PathPrefix, "/") {
+				return errs.Errorf("forward auth path prefix %s must start with /", rule.PathPrefix)
+			}
+			parsed[i].PathPrefix = path.Clean(rule.PathPrefix)
+		}
+	}
+	*rules = parsed
+	return nil
+}
+
+// Match returns the rule with the longest path prefix matching the request. The path is decoded and cleaned first, as
+// the protected host would resolve it, so that dot segments, duplicate slashes and escaped characters cannot sidestep a
+// rule. Paths that cannot be decoded and requests to hosts without rules match none.
+func (rules ForwardAuthRules) Match(host string, requestPath string) ForwardAuthRule {
+	var match ForwardAuthRule
+	unescaped, err := url.PathUnescape(requestPath)
+	if err != nil {
+		return match
+	}
+	cleaned := path.Clean("/" + unescaped)
+	for _, rule := range rules {
+		if strings.EqualFold(rule.Host, host) && hasPathPrefix(cleaned, rule.PathPrefix) &&
+			(!match.IsPresent() || len(rule.PathPrefix) > len(match.PathPrefix)) {
+			match = rule
+		}
+	}
+	return match
+}
+
+func hasPathPrefix(cleanedPath string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || cleanedPath == prefix || strings.HasPrefix(cleanedPath, prefix+"/")
+}
+
+// HasHost reports whether the host is protected by a rule. Only such hosts are valid destinations after login.
+func (rules ForwardAuthRules) HasHost(host string) bool {
+	return slices.ContainsFunc(rules, func(rule ForwardAuthRule) bool {
+		return strings.EqualFold(rule.Host, host)
+	})
+}
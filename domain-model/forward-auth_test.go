@@ -0,0 +1,45 @@
+package domain_model
+
+import "testing"
+
+func TestForwardAuthRulesMatch(t *testing.T) {
+	var rules ForwardAuthRules
+	err := rules.UnmarshalText([]byte(`[
+		{"host": "tools.localhost"},
+		{"host": "tools.localhost", "pathPrefix": "/admin/", "roles": ["admin"]},
+		{"host": "tools.localhost", "pathPrefix": "/admin/audit", "roles": ["super-admin"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		host           string
+		path           string
+		expectedPrefix string
+		expectedMatch  bool
+	}{
+		{"root", "tools.localhost", "/", "", true},
+		{"open path", "tools.localhost", "/dashboard", "", true},
+		{"prefix itself", "tools.localhost", "/admin", "/admin", true},
+		{"below prefix", "tools.localhost", "/admin/settings", "/admin", true},
+		{"longest prefix", "tools.localhost", "/admin/audit/events", "/admin/audit", true},
+		{"partial segment", "tools.localhost", "/administration", "", true},
+		{"host is case insensitive", "TOOLS.localhost", "/admin", "/admin", true},
+		{"dot segments", "tools.localhost", "/dashboard/../admin/x", "/admin", true},
+		{"escaped characters", "tools.localhost", "/%61dmin", "/admin", true},
+		{"escaped slash", "tools.localhost", "/admin%2Fsettings", "/admin", true},
+		{"duplicate slashes", "tools.localhost", "//admin", "/admin", true},
+		{"relative path", "tools.localhost", "admin", "/admin", true},
+		{"invalid escaping", "tools.localhost", "/admin%zz", "", false},
+		{"unknown host", "unknown.localhost", "/", "", false},
+	}
+
+	for _, test := range tests {
+		rule := rules.Match(test.host, test.path)
+		if rule.IsPresent() != test.expectedMatch || rule.PathPrefix != test.expectedPrefix {
+			t.Errorf("%s: expected prefix %q (match %v), got %q (match %v)", test.name, test.expectedPrefix, test.expectedMatch, rule.PathPrefix, rule.IsPresent())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type LedgerEntryType string
+
+const (
+	LedgerEntryTypeCredentialsChange LedgerEntryType = "credentials-change"
+	LedgerEntryTypeEmailChange       LedgerEntryType = "email-change"
+	LedgerEntryTypeRoleGrant         LedgerEntryType = "role-grant"
+	LedgerEntryTypeRoleRevoke        LedgerEntryType = "role-revoke"
+
+	LedgerEntryCollectionName      = "ledgerEntries"
+	LedgerCheckpointCollectionName = "ledgerCheckpoints"
+
+	// LedgerCheckpointInterval is the number of entries after which a signed checkpoint is written
+	LedgerCheckpointInterval = 100
+)
+
+// LedgerEntry is one link of the hash chain. Hash covers the content of the entry and PreviousHash, so changing or
+// removing any entry breaks all links after it.
+type LedgerEntry struct {
+	Sequence     int64              `bson:"_id"`
+	Type         LedgerEntryType    `bson:"type"`
+	UserID       primitive.ObjectID `bson:"userID"`
+	ActorID      primitive.ObjectID `bson:"actorID,omitempty"`
+	Details      map[string]string  `bson:"details,omitempty"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+	PreviousHash string             `bson:"previousHash"`
+	Hash         string             `bson:"hash"`
+}
+
+// LedgerCheckpoint holds a signature over the hash of the entry with the same sequence number. It prevents rewriting
+// the whole chain, which the hashes alone cannot detect.
+type LedgerCheckpoint struct {
+	Sequence  int64     `bson:"_id"`
+	Hash      string    `bson:"hash"`
+	Signature string    `bson:"signature"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
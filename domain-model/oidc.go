@@ -0,0 +1,116 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+	"user-manager/util/slices"
+)
+
+type OidcScope string
+
+const (
+	OidcScopeOpenID        OidcScope = "openid"
+	OidcScopeProfile       OidcScope = "profile"
+	OidcScopeEmail         OidcScope = "email"
+	OidcScopeOfflineAccess OidcScope = "offline_access"
+
+	OidcClientCollectionName            = "oidcClients"
+	OidcAuthorizationCodeCollectionName = "oidcAuthorizationCodes"
+	OidcRefreshTokenCollectionName      = "oidcRefreshTokens"
+	OidcConsentCollectionName           = "oidcConsents"
+	OidcSigningKeyCollectionName        = "oidcSigningKeys"
+
+	OidcAuthorizationCodeDuration = 1 * time.Minute
+	OidcAccessTokenDuration       = 15 * time.Minute
+	OidcIDTokenDuration           = 15 * time.Minute
+	OidcRefreshTokenDuration      = 30 * 24 * time.Hour
+
+	// OidcKeyRotationInterval is how long a signing key is used before the next one takes over
+	OidcKeyRotationInterval = 30 * 24 * time.Hour
+	// OidcKeyPublishDelay is how long a new key is published in the JWKS before tokens are signed with it, so relying parties caching the JWKS know it in time
+	OidcKeyPublishDelay = 24 * time.Hour
+	// OidcKeyRetention is how long a key stays published, which must exceed the rotation interval plus the lifetime of the tokens it signed
+	OidcKeyRetention = 2 * OidcKeyRotationInterval
+)
+
+var OidcScopes = []OidcScope{OidcScopeOpenID, OidcScopeProfile, OidcScopeEmail, OidcScopeOfflineAccess}
+
+// OidcClient is an application that lets its users log in through this service
+type OidcClient struct {
+	ObjectID     primitive.ObjectID `bson:"_id,omitempty"`
+	ClientID     string             `bson:"clientID"`
+	Name         string             `bson:"name"`
+	SecretHash   string             `bson:"secretHash"`
+	RedirectURIs []string           `bson:"redirectURIs"`
+	CreatedBy    primitive.ObjectID `bson:"createdBy"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+}
+
+func (c OidcClient) IsPresent() bool {
+	return c.ObjectID != primitive.NilObjectID
+}
+
+func (c OidcClient) AllowsRedirectURI(redirectURI string) bool {
+	return slices.Contains(c.RedirectURIs, redirectURI)
+}
+
+// OidcAuthorizationCode is issued after the user consented and can be exchanged for tokens once
+type OidcAuthorizationCode struct {
+	CodeHash      string             `bson:"_id"`
+	ClientID      string             `bson:"clientID"`
+	UserID        primitive.ObjectID `bson:"userID"`
+	RedirectURI   string             `bson:"redirectURI"`
+	Scopes        []OidcScope        `bson:"scopes"`
+	Nonce         string             `bson:"nonce,omitempty"`
+	CodeChallenge string             `bson:"codeChallenge"`
+	AuthTime      time.Time          `bson:"authTime"`
+	ExpiresAt     time.Time          `bson:"expiresAt"`
+}
+
+func (c OidcAuthorizationCode) IsPresent() bool {
+	return c.CodeHash != ""
+}
+
+// OidcRefreshToken is rotated on every use, so each stored token can only be redeemed once
+type OidcRefreshToken struct {
+	TokenHash string             `bson:"_id"`
+	ClientID  string             `bson:"clientID"`
+	UserID    primitive.ObjectID `bson:"userID"`
+	Scopes    []OidcScope        `bson:"scopes"`
+	AuthTime  time.Time          `bson:"authTime"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+func (t OidcRefreshToken) IsPresent() bool {
+	return t.TokenHash != ""
+}
+
+// OidcConsent records the scopes a user granted to a client, so the consent screen is only shown for new scopes
+type OidcConsent struct {
+	ObjectID  primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID"`
+	ClientID  string             `bson:"clientID"`
+	Scopes    []OidcScope        `bson:"scopes"`
+	GrantedAt time.Time          `bson:"grantedAt"`
+}
+
+// Covers reports whether all requested scopes were granted before
+func (c OidcConsent) Covers(scopes []OidcScope) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// OidcSigningKey is an RSA key the ID and access tokens are signed with. The PKCS #1 encoded private key is stored
+// encrypted with a key derived from OidcKeyEncryptionSecret.
+type OidcSigningKey struct {
+	KeyID               string    `bson:"_id"`
+	EncryptedPrivateKey []byte    `bson:"encryptedPrivateKey"`
+	CreatedAt           time.Time `bson:"createdAt"`
+	// RotationSlot is the rotation interval the key was created in. It is unique, so concurrent requests cannot both
+	// create the key of an interval.
+	RotationSlot int64 `bson:"rotationSlot,omitempty"`
+}
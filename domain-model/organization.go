@@ -0,0 +1,85 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type OrganizationID primitive.ObjectID
+type OrganizationRole string
+
+const (
+	OrganizationRoleOwner  OrganizationRole = "owner"
+	OrganizationRoleAdmin  OrganizationRole = "admin"
+	OrganizationRoleMember OrganizationRole = "member"
+
+	OrganizationCollectionName           = "organizations"
+	MembershipCollectionName             = "memberships"
+	OrganizationInvitationCollectionName = "organizationInvitations"
+
+	OrganizationInvitationDuration = 7 * 24 * time.Hour
+)
+
+var OrganizationRoles = []OrganizationRole{OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleMember}
+
+type Organization struct {
+	ObjectID  primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+func (o Organization) ID() OrganizationID {
+	return OrganizationID(o.ObjectID)
+}
+
+func (o Organization) IDHex() string {
+	return o.ObjectID.Hex()
+}
+
+func (o Organization) IsPresent() bool {
+	return o.ObjectID != primitive.NilObjectID
+}
+
+// Membership links a user to an organization. The role only applies within that organization.
+type Membership struct {
+	ObjectID       primitive.ObjectID `bson:"_id,omitempty"`
+	OrganizationID primitive.ObjectID `bson:"organizationID"`
+	UserID         primitive.ObjectID `bson:"userID"`
+	Role           OrganizationRole   `bson:"role"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+}
+
+func (m Membership) IsPresent() bool {
+	return m.ObjectID != primitive.NilObjectID
+}
+
+// CanManageMembers reports whether the member may invite and remove other members
+func (m Membership) CanManageMembers() bool {
+	return m.Role == OrganizationRoleOwner || m.Role == OrganizationRoleAdmin
+}
+
+type OrganizationInvitation struct {
+	ObjectID       primitive.ObjectID `bson:"_id,omitempty"`
+	OrganizationID primitive.ObjectID `bson:"organizationID"`
+	Email          string             `bson:"email"`
+	Role           OrganizationRole   `bson:"role"`
+	TokenHash      string             `bson:"tokenHash"`
+	InvitedBy      primitive.ObjectID `bson:"invitedBy"`
+	ValidUntil     time.Time          `bson:"validUntil"`
+}
+
+func (i OrganizationInvitation) IsPresent() bool {
+	return i.ObjectID != primitive.NilObjectID
+}
+
+// OrganizationMembership is an organization as seen by one of its members
+type OrganizationMembership struct {
+	Organization Organization
+	Role         OrganizationRole
+}
+
+// OrganizationMember is a member as seen within an organization
+type OrganizationMember struct {
+	User       User
+	Membership Membership
+}
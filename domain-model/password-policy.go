@@ -0,0 +1,12 @@
+package domain_model
+
+type PasswordPolicyViolation string
+
+const (
+	PasswordPolicyViolationTooShort      PasswordPolicyViolation = "too-short"
+	PasswordPolicyViolationTooLong       PasswordPolicyViolation = "too-long"
+	PasswordPolicyViolationContainsEmail PasswordPolicyViolation = "contains-email"
+	PasswordPolicyViolationContainsName  PasswordPolicyViolation = "contains-name"
+	PasswordPolicyViolationBlocklisted   PasswordPolicyViolation = "blocklisted"
+	PasswordPolicyViolationBreached      PasswordPolicyViolation = "breached"
+)
@@ -0,0 +1,36 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	PersonalAccessTokenCollectionName = "personalAccessTokens"
+	// PersonalAccessTokenPrefix makes leaked tokens easy to recognize for secret scanners
+	PersonalAccessTokenPrefix = "umpat_"
+	// PersonalAccessTokenUsageResolution limits how often the last-used timestamp is written
+	PersonalAccessTokenUsageResolution = 1 * time.Minute
+	PersonalAccessTokenMaxDuration     = 365 * 24 * time.Hour
+)
+
+// PersonalAccessToken lets scripts call the JSON endpoints on behalf of a user. Its scopes are permissions and limit
+// what the token can do to the intersection with the permissions of the user's roles.
+type PersonalAccessToken struct {
+	ObjectID   primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userID"`
+	Name       string             `bson:"name"`
+	TokenHash  string             `bson:"tokenHash"`
+	Scopes     []Permission       `bson:"scopes"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+	LastUsedAt time.Time          `bson:"lastUsedAt,omitempty"`
+}
+
+func (t PersonalAccessToken) IsPresent() bool {
+	return t.ObjectID != primitive.NilObjectID
+}
+
+func (t PersonalAccessToken) IDHex() string {
+	return t.ObjectID.Hex()
+}
@@ -0,0 +1,39 @@
+package domain_model
+
+import (
+	"math"
+	"time"
+)
+
+const RateLimitBucketCollectionName = "rateLimitBuckets"
+
+// RateLimit is a token bucket allowing Capacity requests at once and one more request every RefillInterval
+type RateLimit struct {
+	// Name separates the buckets of limits that use the same key
+	Name           string
+	Capacity       int
+	RefillInterval time.Duration
+}
+
+// Refill returns the tokens available after the elapsed time
+func (l RateLimit) Refill(tokens float64, elapsed time.Duration) float64 {
+	return math.Min(float64(l.Capacity), tokens+float64(elapsed)/float64(l.RefillInterval))
+}
+
+// TimeUntilToken returns how long it takes until a bucket holding tokens has a full token again
+func (l RateLimit) TimeUntilToken(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) * float64(l.RefillInterval))
+}
+
+// FullAfter is the time after which an empty bucket is full again and can be forgotten
+func (l RateLimit) FullAfter() time.Duration {
+	return time.Duration(l.Capacity) * l.RefillInterval
+}
+
+type RateLimitBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	Allowed   bool      `bson:"allowed"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
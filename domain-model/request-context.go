@@ -6,9 +6,19 @@ import (
 )
 
 type RequestContext struct {
-	RequestID string
-	User      User
-	Database  *mongo.Database
-	Logger    *slog.Logger
-	Config    *Config
+	RequestID   string
+	User        User
+	Permissions PermissionSet
+	// Organization is the organization the user switched to in the current session, if any
+	Organization Organization
+	Membership   Membership
+	// PersonalAccessToken is the token the request was authenticated with instead of a login session, if any
+	PersonalAccessToken PersonalAccessToken
+	Database            *mongo.Database
+	Logger              *slog.Logger
+	Config              *Config
+	// CsrfToken is the token the page must send back in the X-CSRF-Token header of state-changing requests
+	CsrfToken string
+	// CspNonce is the nonce the Content-Security-Policy of the response allows scripts with
+	CspNonce string
 }
@@ -0,0 +1,132 @@
+package domain_model
+
+type Permission string
+
+const (
+	PermissionAppUse         Permission = "app:use"
+	PermissionUsersRead      Permission = "users:read"
+	PermissionUsersVerify    Permission = "users:verify"
+	PermissionSessionsRevoke Permission = "sessions:revoke"
+	PermissionMailResend     Permission = "mail:resend"
+	PermissionAuditRead      Permission = "audit:read"
+	PermissionAdminsManage   Permission = "admins:manage"
+	PermissionRolesManage    Permission = "roles:manage"
+
+	RoleCollectionName = "roles"
+)
+
+// AllPermissions lists every permission a role definition may contain
+var AllPermissions = []Permission{
+	PermissionAppUse,
+	PermissionUsersRead,
+	PermissionUsersVerify,
+	PermissionSessionsRevoke,
+	PermissionMailResend,
+	PermissionAuditRead,
+	PermissionAdminsManage,
+	PermissionRolesManage,
+}
+
+// Role is the definition of a UserRole. Its name is the value stored in User.UserRoles.
+type Role struct {
+	Name        UserRole     `bson:"_id"`
+	Description string       `bson:"description"`
+	Permissions []Permission `bson:"permissions"`
+	Inherits    []UserRole   `bson:"inherits,omitempty"`
+	Builtin     bool         `bson:"builtin,omitempty"`
+}
+
+func (r Role) IsPresent() bool {
+	return r.Name != ""
+}
+
+// DefaultRoles are seeded by the migrations and cannot be deleted
+var DefaultRoles = []Role{
+	{
+		Name:        UserRoleUser,
+		Description: "Regular user of the app",
+		Permissions: []Permission{PermissionAppUse},
+		Builtin:     true,
+	},
+	{
+		Name:        UserRoleAdmin,
+		Description: "Supports users",
+		Permissions: []Permission{PermissionUsersRead, PermissionUsersVerify, PermissionSessionsRevoke, PermissionMailResend, PermissionAuditRead},
+		Builtin:     true,
+	},
+	{
+		Name:        UserRoleSuperAdmin,
+		Description: "Manages admins and role definitions",
+		Permissions: []Permission{PermissionAdminsManage, PermissionRolesManage},
+		Inherits:    []UserRole{UserRoleAdmin},
+		Builtin:     true,
+	},
+}
+
+type PermissionSet map[Permission]bool
+
+func (p PermissionSet) Has(permission Permission) bool {
+	return p[permission]
+}
+
+// Restrict returns the permissions of the set that are also contained in the given list
+func (p PermissionSet) Restrict(permissions []Permission) PermissionSet {
+	restricted := PermissionSet{}
+	for _, permission := range permissions {
+		if p[permission] {
+			restricted[permission] = true
+		}
+	}
+	return restricted
+}
+
+// ResolvePermissions collects the permissions of the given roles including all inherited ones. Unknown roles and
+// inheritance cycles are ignored.
+func ResolvePermissions(definitions map[UserRole]Role, roles []UserRole) PermissionSet {
+	permissions := PermissionSet{}
+	visited := map[UserRole]bool{}
+
+	var visit func(role UserRole)
+	visit = func(role UserRole) {
+		if visited[role] {
+			return
+		}
+		visited[role] = true
+		definition, ok := definitions[role]
+		if !ok {
+			return
+		}
+		for _, permission := range definition.Permissions {
+			permissions[permission] = true
+		}
+		for _, inherited := range definition.Inherits {
+			visit(inherited)
+		}
+	}
+
+	for _, role := range roles {
+		visit(role)
+	}
+	return permissions
+}
+
+// InheritsFrom reports whether role reaches ancestor by following the inheritance of the definitions
+func InheritsFrom(definitions map[UserRole]Role, role UserRole, ancestor UserRole) bool {
+	visited := map[UserRole]bool{}
+
+	var visit func(current UserRole) bool
+	visit = func(current UserRole) bool {
+		if visited[current] {
+			return false
+		}
+		visited[current] = true
+		for _, inherited := range definitions[current].Inherits {
+			if inherited == ancestor || visit(inherited) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return visit(role)
+}
@@ -0,0 +1,81 @@
+package domain_model
+
+import "testing"
+
+func testRoleDefinitions() map[UserRole]Role {
+	definitions := map[UserRole]Role{}
+	for _, role := range DefaultRoles {
+		definitions[role.Name] = role
+	}
+	definitions["support-lead"] = Role{
+		Name:        "support-lead",
+		Permissions: []Permission{PermissionAuditRead},
+		Inherits:    []UserRole{UserRoleAdmin, "missing"},
+	}
+	definitions["cycle-a"] = Role{Name: "cycle-a", Permissions: []Permission{PermissionAppUse}, Inherits: []UserRole{"cycle-b"}}
+	definitions["cycle-b"] = Role{Name: "cycle-b", Permissions: []Permission{PermissionMailResend}, Inherits: []UserRole{"cycle-a"}}
+	return definitions
+}
+
+func TestResolvePermissions(t *testing.T) {
+	definitions := testRoleDefinitions()
+
+	tests := []struct {
+		name     string
+		roles    []UserRole
+		expected []Permission
+	}{
+		{"no roles", nil, nil},
+		{"direct permissions", []UserRole{UserRoleUser}, []Permission{PermissionAppUse}},
+		{"inherited permissions", []UserRole{UserRoleSuperAdmin}, []Permission{
+			PermissionAdminsManage, PermissionRolesManage, PermissionUsersRead, PermissionUsersVerify,
+			PermissionSessionsRevoke, PermissionMailResend, PermissionAuditRead,
+		}},
+		{"unknown role", []UserRole{"missing"}, nil},
+		{"unknown inherited role", []UserRole{"support-lead"}, []Permission{
+			PermissionAuditRead, PermissionUsersRead, PermissionUsersVerify, PermissionSessionsRevoke, PermissionMailResend,
+		}},
+		{"inheritance cycle", []UserRole{"cycle-a"}, []Permission{PermissionAppUse, PermissionMailResend}},
+		{"several roles", []UserRole{UserRoleUser, "cycle-b"}, []Permission{PermissionAppUse, PermissionMailResend}},
+	}
+
+	for _, test := range tests {
+		permissions := ResolvePermissions(definitions, test.roles)
+		if len(permissions) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, permissions)
+			continue
+		}
+		for _, permission := range test.expected {
+			if !permissions.Has(permission) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, permissions)
+			}
+		}
+	}
+}
+
+func TestInheritsFrom(t *testing.T) {
+	definitions := testRoleDefinitions()
+
+	tests := []struct {
+		name     string
+		role     UserRole
+		ancestor UserRole
+		expected bool
+	}{
+		{"direct parent", UserRoleSuperAdmin, UserRoleAdmin, true},
+		{"not related", UserRoleAdmin, UserRoleUser, false},
+		{"child is no ancestor", UserRoleAdmin, UserRoleSuperAdmin, false},
+		{"role without inheritance does not reach itself", UserRoleUser, UserRoleUser, false},
+		{"unknown role", "missing", UserRoleAdmin, false},
+		{"unknown ancestor", "support-lead", "missing", true},
+		{"cycle reaches itself", "cycle-a", "cycle-a", true},
+		{"cycle reaches other member", "cycle-a", "cycle-b", true},
+		{"cycle terminates for unrelated ancestor", "cycle-a", UserRoleAdmin, false},
+	}
+
+	for _, test := range tests {
+		if actual := InheritsFrom(definitions, test.role, test.ancestor); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
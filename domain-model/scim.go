@@ -0,0 +1,15 @@
+package domain_model
+
+const (
+	ScimSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	ScimSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ScimSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// ScimMaxResults caps the page size of list requests
+	ScimMaxResults = 200
+)
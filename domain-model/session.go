@@ -0,0 +1,34 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const SessionCollectionName = "sessions"
+
+type UserSession struct {
+	ObjectID             primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash            string             `bson:"tokenHash,omitempty"`
+	UserID               primitive.ObjectID `bson:"userID,omitempty"`
+	Type                 UserSessionType    `bson:"type,omitempty"`
+	RequiresSecondFactor bool               `bson:"requiresSecondFactor,omitempty"`
+	TimeoutAt            time.Time          `bson:"timeoutAt,omitempty"`
+	CreatedAt            time.Time          `bson:"createdAt,omitempty"`
+	LastActivityAt       time.Time          `bson:"lastActivityAt,omitempty"`
+	UserAgent            string             `bson:"userAgent,omitempty"`
+	ClientIP             string             `bson:"clientIP,omitempty"`
+	ActiveOrganizationID primitive.ObjectID `bson:"activeOrganizationID,omitempty"`
+	// LoginSessionID is the login session a forward auth session was issued for, it ends together with it
+	LoginSessionID primitive.ObjectID `bson:"loginSessionID,omitempty"`
+	// Token is only known when the session is issued, the database only stores its hash
+	Token UserSessionToken `bson:"-"`
+}
+
+func (u UserSession) IDHex() string {
+	return u.ObjectID.Hex()
+}
+
+func (u UserSession) IsPresent() bool {
+	return u.ObjectID != primitive.NilObjectID
+}
@@ -0,0 +1,70 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"time"
+)
+
+type ThrottlingScope string
+
+const (
+	ThrottlingScopeLogin        ThrottlingScope = "login"
+	ThrottlingScopeSecondFactor ThrottlingScope = "second-factor"
+
+	ThrottleCollectionName = "throttles"
+
+	// ThrottleRetention is how long counters are kept after the last failure
+	ThrottleRetention = 24 * time.Hour
+)
+
+// ThrottlingPolicy describes the exponential backoff after repeated failures. The first FreeFailures failures are not
+// delayed, every further failure doubles the delay up to MaxDelay.
+type ThrottlingPolicy struct {
+	FreeFailures int32
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+func (p ThrottlingPolicy) Delay(failures int32) time.Duration {
+	if failures <= p.FreeFailures {
+		return 0
+	}
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(failures-p.FreeFailures-1))
+	if delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+var (
+	AccountThrottlingPolicy = ThrottlingPolicy{FreeFailures: 3, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute}
+	// IPThrottlingPolicy is more lenient since many users may share an address
+	IPThrottlingPolicy = ThrottlingPolicy{FreeFailures: 10, BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+)
+
+// Throttle counts consecutive failures for one key, e.g. the password logins of an account or the second factor
+// attempts from an IP address
+type Throttle struct {
+	Key          string             `bson:"_id"`
+	UserID       primitive.ObjectID `bson:"userID,omitempty"`
+	Failures     int32              `bson:"failures"`
+	BlockedUntil time.Time          `bson:"blockedUntil,omitempty"`
+	ExpiresAt    time.Time          `bson:"expiresAt"`
+}
+
+// ThrottleStatus tells whether an attempt may be made right now
+type ThrottleStatus struct {
+	BlockedUntil time.Time
+	// Locked accounts can only be unlocked with the link sent by email
+	Locked bool
+}
+
+func (s ThrottleStatus) IsThrottled() bool {
+	return s.Locked || s.BlockedUntil.After(time.Now())
+}
+
+// RetryAfter is the remaining time until the next attempt is allowed, rounded up to full seconds
+func (s ThrottleStatus) RetryAfter() time.Duration {
+	return time.Until(s.BlockedUntil).Truncate(time.Second) + time.Second
+}
@@ -0,0 +1,34 @@
+package domain_model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottlingPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ThrottlingPolicy
+		failures int32
+		expected time.Duration
+	}{
+		{"no failures", AccountThrottlingPolicy, 0, 0},
+		{"free failures", AccountThrottlingPolicy, 3, 0},
+		{"first delayed failure", AccountThrottlingPolicy, 4, 30 * time.Second},
+		{"doubling", AccountThrottlingPolicy, 5, time.Minute},
+		{"doubling again", AccountThrottlingPolicy, 8, 8 * time.Minute},
+		{"max delay", AccountThrottlingPolicy, 9, 15 * time.Minute},
+		{"max delay after many failures", AccountThrottlingPolicy, 1000, 15 * time.Minute},
+		{"ip free failures", IPThrottlingPolicy, 10, 0},
+		{"ip first delayed failure", IPThrottlingPolicy, 11, 30 * time.Second},
+		{"ip max delay", IPThrottlingPolicy, 18, time.Hour},
+		{"without free failures", ThrottlingPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 1, time.Second},
+	}
+
+	for _, test := range tests {
+		actual := test.policy.Delay(test.failures)
+		if actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
@@ -15,6 +15,7 @@ const (
 	UserSessionTypeLogin          UserSessionType = "LOGIN"
 	UserSessionTypeSudo           UserSessionType = "SUDO"
 	UserSessionTypeRememberDevice UserSessionType = "REMEMBER-DEVICE"
+	UserSessionTypeForwardAuth    UserSessionType = "FORWARD-AUTH"
 
 	UserRoleUser       UserRole = "user"
 	UserRoleAdmin      UserRole = "admin"
@@ -23,41 +24,77 @@ const (
 	UserCollectionName = "users"
 )
 
-type SecondFactorThrottling struct {
-	FailuresSinceSuccess int32     `bson:"failedAttemptsSinceLastSuccess,omitempty"`
-	TimeoutUntil         time.Time `bson:"timeoutUntil,omitempty"`
-}
-type UserSession struct {
-	Token                UserSessionToken `bson:"token,omitempty"`
-	Type                 UserSessionType  `bson:"type,omitempty"`
-	RequiresSecondFactor bool             `bson:"requiresSecondFactor,omitempty"`
-	TimeoutAt            time.Time        `bson:"timeoutAt,omitempty"`
+type WebAuthnCredential struct {
+	ID              []byte    `bson:"id,omitempty"`
+	PublicKey       []byte    `bson:"publicKey,omitempty"`
+	AttestationType string    `bson:"attestationType,omitempty"`
+	AAGUID          []byte    `bson:"aaguid,omitempty"`
+	SignCount       uint32    `bson:"signCount,omitempty"`
+	Transports      []string  `bson:"transports,omitempty"`
+	BackupEligible  bool      `bson:"backupEligible,omitempty"`
+	BackupState     bool      `bson:"backupState,omitempty"`
+	Nickname        string    `bson:"nickname,omitempty"`
+	CreatedAt       time.Time `bson:"createdAt,omitempty"`
+	LastUsedAt      time.Time `bson:"lastUsedAt,omitempty"`
 }
 
-func (u UserSession) IsPresent() bool {
-	return u.Token != ""
+type PasswordHashAlgorithm string
+
+const (
+	PasswordHashAlgorithmArgon2id PasswordHashAlgorithm = "argon2id"
+	PasswordHashAlgorithmBcrypt   PasswordHashAlgorithm = "bcrypt"
+	PasswordHashAlgorithmScrypt   PasswordHashAlgorithm = "scrypt"
+)
+
+// PasswordHashParams holds the cost parameters a password hash was computed with.
+// Which fields are set depends on the algorithm; bcrypt hashes carry their cost within the key.
+type PasswordHashParams struct {
+	Time      uint32 `bson:"time,omitempty"`
+	MemoryKiB uint32 `bson:"memoryKiB,omitempty"`
+	Threads   uint8  `bson:"threads,omitempty"`
+	N         int    `bson:"n,omitempty"`
+	R         int    `bson:"r,omitempty"`
+	P         int    `bson:"p,omitempty"`
+	KeyLength uint32 `bson:"keyLength,omitempty"`
 }
 
+// UserCredentials without algorithm were created before hashes were versioned and use argon2id with LegacyArgon2idParams
 type UserCredentials struct {
-	Key  []byte
-	Salt []byte
+	Algorithm PasswordHashAlgorithm `bson:"algorithm,omitempty"`
+	Params    PasswordHashParams    `bson:"params,omitempty"`
+	Key       []byte                `bson:"key"`
+	Salt      []byte                `bson:"salt,omitempty"`
 }
 
+var LegacyArgon2idParams = PasswordHashParams{Time: 1, MemoryKiB: 64 * 1024, Threads: 4, KeyLength: 32}
+
 type User struct {
-	ObjectID                     primitive.ObjectID     `bson:"_id,omitempty"`
-	Name                         string                 `bson:"name,omitempty"`
-	Credentials                  UserCredentials        `bson:"credentials,omitempty"`
-	Email                        string                 `bson:"email,omitempty"`
-	EmailVerified                bool                   `bson:"emailVerified,omitempty"`
-	EmailVerificationToken       string                 `bson:"emailVerificationToken,omitempty"`
-	NextEmail                    string                 `bson:"nextEmail,omitempty"`
-	PasswordResetToken           string                 `bson:"passwordResetToken,omitempty"`
-	PasswordResetTokenValidUntil time.Time              `bson:"passwordResetTokenValidUntil,omitempty"`
-	SecondFactorToken            string                 `bson:"secondFactorToken,omitempty"`
-	TemporarySecondFactorToken   string                 `bson:"temporarySecondFactorToken,omitempty"`
-	UserRoles                    []UserRole             `bson:"userRoles,omitempty"`
-	Sessions                     []UserSession          `bson:"sessions,omitempty"`
-	SecondFactorThrottling       SecondFactorThrottling `bson:"secondFactorThrottling,omitempty"`
+	ObjectID                     primitive.ObjectID   `bson:"_id,omitempty"`
+	Name                         string               `bson:"name,omitempty"`
+	Credentials                  UserCredentials      `bson:"credentials,omitempty"`
+	Email                        string               `bson:"email,omitempty"`
+	EmailVerified                bool                 `bson:"emailVerified,omitempty"`
+	EmailVerificationTokenHash   string               `bson:"emailVerificationTokenHash,omitempty"`
+	NextEmail                    string               `bson:"nextEmail,omitempty"`
+	PasswordResetTokenHash       string               `bson:"passwordResetTokenHash,omitempty"`
+	PasswordResetTokenValidUntil time.Time            `bson:"passwordResetTokenValidUntil,omitempty"`
+	MagicLinkTokenHash           string               `bson:"magicLinkTokenHash,omitempty"`
+	MagicLinkTokenValidUntil     time.Time            `bson:"magicLinkTokenValidUntil,omitempty"`
+	AdminInvitationTokenHash     string               `bson:"adminInvitationTokenHash,omitempty"`
+	AdminInvitationValidUntil    time.Time            `bson:"adminInvitationValidUntil,omitempty"`
+	SecondFactorToken            string               `bson:"secondFactorToken,omitempty"`
+	TemporarySecondFactorToken   string               `bson:"temporarySecondFactorToken,omitempty"`
+	SecondFactorRecoveryCodes    []string             `bson:"secondFactorRecoveryCodes,omitempty"`
+	UserRoles                    []UserRole           `bson:"userRoles,omitempty"`
+	WebAuthnCredentials          []WebAuthnCredential `bson:"webAuthnCredentials,omitempty"`
+	LockedAt                     time.Time            `bson:"lockedAt,omitempty"`
+	UnlockTokenHash              string               `bson:"unlockTokenHash,omitempty"`
+	ExternalIdentities           []ExternalIdentity   `bson:"externalIdentities,omitempty"`
+	ScimExternalID               string               `bson:"scimExternalID,omitempty"`
+	ScimProvisioned              bool                 `bson:"scimProvisioned,omitempty"`
+	DeactivatedAt                time.Time            `bson:"deactivatedAt,omitempty"`
+	DeletionScheduledFor         time.Time            `bson:"deletionScheduledFor,omitempty"`
+	DeletionCancelTokenHash      string               `bson:"deletionCancelTokenHash,omitempty"`
 }
 
 func (u User) ID() UserID {
@@ -72,11 +109,59 @@ func (u User) IsPresent() bool {
 	return u.ObjectID != primitive.NilObjectID
 }
 
+// IsLocked reports whether the account was locked after too many failed logins, deactivated by provisioning or
+// scheduled for deletion
+func (u User) IsLocked() bool {
+	return !u.LockedAt.IsZero() || u.IsDeactivated() || u.IsDeletionScheduled()
+}
+
+// IsDeactivated reports whether the identity provider deactivated the account. Unlike a lock, only provisioning can lift it.
+func (u User) IsDeactivated() bool {
+	return !u.DeactivatedAt.IsZero()
+}
+
+// IsScimManaged reports whether the identity provider created the user or linked it by its external id. Provisioning
+// can only read and change such users.
+func (u User) IsScimManaged() bool {
+	return u.ScimProvisioned || u.ScimExternalID != ""
+}
+
+// IsDeletionScheduled reports whether the user asked to delete the account. Only the cancel link sent by email lifts it.
+func (u User) IsDeletionScheduled() bool {
+	return !u.DeletionScheduledFor.IsZero()
+}
+
+func (u User) HasSecondFactor() bool {
+	return u.SecondFactorToken != "" || len(u.WebAuthnCredentials) > 0
+}
+
+// ExternalIdentity returns the identity linked at the given provider, if any
+func (u User) ExternalIdentity(provider string) (ExternalIdentity, bool) {
+	for _, identity := range u.ExternalIdentities {
+		if identity.Provider == provider {
+			return identity, true
+		}
+	}
+	return ExternalIdentity{}, false
+}
+
+// HasPrivilegedRole reports whether the user holds any role besides UserRoleUser. Such users cannot log in without a second factor.
+func (u User) HasPrivilegedRole() bool {
+	for _, role := range u.UserRoles {
+		if role != UserRoleUser {
+			return true
+		}
+	}
+	return false
+}
+
 type UserInsert struct {
-	UserName               string
-	Credentials            UserCredentials
-	Email                  string
-	EmailVerified          bool
-	EmailVerificationToken string
-	UserRoles              []UserRole
+	UserName                   string
+	Credentials                UserCredentials
+	Email                      string
+	EmailVerified              bool
+	EmailVerificationTokenHash string
+	AdminInvitationTokenHash   string
+	AdminInvitationValidUntil  time.Time
+	UserRoles                  []UserRole
 }
@@ -0,0 +1,31 @@
+package domain_model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type WebAuthnCeremonyType string
+
+const (
+	WebAuthnCeremonyTypeRegistration WebAuthnCeremonyType = "REGISTRATION"
+	WebAuthnCeremonyTypeLogin        WebAuthnCeremonyType = "LOGIN"
+	WebAuthnCeremonyTypeSecondFactor WebAuthnCeremonyType = "SECOND-FACTOR"
+
+	WebAuthnCeremonyCollectionName = "webAuthnCeremonies"
+	WebAuthnCeremonyDuration       = 5 * time.Minute
+)
+
+// WebAuthnCeremony holds the challenge state between the begin and finish steps of a WebAuthn ceremony.
+type WebAuthnCeremony struct {
+	ObjectID    primitive.ObjectID   `bson:"_id,omitempty"`
+	TokenHash   string               `bson:"tokenHash,omitempty"`
+	Type        WebAuthnCeremonyType `bson:"type,omitempty"`
+	UserID      primitive.ObjectID   `bson:"userID,omitempty"`
+	SessionData []byte               `bson:"sessionData,omitempty"`
+	TimeoutAt   time.Time            `bson:"timeoutAt,omitempty"`
+}
+
+func (c WebAuthnCeremony) IsPresent() bool {
+	return c.ObjectID != primitive.NilObjectID
+}
@@ -0,0 +1,91 @@
+package functional_tests
+
+import (
+	"strings"
+	"time"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+// TestAccountDeletion requests the deletion of the account and cancels it with the link from the confirmation email
+func TestAccountDeletion(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+
+	// Sudo login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+		Sudo:     true,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	// Request deletion
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/delete-account", nil)
+	if !testUser.EmailVerified {
+		// The settings are only available with a verified email
+		return client.AssertLastResponseEq(403, nil)
+	}
+	if err := helper.AssertEq(strings.Contains(client.LastResponseBody(), "will be deleted"), true); err != nil {
+		return errs.Wrap("delete account response mismatch", err)
+	}
+
+	// Login is blocked during the grace period, with the same answer as for a wrong password
+	otherClient := helper.NewRequestClient(testUser)
+	for _, attemptedPassword := range []string{password, password + "-wrong"} {
+		otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+			Email:    email,
+			Password: attemptedPassword,
+		})
+		body := otherClient.LastResponseBody()
+		if err := helper.AssertEq(strings.Contains(body, "Invalid credentials") && !strings.Contains(body, "deletion"), true); err != nil {
+			return errs.Wrap("login during grace period response mismatch", err)
+		}
+		if otherClient.HasSessionCookie() {
+			return errs.Error("unexpected session cookie returned during grace period")
+		}
+	}
+
+	// Grab cancel token from email
+	token := ""
+	for i := 0; token == "" && i < 10; i++ {
+		emails := helper.GetSentEmails(testUser, email, "Your account will be deleted")
+		if len(emails) > 1 {
+			return errs.Error("too many account deletion emails found")
+		}
+		if len(emails) == 1 {
+			token = strings.TrimSpace(strings.Split(strings.Split(emails[0].Body, "cancel-account-deletion?token=")[1], "\n")[0])
+		}
+
+		if token == "" {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	if token == "" {
+		return errs.Error("token not found")
+	}
+
+	// Cancel deletion
+	otherClient.MakeApiRequest("POST", "auth/cancel-account-deletion", resource.AccountDeletionCancelTO{
+		Token: token,
+	})
+	if err := helper.AssertEq(strings.Contains(otherClient.LastResponseBody(), "has been canceled"), true); err != nil {
+		return errs.Wrap("cancel deletion response mismatch", err)
+	}
+
+	// Login works again
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	otherClient.LastResponseBody()
+	if !otherClient.HasSessionCookie() {
+		return errs.Error("expected session cookie returned after canceling deletion, got none")
+	}
+	return nil
+}
@@ -10,17 +10,23 @@ func TestCallWithMismatchingCsrfTokens(testUser *helper.TestUser) error {
 	email := testUser.Email
 	password := testUser.Password
 	client := helper.NewRequestClient(testUser)
-	client.SetCsrfTokens("some", "other")
-
-	// Check user with mismatching CSRF tokens
-	client.MakeApiRequest("GET", "user-info", nil)
+	anonymousToken := client.CsrfToken()
+	if anonymousToken == "" {
+		return errs.Error("no CSRF token issued")
+	}
 
+	// Login with matching but forged CSRF tokens
+	client.SetCsrfTokens("some", "some")
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
 	if err := client.AssertLastResponseEq(400, nil); err != nil {
-		return errs.Wrap("auth user response mismatch", err)
+		return errs.Wrap("forged token response mismatch", err)
 	}
 
-	// Login (with matching CSRF tokens)
-	client.SetCsrfTokens("some", "some")
+	// Login (with issued CSRF tokens)
+	client.FetchCsrfToken()
 	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
 		Email:    email,
 		Password: password,
@@ -32,9 +38,20 @@ func TestCallWithMismatchingCsrfTokens(testUser *helper.TestUser) error {
 	if !client.HasSessionCookie() {
 		return errs.Error("session cookie not found")
 	}
+	if client.CsrfToken() == anonymousToken {
+		return errs.Error("CSRF token not rotated on login")
+	}
+
+	// POST (logged in, with the token issued before login)
+	loggedInToken := client.CsrfToken()
+	client.SetCsrfTokens(anonymousToken, anonymousToken)
+	client.MakeApiRequest("POST", "user/re-trigger-confirmation-email", nil)
+	if err := client.AssertLastResponseEq(400, nil); err != nil {
+		return errs.Wrap("POST with stale token response mismatch", err)
+	}
 
-	// POST (logged in, with wrong tokens)
-	client.SetCsrfTokens("some", "other")
+	// POST (logged in, with mismatching tokens)
+	client.SetCsrfTokens(loggedInToken, anonymousToken)
 	client.MakeApiRequest("POST", "user/re-trigger-confirmation-email", nil)
 	if err := client.AssertLastResponseEq(400, nil); err != nil {
 		return errs.Wrap("POST response mismatch", err)
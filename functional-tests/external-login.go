@@ -0,0 +1,87 @@
+package functional_tests
+
+import (
+	"strings"
+	"user-manager/cmd/app/resource"
+	dm "user-manager/domain-model"
+	"user-manager/functional-tests/helper"
+	openidprovider "user-manager/third-party-models/openid-provider"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+// TestExternalLogin signs in through the mock identity provider, which is configured as provider "mock" in the local environment.
+// Identities are only linked to the test user by email if the test user verified it.
+func TestExternalLogin(testUser *helper.TestUser) error {
+	subject := "mock-subject-" + random.MakeRandomURLSafeB64(6)
+
+	// Unverified email at the provider is never linked
+	helper.SetMockIdentity(testUser, openidprovider.IdentityTO{Subject: subject, Email: testUser.Email, EmailVerified: false})
+	client := helper.NewRequestClient(testUser)
+	if err := loginWithMockIdp(client, testUser); err != nil {
+		return errs.Wrap("login with unverified email failed", err)
+	}
+	if client.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned despite unverified email")
+	}
+
+	// Tampered state is rejected
+	helper.SetMockIdentity(testUser, openidprovider.IdentityTO{Subject: subject, Email: testUser.Email, EmailVerified: true})
+	client = helper.NewRequestClient(testUser)
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/auth/external/mock/login")
+	client.MakeRequestWithoutRedirect("GET", client.LastResponseHeader("Location"))
+	callback := client.LastResponseHeader("Location")
+	client.MakeRequestWithoutRedirect("GET", strings.Replace(callback, "state=", "state=tampered", 1))
+	if client.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned despite tampered state")
+	}
+
+	// Verified email is linked to the test user
+	client = helper.NewRequestClient(testUser)
+	if err := loginWithMockIdp(client, testUser); err != nil {
+		return errs.Wrap("login with verified email failed", err)
+	}
+	if client.HasSessionCookie() != testUser.EmailVerified {
+		return errs.Errorf("expected session cookie %v, got %v", testUser.EmailVerified, client.HasSessionCookie())
+	}
+	if !testUser.EmailVerified {
+		return nil
+	}
+
+	// The linked identity signs in even after its email changed at the provider
+	helper.SetMockIdentity(testUser, openidprovider.IdentityTO{Subject: subject, Email: "changed-" + testUser.Email, EmailVerified: true})
+	client = helper.NewRequestClient(testUser)
+	if err := loginWithMockIdp(client, testUser); err != nil {
+		return errs.Wrap("login with linked identity failed", err)
+	}
+	if !client.HasSessionCookie() {
+		return errs.Error("expected session cookie returned, got none")
+	}
+
+	client.MakeApiRequest("GET", "user-info", nil)
+	if err := client.AssertLastResponseEq(200, resource.UserInfoTO{Roles: []dm.UserRole{dm.UserRoleUser}, EmailVerified: true}); err != nil {
+		return errs.Wrap("get user info response mismatch", err)
+	}
+	return nil
+}
+
+// loginWithMockIdp follows the redirects from the app to the mock identity provider and back
+func loginWithMockIdp(client *helper.RequestClient, testUser *helper.TestUser) error {
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/auth/external/mock/login")
+	authorizationURL := client.LastResponseHeader("Location")
+	if !strings.HasPrefix(authorizationURL, testUser.MockApiURL+"/mock-idp/authorize") {
+		return errs.Errorf("unexpected authorization url %s", authorizationURL)
+	}
+
+	client.MakeRequestWithoutRedirect("GET", authorizationURL)
+	callback := client.LastResponseHeader("Location")
+	if !strings.HasPrefix(callback, testUser.AppURL+"/auth/external/mock/callback") {
+		return errs.Errorf("unexpected callback url %s", callback)
+	}
+
+	client.MakeRequestWithoutRedirect("GET", callback)
+	if err := helper.AssertEq(client.LastResponseBody() != "", true); err != nil {
+		return errs.Wrap("empty callback response", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package functional_tests
+
+import (
+	"net/url"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+// TestForwardAuth acts as a reverse proxy for tools.localhost, which is open to all users except for /admin in the local environment
+func TestForwardAuth(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+	client.SetHeader("X-Forwarded-Proto", "http")
+	client.SetHeader("X-Forwarded-Host", "tools.localhost")
+	client.SetHeader("X-Forwarded-Uri", "/dashboard?tab=1")
+
+	// Without login session
+	client.MakeApiRequest("GET", "forward-auth", nil)
+	if err := client.AssertLastResponseEq(401, nil); err != nil {
+		return errs.Wrap("forward auth without session response mismatch", err)
+	}
+
+	// Login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	// Login session alone is not accepted, its cookie is not sent to the protected host
+	client.MakeApiRequest("GET", "forward-auth", nil)
+	if err := client.AssertLastResponseEq(401, nil); err != nil {
+		return errs.Wrap("forward auth with login session only response mismatch", err)
+	}
+
+	// Login page issues the forward auth cookie and returns to the protected host
+	returnTo := "http://tools.localhost/dashboard?tab=1"
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/forward-auth/login?"+url.Values{"returnTo": {returnTo}}.Encode())
+	if err := helper.AssertEq(client.LastResponseStatus(), 302); err != nil {
+		return errs.Wrap("forward auth login status mismatch", err)
+	}
+	if err := helper.AssertEq(client.LastResponseHeader("Location"), returnTo); err != nil {
+		return errs.Wrap("forward auth login redirect mismatch", err)
+	}
+	if !client.HasForwardAuthCookie() {
+		return errs.Error("forward auth cookie not found")
+	}
+
+	// With forward auth session
+	client.MakeApiRequest("GET", "forward-auth", nil)
+	if err := client.AssertLastResponseEq(200, nil); err != nil {
+		return errs.Wrap("forward auth with session response mismatch", err)
+	}
+	if err := helper.AssertEq(client.LastResponseHeader("X-Auth-Email"), email); err != nil {
+		return errs.Wrap("X-Auth-Email mismatch", err)
+	}
+	if err := helper.AssertEq(client.LastResponseHeader("X-Auth-Roles"), "user"); err != nil {
+		return errs.Wrap("X-Auth-Roles mismatch", err)
+	}
+
+	// Path requiring a role the user does not have
+	client.SetHeader("X-Forwarded-Uri", "/admin/settings")
+	client.MakeApiRequest("GET", "forward-auth", nil)
+	if err := client.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("forward auth for admin path response mismatch", err)
+	}
+
+	// Variants of the admin path the protected host resolves to the same resource
+	for _, uri := range []string{"/dashboard/../admin/x", "/%61dmin", "//admin"} {
+		client.SetHeader("X-Forwarded-Uri", uri)
+		client.MakeApiRequest("GET", "forward-auth", nil)
+		if err := client.AssertLastResponseEq(403, nil); err != nil {
+			return errs.Wrap("forward auth for admin path variant "+uri+" response mismatch", err)
+		}
+	}
+
+	// Host without rules
+	client.SetHeader("X-Forwarded-Host", "unknown.localhost")
+	client.MakeApiRequest("GET", "forward-auth", nil)
+	if err := client.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("forward auth for unknown host response mismatch", err)
+	}
+
+	// Login page does not return to hosts without rules
+	client.MakeApiRequest("GET", "forward-auth/login?"+url.Values{"returnTo": {"https://evil.example/"}}.Encode(), nil)
+	if err := client.AssertLastResponseEq(400, nil); err != nil {
+		return errs.Wrap("forward auth login with foreign return url response mismatch", err)
+	}
+	return nil
+}
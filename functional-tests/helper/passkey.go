@@ -0,0 +1,157 @@
+package helper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"user-manager/util/errs"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	authenticatorFlagUserPresent        = 0x01
+	authenticatorFlagUserVerified       = 0x04
+	authenticatorFlagAttestedCredential = 0x40
+)
+
+// Passkey emulates an authenticator holding a single ES256 credential, which always verifies the user
+type Passkey struct {
+	credentialID []byte
+	privateKey   *ecdsa.PrivateKey
+	userHandle   []byte
+	signCount    uint32
+}
+
+func NewPasskey() *Passkey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(errs.Wrap("issue generating passkey", err))
+	}
+	credentialID := make([]byte, 16)
+	if _, err = rand.Read(credentialID); err != nil {
+		panic(errs.Wrap("issue generating credential id", err))
+	}
+	return &Passkey{credentialID: credentialID, privateKey: privateKey}
+}
+
+func (p *Passkey) CredentialID() string {
+	return base64.RawURLEncoding.EncodeToString(p.credentialID)
+}
+
+// MakeCreationResponse creates the credential for the options returned by the relying party, using "none" attestation
+func (p *Passkey) MakeCreationResponse(origin string, options string) (json.RawMessage, error) {
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal([]byte(options), &creation); err != nil {
+		return nil, errs.Wrap("issue reading creation options", err)
+	}
+	userID, ok := creation.Response.User.ID.(string)
+	if !ok {
+		return nil, errs.Error("user id missing from creation options")
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(userID)
+	if err != nil {
+		return nil, errs.Wrap("issue decoding user id", err)
+	}
+	p.userHandle = userHandle
+
+	clientData, err := makeClientData("webauthn.create", creation.Response.Challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1,
+		XCoord:        p.privateKey.X.FillBytes(make([]byte, 32)),
+		YCoord:        p.privateKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, errs.Wrap("issue encoding public key", err)
+	}
+
+	authData := p.makeAuthenticatorData(creation.Response.RelyingParty.ID, authenticatorFlagUserPresent|authenticatorFlagUserVerified|authenticatorFlagAttestedCredential)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(p.credentialID)))
+	authData = append(authData, p.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, errs.Wrap("issue encoding attestation object", err)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":    p.CredentialID(),
+		"rawId": p.CredentialID(),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+		},
+	})
+}
+
+// MakeAssertionResponse signs the challenge of the options returned by the relying party
+func (p *Passkey) MakeAssertionResponse(origin string, rpID string, options string) (json.RawMessage, error) {
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal([]byte(options), &assertion); err != nil {
+		return nil, errs.Wrap("issue reading assertion options", err)
+	}
+	if assertion.Response.RelyingPartyID != "" {
+		rpID = assertion.Response.RelyingPartyID
+	}
+
+	clientData, err := makeClientData("webauthn.get", assertion.Response.Challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+	p.signCount++
+	authData := p.makeAuthenticatorData(rpID, authenticatorFlagUserPresent|authenticatorFlagUserVerified)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, p.privateKey, digest[:])
+	if err != nil {
+		return nil, errs.Wrap("issue signing assertion", err)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":    p.CredentialID(),
+		"rawId": p.CredentialID(),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(p.userHandle),
+		},
+	})
+}
+
+func (p *Passkey) makeAuthenticatorData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, p.signCount)
+}
+
+func makeClientData(ceremonyType string, challenge protocol.URLEncodedBase64, origin string) ([]byte, error) {
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	if err != nil {
+		return nil, errs.Wrap("issue encoding client data", err)
+	}
+	return clientData, nil
+}
@@ -6,16 +6,24 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	emailapi "user-manager/third-party-models/email-api"
+	openidprovider "user-manager/third-party-models/openid-provider"
 	"user-manager/util/errs"
 )
 
 type TestUser struct {
-	Email         string
-	EmailVerified bool
-	Password      string
-	AppURL        string
-	MockApiURL    string
+	Email              string
+	EmailVerified      bool
+	Password           string
+	SecondFactorSecret string
+	AppURL             string
+	MockApiURL         string
+	// OidcClientID, OidcClientSecret and OidcRedirectURI belong to the relying party registered for the test run
+	OidcClientID     string
+	OidcClientSecret string
+	OidcRedirectURI  string
 }
 
 type FunctionalTest struct {
@@ -36,10 +44,20 @@ func NewRequestClient(testUser *TestUser) *RequestClient {
 		cookies:  map[string]*http.Cookie{},
 		headers:  map[string]string{},
 	}
-	client.SetCsrfTokens("abcdef", "abcdef")
+	client.FetchCsrfToken()
 	return client
 }
 
+// FetchCsrfToken makes a safe request, which is issued a CSRF token unless the client already holds a valid one
+func (r *RequestClient) FetchCsrfToken() {
+	r.MakeApiRequest("GET", "user-info", nil)
+	readAllClose(r.lastResponse.Body)
+}
+
+func (r *RequestClient) CsrfToken() string {
+	return r.headers["X-CSRF-Token"]
+}
+
 func (r *RequestClient) SetCsrfTokens(header string, cookie string) {
 	r.headers["X-CSRF-Token"] = header
 	r.cookies["CSRF-Token"] = &http.Cookie{
@@ -48,6 +66,16 @@ func (r *RequestClient) SetCsrfTokens(header string, cookie string) {
 	}
 }
 
+// SetHeader sends the header with subsequent API requests, e.g. to act as a reverse proxy
+func (r *RequestClient) SetHeader(name string, value string) {
+	r.headers[name] = value
+}
+
+// SetBearerToken authenticates subsequent requests with a personal access token instead of the session cookie
+func (r *RequestClient) SetBearerToken(token string) {
+	r.headers["Authorization"] = "Bearer " + token
+}
+
 func (r *RequestClient) MakeApiRequest(method string, subpath string, payload interface{}) {
 	req, err := http.NewRequest(method, fmt.Sprintf("%s/api/%s", r.testUser.AppURL, subpath), nil)
 	if err != nil {
@@ -76,6 +104,70 @@ func (r *RequestClient) MakeApiRequest(method string, subpath string, payload in
 	for _, cookie := range resp.Cookies() {
 		r.cookies[cookie.Name] = cookie
 	}
+	if csrfToken := resp.Header.Get("X-CSRF-Token"); csrfToken != "" {
+		r.headers["X-CSRF-Token"] = csrfToken
+	}
+
+	r.lastResponse = resp
+}
+
+// MakeRequestWithoutRedirect requests an absolute URL and keeps redirect responses, so redirects to other sites can be followed step by step
+func (r *RequestClient) MakeRequestWithoutRedirect(method string, url string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		panic(errs.Wrap("error building request", err))
+	}
+	for _, val := range r.cookies {
+		req.AddCookie(val)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		panic(errs.Wrap("error making request", err))
+	}
+
+	for _, cookie := range resp.Cookies() {
+		r.cookies[cookie.Name] = cookie
+	}
+	if csrfToken := resp.Header.Get("X-CSRF-Token"); csrfToken != "" {
+		r.headers["X-CSRF-Token"] = csrfToken
+	}
+
+	r.lastResponse = resp
+}
+
+// MakeFormRequestWithoutRedirect posts the form to an absolute URL like a browser or a relying party backend would, and
+// keeps redirect responses
+func (r *RequestClient) MakeFormRequestWithoutRedirect(url string, form url.Values) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(form.Encode()))
+	if err != nil {
+		panic(errs.Wrap("error building request", err))
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	for _, val := range r.cookies {
+		req.AddCookie(val)
+	}
+	for name, val := range r.headers {
+		req.Header.Add(name, val)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		panic(errs.Wrap("error making request", err))
+	}
+
+	for _, cookie := range resp.Cookies() {
+		r.cookies[cookie.Name] = cookie
+	}
+	if csrfToken := resp.Header.Get("X-CSRF-Token"); csrfToken != "" {
+		r.headers["X-CSRF-Token"] = csrfToken
+	}
 
 	r.lastResponse = resp
 }
@@ -87,6 +179,13 @@ func (r *RequestClient) HasSessionCookie() bool {
 	return false
 }
 
+func (r *RequestClient) HasForwardAuthCookie() bool {
+	if val, ok := r.cookies["FORWARD-AUTH_TOKEN"]; ok {
+		return val.Value != ""
+	}
+	return false
+}
+
 func (r *RequestClient) AssertLastResponseEq(expectedStatusCode int, expectedPayload interface{}) error {
 	resp := r.lastResponse
 	if err := AssertEq(resp.StatusCode, expectedStatusCode); err != nil {
@@ -110,6 +209,18 @@ func (r *RequestClient) AssertLastResponseEq(expectedStatusCode int, expectedPay
 	return nil
 }
 
+func (r *RequestClient) LastResponseStatus() int {
+	return r.lastResponse.StatusCode
+}
+
+func (r *RequestClient) LastResponseHeader(name string) string {
+	return r.lastResponse.Header.Get(name)
+}
+
+func (r *RequestClient) LastResponseBody() string {
+	return string(readAllClose(r.lastResponse.Body))
+}
+
 func AssertEq(received interface{}, expected interface{}) error {
 	if received != expected {
 		return errs.Errorf("expected %v got %v", expected, received)
@@ -152,3 +263,16 @@ func GetSentEmails(testUser *TestUser, address string, subject string) []emailap
 
 	return emails
 }
+
+// SetMockIdentity sets the account the mock identity provider signs in as
+func SetMockIdentity(testUser *TestUser, identity openidprovider.IdentityTO) {
+	b, err := json.Marshal(identity)
+	if err != nil {
+		panic(errs.Wrap("issue marshalling json", err))
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/mock-idp/identity", testUser.MockApiURL), "application/json", bytes.NewReader(b))
+	if err != nil {
+		panic(errs.Wrap("error making request", err))
+	}
+	readAllClose(resp.Body)
+}
@@ -0,0 +1,110 @@
+package functional_tests
+
+import (
+	"strings"
+	"time"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+// TestLoginThrottling fails the password of a separate user until the account is throttled and then locked at the
+// ACCOUNT_LOCK_THRESHOLD of the local environment, and unlocks it with the link from the email
+func TestLoginThrottling(testUser *helper.TestUser) error {
+	email := "throttled-" + testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+
+	// Sign-up
+	client.MakeApiRequest("POST", "auth/sign-up", resource.SignUpTO{
+		UserName: "throttled-user",
+		Email:    email,
+		Password: []byte(password),
+	})
+	if err := client.AssertLastResponseEq(204, nil); err != nil {
+		return errs.Wrap("signup response mismatch", err)
+	}
+
+	// The first failures are free
+	otherClient := helper.NewRequestClient(testUser)
+	for i := 0; i < 3; i++ {
+		otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+			Email:    email,
+			Password: "not-the-password",
+		})
+		if err := helper.AssertEq(strings.Contains(otherClient.LastResponseBody(), "Invalid credentials"), true); err != nil {
+			return errs.Wrap("login with wrong password response mismatch", err)
+		}
+	}
+
+	// The next failure throttles the account, even for the correct password
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: "not-the-password",
+	})
+	if err := helper.AssertEq(strings.Contains(otherClient.LastResponseBody(), "Too many failed attempts"), true); err != nil {
+		return errs.Wrap("throttling login response mismatch", err)
+	}
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	if err := helper.AssertEq(strings.Contains(otherClient.LastResponseBody(), "Too many failed attempts"), true); err != nil {
+		return errs.Wrap("throttled login response mismatch", err)
+	}
+	if otherClient.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned despite throttling")
+	}
+
+	// Once the delay has passed, the failure at the lock threshold locks the account
+	time.Sleep(31 * time.Second)
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: "not-the-password",
+	})
+	otherClient.LastResponseBody()
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	otherClient.LastResponseBody()
+	if otherClient.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned for locked account")
+	}
+
+	// Grab unlock token from email
+	token := ""
+	for i := 0; token == "" && i < 10; i++ {
+		emails := helper.GetSentEmails(testUser, email, "Your account has been locked")
+		if len(emails) > 1 {
+			return errs.Error("too many account locked emails found")
+		}
+		if len(emails) == 1 {
+			token = strings.TrimSpace(strings.Split(strings.Split(emails[0].Body, "unlock-account?token=")[1], "\n")[0])
+		}
+		if token == "" {
+			time.Sleep(1 * time.Second)
+		}
+	}
+	if token == "" {
+		return errs.Error("account locked email not found")
+	}
+
+	// Unlock
+	client.MakeApiRequest("POST", "auth/unlock-account", resource.AccountUnlockTO{Token: token})
+	if err := helper.AssertEq(client.LastResponseStatus(), 200); err != nil {
+		return errs.Wrap("unlock account status mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Login after unlocking
+	otherClient.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	otherClient.LastResponseBody()
+	if !otherClient.HasSessionCookie() {
+		return errs.Error("expected session cookie after unlocking, got none")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package functional_tests
+
+import (
+	"strings"
+	"time"
+	"user-manager/cmd/app/resource"
+	dm "user-manager/domain-model"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+func TestMagicLinkLogin(testUser *helper.TestUser) error {
+	email := testUser.Email
+	client := helper.NewRequestClient(testUser)
+
+	// Request link
+	client.MakeApiRequest("POST", "auth/request-magic-link", resource.MagicLinkRequestTO{
+		Email: email,
+	})
+	if err := helper.AssertEq(client.LastResponseBody() != "", true); err != nil {
+		return errs.Wrap("request magic link response mismatch", err)
+	}
+
+	// Grab token from email
+	token := ""
+	for i := 0; token == "" && i < 10; i++ {
+		emails := helper.GetSentEmails(testUser, email, "Your sign-in link")
+		if len(emails) > 1 {
+			return errs.Error("too many magic link emails found")
+		}
+		if len(emails) == 1 {
+			token = strings.TrimSpace(strings.Split(strings.Split(emails[0].Body, "magic-link?token=")[1], "\n")[0])
+		}
+
+		if token == "" {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	if token == "" {
+		return errs.Error("token not found")
+	}
+
+	// Login with wrong token
+	client.MakeApiRequest("POST", "auth/magic-link-login", resource.MagicLinkLoginTO{
+		Token: "not-correct",
+	})
+	client.LastResponseBody()
+	if client.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned despite wrong token")
+	}
+
+	// Login with right token
+	client.MakeApiRequest("POST", "auth/magic-link-login", resource.MagicLinkLoginTO{
+		Token: token,
+	})
+	client.LastResponseBody()
+	if !client.HasSessionCookie() {
+		return errs.Error("expected session cookie returned, got none")
+	}
+
+	client.MakeApiRequest("GET", "user-info", nil)
+	if err := client.AssertLastResponseEq(200, resource.UserInfoTO{Roles: []dm.UserRole{dm.UserRoleUser}, EmailVerified: testUser.EmailVerified}); err != nil {
+		return errs.Wrap("get user info response mismatch", err)
+	}
+
+	// The link can only be used once
+	otherClient := helper.NewRequestClient(testUser)
+	otherClient.MakeApiRequest("POST", "auth/magic-link-login", resource.MagicLinkLoginTO{
+		Token: token,
+	})
+	otherClient.LastResponseBody()
+	if otherClient.HasSessionCookie() {
+		return errs.Error("unexpected session cookie returned for used token")
+	}
+	return nil
+}
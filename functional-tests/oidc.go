@@ -0,0 +1,247 @@
+package functional_tests
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/url"
+	"strings"
+	"user-manager/cmd/app/resource"
+	"user-manager/cmd/app/service/oidc"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+	"user-manager/util/random"
+)
+
+// TestOidc signs in to the relying party registered for the test run with the authorization code flow and PKCE, then
+// exercises the token endpoint like the relying party's backend would
+func TestOidc(testUser *helper.TestUser) error {
+	client := helper.NewRequestClient(testUser)
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    testUser.Email,
+		Password: testUser.Password,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	codeVerifier := random.MakeRandomURLSafeB64(48)
+	codeChallengeHash := sha256.Sum256([]byte(codeVerifier))
+	nonce := random.MakeRandomURLSafeB64(12)
+	authorizationRequest := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testUser.OidcClientID},
+		"redirect_uri":          {testUser.OidcRedirectURI},
+		"scope":                 {"openid email offline_access"},
+		"state":                 {random.MakeRandomURLSafeB64(12)},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(codeChallengeHash[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	// Redirect URI that is not registered for the client is not redirected to
+	mismatchingRequest := cloneValues(authorizationRequest)
+	mismatchingRequest.Set("redirect_uri", "https://evil.example/callback")
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/authorize?"+mismatchingRequest.Encode())
+	if err := helper.AssertEq(client.LastResponseStatus(), 200); err != nil {
+		return errs.Wrap("authorization with unregistered redirect URI status mismatch", err)
+	}
+	if location := client.LastResponseHeader("Location"); location != "" {
+		return errs.Errorf("authorization with unregistered redirect URI redirected to %s", location)
+	}
+
+	// First authorization shows the consent screen
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/authorize?"+authorizationRequest.Encode())
+	if err := helper.AssertEq(client.LastResponseStatus(), 200); err != nil {
+		return errs.Wrap("authorization request status mismatch", err)
+	}
+	client.LastResponseBody()
+	consent := cloneValues(authorizationRequest)
+	consent.Set("decision", "allow")
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/authorize", consent)
+	code, err := getAuthorizationCode(client, testUser, authorizationRequest.Get("state"))
+	if err != nil {
+		return errs.Wrap("consent did not issue an authorization code", err)
+	}
+
+	// Code is bound to the redirect URI it was issued for and cannot be used after a failed exchange
+	tokenRequest := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testUser.MockApiURL + "/other-callback"},
+		"code_verifier": {codeVerifier},
+		"client_id":     {testUser.OidcClientID},
+		"client_secret": {testUser.OidcClientSecret},
+	}
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", tokenRequest)
+	if err = assertOAuthError(client, 400, "invalid_grant"); err != nil {
+		return errs.Wrap("token request with mismatching redirect URI", err)
+	}
+
+	// Consent is remembered, so the next authorization redirects right away
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/authorize?"+authorizationRequest.Encode())
+	code, err = getAuthorizationCode(client, testUser, authorizationRequest.Get("state"))
+	if err != nil {
+		return errs.Wrap("authorization with consent did not issue an authorization code", err)
+	}
+
+	// Code exchange with PKCE
+	tokenRequest.Set("code", code)
+	tokenRequest.Set("redirect_uri", testUser.OidcRedirectURI)
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", tokenRequest)
+	tokens, err := getTokens(client)
+	if err != nil {
+		return errs.Wrap("code exchange failed", err)
+	}
+	if tokens.IDToken == "" || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		return errs.Error("code exchange did not return ID, access and refresh token")
+	}
+
+	// Code replay
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", tokenRequest)
+	if err = assertOAuthError(client, 400, "invalid_grant"); err != nil {
+		return errs.Wrap("replayed code", err)
+	}
+
+	// ID token is signed by a key published in the JWKS
+	if err = verifyIDToken(client, testUser, tokens.IDToken, nonce); err != nil {
+		return errs.Wrap("ID token verification failed", err)
+	}
+
+	// Refresh tokens are rotated, the old one is rejected
+	refreshRequest := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {testUser.OidcClientID},
+		"client_secret": {testUser.OidcClientSecret},
+	}
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", refreshRequest)
+	refreshedTokens, err := getTokens(client)
+	if err != nil {
+		return errs.Wrap("refresh failed", err)
+	}
+	if refreshedTokens.RefreshToken == "" || refreshedTokens.RefreshToken == tokens.RefreshToken {
+		return errs.Error("refresh token was not rotated")
+	}
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", refreshRequest)
+	if err = assertOAuthError(client, 400, "invalid_grant"); err != nil {
+		return errs.Wrap("refresh with rotated token", err)
+	}
+
+	// Revoked refresh token is rejected
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/revoke", url.Values{
+		"token":         {refreshedTokens.RefreshToken},
+		"client_id":     {testUser.OidcClientID},
+		"client_secret": {testUser.OidcClientSecret},
+	})
+	if err = helper.AssertEq(client.LastResponseStatus(), 200); err != nil {
+		return errs.Wrap("revocation status mismatch", err)
+	}
+	client.LastResponseBody()
+	refreshRequest.Set("refresh_token", refreshedTokens.RefreshToken)
+	client.MakeFormRequestWithoutRedirect(testUser.AppURL+"/token", refreshRequest)
+	if err = assertOAuthError(client, 400, "invalid_grant"); err != nil {
+		return errs.Wrap("refresh with revoked token", err)
+	}
+	return nil
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for key, value := range values {
+		clone[key] = append([]string{}, value...)
+	}
+	return clone
+}
+
+// getAuthorizationCode returns the code of the redirect to the relying party
+func getAuthorizationCode(client *helper.RequestClient, testUser *helper.TestUser, state string) (string, error) {
+	if err := helper.AssertEq(client.LastResponseStatus(), 302); err != nil {
+		return "", errs.Wrap("status mismatch", err)
+	}
+	location := client.LastResponseHeader("Location")
+	if !strings.HasPrefix(location, testUser.OidcRedirectURI+"?") {
+		return "", errs.Errorf("redirected to %s instead of the redirect URI", location)
+	}
+	redirectURL, err := url.Parse(location)
+	if err != nil {
+		return "", errs.Wrap("invalid redirect", err)
+	}
+	if err = helper.AssertEq(redirectURL.Query().Get("state"), state); err != nil {
+		return "", errs.Wrap("state mismatch", err)
+	}
+	code := redirectURL.Query().Get("code")
+	if code == "" {
+		return "", errs.Errorf("redirect without code: %s", location)
+	}
+	return code, nil
+}
+
+func getTokens(client *helper.RequestClient) (resource.TokenResponseTO, error) {
+	status := client.LastResponseStatus()
+	body := client.LastResponseBody()
+	if status != 200 {
+		return resource.TokenResponseTO{}, errs.Errorf("expected status 200 got %d: %s", status, body)
+	}
+	var tokens resource.TokenResponseTO
+	if err := json.Unmarshal([]byte(body), &tokens); err != nil {
+		return resource.TokenResponseTO{}, errs.Wrap("issue unmarshalling token response", err)
+	}
+	return tokens, nil
+}
+
+func assertOAuthError(client *helper.RequestClient, expectedStatus int, expectedError string) error {
+	if err := helper.AssertEq(client.LastResponseStatus(), expectedStatus); err != nil {
+		return errs.Wrap("status mismatch", err)
+	}
+	var response resource.OAuthErrorTO
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &response); err != nil {
+		return errs.Wrap("issue unmarshalling error response", err)
+	}
+	return helper.AssertEq(response.Error, expectedError)
+}
+
+// verifyIDToken checks the ID token like a relying party that only knows the discovery information
+func verifyIDToken(client *helper.RequestClient, testUser *helper.TestUser, idToken string, nonce string) error {
+	client.MakeRequestWithoutRedirect("GET", testUser.AppURL+"/jwks")
+	if err := helper.AssertEq(client.LastResponseStatus(), 200); err != nil {
+		return errs.Wrap("jwks status mismatch", err)
+	}
+	var jwks resource.JwksTO
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &jwks); err != nil {
+		return errs.Wrap("issue unmarshalling jwks", err)
+	}
+
+	var claims oidc.IDTokenClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range jwks.Keys {
+			if token.Header["kid"] == key.KeyID {
+				return parseJwk(key)
+			}
+		}
+		return nil, errs.Errorf("key %v is not published", token.Header["kid"])
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(strings.TrimSuffix(testUser.AppURL, "/")),
+		jwt.WithAudience(testUser.OidcClientID), jwt.WithExpirationRequired())
+	if err != nil {
+		return errs.Wrap("invalid ID token", err)
+	}
+	if err = helper.AssertEq(claims.Nonce, nonce); err != nil {
+		return errs.Wrap("nonce mismatch", err)
+	}
+	return helper.AssertEq(claims.Email, testUser.Email)
+}
+
+func parseJwk(key resource.JwkTO) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(key.Modulus)
+	if err != nil {
+		return nil, errs.Wrap("invalid modulus", err)
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(key.Exponent)
+	if err != nil {
+		return nil, errs.Wrap("invalid exponent", err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+}
@@ -0,0 +1,101 @@
+package functional_tests
+
+import (
+	"encoding/json"
+	"net/url"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+// TestPasskeyLogin registers an emulated passkey, signs in with it and deletes it again, so that later password logins
+// do not need a second factor
+func TestPasskeyLogin(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	appURL, err := url.Parse(testUser.AppURL)
+	if err != nil {
+		return errs.Wrap("issue parsing app url", err)
+	}
+	origin := appURL.Scheme + "://" + appURL.Host
+	passkey := helper.NewPasskey()
+	client := helper.NewRequestClient(testUser)
+
+	// Sudo login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+		Sudo:     true,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	// Register passkey
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/begin-passkey-registration", nil)
+	if !testUser.EmailVerified {
+		// The settings are only available with a verified email
+		return client.AssertLastResponseEq(403, nil)
+	}
+	credential, err := passkey.MakeCreationResponse(origin, client.LastResponseBody())
+	if err != nil {
+		return errs.Wrap("issue creating passkey", err)
+	}
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/finish-passkey-registration", resource.FinishPasskeyRegistrationTO{
+		Nickname:   "functional test",
+		Credential: credential,
+	})
+	if err = client.AssertLastResponseEq(200, resource.FinishPasskeyRegistrationResponseTO{Registered: true}); err != nil {
+		return errs.Wrap("finish passkey registration response mismatch", err)
+	}
+
+	// Login with tampered signature
+	otherClient := helper.NewRequestClient(testUser)
+	otherClient.MakeApiRequest("POST", "auth/begin-passkey-login", nil)
+	assertion, err := passkey.MakeAssertionResponse(origin, appURL.Hostname(), otherClient.LastResponseBody())
+	if err != nil {
+		return errs.Wrap("issue signing assertion", err)
+	}
+	var tampered map[string]interface{}
+	if err = json.Unmarshal(assertion, &tampered); err != nil {
+		return errs.Wrap("issue reading assertion", err)
+	}
+	tampered["response"].(map[string]interface{})["signature"] = "MEUCIQ"
+	otherClient.MakeApiRequest("POST", "auth/finish-passkey-login", map[string]interface{}{"credential": tampered})
+	if err = otherClient.AssertLastResponseEq(200, resource.PasskeyLoginResponseTO{LoggedIn: false}); err != nil {
+		return errs.Wrap("passkey login with tampered signature response mismatch", err)
+	}
+
+	// Login with passkey
+	otherClient.MakeApiRequest("POST", "auth/begin-passkey-login", nil)
+	assertion, err = passkey.MakeAssertionResponse(origin, appURL.Hostname(), otherClient.LastResponseBody())
+	if err != nil {
+		return errs.Wrap("issue signing assertion", err)
+	}
+	otherClient.MakeApiRequest("POST", "auth/finish-passkey-login", resource.PasskeyAssertionTO{Credential: assertion})
+	if err = otherClient.AssertLastResponseEq(200, resource.PasskeyLoginResponseTO{LoggedIn: true}); err != nil {
+		return errs.Wrap("passkey login response mismatch", err)
+	}
+	if !otherClient.HasSessionCookie() {
+		return errs.Error("session cookie not found after passkey login")
+	}
+
+	// Delete passkey
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/delete-passkey", resource.DeletePasskeyTO{
+		CredentialID: passkey.CredentialID(),
+	})
+	client.LastResponseBody()
+
+	// Deleted passkey no longer signs in
+	thirdClient := helper.NewRequestClient(testUser)
+	thirdClient.MakeApiRequest("POST", "auth/begin-passkey-login", nil)
+	assertion, err = passkey.MakeAssertionResponse(origin, appURL.Hostname(), thirdClient.LastResponseBody())
+	if err != nil {
+		return errs.Wrap("issue signing assertion", err)
+	}
+	thirdClient.MakeApiRequest("POST", "auth/finish-passkey-login", resource.PasskeyAssertionTO{Credential: assertion})
+	if err = thirdClient.AssertLastResponseEq(200, resource.PasskeyLoginResponseTO{LoggedIn: false}); err != nil {
+		return errs.Wrap("passkey login after deletion response mismatch", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package functional_tests
+
+import (
+	"regexp"
+	"user-manager/cmd/app/resource"
+	dm "user-manager/domain-model"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+var personalAccessTokenPattern = regexp.MustCompile(dm.PersonalAccessTokenPrefix + `[A-Za-z0-9_-]+`)
+
+func TestPersonalAccessTokens(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+
+	// Sudo login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+		Sudo:     true,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	// Scopes beyond the user's permissions are refused
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/create-personal-access-token", resource.CreatePersonalAccessTokenTO{
+		Name:          "functional test",
+		Scopes:        []dm.Permission{dm.PermissionRolesManage},
+		ExpiresInDays: 1,
+	})
+	if !testUser.EmailVerified {
+		// The settings are only available with a verified email
+		return client.AssertLastResponseEq(403, nil)
+	}
+	if err := client.AssertLastResponseEq(400, nil); err != nil {
+		return errs.Wrap("create with foreign scope response mismatch", err)
+	}
+
+	// Create token
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/create-personal-access-token", resource.CreatePersonalAccessTokenTO{
+		Name:          "functional test",
+		Scopes:        []dm.Permission{dm.PermissionAppUse},
+		ExpiresInDays: 1,
+	})
+	token := personalAccessTokenPattern.FindString(client.LastResponseBody())
+	if token == "" {
+		return errs.Error("no personal access token in response")
+	}
+
+	// The token authenticates without session cookie
+	tokenClient := helper.NewRequestClient(&helper.TestUser{AppURL: testUser.AppURL})
+	tokenClient.SetBearerToken(token)
+	tokenClient.MakeApiRequest("GET", "user-info", nil)
+	if err := tokenClient.AssertLastResponseEq(200, resource.UserInfoTO{Roles: []dm.UserRole{dm.UserRoleUser}, EmailVerified: true}); err != nil {
+		return errs.Wrap("user info with token response mismatch", err)
+	}
+
+	// Pages are not served to tokens
+	tokenClient.MakeApiRequest("GET", "user/settings", nil)
+	if err := tokenClient.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("page with token response mismatch", err)
+	}
+
+	// Tokens cannot enter sudo mode, not even with the password
+	tokenClient.MakeApiRequest("POST", "user/settings/enter-sudo-mode", resource.SudoTO{Password: []byte(password)})
+	if err := tokenClient.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("enter sudo mode with token response mismatch", err)
+	}
+
+	// Tokens cannot use the sensitive settings, not even together with a sudo session cookie
+	client.SetBearerToken(token)
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/change-password", resource.ChangePasswordTO{
+		OldPassword: []byte(password),
+		NewPassword: []byte(password + "-changed"),
+	})
+	if err := client.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("change password with token and sudo session response mismatch", err)
+	}
+
+	// Unknown tokens are rejected
+	tokenClient.SetBearerToken(dm.PersonalAccessTokenPrefix + "invalid")
+	tokenClient.MakeApiRequest("GET", "user-info", nil)
+	if err := tokenClient.AssertLastResponseEq(401, nil); err != nil {
+		return errs.Wrap("user info with invalid token response mismatch", err)
+	}
+	return nil
+}
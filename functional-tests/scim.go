@@ -0,0 +1,148 @@
+package functional_tests
+
+import (
+	"encoding/json"
+	"net/url"
+	"user-manager/cmd/app/resource"
+	dm "user-manager/domain-model"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+// TestScim acts as the organization's identity provider, which authenticates with the SCIM bearer token of the local environment
+func TestScim(testUser *helper.TestUser) error {
+	email := "scim-" + testUser.Email
+	client := helper.NewRequestClient(testUser)
+	client.SetBearerToken("local-scim-token")
+
+	// Discovery
+	client.MakeApiRequest("GET", "scim/v2/ServiceProviderConfig", nil)
+	if err := helper.AssertEq(client.LastResponseHeader("Content-Type"), "application/scim+json"); err != nil {
+		return errs.Wrap("service provider config content type mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Create user
+	active := true
+	client.MakeApiRequest("POST", "scim/v2/Users", resource.ScimUserTO{
+		Schemas:    []string{dm.ScimSchemaUser},
+		ExternalID: "external-1",
+		UserName:   email,
+		Name:       &resource.ScimNameTO{Formatted: "Provisioned User"},
+		Active:     &active,
+	})
+	if err := helper.AssertEq(client.LastResponseHeader("Location") != "", true); err != nil {
+		return errs.Wrap("create user location mismatch", err)
+	}
+	var user resource.ScimUserTO
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &user); err != nil {
+		return errs.Wrap("issue reading created user", err)
+	}
+	if err := helper.AssertEq(user.UserName, email); err != nil {
+		return errs.Wrap("created user name mismatch", err)
+	}
+
+	// Create duplicate user
+	client.MakeApiRequest("POST", "scim/v2/Users", resource.ScimUserTO{
+		Schemas:  []string{dm.ScimSchemaUser},
+		UserName: email,
+	})
+	if err := helper.AssertEq(client.LastResponseStatus(), 409); err != nil {
+		return errs.Wrap("create duplicate user status mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Filter users
+	client.MakeApiRequest("GET", "scim/v2/Users?filter="+url.QueryEscape(`userName eq "`+email+`"`), nil)
+	var list resource.ScimListResponseTO[resource.ScimUserTO]
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &list); err != nil {
+		return errs.Wrap("issue reading user list", err)
+	}
+	if err := helper.AssertEq(list.TotalResults, 1); err != nil {
+		return errs.Wrap("filtered user count mismatch", err)
+	}
+
+	// Users that were not provisioned are out of reach
+	client.MakeApiRequest("GET", "scim/v2/Users?filter="+url.QueryEscape(`userName eq "`+testUser.Email+`"`), nil)
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &list); err != nil {
+		return errs.Wrap("issue reading user list", err)
+	}
+	if err := helper.AssertEq(list.TotalResults, 0); err != nil {
+		return errs.Wrap("unmanaged user count mismatch", err)
+	}
+
+	// Change email
+	changedEmail := "scim-changed-" + testUser.Email
+	client.MakeApiRequest("PATCH", "scim/v2/Users/"+user.ID, resource.ScimPatchTO{
+		Schemas:    []string{dm.ScimSchemaPatchOp},
+		Operations: []resource.ScimPatchOperationTO{{Op: "replace", Path: "userName", Value: json.RawMessage(`"` + changedEmail + `"`)}},
+	})
+	var renamed resource.ScimUserTO
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &renamed); err != nil {
+		return errs.Wrap("issue reading user with changed email", err)
+	}
+	if err := helper.AssertEq(renamed.UserName, changedEmail); err != nil {
+		return errs.Wrap("changed email mismatch", err)
+	}
+
+	// Deactivate user
+	client.MakeApiRequest("PATCH", "scim/v2/Users/"+user.ID, resource.ScimPatchTO{
+		Schemas:    []string{dm.ScimSchemaPatchOp},
+		Operations: []resource.ScimPatchOperationTO{{Op: "replace", Path: "active", Value: json.RawMessage("false")}},
+	})
+	var patched resource.ScimUserTO
+	if err := json.Unmarshal([]byte(client.LastResponseBody()), &patched); err != nil {
+		return errs.Wrap("issue reading patched user", err)
+	}
+	if err := helper.AssertEq(patched.Active != nil && !*patched.Active, true); err != nil {
+		return errs.Wrap("patched user still active", err)
+	}
+
+	// Group membership requires two-factor authentication
+	client.MakeApiRequest("POST", "scim/v2/Groups", resource.ScimGroupTO{
+		Schemas:     []string{dm.ScimSchemaGroup},
+		DisplayName: "scim-group",
+		Members:     []resource.ScimReferenceTO{{Value: user.ID}},
+	})
+	if err := helper.AssertEq(client.LastResponseStatus(), 400); err != nil {
+		return errs.Wrap("create group with member status mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Members of privileged groups are left to super-admins
+	client.MakeApiRequest("PATCH", "scim/v2/Groups/"+string(dm.UserRoleSuperAdmin), resource.ScimPatchTO{
+		Schemas:    []string{dm.ScimSchemaPatchOp},
+		Operations: []resource.ScimPatchOperationTO{{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "` + user.ID + `"}]`)}},
+	})
+	if err := helper.AssertEq(client.LastResponseStatus(), 400); err != nil {
+		return errs.Wrap("add member to privileged group status mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Delete group and user
+	client.MakeApiRequest("DELETE", "scim/v2/Groups/scim-group", nil)
+	if err := client.AssertLastResponseEq(204, nil); err != nil {
+		return errs.Wrap("delete group response mismatch", err)
+	}
+	client.MakeApiRequest("DELETE", "scim/v2/Users/"+user.ID, nil)
+	if err := client.AssertLastResponseEq(204, nil); err != nil {
+		return errs.Wrap("delete user response mismatch", err)
+	}
+
+	// Wrong token
+	client.SetBearerToken("not-correct")
+	client.MakeApiRequest("GET", "scim/v2/Users", nil)
+	if err := helper.AssertEq(client.LastResponseStatus(), 401); err != nil {
+		return errs.Wrap("wrong token status mismatch", err)
+	}
+	client.LastResponseBody()
+
+	// Correct token without bearer scheme
+	client.SetHeader("Authorization", "local-scim-token")
+	client.MakeApiRequest("GET", "scim/v2/Users", nil)
+	if err := helper.AssertEq(client.LastResponseStatus(), 401); err != nil {
+		return errs.Wrap("token without bearer scheme status mismatch", err)
+	}
+	client.LastResponseBody()
+	return nil
+}
@@ -0,0 +1,120 @@
+package functional_tests
+
+import (
+	"github.com/pquerna/otp/totp"
+	"regexp"
+	"time"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+var secondFactorSecretPattern = regexp.MustCompile(`font-mono break-all">([A-Z2-7]+)<`)
+var recoveryCodePattern = regexp.MustCompile(`<li>([a-z2-7]+-[a-z2-7]+)</li>`)
+
+func TestSecondFactorEnrollment(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+
+	// Login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	if !client.HasSessionCookie() {
+		return errs.Error("session cookie not found")
+	}
+
+	// Attempt to enroll without sudo mode
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/initiate-second-factor-enrollment", nil)
+	if err := client.AssertLastResponseEq(403, nil); err != nil {
+		return errs.Wrap("enrollment without sudo mode response mismatch", err)
+	}
+
+	// Sudo login
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+		Sudo:     true,
+	})
+
+	// Initiate enrollment and grab secret from the rendered page
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/initiate-second-factor-enrollment", nil)
+	match := secondFactorSecretPattern.FindStringSubmatch(client.LastResponseBody())
+	if match == nil {
+		return errs.Error("second factor secret not found")
+	}
+	secret := match[1]
+
+	// Confirm with wrong code
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/confirm-second-factor-enrollment", resource.SecondFactorCodeTO{
+		Code: "000000",
+	})
+	if client.LastResponseHeader("HX-Retarget") != "" {
+		return errs.Error("enrollment confirmed with wrong code")
+	}
+
+	// Confirm with correct code
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		return errs.Wrap("issue generating totp code", err)
+	}
+	client.MakeApiRequest("POST", "user/settings/sensitive-settings/confirm-second-factor-enrollment", resource.SecondFactorCodeTO{
+		Code: code,
+	})
+	if err := helper.AssertEq(client.LastResponseHeader("HX-Retarget"), "#second-factor-section"); err != nil {
+		return errs.Wrap("enrollment confirmation response mismatch", err)
+	}
+	recoveryCodes := recoveryCodePattern.FindAllStringSubmatch(client.LastResponseBody(), -1)
+	if len(recoveryCodes) == 0 {
+		return errs.Error("recovery codes not found")
+	}
+	recoveryCode := recoveryCodes[0][1]
+
+	// Login now requires the second factor
+	client = helper.NewRequestClient(testUser)
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	client.MakeApiRequest("GET", "user-info", nil)
+	if err := client.AssertLastResponseEq(200, resource.UserInfoTO{Roles: nil, EmailVerified: false}); err != nil {
+		return errs.Wrap("user info before second factor response mismatch", err)
+	}
+
+	// Login with recovery code
+	client.MakeApiRequest("POST", "auth/login-with-second-factor", resource.SecondFactorTO{
+		RecoveryCode: recoveryCode,
+	})
+	if err := client.AssertLastResponseEq(200, resource.LoginWithSecondFactorResponseTO{LoggedIn: true}); err != nil {
+		return errs.Wrap("login with recovery code response mismatch", err)
+	}
+
+	// Recovery code cannot be used twice
+	client.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+		Email:    email,
+		Password: password,
+	})
+	client.MakeApiRequest("POST", "auth/login-with-second-factor", resource.SecondFactorTO{
+		RecoveryCode: recoveryCode,
+	})
+	if err := client.AssertLastResponseEq(200, resource.LoginWithSecondFactorResponseTO{}); err != nil {
+		return errs.Wrap("second login with same recovery code response mismatch", err)
+	}
+
+	// Grab notification email
+	receivedNotificationEmail := false
+	for i := 0; !receivedNotificationEmail && i < 10; i++ {
+		receivedNotificationEmail = len(helper.GetSentEmails(testUser, email, "Recovery code used")) == 1
+		if !receivedNotificationEmail {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	if !receivedNotificationEmail {
+		return errs.Error("recovery code used email not received")
+	}
+
+	testUser.SecondFactorSecret = secret
+	return nil
+}
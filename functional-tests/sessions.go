@@ -0,0 +1,53 @@
+package functional_tests
+
+import (
+	"strings"
+	"user-manager/cmd/app/resource"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/errs"
+)
+
+func TestRevokeOtherSessions(testUser *helper.TestUser) error {
+	email := testUser.Email
+	password := testUser.Password
+	client := helper.NewRequestClient(testUser)
+	otherClient := helper.NewRequestClient(testUser)
+
+	// Login on two devices
+	for _, c := range []*helper.RequestClient{client, otherClient} {
+		c.MakeApiRequest("POST", "auth/login", resource.LoginTO{
+			Email:    email,
+			Password: password,
+		})
+		if !c.HasSessionCookie() {
+			return errs.Error("session cookie not found")
+		}
+	}
+
+	// List sessions
+	client.MakeApiRequest("GET", "user/settings/sessions", nil)
+	body := client.LastResponseBody()
+	if strings.Count(body, "hx-post=\"/user/settings/revoke-session\"") < 1 {
+		return errs.Error("other session not listed")
+	}
+	if !strings.Contains(body, "This device") {
+		return errs.Error("current session not marked")
+	}
+
+	// Log out everywhere else
+	client.MakeApiRequest("POST", "user/settings/revoke-other-sessions", nil)
+	if strings.Contains(client.LastResponseBody(), "hx-post=\"/user/settings/revoke-session\"") {
+		return errs.Error("other sessions still listed after revoking")
+	}
+
+	// Other device is logged out, current device is not
+	otherClient.MakeApiRequest("GET", "user-info", nil)
+	if err := otherClient.AssertLastResponseEq(200, resource.UserInfoTO{Roles: nil, EmailVerified: false}); err != nil {
+		return errs.Wrap("user info of revoked session mismatch", err)
+	}
+	client.MakeApiRequest("GET", "user/settings/sessions", nil)
+	if !strings.Contains(client.LastResponseBody(), "This device") {
+		return errs.Error("current session revoked")
+	}
+	return nil
+}
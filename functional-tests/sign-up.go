@@ -20,6 +20,16 @@ func TestSignUp(testUser *helper.TestUser) error {
 		return errs.Wrap("response mismatch", err)
 	}
 
+	// Sign-up with password violating the policy
+	client.MakeApiRequest("POST", "auth/sign-up", resource.SignUpTO{
+		UserName: "test-user",
+		Email:    email,
+		Password: []byte("test-user"),
+	})
+	if err := client.AssertLastResponseEq(400, resource.PasswordPolicyViolationsTO{Violations: []dm.PasswordPolicyViolation{dm.PasswordPolicyViolationContainsName}}); err != nil {
+		return errs.Wrap("signup with rejected password response mismatch", err)
+	}
+
 	// Sign-up
 	client.MakeApiRequest("POST", "auth/sign-up", resource.SignUpTO{
 		UserName: "test-user",
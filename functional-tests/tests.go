@@ -0,0 +1,31 @@
+package functional_tests
+
+import (
+	"slices"
+	"user-manager/functional-tests/helper"
+)
+
+// BasicTests cover sign-up and the password flows. They run in order on the same test user.
+var BasicTests = []helper.FunctionalTest{
+	{Description: "sign-up", Test: TestSignUp},
+	{Description: "password reset", Test: TestPasswordReset},
+	{Description: "CSRF", Test: TestCallWithMismatchingCsrfTokens},
+	{Description: "simple login", Test: TestSimpleLogin},
+	{Description: "change password", Test: TestChangePassword},
+}
+
+// AllTests continue on the test user of BasicTests. The second factor is enrolled last, because password logins of the
+// tests before do not provide it.
+var AllTests = slices.Concat(BasicTests, []helper.FunctionalTest{
+	{Description: "login throttling", Test: TestLoginThrottling},
+	{Description: "revoke other sessions", Test: TestRevokeOtherSessions},
+	{Description: "external login", Test: TestExternalLogin},
+	{Description: "magic link login", Test: TestMagicLinkLogin},
+	{Description: "personal access tokens", Test: TestPersonalAccessTokens},
+	{Description: "forward auth", Test: TestForwardAuth},
+	{Description: "SCIM provisioning", Test: TestScim},
+	{Description: "account deletion", Test: TestAccountDeletion},
+	{Description: "passkey login", Test: TestPasskeyLogin},
+	{Description: "OpenID Connect", Test: TestOidc},
+	{Description: "second factor enrollment", Test: TestSecondFactorEnrollment},
+})
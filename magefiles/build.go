@@ -21,9 +21,37 @@ func (b Build) EmailJob() error {
 	return sh.Run("go", "build", "-o", "bin/email-job", "cmd/email-job/main.go")
 }
 
+// AccountDeletionJob checks and builds the job deleting accounts after their grace period
+func (b Build) AccountDeletionJob() error {
+	mg.Deps(Check)
+
+	return sh.Run("go", "build", "-o", "bin/account-deletion-job", "./cmd/account-deletion-job")
+}
+
 // MockApis checks and builds mock 3rd party apis
 func (b Build) MockApis() error {
 	mg.Deps(Check)
 
-	return sh.Run("go", "build", "-o", "bin/mock-api", "cmd/mock-3rd-party-apis/main.go")
+	return sh.Run("go", "build", "-o", "bin/mock-api", "./cmd/mock-3rd-party-apis")
+}
+
+// Migrations checks and builds the database migrations
+func (b Build) Migrations() error {
+	mg.Deps(Check)
+
+	return sh.Run("go", "build", "-o", "bin/migrations", "./cmd/migrations")
+}
+
+// ImportUsers checks and builds the legacy user import
+func (b Build) ImportUsers() error {
+	mg.Deps(Check)
+
+	return sh.Run("go", "build", "-o", "bin/import-users", "cmd/import-users/main.go")
+}
+
+// VerifyLedger checks and builds the security ledger verification
+func (b Build) VerifyLedger() error {
+	mg.Deps(Check)
+
+	return sh.Run("go", "build", "-o", "bin/verify-ledger", "./cmd/verify-ledger")
 }
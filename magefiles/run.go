@@ -6,24 +6,55 @@ import (
 )
 
 var appEnv = map[string]string{
-	"ENVIRONMENT":  "local",
-	"PORT":         "8080",
-	"APP_URL":      "http://localhost:8080",
-	"SERVICE_NAME": "TestApp",
-	"EMAIL_FROM":   "test-email-from@example.com",
-	"DB_NAME":      "db",
-	"DB_HOST":      "localhost",
-	"DB_PORT":      "27017",
-	"DB_USER":      "test",
-	"DB_PASSWORD":  "mongo-test-password",
+	"ENVIRONMENT":                 "local",
+	"PORT":                        "8080",
+	"APP_URL":                     "http://localhost:8080",
+	"SERVICE_NAME":                "TestApp",
+	"EMAIL_FROM":                  "test-email-from@example.com",
+	"DB_NAME":                     "db",
+	"DB_HOST":                     "localhost",
+	"DB_PORT":                     "27017",
+	"DB_USER":                     "test",
+	"DB_PASSWORD":                 "mongo-test-password",
+	"TOKEN_HASH_SECRET":           "local-token-hash-secret",
+	"MAGIC_LINK_LOGIN_ENABLED":    "true",
+	"PASSWORDLESS_LOGIN_ENABLED":  "true",
+	"ACCOUNT_LOCK_THRESHOLD":      "5",
+	"LEDGER_SIGNING_KEY":          "bG9jYWwtbGVkZ2VyLXNpZ25pbmcta2V5LXNlZWQtMzI=",
+	"OIDC_KEY_ENCRYPTION_SECRET":  "local-oidc-key-encryption-secret",
+	"EXTERNAL_IDENTITY_PROVIDERS": `[{"name": "mock", "displayName": "Mock IdP", "issuer": "http://localhost:8081/mock-idp", "clientID": "mock-client", "clientSecret": "mock-client-secret"}]`,
+	"SCIM_BEARER_TOKEN":           "local-scim-token",
+	"FORWARD_AUTH_RULES":          `[{"host": "tools.localhost"}, {"host": "tools.localhost", "pathPrefix": "/admin", "roles": ["admin"]}]`,
+}
+
+var verifyLedgerEnv = map[string]string{
+	"ENVIRONMENT":       "local",
+	"DB_NAME":           "db",
+	"DB_HOST":           "localhost",
+	"DB_PORT":           "27017",
+	"DB_USER":           "test",
+	"DB_PASSWORD":       "mongo-test-password",
+	"LEDGER_PUBLIC_KEY": "PeLProKJTCgu0+jgURJKr6yAbxaU40XXY53ppGulpts=",
 }
 
 // Start checks then starts app, emailer and mock 3rd-party APIs
 func Start() error {
-	mg.Deps(Build.App, ComposeUpLocalEnvironment)
+	mg.Deps(Build.App, Migrate)
 	return sh.RunWithV(appEnv, "bin/app")
 }
 
+// Migrate applies pending database migrations to the local MongoDB instance
+func Migrate() error {
+	mg.Deps(Build.Migrations, ComposeUpLocalEnvironment)
+	return sh.RunWithV(appEnv, "bin/migrations")
+}
+
+// VerifyLedger checks the security ledger of the local MongoDB instance
+func VerifyLedger() error {
+	mg.Deps(Build.VerifyLedger, ComposeUpLocalEnvironment)
+	return sh.RunWithV(verifyLedgerEnv, "bin/verify-ledger")
+}
+
 // Watch checks then starts app, emailer and mock 3rd-party APIs each time a file changes
 func Watch() error {
 	//mg.Deps(Build.App, ComposeUpLocalEnvironment)
@@ -31,9 +62,9 @@ func Watch() error {
 	return sh.RunV(wgo, "-xdir", "magefiles", "-xfile", "bin/app", "-xfile", ".*"+templGeneratedSuffix, "-xdir", assets, "-xdir", buildDir, "mage", "start")
 }
 
-// ComposeUpLocalEnvironment starts a local MongoDB instance, emailer service and mock-3rd-party APIs as docker containers
+// ComposeUpLocalEnvironment starts a local MongoDB instance, emailer service, account deletion job and mock-3rd-party APIs as docker containers
 func ComposeUpLocalEnvironment() error {
-	mg.Deps(Check, Build.EmailJob, Build.MockApis)
+	mg.Deps(Check, Build.EmailJob, Build.AccountDeletionJob, Build.MockApis)
 	return sh.Run("docker-compose", "-f", "local-env-docker-compose.yml", "up", "-d")
 }
 
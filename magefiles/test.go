@@ -1,46 +1,85 @@
 package main
 
 import (
+	"context"
 	"github.com/magefile/mage/mg"
+	"log"
+	"strconv"
+	"user-manager/cmd/app/service/oidc"
+	dm "user-manager/domain-model"
+	functionaltests "user-manager/functional-tests"
+	"user-manager/functional-tests/helper"
+	"user-manager/util/db"
+	"user-manager/util/errs"
+	"user-manager/util/random"
 )
 
 type FunctionalTests mg.Namespace
 
-//// Basic runs a basic set of functional tests.
-//func (FunctionalTests) Basic() error {
-//	mg.Deps(ComposeUpLocalEnvironment)
-//	testUser := helper.TestUser{
-//		AppURL:     appEnv["APP_URL"],
-//		MockApiURL: "http://localhost:8081",
-//		Email:      "test-user-" + random.MakeRandomURLSafeB64(5) + "@example.com",
-//		Password:   "hunter12",
-//	}
-//	log.Print("Test user email: " + testUser.Email)
-//	log.Print("Testing signup...")
-//	if err := functionaltests.TestSignUp(&testUser); err != nil {
-//		return errs.Wrap("sign-up test failed", err)
-//	}
-//	log.Print("Testing password reset...")
-//	if err := functionaltests.TestPasswordReset(&testUser); err != nil {
-//		return errs.Wrap("password reset test failed", err)
-//	}
-//	log.Print("Testing CSRF...")
-//	if err := functionaltests.TestCallWithMismatchingCsrfTokens(&testUser); err != nil {
-//		return errs.Wrap("csrf test failed", err)
-//	}
-//	log.Print("Testing simple login...")
-//	if err := functionaltests.TestSimpleLogin(&testUser); err != nil {
-//		return errs.Wrap("simple login test failed", err)
-//	}
-//	log.Print("Testing change password...")
-//	if err := functionaltests.TestChangePassword(&testUser); err != nil {
-//		return errs.Wrap("change password test failed", err)
-//	}
-//	log.Print("Success!")
-//	return nil
-//}
-//
-//// All runs all functional tests.
-//func (FunctionalTests) All() {
-//	mg.Deps(FunctionalTests.Basic)
-//}
+// Basic runs a basic set of functional tests against the running app.
+func (FunctionalTests) Basic() error {
+	mg.Deps(ComposeUpLocalEnvironment)
+	return runFunctionalTests(functionaltests.BasicTests)
+}
+
+// All runs all functional tests against the running app.
+func (FunctionalTests) All() error {
+	mg.Deps(ComposeUpLocalEnvironment)
+	return runFunctionalTests(functionaltests.AllTests)
+}
+
+func runFunctionalTests(tests []helper.FunctionalTest) error {
+	testUser := helper.TestUser{
+		AppURL:     appEnv["APP_URL"],
+		MockApiURL: "http://localhost:8081",
+		Email:      "test-user-" + random.MakeRandomURLSafeB64(5) + "@example.com",
+		Password:   "hunter12",
+	}
+	log.Print("Test user email: " + testUser.Email)
+	if err := registerOidcTestClient(&testUser); err != nil {
+		return errs.Wrap("issue registering oidc test client", err)
+	}
+	for _, test := range tests {
+		log.Print("Testing " + test.Description + "...")
+		if err := test.Test(&testUser); err != nil {
+			return errs.Wrap(test.Description+" test failed", err)
+		}
+	}
+	// the tests change credentials, emails and roles, so the ledger they appended to has to be intact
+	log.Print("Verifying ledger...")
+	if err := VerifyLedger(); err != nil {
+		return errs.Wrap("ledger verification after tests failed", err)
+	}
+	log.Print("Success!")
+	return nil
+}
+
+// registerOidcTestClient registers a relying party for the test run directly in the database, since registering clients
+// requires a super admin
+func registerOidcTestClient(testUser *helper.TestUser) error {
+	port, err := strconv.Atoi(appEnv["DB_PORT"])
+	if err != nil {
+		return errs.Wrap("invalid db port", err)
+	}
+	database, err := db.OpenDbConnection(db.Info{
+		Name:     appEnv["DB_NAME"],
+		Host:     appEnv["DB_HOST"],
+		Port:     port,
+		User:     appEnv["DB_USER"],
+		Password: appEnv["DB_PASSWORD"],
+	})
+	if err != nil {
+		return errs.Wrap("issue opening db connection", err)
+	}
+	defer db.CloseOrPanic(database.Client())
+
+	testUser.OidcRedirectURI = testUser.MockApiURL + "/oidc-callback"
+	client, secret, err := oidc.CreateClient(context.Background(), database, &dm.Config{TokenHashSecret: appEnv["TOKEN_HASH_SECRET"]},
+		"Functional tests", []string{testUser.OidcRedirectURI}, dm.UserID{})
+	if err != nil {
+		return errs.Wrap("issue creating oidc client", err)
+	}
+	testUser.OidcClientID = client.ClientID
+	testUser.OidcClientSecret = secret
+	return nil
+}